@@ -0,0 +1,810 @@
+package sqlitehench
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SQLKind is the kind of an SQL statement.
+type SQLKind int
+
+const (
+	SQLUnknown SQLKind = iota
+
+	// SQLRead is a SELECT, VALUES or EXPLAIN statement.
+	SQLRead
+
+	// SQLWrite is an INSERT, REPLACE, UPDATE or DELETE statement.
+	SQLWrite
+
+	// SQLDDL is a CREATE, DROP or ALTER statement.
+	SQLDDL
+
+	SQLPragma
+
+	// SQLOther is a transaction control statement, VACUUM, ANALYZE,
+	// ATTACH, DETACH or REINDEX.
+	SQLOther
+)
+
+func (k SQLKind) String() string {
+	switch k {
+	case SQLRead:
+		return "read"
+	case SQLWrite:
+		return "write"
+	case SQLDDL:
+		return "ddl"
+	case SQLPragma:
+		return "pragma"
+	case SQLOther:
+		return "other"
+	}
+	return "unknown"
+}
+
+// SQLStatement is the result of AnalyzeSQL for one statement.
+type SQLStatement struct {
+	// Text is the statement; without the trailing semicolon.
+	Text string
+
+	Kind SQLKind
+
+	// Verb is the first keyword of the statement (after WITH and
+	// EXPLAIN) in upper-case; i.e. SELECT, INSERT, CREATE, PRAGMA.
+	Verb string
+
+	// Tables are the tables (and views) that the statement refers to,
+	// in order of appearance; the names of common table expressions
+	// and table-valued functions are not included.
+	Tables []string
+
+	// Target is the table that is written to (INSERT, UPDATE, DELETE),
+	// or defined (CREATE, DROP, ALTER); for a PRAGMA with an argument
+	// (i.e. table_info(Customer)), it is the argument.
+	Target string
+
+	// Source is the table that the rows of a read come from; the first
+	// table of the outermost FROM clause, resolved through subqueries
+	// and common table expressions. It is empty for i.e. SELECT 1.
+	Source string
+
+	// Pragma is the pragma name of a PRAGMA statement.
+	Pragma string
+}
+
+// Writes reports whether the statement can modify the database.
+func (s SQLStatement) Writes() bool {
+
+	switch s.Kind {
+	case SQLWrite, SQLDDL, SQLOther:
+		return true
+	case SQLPragma:
+		// i.e. PRAGMA journal_mode = WAL
+		return strings.Contains(s.Text, "=")
+	}
+
+	return false
+}
+
+// AnalyzeSQL splits SQL text into statements, and returns the kind and
+// the referenced tables of each statement. Comments, string literals and
+// quoted identifiers ("x", [x], `x`) are taken into account; so that i.e.
+// a semicolon in a string does not end a statement.
+func AnalyzeSQL(sqlText string) []SQLStatement {
+
+	var ret []SQLStatement
+
+	stmts := splitTokens(sqlText, tokenizeSQL(sqlText))
+	for i := 0; i < len(stmts); i++ {
+		ret = append(ret, analyzeTokens(sqlText, stmts[i]))
+	}
+
+	return ret
+}
+
+// analyzeSQL returns the analysis of the first statement in sqlText.
+func analyzeSQL(sqlText string) SQLStatement {
+
+	stmts := AnalyzeSQL(sqlText)
+	if len(stmts) == 0 {
+		return SQLStatement{}
+	}
+
+	return stmts[0]
+}
+
+type sqlTokKind int
+
+const (
+	tokWord   sqlTokKind = iota // identifier or keyword
+	tokQuoted                   // quoted identifier
+	tokString                   // string or blob literal
+	tokNumber
+	tokParam
+	tokPunct
+)
+
+type sqlToken struct {
+	kind sqlTokKind
+
+	// val is the unquoted identifier, or the raw text.
+	val string
+
+	start int
+	end   int
+}
+
+// isKeyword reports whether the token is the (unquoted) keyword kw.
+func (t sqlToken) isKeyword(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.val, kw)
+}
+
+func (t sqlToken) isPunct(p string) bool {
+	return t.kind == tokPunct && t.val == p
+}
+
+func (t sqlToken) isName() bool {
+	return t.kind == tokWord || t.kind == tokQuoted || t.kind == tokString
+}
+
+// tokenizeSQL splits SQL text into tokens; whitespace and comments are
+// dropped.
+func tokenizeSQL(s string) []sqlToken {
+
+	var toks []sqlToken
+
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+			continue
+
+		case c == '-' && i+1 < len(s) && s[i+1] == '-':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			continue
+
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			if n := strings.Index(s[i+2:], "*/"); n >= 0 {
+				i += n + 4
+			} else {
+				i = len(s)
+			}
+			continue
+
+		case c == '\'':
+			i = scanQuoted(s, i, '\'')
+			toks = append(toks, sqlToken{kind: tokString, val: unquote(s[start:i], '\''), start: start, end: i})
+			continue
+
+		case (c == 'x' || c == 'X') && i+1 < len(s) && s[i+1] == '\'':
+			i = scanQuoted(s, i+1, '\'')
+			toks = append(toks, sqlToken{kind: tokString, val: s[start:i], start: start, end: i})
+			continue
+
+		case c == '"' || c == '`':
+			i = scanQuoted(s, i, c)
+			toks = append(toks, sqlToken{kind: tokQuoted, val: unquote(s[start:i], c), start: start, end: i})
+			continue
+
+		case c == '[':
+			if n := strings.IndexByte(s[i:], ']'); n >= 0 {
+				i += n + 1
+			} else {
+				i = len(s)
+			}
+			toks = append(toks, sqlToken{kind: tokQuoted, val: strings.TrimSuffix(s[start+1:i], "]"), start: start, end: i})
+			continue
+
+		case isDigit(c) || (c == '.' && i+1 < len(s) && isDigit(s[i+1])):
+			for i < len(s) && (isIdentChar(s[i]) || s[i] == '.' ||
+				((s[i] == '+' || s[i] == '-') && (s[i-1] == 'e' || s[i-1] == 'E') && !strings.HasPrefix(strings.ToLower(s[start:]), "0x"))) {
+				i++
+			}
+			toks = append(toks, sqlToken{kind: tokNumber, val: s[start:i], start: start, end: i})
+			continue
+
+		case c == '?':
+			i++
+			for i < len(s) && isDigit(s[i]) {
+				i++
+			}
+			toks = append(toks, sqlToken{kind: tokParam, val: s[start:i], start: start, end: i})
+			continue
+
+		case (c == ':' || c == '@' || c == '$') && i+1 < len(s) && isIdentStart(s[i+1]):
+			i++
+			for i < len(s) && isIdentChar(s[i]) {
+				i++
+			}
+			toks = append(toks, sqlToken{kind: tokParam, val: s[start:i], start: start, end: i})
+			continue
+
+		case isIdentStart(c):
+			for i < len(s) && isIdentChar(s[i]) {
+				i++
+			}
+			toks = append(toks, sqlToken{kind: tokWord, val: s[start:i], start: start, end: i})
+			continue
+		}
+
+		// Operators and punctuation.
+		n := 1
+		for _, op := range []string{"->>", "||", "<=", ">=", "==", "!=", "<>", "<<", ">>", "->"} {
+			if strings.HasPrefix(s[i:], op) {
+				n = len(op)
+				break
+			}
+		}
+		i += n
+		toks = append(toks, sqlToken{kind: tokPunct, val: s[start:i], start: start, end: i})
+	}
+
+	return toks
+}
+
+// scanQuoted returns the position after the closing quote q; a doubled
+// quote is an escaped one.
+func scanQuoted(s string, i int, q byte) int {
+
+	i++
+	for i < len(s) {
+		if s[i] == q {
+			if i+1 < len(s) && s[i+1] == q {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+
+	return len(s)
+}
+
+func unquote(s string, q byte) string {
+
+	if len(s) >= 2 && s[len(s)-1] == q {
+		s = s[1 : len(s)-1]
+	} else if len(s) >= 1 {
+		s = s[1:]
+	}
+
+	return strings.ReplaceAll(s, string([]byte{q, q}), string(q))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= utf8.RuneSelf || unicode.IsLetter(rune(c))
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
+
+// splitTokens splits the tokens into statements at the semicolons; a
+// CREATE TRIGGER statement ends at the semicolon after its END.
+func splitTokens(sqlText string, toks []sqlToken) [][]sqlToken {
+
+	var stmts [][]sqlToken
+
+	begin := 0
+	inTrigger := false
+	inBody := false
+	caseDepth := 0
+
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+
+		if i == begin+1 && toks[begin].isKeyword("CREATE") {
+			j := i
+			if toks[j].isKeyword("TEMP") || toks[j].isKeyword("TEMPORARY") {
+				j++
+			}
+			inTrigger = j < len(toks) && toks[j].isKeyword("TRIGGER")
+		}
+
+		if inTrigger {
+			switch {
+			case t.isKeyword("BEGIN"):
+				inBody = true
+			case t.isKeyword("CASE"):
+				caseDepth++
+			case t.isKeyword("END"):
+				if caseDepth > 0 {
+					caseDepth--
+				} else {
+					inBody = false
+				}
+			}
+		}
+
+		if !t.isPunct(";") || inBody {
+			continue
+		}
+
+		if i > begin {
+			stmts = append(stmts, toks[begin:i])
+		}
+		begin = i + 1
+		inTrigger = false
+		caseDepth = 0
+	}
+
+	if begin < len(toks) {
+		stmts = append(stmts, toks[begin:])
+	}
+
+	return stmts
+}
+
+// sqlAnalysis holds the state of analyzeTokens.
+type sqlAnalysis struct {
+	toks []sqlToken
+
+	// ctes holds the body tokens of each common table expression, by
+	// lower-case name.
+	ctes map[string][]sqlToken
+
+	tables []string
+}
+
+func analyzeTokens(sqlText string, toks []sqlToken) SQLStatement {
+
+	var st SQLStatement
+
+	if len(toks) == 0 {
+		return st
+	}
+
+	st.Text = sqlText[toks[0].start:toks[len(toks)-1].end]
+
+	a := &sqlAnalysis{toks: toks, ctes: make(map[string][]sqlToken)}
+
+	// i is the position of the verb.
+	i := 0
+	explain := false
+	if toks[i].isKeyword("EXPLAIN") {
+		explain = true
+		i++
+		if i+1 < len(toks) && toks[i].isKeyword("QUERY") && toks[i+1].isKeyword("PLAN") {
+			i += 2
+		}
+	}
+
+	// All WITH clauses (including the ones in subqueries) are read
+	// first; so that the CTE names are known.
+	for j := 0; j < len(toks); j++ {
+		if toks[j].isKeyword("WITH") {
+			end := a.readWith(j)
+			if j == i {
+				i = end
+			}
+		}
+	}
+
+	if i < len(toks) && toks[i].kind == tokWord {
+		st.Verb = strings.ToUpper(toks[i].val)
+	}
+
+	switch st.Verb {
+	case "SELECT", "VALUES":
+		st.Kind = SQLRead
+	case "INSERT", "REPLACE", "UPDATE", "DELETE":
+		st.Kind = SQLWrite
+	case "CREATE", "DROP", "ALTER":
+		st.Kind = SQLDDL
+	case "PRAGMA":
+		st.Kind = SQLPragma
+	case "BEGIN", "COMMIT", "END", "ROLLBACK", "SAVEPOINT", "RELEASE",
+		"VACUUM", "ANALYZE", "ATTACH", "DETACH", "REINDEX":
+		st.Kind = SQLOther
+	}
+	if explain {
+		st.Kind = SQLRead
+	}
+
+	if st.Kind == SQLPragma {
+		st.Pragma, st.Target = a.readPragma(i + 1)
+		if st.Target != "" {
+			st.Tables = []string{st.Target}
+		}
+		return st
+	}
+
+	st.Target = a.target(i, st.Verb)
+
+	for j := 0; j < len(toks); j++ {
+		t := toks[j]
+		if t.kind != tokWord {
+			continue
+		}
+
+		switch strings.ToUpper(t.val) {
+		case "FROM":
+			// but not IS [NOT] DISTINCT FROM
+			if j == 0 || !toks[j-1].isKeyword("DISTINCT") {
+				a.readTableList(j+1, true)
+			}
+
+		case "JOIN":
+			a.readTableList(j+1, false)
+
+		case "INTO", "REFERENCES":
+			if name, _ := a.readTableName(j + 1); name != "" {
+				a.addTable(name)
+			}
+
+		case "UPDATE":
+			// UPDATE [OR action] table; but not DO UPDATE SET (upsert),
+			// and UPDATE OF col in a trigger.
+			k := j + 1
+			if k+1 < len(toks) && toks[k].isKeyword("OR") {
+				k += 2
+			}
+			if k < len(toks) && !toks[k].isKeyword("SET") && !toks[k].isKeyword("OF") {
+				if name, _ := a.readTableName(k); name != "" {
+					a.addTable(name)
+				}
+			}
+
+		case "TABLE":
+			// CREATE/DROP/ALTER TABLE [IF [NOT] EXISTS] name
+			if name, _ := a.readTableName(a.skipIfExists(j + 1)); name != "" {
+				a.addTable(name)
+			}
+
+		case "ON":
+			// CREATE INDEX/TRIGGER ... ON table
+			if st.Kind == SQLDDL {
+				if name, _ := a.readTableName(j + 1); name != "" {
+					a.addTable(name)
+				}
+			}
+		}
+	}
+
+	if st.Kind == SQLRead {
+		st.Source = a.source(toks[i:], map[string]bool{})
+	}
+
+	st.Tables = a.tables
+
+	return st
+}
+
+// target returns the table that a statement writes to, or defines; i is
+// the position of the verb.
+func (a *sqlAnalysis) target(i int, verb string) string {
+
+	toks := a.toks
+
+	switch verb {
+	case "INSERT", "REPLACE":
+		for j := i + 1; j < len(toks); j++ {
+			if toks[j].isKeyword("INTO") {
+				name, _ := a.readTableName(j + 1)
+				return name
+			}
+		}
+
+	case "UPDATE":
+		j := i + 1
+		if j+1 < len(toks) && toks[j].isKeyword("OR") {
+			j += 2
+		}
+		name, _ := a.readTableName(j)
+		return name
+
+	case "DELETE":
+		if i+1 < len(toks) && toks[i+1].isKeyword("FROM") {
+			name, _ := a.readTableName(i + 2)
+			return name
+		}
+
+	case "CREATE", "DROP", "ALTER":
+		for j := i + 1; j < len(toks); j++ {
+			switch {
+			case toks[j].isKeyword("TABLE"), toks[j].isKeyword("VIEW"):
+				name, _ := a.readTableName(a.skipIfExists(j + 1))
+				return name
+
+			case toks[j].isKeyword("INDEX"), toks[j].isKeyword("TRIGGER"):
+				if verb != "CREATE" {
+					return ""
+				}
+				for k := j + 1; k < len(toks); k++ {
+					if toks[k].isKeyword("ON") {
+						name, _ := a.readTableName(k + 1)
+						return name
+					}
+				}
+				return ""
+			}
+		}
+	}
+
+	return ""
+}
+
+// readWith reads the CTE list of a WITH clause that starts at i, and
+// returns the position after it.
+func (a *sqlAnalysis) readWith(i int) int {
+
+	toks := a.toks
+
+	i++
+	if i < len(toks) && toks[i].isKeyword("RECURSIVE") {
+		i++
+	}
+
+	for i < len(toks) && toks[i].isName() {
+		name := strings.ToLower(toks[i].val)
+		i++
+
+		if i < len(toks) && toks[i].isPunct("(") {
+			// column list
+			i = a.matchParen(i) + 1
+		}
+		if i < len(toks) && toks[i].isKeyword("AS") {
+			i++
+		}
+		if i < len(toks) && toks[i].isKeyword("NOT") {
+			i++
+		}
+		if i < len(toks) && toks[i].isKeyword("MATERIALIZED") {
+			i++
+		}
+		if i >= len(toks) || !toks[i].isPunct("(") {
+			break
+		}
+
+		end := a.matchParen(i)
+		a.ctes[name] = toks[i+1 : end]
+		i = end + 1
+
+		if i < len(toks) && toks[i].isPunct(",") {
+			i++
+			continue
+		}
+		break
+	}
+
+	return i
+}
+
+// readPragma returns the name and argument of PRAGMA [schema.]name
+// [(arg) | = value].
+func (a *sqlAnalysis) readPragma(i int) (string, string) {
+
+	toks := a.toks
+
+	if i >= len(toks) {
+		return "", ""
+	}
+
+	name := toks[i].val
+	if i+2 < len(toks) && toks[i+1].isPunct(".") {
+		i += 2
+		name = toks[i].val
+	}
+	i++
+
+	if i+1 < len(toks) && toks[i].isPunct("(") && toks[i+1].isName() {
+		return strings.ToLower(name), toks[i+1].val
+	}
+
+	return strings.ToLower(name), ""
+}
+
+// readTableList reads the table list of a FROM (list is true) or JOIN
+// clause that starts at i; and returns the first table name.
+func (a *sqlAnalysis) readTableList(i int, list bool) string {
+
+	toks := a.toks
+	first := ""
+
+	for i < len(toks) {
+		if toks[i].isPunct("(") {
+			// subquery; its tables are read by the main loop
+			i = a.matchParen(i) + 1
+		} else {
+			name, next := a.readTableName(i)
+			if name == "" {
+				break
+			}
+			if next < len(toks) && toks[next].isPunct("(") {
+				// table-valued function; i.e. json_each(...)
+				next = a.matchParen(next) + 1
+			} else {
+				a.addTable(name)
+				if first == "" {
+					first = name
+				}
+			}
+			i = next
+		}
+
+		i = a.skipAlias(i)
+
+		if !list || i >= len(toks) || !toks[i].isPunct(",") {
+			break
+		}
+		i++
+	}
+
+	return first
+}
+
+// readTableName reads [schema.]name at i; and returns the name and the
+// position after it.
+func (a *sqlAnalysis) readTableName(i int) (string, int) {
+
+	toks := a.toks
+
+	if i >= len(toks) || (toks[i].kind != tokWord && toks[i].kind != tokQuoted) {
+		return "", i
+	}
+	if toks[i].kind == tokWord && isReservedWord(toks[i].val) {
+		return "", i
+	}
+
+	name := toks[i].val
+	i++
+
+	if i+1 < len(toks) && toks[i].isPunct(".") && toks[i+1].isName() {
+		name = name + "." + toks[i+1].val
+		i += 2
+	}
+
+	return name, i
+}
+
+// skipIfExists skips IF [NOT] EXISTS at i.
+func (a *sqlAnalysis) skipIfExists(i int) int {
+
+	toks := a.toks
+
+	if i < len(toks) && toks[i].isKeyword("IF") {
+		i++
+		if i < len(toks) && toks[i].isKeyword("NOT") {
+			i++
+		}
+		if i < len(toks) && toks[i].isKeyword("EXISTS") {
+			i++
+		}
+	}
+
+	return i
+}
+
+// skipAlias skips [AS] alias at i.
+func (a *sqlAnalysis) skipAlias(i int) int {
+
+	toks := a.toks
+
+	if i < len(toks) && toks[i].isKeyword("AS") {
+		return i + 2
+	}
+	if i < len(toks) && (toks[i].kind == tokQuoted || toks[i].kind == tokWord && !isReservedWord(toks[i].val)) {
+		return i + 1
+	}
+
+	return i
+}
+
+// matchParen returns the position of the ) that closes the ( at i.
+func (a *sqlAnalysis) matchParen(i int) int {
+
+	depth := 0
+	for ; i < len(a.toks); i++ {
+		switch {
+		case a.toks[i].isPunct("("):
+			depth++
+		case a.toks[i].isPunct(")"):
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return len(a.toks) - 1
+}
+
+func (a *sqlAnalysis) addTable(name string) {
+
+	if _, ok := a.ctes[strings.ToLower(name)]; ok {
+		return
+	}
+
+	for i := 0; i < len(a.tables); i++ {
+		if strings.EqualFold(a.tables[i], name) {
+			return
+		}
+	}
+
+	a.tables = append(a.tables, name)
+}
+
+// source returns the first table in the outermost FROM clause of toks;
+// resolved through subqueries and CTEs (visited guards against
+// recursive CTEs).
+func (a *sqlAnalysis) source(toks []sqlToken, visited map[string]bool) string {
+
+	sub := &sqlAnalysis{toks: toks, ctes: a.ctes}
+
+	for i := 0; i < len(toks); i++ {
+		if toks[i].isPunct("(") {
+			i = sub.matchParen(i)
+			continue
+		}
+		if !toks[i].isKeyword("FROM") || (i > 0 && toks[i-1].isKeyword("DISTINCT")) {
+			continue
+		}
+
+		// The first item of the table list; that is not a
+		// table-valued function.
+		for j := i + 1; j < len(toks); {
+			if toks[j].isPunct("(") {
+				end := sub.matchParen(j)
+				return a.source(toks[j+1:end], visited)
+			}
+
+			name, next := sub.readTableName(j)
+			if name == "" {
+				return ""
+			}
+
+			if next < len(toks) && toks[next].isPunct("(") {
+				next = sub.skipAlias(sub.matchParen(next) + 1)
+				if next < len(toks) && toks[next].isPunct(",") {
+					j = next + 1
+					continue
+				}
+				return ""
+			}
+
+			key := strings.ToLower(name)
+			if body, ok := a.ctes[key]; ok {
+				if visited[key] {
+					return ""
+				}
+				visited[key] = true
+				return a.source(body, visited)
+			}
+
+			return name
+		}
+
+		return ""
+	}
+
+	return ""
+}
+
+// isReservedWord reports whether w is a keyword that cannot be a table
+// name or an alias without quotes; i.e. the keywords that follow a table
+// name in a FROM clause.
+func isReservedWord(w string) bool {
+
+	switch strings.ToUpper(w) {
+	case "SELECT", "FROM", "WHERE", "GROUP", "ORDER", "LIMIT", "HAVING", "WINDOW",
+		"UNION", "EXCEPT", "INTERSECT", "ON", "USING", "NATURAL", "LEFT", "RIGHT",
+		"FULL", "INNER", "CROSS", "OUTER", "JOIN", "RETURNING", "SET", "VALUES",
+		"DEFAULT", "AS", "INDEXED", "NOT", "WITH", "AND", "OR", "DO":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,308 @@
+package sqlitehench
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeSQL(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		sql    string
+		kind   SQLKind
+		verb   string
+		tables []string
+		target string
+		source string
+		pragma string
+		writes bool
+	}{
+		{
+			name:   "select",
+			sql:    "SELECT a, b FROM Customer c JOIN Orders o ON o.cid = c.id WHERE a > 1",
+			kind:   SQLRead,
+			verb:   "SELECT",
+			tables: []string{"Customer", "Orders"},
+			source: "Customer",
+		},
+		{
+			name: "select without a table",
+			sql:  "select 1",
+			kind: SQLRead,
+			verb: "SELECT",
+		},
+		{
+			name:   "subquery",
+			sql:    "select * from (select id from Customer where id in (select cid from Orders)) x",
+			kind:   SQLRead,
+			verb:   "SELECT",
+			tables: []string{"Customer", "Orders"},
+			source: "Customer",
+		},
+		{
+			name:   "cte and select",
+			sql:    "WITH recent AS (SELECT * FROM Orders WHERE d > 5) SELECT * FROM recent JOIN Customer ON 1",
+			kind:   SQLRead,
+			verb:   "SELECT",
+			tables: []string{"Orders", "Customer"},
+			source: "Orders",
+		},
+		{
+			name:   "cte and insert",
+			sql:    "with x(id) as (select id from Staging) insert into Customer (id) select id from x",
+			kind:   SQLWrite,
+			verb:   "INSERT",
+			tables: []string{"Staging", "Customer"},
+			target: "Customer",
+			writes: true,
+		},
+		{
+			name:   "cte and delete",
+			sql:    "WITH old AS (SELECT id FROM Orders WHERE d < 1) DELETE FROM Orders WHERE id IN (SELECT id FROM old)",
+			kind:   SQLWrite,
+			verb:   "DELETE",
+			tables: []string{"Orders"},
+			target: "Orders",
+			writes: true,
+		},
+		{
+			name:   "recursive cte",
+			sql:    "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n WHERE i < 10) SELECT i FROM n",
+			kind:   SQLRead,
+			verb:   "SELECT",
+			tables: nil,
+		},
+		{
+			name:   "update",
+			sql:    "UPDATE OR IGNORE main.Customer SET name = 'x;y' WHERE id = ?",
+			kind:   SQLWrite,
+			verb:   "UPDATE",
+			tables: []string{"main.Customer"},
+			target: "main.Customer",
+			writes: true,
+		},
+		{
+			name:   "replace",
+			sql:    "REPLACE INTO Customer VALUES (1, 'a')",
+			kind:   SQLWrite,
+			verb:   "REPLACE",
+			tables: []string{"Customer"},
+			target: "Customer",
+			writes: true,
+		},
+		{
+			name:   "create table as",
+			sql:    "CREATE TABLE IF NOT EXISTS Archive AS SELECT * FROM Orders",
+			kind:   SQLDDL,
+			verb:   "CREATE",
+			tables: []string{"Archive", "Orders"},
+			target: "Archive",
+			writes: true,
+		},
+		{
+			name:   "create trigger",
+			sql:    "CREATE TRIGGER trg AFTER INSERT ON Orders BEGIN UPDATE Customer SET n = n + 1; INSERT INTO Log VALUES (CASE WHEN new.a THEN 1 END); END",
+			kind:   SQLDDL,
+			verb:   "CREATE",
+			tables: []string{"Orders", "Customer", "Log"},
+			target: "Orders",
+			writes: true,
+		},
+		{
+			name:   "drop",
+			sql:    "DROP TABLE IF EXISTS [Old Orders]",
+			kind:   SQLDDL,
+			verb:   "DROP",
+			tables: []string{"Old Orders"},
+			target: "Old Orders",
+			writes: true,
+		},
+		{
+			name:   "quoted identifiers",
+			sql:    "SELECT \"a\"\"b\" FROM \"My \"\"Table\"\"\" JOIN `Other;Table` ON 1 JOIN [x y] ON 1",
+			kind:   SQLRead,
+			verb:   "SELECT",
+			tables: []string{"My \"Table\"", "Other;Table", "x y"},
+			source: "My \"Table\"",
+		},
+		{
+			name:   "comments",
+			sql:    "-- from Skipped;\n/* select * from Hidden; */ SELECT * /* ; */ FROM Visible -- ; trailing",
+			kind:   SQLRead,
+			verb:   "SELECT",
+			tables: []string{"Visible"},
+			source: "Visible",
+		},
+		{
+			name:   "pragma assignment",
+			sql:    "PRAGMA main.journal_mode = WAL",
+			kind:   SQLPragma,
+			verb:   "PRAGMA",
+			pragma: "journal_mode",
+			writes: true,
+		},
+		{
+			name:   "pragma call",
+			sql:    "PRAGMA table_info(Customer)",
+			kind:   SQLPragma,
+			verb:   "PRAGMA",
+			tables: []string{"Customer"},
+			target: "Customer",
+			pragma: "table_info",
+		},
+		{
+			name:   "pragma query",
+			sql:    "pragma user_version",
+			kind:   SQLPragma,
+			verb:   "PRAGMA",
+			pragma: "user_version",
+		},
+		{
+			name:   "explain",
+			sql:    "EXPLAIN QUERY PLAN SELECT * FROM Customer",
+			kind:   SQLRead,
+			verb:   "SELECT",
+			tables: []string{"Customer"},
+			source: "Customer",
+		},
+		{
+			name:   "vacuum",
+			sql:    "VACUUM",
+			kind:   SQLOther,
+			verb:   "VACUUM",
+			writes: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got := AnalyzeSQL(tt.sql)
+			if len(got) != 1 {
+				t.Fatalf("got %d statements; want 1", len(got))
+			}
+			st := got[0]
+
+			if st.Kind != tt.kind {
+				t.Errorf("Kind = %v; want %v", st.Kind, tt.kind)
+			}
+			if st.Verb != tt.verb {
+				t.Errorf("Verb = %q; want %q", st.Verb, tt.verb)
+			}
+			if !reflect.DeepEqual(st.Tables, tt.tables) {
+				t.Errorf("Tables = %q; want %q", st.Tables, tt.tables)
+			}
+			if st.Target != tt.target {
+				t.Errorf("Target = %q; want %q", st.Target, tt.target)
+			}
+			if st.Source != tt.source {
+				t.Errorf("Source = %q; want %q", st.Source, tt.source)
+			}
+			if st.Pragma != tt.pragma {
+				t.Errorf("Pragma = %q; want %q", st.Pragma, tt.pragma)
+			}
+			if st.Writes() != tt.writes {
+				t.Errorf("Writes() = %v; want %v", st.Writes(), tt.writes)
+			}
+		})
+	}
+}
+
+func TestAnalyzeSQLSplit(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		sql   string
+		texts []string
+	}{
+		{
+			name:  "statements",
+			sql:   "CREATE TABLE a (x); INSERT INTO a VALUES (1);\nSELECT * FROM a;",
+			texts: []string{"CREATE TABLE a (x)", "INSERT INTO a VALUES (1)", "SELECT * FROM a"},
+		},
+		{
+			name:  "empty statements",
+			sql:   ";; SELECT 1 ;;",
+			texts: []string{"SELECT 1"},
+		},
+		{
+			name:  "semicolons in literals and comments",
+			sql:   "INSERT INTO a VALUES ('x;y', \"p;q\"); -- c;d\nSELECT 1 /* e;f */;",
+			texts: []string{"INSERT INTO a VALUES ('x;y', \"p;q\")", "SELECT 1"},
+		},
+		{
+			name: "trigger body",
+			sql: "CREATE TEMP TRIGGER t AFTER DELETE ON a BEGIN DELETE FROM b; " +
+				"SELECT CASE WHEN 1 THEN 2 END; END; SELECT 2",
+			texts: []string{
+				"CREATE TEMP TRIGGER t AFTER DELETE ON a BEGIN DELETE FROM b; SELECT CASE WHEN 1 THEN 2 END; END",
+				"SELECT 2",
+			},
+		},
+		{
+			name:  "transaction",
+			sql:   "BEGIN; UPDATE a SET x = 1; END;",
+			texts: []string{"BEGIN", "UPDATE a SET x = 1", "END"},
+		},
+		{
+			name:  "none",
+			sql:   "  -- only a comment\n",
+			texts: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var texts []string
+			for _, st := range AnalyzeSQL(tt.sql) {
+				texts = append(texts, st.Text)
+			}
+
+			if !reflect.DeepEqual(texts, tt.texts) {
+				t.Errorf("got %q; want %q", texts, tt.texts)
+			}
+		})
+	}
+}
+
+func TestTokenizeSQL(t *testing.T) {
+
+	toks := tokenizeSQL("select [a b], 'it''s', x'0F', 1.5e3, :name, ?2, $v, @w, \"q\"\"r\" from t -- c\n")
+
+	want := []struct {
+		kind sqlTokKind
+		val  string
+	}{
+		{tokWord, "select"},
+		{tokQuoted, "a b"},
+		{tokPunct, ","},
+		{tokString, "it's"},
+		{tokPunct, ","},
+		{tokString, "x'0F'"},
+		{tokPunct, ","},
+		{tokNumber, "1.5e3"},
+		{tokPunct, ","},
+		{tokParam, ":name"},
+		{tokPunct, ","},
+		{tokParam, "?2"},
+		{tokPunct, ","},
+		{tokParam, "$v"},
+		{tokPunct, ","},
+		{tokParam, "@w"},
+		{tokPunct, ","},
+		{tokQuoted, "q\"r"},
+		{tokWord, "from"},
+		{tokWord, "t"},
+	}
+
+	if len(toks) != len(want) {
+		t.Fatalf("got %d tokens; want %d: %+v", len(toks), len(want), toks)
+	}
+	for i := range want {
+		if toks[i].kind != want[i].kind || toks[i].val != want[i].val {
+			t.Errorf("token %d = (%d, %q); want (%d, %q)", i, toks[i].kind, toks[i].val, want[i].kind, want[i].val)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package sqlitehench
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// defaultBackupPagesPerStep is the number of pages per backup step, when
+// CloneOptions.PagesPerStep is not set.
+const defaultBackupPagesPerStep = 100
+
+// errBackupNotSupported is returned when the driver connection is not a
+// go-sqlite3 connection; VACUUM INTO is used instead.
+var errBackupNotSupported = errors.New("the driver does not support the backup API")
+
+// CloneDatabaseWithOptions copies one database to the other. In the
+// backup modes, the copy is a consistent snapshot of the source; which
+// may be in use (i.e. a live WAL database). The copy is written to a
+// temporary file that replaces the destination once it is complete; so
+// the destination is left as is, if the copy fails.
+func (dc *DBAccess) CloneDatabaseWithOptions(ctx context.Context, srcFilePath string, destFilePath string, opt CloneOptions) (err error) {
+
+	ctx, done := dc.startRetryScope(ctx, "CloneDatabase", destFilePath)
+	defer func() {
+		err = wrapErr("CloneDatabase", destFilePath, "", err)
+		done(err)
+	}()
+
+	if !fileOrDirExists(srcFilePath) {
+		return &Error{Op: "CloneDatabase", DBFilePath: srcFilePath, Err: ErrDatabaseFileNotExists}
+	}
+
+	if sameFile(srcFilePath, destFilePath) {
+		return errors.New("source and destination cannot be the same")
+	}
+
+	// The copy would replace the encrypted file with a plain one.
+	if dc.sessions.get(destFilePath) != nil {
+		return ErrEncryptedDBOpen
+	}
+
+	if opt.Mode == CloneRows {
+		return dc.cloneRows(ctx, srcFilePath, destFilePath, opt.Notify)
+	}
+
+	tmpFilePath := destFilePath + "-clone.tmp"
+	os.Remove(tmpFilePath)
+	defer os.Remove(tmpFilePath)
+
+	// The source is opened on its own; without the PRAGMA of this
+	// instance (i.e. journal_mode), which would change the source.
+	srcDB, err := sql.Open(dc.driverName, dc.dataSource(srcFilePath))
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+
+	srcConn, err := srcDB.Conn(ctx)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer srcConn.Close()
+
+	busyTimeout := dc.RetryPolicy.BusyTimeout
+	if busyTimeout <= 0 {
+		busyTimeout = 5 * time.Second
+	}
+	if _, err = srcConn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d;", busyTimeout.Milliseconds())); err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	if opt.Mode == CloneBackup {
+		err = dc.backupPages(ctx, srcConn, tmpFilePath, opt)
+	}
+	if opt.Mode == CloneVacuumInto || errors.Is(err, errBackupNotSupported) {
+		err = vacuumInto(ctx, srcConn, tmpFilePath, opt)
+	}
+	if err != nil {
+		return err
+	}
+
+	return dc.replaceDBFile(ctx, tmpFilePath, destFilePath)
+}
+
+// backupPages copies the pages of the source into a new db file with
+// the online backup API.
+func (dc *DBAccess) backupPages(ctx context.Context, srcConn *sql.Conn, destFilePath string, opt CloneOptions) error {
+
+	destDB, err := sql.Open(dc.driverName, destFilePath)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+			dest, ok := destDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errBackupNotSupported
+			}
+			src, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errBackupNotSupported
+			}
+			return runBackup(ctx, dest, src, opt)
+		})
+	})
+}
+
+// runBackup runs the backup steps. When the source is written to by
+// another connection, SQLite restarts the backup on the next step; so
+// the copy is always a consistent snapshot.
+func runBackup(ctx context.Context, dest *sqlite3.SQLiteConn, src *sqlite3.SQLiteConn, opt CloneOptions) error {
+
+	pagesPerStep := opt.PagesPerStep
+	if pagesPerStep == 0 {
+		pagesPerStep = defaultBackupPagesPerStep
+	}
+
+	b, err := dest.Backup("main", src, "main")
+	if err != nil {
+		return err
+	}
+
+	tstart := time.Now()
+	lastCopied := -1
+
+	for {
+
+		if err = ctx.Err(); err != nil {
+			b.Finish()
+			return err
+		}
+
+		// A busy or locked source is not an error; the step
+		// is tried again.
+		done, err := b.Step(pagesPerStep)
+		if err != nil {
+			b.Finish()
+			return err
+		}
+
+		total := b.PageCount()
+		copied := total - b.Remaining()
+
+		progressed := copied != lastCopied
+		if progressed || done {
+			lastCopied = copied
+			notifyCloneProgress(opt, copied, total, time.Since(tstart))
+		}
+
+		if done {
+			break
+		}
+
+		delay := opt.StepDelay
+		if delay <= 0 && !progressed {
+			delay = 10 * time.Millisecond
+		}
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				b.Finish()
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+	}
+
+	return b.Finish()
+}
+
+// vacuumInto copies the source into a new db file with VACUUM INTO.
+func vacuumInto(ctx context.Context, srcConn *sql.Conn, destFilePath string, opt CloneOptions) error {
+
+	tstart := time.Now()
+
+	if _, err := srcConn.ExecContext(ctx, "VACUUM INTO ?", destFilePath); err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	if opt.Progress != nil || opt.Notify != nil {
+		var pageCount int
+		if err := srcConn.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pageCount); err != nil {
+			return ctxErr(ctx, err)
+		}
+		notifyCloneProgress(opt, pageCount, pageCount, time.Since(tstart))
+	}
+
+	return nil
+}
+
+func notifyCloneProgress(opt CloneOptions, copied int, total int, elapsed time.Duration) {
+
+	if opt.Progress == nil && opt.Notify == nil {
+		return
+	}
+
+	p := CloneProgress{
+		PagesCopied: copied,
+		TotalPages:  total,
+		Elapsed:     elapsed,
+		Status: fmt.Sprintf("pages copied => %s of %s, elapsed: %v",
+			formatNumber(int64(copied)), formatNumber(int64(total)), durationToString(elapsed)),
+	}
+
+	if opt.Progress != nil {
+		opt.Progress(p)
+	}
+	if opt.Notify != nil {
+		opt.Notify(p.Status)
+	}
+}
+
+// replaceDBFile moves a db file over another; the -wal and -shm files of
+// the replaced file are removed, as they do not belong to the new file.
+// It fails if the replaced file has a WAL or a rollback journal with
+// content (see releaseDBFile); whose changes would be lost.
+func (d *DBAccess) replaceDBFile(ctx context.Context, srcFilePath string, destFilePath string) error {
+
+	// The new file is synced before the rename, and its directory
+	// after it (as in writeFileAtomic); so that a crash does not leave
+	// a partly written file in place of the database.
+	f, err := os.OpenFile(srcFilePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = d.releaseDBFile(ctx, destFilePath); err != nil {
+		return err
+	}
+
+	if err = os.Rename(srcFilePath, destFilePath); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(destFilePath))
+
+	return nil
+}
+
+// releaseDBFile closes the pooled handle of a db file; so that its WAL is
+// checkpointed, and the file can be replaced. A handle that is in use (of
+// a running operation, or an open Cursor) is waited for, up to the
+// BusyTimeout of the RetryPolicy (5 seconds by default) or until the ctx
+// is done; ErrDatabaseIsLocked is returned if it is still open. It fails
+// if the file has a WAL or a rollback journal with content; i.e. it is
+// open in another process, whose changes would be lost.
+func (d *DBAccess) releaseDBFile(ctx context.Context, dbFilePath string) error {
+
+	if d.sessions.get(dbFilePath) != nil {
+		return ErrEncryptedDBOpen
+	}
+
+	d.CloseDB(dbFilePath)
+
+	if d.pool != nil {
+		timeout := d.RetryPolicy.BusyTimeout
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		if err := d.pool.waitClosed(ctx, dbFilePath, timeout); err != nil {
+			return err
+		}
+	}
+
+	for _, suffix := range []string{"-wal", "-journal"} {
+		fi, err := os.Stat(dbFilePath + suffix)
+		if err == nil && fi.Size() > 0 {
+			return fmt.Errorf("the database has a %s file; it may be open in another process", suffix)
+		}
+	}
+
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		os.Remove(dbFilePath + suffix)
+	}
+
+	return nil
+}
+
+// syncDir syncs a directory; a rename is durable once its directory is
+// synced, which is not supported on every platform.
+func syncDir(dirPath string) {
+
+	if dir, err := os.Open(dirPath); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+}
+
+// sameFile reports whether two paths are the same file.
+func sameFile(path1 string, path2 string) bool {
+
+	if path1 == path2 {
+		return true
+	}
+
+	fi1, err1 := os.Stat(path1)
+	fi2, err2 := os.Stat(path2)
+	if err1 == nil && err2 == nil {
+		return os.SameFile(fi1, fi2)
+	}
+
+	abs1, err1 := filepath.Abs(path1)
+	abs2, err2 := filepath.Abs(path2)
+
+	return err1 == nil && err2 == nil && abs1 == abs2
+}
@@ -0,0 +1,114 @@
+package sqlitehench
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// backupTest returns the path of a db file with the table t of n rows,
+// and the index of its v column.
+func backupTest(t *testing.T, d *DBAccess, n int) string {
+
+	t.Helper()
+
+	return testDB(t, d, "src.sqlite", []string{
+		"CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT)",
+		"CREATE INDEX ix_t_v ON t (v)",
+		fmt.Sprintf(`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < %d)
+			INSERT INTO t (id, v) SELECT i, printf('row-%%06d', i) FROM n`, n),
+	}, "")
+}
+
+func TestCloneDatabase(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	srcFilePath := backupTest(t, d, 2500)
+
+	for _, mode := range []CloneMode{CloneBackup, CloneVacuumInto, CloneRows} {
+
+		destFilePath := filepath.Join(t.TempDir(), fmt.Sprintf("clone-%d.sqlite", mode))
+
+		// The clone replaces the file that is there.
+		if _, err := d.ExecuteNonQuery("CREATE TABLE old (id INTEGER)", destFilePath); err != nil {
+			t.Fatal(err)
+		}
+
+		var calls int
+		var last CloneProgress
+		err := d.CloneDatabaseWithOptions(context.Background(), srcFilePath, destFilePath, CloneOptions{
+			Mode:         mode,
+			PagesPerStep: 5,
+			Progress: func(p CloneProgress) {
+				calls++
+				last = p
+			},
+			Notify: func(string) {},
+		})
+		if err != nil {
+			t.Fatalf("mode %d: %v", mode, err)
+		}
+
+		if n := rowCount(t, d, "t", destFilePath); n != 2500 {
+			t.Errorf("mode %d: the clone has %d rows; want 2500", mode, n)
+		}
+		info, err := d.GetTableInfo(destFilePath, "old")
+		if err == nil {
+			t.Errorf("mode %d: the replaced file is kept: %v", mode, info)
+		}
+
+		if mode == CloneBackup && (calls < 2 || last.PagesCopied != last.TotalPages) {
+			t.Errorf("mode %d: %d progress calls; the last %+v", mode, calls, last)
+		}
+
+		// No temporary file is left behind.
+		if _, err = os.Stat(destFilePath + "-clone.tmp"); !os.IsNotExist(err) {
+			t.Errorf("mode %d: the temporary file is left: %v", mode, err)
+		}
+	}
+}
+
+func TestCloneDatabaseWAL(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{ConnPolicy: ConnPolicyPooled})
+	defer d.Close()
+
+	srcFilePath := backupTest(t, d, 10)
+
+	// The rows that are in the WAL of the source (i.e. not yet
+	// checkpointed) are cloned.
+	if _, err := d.ExecuteNonQuery("INSERT INTO t (id, v) VALUES (11, 'wal')", srcFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(srcFilePath + "-wal"); err != nil || fi.Size() == 0 {
+		t.Skip("the source has no WAL")
+	}
+
+	destFilePath := filepath.Join(t.TempDir(), "clone.sqlite")
+	if err := d.CloneDatabase(srcFilePath, destFilePath, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := rowCount(t, d, "t", destFilePath); n != 11 {
+		t.Errorf("the clone has %d rows; want 11", n)
+	}
+}
+
+func TestCloneDatabaseSameFile(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	srcFilePath := backupTest(t, d, 1)
+	other := filepath.Join(filepath.Dir(srcFilePath), ".", "src.sqlite")
+
+	if err := d.CloneDatabase(srcFilePath, other, nil); err == nil {
+		t.Error("cloned a database onto itself")
+	}
+	if err := d.CloneDatabase(srcFilePath+"x", other+"y", nil); err == nil {
+		t.Error("cloned a database that does not exist")
+	}
+}
@@ -0,0 +1,363 @@
+package sqlitehench
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// defaultBulkBatchSize is the number of rows per transaction, when
+// BulkInsertOptions.BatchSize is not set.
+const defaultBulkBatchSize = 10000
+
+// BulkInsertWithOptions inserts a DataTable into a database; on one
+// connection, with one prepared INSERT statement, and opt.BatchSize rows
+// per transaction. It returns the number of rows inserted. The batches
+// that have been committed remain, if the insert fails (or the ctx is
+// cancelled) part way.
+func (dc *DBAccess) BulkInsertWithOptions(ctx context.Context, dtSrc *collc.Table, dbFilePath string, opt BulkInsertOptions) (_ int64, err error) {
+
+	ctx, done := dc.startRetryScope(ctx, "BulkInsert", dbFilePath)
+	defer func() {
+		err = wrapErr("BulkInsert", dbFilePath, "", err)
+		done(err)
+	}()
+
+	if dtSrc == nil || dtSrc.Rows.Count() < 1 {
+		return -1, fmt.Errorf("source data-table has no rows; %w", ErrNoRowsFound)
+	}
+
+	// The instance itself is used (i.e. not one with its own PRAGMA);
+	// so that its pool, and its EncryptedDB sessions, apply.
+	if opt.KeepTable {
+		err = dc.validateInsertEntry(ctx, dtSrc, dbFilePath)
+	} else {
+		_, err = dc.CreateNewDatabaseContext(ctx, dtSrc, dbFilePath)
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	db, release, err := dc.acquireDB(dbFilePath, true)
+	if err != nil {
+		return -1, err
+	}
+	defer release()
+
+	return dc.writeTableRows(ctx, db, dtSrc, opt)
+}
+
+// bulkBatch holds the bind values of a batch of rows; or the error
+// that occurred while preparing them.
+type bulkBatch struct {
+	vals [][]interface{}
+	err  error
+}
+
+// writeTableRows inserts the rows of a DataTable into the table of the
+// same name; the DataTable columns that do not exist in the table are
+// skipped. Each batch runs in its own transaction, which is retried as
+// a whole under the RetryPolicy.
+func (d *DBAccess) writeTableRows(ctx context.Context, db *sql.DB, t *collc.Table, opt BulkInsertOptions) (int64, error) {
+
+	batchSize := opt.BatchSize
+	if batchSize < 1 {
+		batchSize = defaultBulkBatchSize
+	}
+
+	tName := strings.Trim(t.Name, " ")
+	if !strings.HasPrefix(tName, "[") {
+		tName = fmt.Sprintf("[%s]", tName)
+	}
+
+	// One connection for the whole insert; so that the prepared
+	// statement is reused by every batch.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return -1, ctxErr(ctx, err)
+	}
+	defer conn.Close()
+
+	srcCols, sqlx, err := bulkInsertStatement(ctx, conn, t, tName)
+	if err != nil {
+		return -1, err
+	}
+
+	stmt, err := conn.PrepareContext(ctx, sqlx)
+	if err != nil {
+		return -1, ctxErr(ctx, err)
+	}
+	defer stmt.Close()
+
+	// The ciphers of the encrypted columns; by their index in srcCols.
+	var ciphers map[int]*columnCipher
+	if c := d.columnCrypter(); c != nil {
+		ciphers = make(map[int]*columnCipher)
+		for j := 0; j < len(srcCols); j++ {
+			if c.lookup(tName, srcCols[j]) == nil {
+				continue
+			}
+			if ciphers[j], err = c.cipher(tName, srcCols[j]); err != nil {
+				return -1, err
+			}
+		}
+	}
+
+	rowArry := t.Rows.GetRows()
+	rowCount := len(rowArry)
+	batchCount := (rowCount + batchSize - 1) / batchSize
+
+	prepare := func(b int) bulkBatch {
+		from := b * batchSize
+		to := from + batchSize
+		if to > rowCount {
+			to = rowCount
+		}
+		batch := prepareBulkBatch(rowArry[from:to], srcCols)
+		for i := 0; i < len(batch.vals) && batch.err == nil; i++ {
+			for j, cc := range ciphers {
+				if batch.vals[i][j], batch.err = cc.encrypt(batch.vals[i][j]); batch.err != nil {
+					break
+				}
+			}
+		}
+		return batch
+	}
+
+	// The batches are prepared ahead by the workers; and are taken
+	// in order from the queue.
+	var queue chan chan bulkBatch
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if opt.Workers > 1 {
+		queue = make(chan chan bulkBatch, opt.Workers)
+		go func() {
+			defer close(queue)
+			sem := make(chan struct{}, opt.Workers)
+			for b := 0; b < batchCount; b++ {
+				c := make(chan bulkBatch, 1)
+				select {
+				case queue <- c:
+				case <-stop:
+					return
+				}
+				sem <- struct{}{}
+				go func(b int) {
+					c <- prepare(b)
+					<-sem
+				}(b)
+			}
+		}()
+	}
+
+	var allRowsAffected int64
+	tstart := time.Now()
+	fmtTblRecCnt := formatNumber(int64(rowCount))
+
+	for b := 0; b < batchCount; b++ {
+
+		if err = ctx.Err(); err != nil {
+			return allRowsAffected, err
+		}
+
+		var batch bulkBatch
+		if opt.Workers > 1 {
+			batch = <-<-queue
+		} else {
+			batch = prepare(b)
+		}
+		if batch.err != nil {
+			return allRowsAffected, batch.err
+		}
+
+		var rowsAffected int64
+		err = d.withRetry(ctx, func() error {
+			rowsAffected, err = execBulkBatch(ctx, conn, stmt, batch.vals)
+			return err
+		})
+		if err != nil {
+			return allRowsAffected, err
+		}
+		allRowsAffected += rowsAffected
+
+		if opt.Notify != nil {
+			elapsed := time.Since(tstart)
+			p := BulkInsertProgress{
+				RowsInserted: allRowsAffected,
+				TotalRows:    int64(rowCount),
+				Elapsed:      elapsed,
+			}
+			if elapsed > 0 {
+				p.RowsPerSec = float64(allRowsAffected) / elapsed.Seconds()
+			}
+			p.Status = fmt.Sprintf("copied => rows: %s of %s, elapsed: %v, rows/sec: %s",
+				formatNumber(allRowsAffected), fmtTblRecCnt, durationToString(elapsed), formatNumber(int64(p.RowsPerSec)))
+			opt.Notify(p)
+		}
+	}
+
+	return allRowsAffected, nil
+}
+
+// bulkInsertStatement returns the DataTable columns that exist in the
+// database table, and the INSERT statement for them.
+func bulkInsertStatement(ctx context.Context, conn *sql.Conn, t *collc.Table, tName string) ([]string, string, error) {
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("select * from %s limit 0", tName))
+	if err != nil {
+		return nil, "", ctxErr(ctx, err)
+	}
+	destCols, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	cols := t.Cols.Get()
+
+	var srcCols []string
+	var inserts []string
+	for i := 0; i < len(cols); i++ {
+		// skip - if the col name from source, does not exist in the database.
+		if !arryElmExistsIgnoreCase(destCols, cols[i].Name) {
+			continue
+		}
+		srcCols = append(srcCols, cols[i].Name)
+		inserts = append(inserts, fmt.Sprintf("[%s]", strings.Trim(cols[i].Name, "[]")))
+	}
+
+	if len(srcCols) == 0 {
+		return nil, "", fmt.Errorf("none of the DataTable columns exist in table %s", tName)
+	}
+
+	sqlx := fmt.Sprintf("insert into %s (%s) values(%s)", tName, strings.Join(inserts, ","),
+		strings.TrimSuffix(strings.Repeat("?,", len(inserts)), ","))
+
+	return srcCols, sqlx, nil
+}
+
+func arryElmExistsIgnoreCase(arry []string, item string) bool {
+	item = strings.Trim(item, "[]")
+	for i := 0; i < len(arry); i++ {
+		if strings.EqualFold(arry[i], item) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareBulkBatch returns the bind values of rows; the rows that have
+// no non-NULL value are skipped. A value that cannot be bound is the
+// error of the batch.
+func prepareBulkBatch(rows []map[string]interface{}, cols []string) bulkBatch {
+
+	var batch bulkBatch
+
+	batch.vals = make([][]interface{}, 0, len(rows))
+
+	for i := 0; i < len(rows); i++ {
+		v := make([]interface{}, len(cols))
+		atleastOneNoneNULL := false
+		for j := 0; j < len(cols); j++ {
+			if v[j], batch.err = bindValue(rows[i][cols[j]]); batch.err != nil {
+				batch.err = fmt.Errorf("column %s: %w", cols[j], batch.err)
+				return batch
+			}
+			if v[j] != nil {
+				atleastOneNoneNULL = true
+			}
+		}
+		if atleastOneNoneNULL {
+			batch.vals = append(batch.vals, v)
+		}
+	}
+
+	return batch
+}
+
+// bindValue returns a value that database/sql can bind; i.e. an int64 of
+// an int32, or the string of a named string type. An error is returned
+// for a uint64 that is above math.MaxInt64, and for the kinds that have
+// no SQLite type (i.e. a map, a struct or a slice); rather than storing
+// them as their text.
+func bindValue(v interface{}) (interface{}, error) {
+
+	switch v.(type) {
+	case nil, int64, float64, bool, []byte, string, time.Time, driver.Valuer:
+		return v, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return bindValue(rv.Elem().Interface())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows an INTEGER (int64)", rv.Uint())
+		}
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Slice:
+		// i.e. json.RawMessage
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return rv.Bytes(), nil
+		}
+	}
+
+	return nil, fmt.Errorf("a value of type %T cannot be bound", v)
+}
+
+// execBulkBatch inserts a batch of rows in one transaction; it is
+// rolled back on failure.
+func execBulkBatch(ctx context.Context, conn *sql.Conn, stmt *sql.Stmt, vals [][]interface{}) (int64, error) {
+
+	if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return -1, ctxErr(ctx, err)
+	}
+
+	rollback := func() {
+		// The ctx may have been cancelled.
+		conn.ExecContext(context.Background(), "ROLLBACK")
+	}
+
+	var rowsAffected int64
+
+	for i := 0; i < len(vals); i++ {
+		result, err := stmt.ExecContext(ctx, vals[i]...)
+		if err != nil {
+			rollback()
+			return -1, ctxErr(ctx, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			rollback()
+			return -1, err
+		}
+		rowsAffected += n
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		rollback()
+		return -1, ctxErr(ctx, err)
+	}
+
+	return rowsAffected, nil
+}
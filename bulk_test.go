@@ -0,0 +1,192 @@
+package sqlitehench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// bulkTable returns a DataTable t of n rows; with the columns id and v,
+// and the extra columns.
+func bulkTable(n int, extra ...string) *collc.Table {
+
+	tbl, _ := collc.NewCollection().Table.Create("t")
+	tbl.Cols.Add("id")
+	tbl.Cols.Add("v")
+	for i := 0; i < len(extra); i++ {
+		tbl.Cols.Add(extra[i])
+	}
+	for i := 1; i <= n; i++ {
+		r := tbl.Rows.New()
+		r["id"] = int64(i)
+		r["v"] = "x"
+	}
+
+	return tbl
+}
+
+func TestBulkInsertWithOptions(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "bulk.sqlite")
+
+	for _, workers := range []int{0, 3} {
+
+		var progress []BulkInsertProgress
+		n, err := d.BulkInsertWithOptions(context.Background(), bulkTable(2500), dbFilePath, BulkInsertOptions{
+			BatchSize: 1000,
+			Workers:   workers,
+			Notify:    func(p BulkInsertProgress) { progress = append(progress, p) },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2500 {
+			t.Errorf("workers %d: inserted %d rows", workers, n)
+		}
+
+		// The table is created again; not added to.
+		if c := rowCount(t, d, "t", dbFilePath); c != 2500 {
+			t.Errorf("workers %d: the table has %d rows", workers, c)
+		}
+		if s, err := ScalarAs[int64](d, "SELECT sum(id) FROM t", dbFilePath); err != nil || s != 2500*2501/2 {
+			t.Errorf("workers %d: the sum of the ids is %d, %v", workers, s, err)
+		}
+
+		if len(progress) != 3 {
+			t.Fatalf("workers %d: got %d notifications", workers, len(progress))
+		}
+		for i, want := range []int64{1000, 2000, 2500} {
+			if progress[i].RowsInserted != want || progress[i].TotalRows != 2500 {
+				t.Errorf("workers %d: notification %d: %+v", workers, i, progress[i])
+			}
+		}
+	}
+}
+
+func TestBulkInsertKeepTable(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "bulk.sqlite")
+	if _, err := d.ExecuteNonQuery("CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT, at DATETIME)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// The columns that the table does not have are skipped; and so
+	// are the rows that have no value.
+	tbl := bulkTable(3, "extra", "at")
+	rows := tbl.Rows.GetRows()
+	rows[0]["extra"] = "dropped"
+	rows[1]["at"] = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	empty := tbl.Rows.New()
+	empty["v"] = nil
+
+	n, err := d.BulkInsertWithOptions(context.Background(), tbl, dbFilePath, BulkInsertOptions{KeepTable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("inserted %d rows; want 3", n)
+	}
+
+	at, err := ScalarAs[time.Time](d, "SELECT at FROM t WHERE id = 2", dbFilePath)
+	if err != nil || !at.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("got %v, %v", at, err)
+	}
+
+	// A failed batch is rolled back.
+	if _, err = d.BulkInsertWithOptions(context.Background(), bulkTable(3), dbFilePath, BulkInsertOptions{KeepTable: true}); !errors.Is(err, ErrConstraint) {
+		t.Errorf("got %v; want %v", err, ErrConstraint)
+	}
+	if c := rowCount(t, d, "t", dbFilePath); c != 3 {
+		t.Errorf("the table has %d rows; want 3", c)
+	}
+}
+
+func TestBulkInsertCancel(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "bulk.sqlite")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The batches that have been committed remain.
+	n, err := d.BulkInsertWithOptions(ctx, bulkTable(50), dbFilePath, BulkInsertOptions{
+		BatchSize: 20,
+		Workers:   2,
+		Notify:    func(BulkInsertProgress) { cancel() },
+	})
+	if !errors.Is(err, context.Canceled) || n != 20 {
+		t.Errorf("got %d, %v", n, err)
+	}
+	if c := rowCount(t, d, "t", dbFilePath); c != 20 {
+		t.Errorf("the table has %d rows; want 20", c)
+	}
+
+	if _, err = d.BulkInsertWithOptions(context.Background(), bulkTable(0), dbFilePath, BulkInsertOptions{}); !errors.Is(err, ErrNoRowsFound) {
+		t.Errorf("got %v; want %v", err, ErrNoRowsFound)
+	}
+}
+
+func TestBindValue(t *testing.T) {
+
+	type code string
+	s := "p"
+	var nilPtr *int
+
+	tests := []struct {
+		in   interface{}
+		want interface{}
+	}{
+		{nil, nil},
+		{3, int64(3)},
+		{uint8(4), int64(4)},
+		{uint64(math.MaxInt64), int64(math.MaxInt64)},
+		{float32(1.5), 1.5},
+		{true, true},
+		{code("c"), "c"},
+		{&s, "p"},
+		{nilPtr, nil},
+		{[]byte{1}, []byte{1}},
+		{json.RawMessage(`{}`), []byte(`{}`)},
+	}
+
+	for _, tt := range tests {
+		if got, err := bindValue(tt.in); err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bindValue(%#v) = %#v, %v; want %#v", tt.in, got, err, tt.want)
+		}
+	}
+
+	// The values that would be stored as something else are refused.
+	for _, in := range []interface{}{uint64(math.MaxInt64) + 1, []int{1, 2}, map[string]int{"a": 1}, struct{ A int }{1}} {
+		if got, err := bindValue(in); err == nil {
+			t.Errorf("bindValue(%#v) = %#v; want an error", in, got)
+		}
+	}
+
+	// And are the error of the batch.
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "bulk.sqlite")
+	tbl := bulkTable(3)
+	tbl.Rows.GetRows()[2]["v"] = uint64(math.MaxUint64)
+	if _, err := d.BulkInsertWithOptions(context.Background(), tbl, dbFilePath, BulkInsertOptions{}); err == nil || !strings.Contains(err.Error(), "overflows") {
+		t.Errorf("got %v; want an overflow error", err)
+	}
+}
@@ -0,0 +1,1118 @@
+package sqlitehench
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// colEncPrefix begins the BLOB of an encrypted column value; it is
+// followed by the type of the plain value, the nonce, and the sealed
+// value. The prefix, the type, and the table and column name are the
+// additional data; so that a BLOB does not decrypt in another column.
+var colEncPrefix = []byte("\x00shc1")
+
+// The types of the plain values of the encrypted columns; so that a value
+// is read back as it was written (i.e. an INTEGER as an int64).
+const (
+	colTypeText = 's'
+	colTypeBlob = 'b'
+	colTypeInt  = 'i'
+	colTypeReal = 'f'
+	colTypeTime = 't'
+)
+
+// colTimeFormat is the text of a time.Time; as the driver writes it.
+const colTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// columnCrypter encrypts and decrypts the values of the encrypted columns;
+// a cipher is made once per column, for the life of the crypter (i.e.
+// of the DBAccess).
+type columnCrypter struct {
+	enc ColumnEncryption
+
+	// The crypter is shared by the goroutines of the DBAccess.
+	mu      sync.Mutex
+	ciphers map[string]*columnCipher
+}
+
+type columnCipher struct {
+	col      EncryptedColumn
+	name     []byte
+	gcm      cipher.AEAD
+	nonceKey []byte
+}
+
+// columnCrypter returns the crypter of NewDBAccess; or a new one, if d
+// was not made by NewDBAccess. nil is returned when no column is
+// encrypted.
+func (d *DBAccess) columnCrypter() *columnCrypter {
+
+	if d.crypter != nil {
+		return d.crypter
+	}
+
+	return newColumnCrypter(d.ColumnEncryption)
+}
+
+// newColumnCrypter returns nil, when no column is encrypted.
+func newColumnCrypter(enc ColumnEncryption) *columnCrypter {
+
+	if len(enc.Columns) == 0 {
+		return nil
+	}
+
+	return &columnCrypter{enc: enc, ciphers: make(map[string]*columnCipher)}
+}
+
+// lookup returns the declaration of an encrypted column; nil, if the
+// column is not encrypted.
+func (c *columnCrypter) lookup(table string, column string) *EncryptedColumn {
+
+	table = strings.Trim(table, "[]")
+	column = strings.Trim(column, "[]")
+
+	for i := 0; i < len(c.enc.Columns); i++ {
+		col := &c.enc.Columns[i]
+		if strings.EqualFold(strings.Trim(col.Table, "[]"), table) && strings.EqualFold(strings.Trim(col.Column, "[]"), column) {
+			return col
+		}
+	}
+
+	return nil
+}
+
+// tableOf returns the first of the tables, in which the column is
+// encrypted; "" if it is in none.
+func (c *columnCrypter) tableOf(tables []string, column string) string {
+
+	for i := 0; i < len(tables); i++ {
+		if c.lookup(tables[i], column) != nil {
+			return tables[i]
+		}
+	}
+
+	return ""
+}
+
+func (c *columnCrypter) cipher(table string, column string) (*columnCipher, error) {
+
+	col := c.lookup(table, column)
+	if col == nil {
+		return nil, fmt.Errorf("%s.%s is not an encrypted column", table, column)
+	}
+
+	name := strings.ToLower(strings.Trim(col.Table, "[]") + "." + strings.Trim(col.Column, "[]"))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cc := c.ciphers[name]; cc != nil {
+		return cc, nil
+	}
+
+	if c.enc.Keys == nil {
+		return nil, errors.New("ColumnEncryption.Keys is not set")
+	}
+
+	key, err := c.enc.Keys.ColumnKey(col.Table, col.Column)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return nil, fmt.Errorf("the key of %s must be 16, 24 or 32 bytes; got %d", name, len(key))
+	}
+
+	// The cipher key and the nonce key are derived from the key; so
+	// that neither is used for both.
+	block, err := aes.NewCipher(hmacSum(key, []byte("column cipher"))[:len(key)])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	cc := &columnCipher{col: *col, name: []byte(name), gcm: gcm, nonceKey: hmacSum(key, []byte("column nonce"))}
+	c.ciphers[name] = cc
+
+	return cc, nil
+}
+
+func hmacSum(key []byte, data ...[]byte) []byte {
+
+	h := hmac.New(sha256.New, key)
+	for i := 0; i < len(data); i++ {
+		h.Write(data[i])
+	}
+
+	return h.Sum(nil)
+}
+
+// encrypt returns the BLOB of a value; NULL stays NULL.
+func (cc *columnCipher) encrypt(v interface{}) (interface{}, error) {
+
+	typ, plain, err := encodeColumnValue(v)
+	if err != nil || typ == 0 {
+		return nil, err
+	}
+
+	header := append(append([]byte{}, colEncPrefix...), typ)
+	aad := append(append([]byte{}, header...), cc.name...)
+
+	nonce := make([]byte, cc.gcm.NonceSize())
+	if cc.col.Deterministic {
+		copy(nonce, hmacSum(cc.nonceKey, aad, plain))
+	} else if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(header)+len(nonce)+len(plain)+cc.gcm.Overhead())
+	out = append(append(out, header...), nonce...)
+
+	return cc.gcm.Seal(out, nonce, plain, aad), nil
+}
+
+// decrypt returns the plain value of a BLOB of encrypt.
+func (cc *columnCipher) decrypt(b []byte) (interface{}, error) {
+
+	n := len(colEncPrefix) + 1
+	ns := cc.gcm.NonceSize()
+	if len(b) < n+ns+cc.gcm.Overhead() {
+		return nil, ErrDecrypt
+	}
+
+	aad := append(append([]byte{}, b[:n]...), cc.name...)
+
+	plain, err := cc.gcm.Open(nil, b[n:n+ns], b[n+ns:], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return decodeColumnValue(b[n-1], plain)
+}
+
+// decryptValue returns the plain value of a value that was read from an
+// encrypted column (of one of the tables). The encrypted columns of the
+// name of the result column are tried first; then every encrypted column
+// of the tables, as the name may be an alias (or empty; i.e. the value of
+// ExecuteScalare). A value that is not encrypted (i.e. written before the
+// column was) is returned as is; one that none of them decrypts is
+// ErrDecrypt, so that the ciphertext is never returned.
+func (c *columnCrypter) decryptValue(tables []string, column string, v interface{}) (interface{}, error) {
+
+	b, ok := v.([]byte)
+	if !ok || !bytes.HasPrefix(b, colEncPrefix) {
+		return v, nil
+	}
+
+	if column != "" {
+		if p, ok, err := c.decryptAs(tables, column, b); ok || err != nil {
+			return p, err
+		}
+	}
+
+	if p, ok, err := c.decryptAs(tables, "", b); ok || err != nil {
+		return p, err
+	}
+
+	return nil, ErrDecrypt
+}
+
+// decryptAs decrypts a value with the encrypted columns of the tables (of
+// the name of column, unless it is empty); ok is set, if one of them
+// decrypted it.
+func (c *columnCrypter) decryptAs(tables []string, column string, b []byte) (_ interface{}, ok bool, _ error) {
+
+	for i := 0; i < len(tables); i++ {
+		for k := 0; k < len(c.enc.Columns); k++ {
+			col := c.enc.Columns[k]
+			if !strings.EqualFold(strings.Trim(col.Table, "[]"), strings.Trim(tables[i], "[]")) ||
+				(column != "" && !strings.EqualFold(strings.Trim(col.Column, "[]"), column)) {
+				continue
+			}
+			cc, err := c.cipher(col.Table, col.Column)
+			if err != nil {
+				return nil, false, err
+			}
+			if p, err := cc.decrypt(b); err == nil {
+				return p, true, nil
+			}
+		}
+	}
+
+	return nil, false, nil
+}
+
+// decryptRows decrypts the values of the rows of a query that were read
+// from the encrypted columns (by whichever name); in place.
+func (c *columnCrypter) decryptRows(sqlQuery string, rows []map[string]interface{}) error {
+
+	if c == nil || len(rows) == 0 {
+		return nil
+	}
+
+	tables := statementTables(analyzeSQL(sqlQuery))
+
+	for i := 0; i < len(rows); i++ {
+		for col, v := range rows[i] {
+			v, err := c.decryptValue(tables, col, v)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col, err)
+			}
+			rows[i][col] = v
+		}
+	}
+
+	return nil
+}
+
+// decryptValues decrypts the values of a row of a query (in the order of
+// cols); in place.
+func (c *columnCrypter) decryptValues(tables []string, cols []string, vals []interface{}) error {
+
+	if c == nil {
+		return nil
+	}
+
+	for i := 0; i < len(cols); i++ {
+		v, err := c.decryptValue(tables, cols[i], vals[i])
+		if err != nil {
+			return fmt.Errorf("column %s: %w", cols[i], err)
+		}
+		vals[i] = v
+	}
+
+	return nil
+}
+
+// encryptArgs returns the bind args of a statement; the values that are
+// bound to an encrypted column are encrypted. A parameter is bound to a
+// column in the VALUES of an INSERT with a column list, in SET col = ?,
+// and in a comparison (i.e. col = ?, col IN (?, ...)); where col is of the
+// table that is written to, or of a table of the statement.
+//
+// The encryption fails closed; a statement that uses a table with
+// encrypted columns returns an error (whether it has args or not), if
+// one of its parameters is not bound to a column (but for LIMIT and
+// OFFSET), if a value other than a parameter is written to or compared
+// with an encrypted column (see checkValues), if an encrypted column is
+// compared other than with = or IN (or at all, if it is not
+// Deterministic), or if it has args and is one of several statements.
+func (c *columnCrypter) encryptArgs(sqlText string, args []interface{}) ([]interface{}, error) {
+
+	if c == nil {
+		return args, nil
+	}
+
+	stmts := AnalyzeSQL(sqlText)
+
+	var encTable string
+	for i := 0; i < len(stmts); i++ {
+		tables := statementTables(stmts[i])
+		for k := 0; k < len(tables); k++ {
+			if !c.hasTable(tables[k]) {
+				continue
+			}
+			if encTable == "" {
+				encTable = tables[k]
+			}
+			if err := c.checkValues(stmts[i]); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if encTable == "" || len(args) == 0 {
+		return args, nil
+	}
+	if len(stmts) != 1 {
+		return nil, fmt.Errorf("%s has encrypted columns; its args must be bound in a statement of its own", encTable)
+	}
+
+	tables := statementTables(stmts[0])
+	toks := tokenizeSQL(stmts[0].Text)
+	ords := paramOrdinals(toks)
+
+	params := paramColumns(toks, ords)
+
+	bound := make(map[int]bool)
+	for i := 0; i < len(params); i++ {
+		bound[params[i].tok] = true
+	}
+	for i := 0; i < len(toks); i++ {
+		if toks[i].kind == tokParam && !bound[i] && !isLimitParam(toks, i) {
+			return nil, fmt.Errorf("parameter %d (%s) is not bound to a column; %s has encrypted columns", ords[i], toks[i].val, encTable)
+		}
+	}
+
+	args, err := bindArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	args = append([]interface{}{}, args...)
+
+	done := make(map[int]bool)
+
+	for i := 0; i < len(params); i++ {
+
+		p := params[i]
+
+		table := c.tableOf(tables, p.column)
+		if table == "" {
+			continue
+		}
+
+		k := -1
+		for j := 0; j < len(args) && p.name != ""; j++ {
+			if na, ok := args[j].(sql.NamedArg); ok && na.Name == p.name {
+				k = j
+			}
+		}
+		if k == -1 && p.index > 0 && p.index <= len(args) {
+			if _, ok := args[p.index-1].(sql.NamedArg); !ok {
+				k = p.index - 1
+			}
+		}
+		if k == -1 {
+			continue
+		}
+
+		cc, err := c.cipher(table, p.column)
+		if err != nil {
+			return nil, err
+		}
+		switch p.op {
+		case "":
+		case "=", "==", "!=", "<>", "IN", "NOT IN", "IS", "IS NOT":
+			if !cc.col.Deterministic {
+				return nil, fmt.Errorf("%s.%s is not Deterministic; it cannot be compared with %s", cc.col.Table, cc.col.Column, p.op)
+			}
+		default:
+			return nil, fmt.Errorf("%s.%s is encrypted; it cannot be compared with %s", cc.col.Table, cc.col.Column, p.op)
+		}
+
+		if done[k] {
+			continue
+		}
+		done[k] = true
+
+		if na, ok := args[k].(sql.NamedArg); ok {
+			if na.Value, err = cc.encrypt(na.Value); err != nil {
+				return nil, err
+			}
+			args[k] = na
+		} else if args[k], err = cc.encrypt(args[k]); err != nil {
+			return nil, err
+		}
+	}
+
+	return args, nil
+}
+
+// checkValues returns an error, if a value other than a bind parameter
+// (or NULL) is written to an encrypted column of the statement, or is
+// compared with one; i.e. a literal, an expression, or the rows of an
+// INSERT ... SELECT. The value would be stored, or compared, as plain
+// text; and be read back as is.
+func (c *columnCrypter) checkValues(st SQLStatement) error {
+
+	if st.Kind != SQLRead && st.Kind != SQLWrite {
+		return nil
+	}
+
+	toks := tokenizeSQL(st.Text)
+	tables := statementTables(st)
+
+	if err := c.checkInsert(toks, st.Target); err != nil {
+		return err
+	}
+
+	assign := setAssignments(toks)
+
+	for i := 0; i < len(toks); i++ {
+
+		t := toks[i]
+
+		// UPDATE t SET (a, b) = (...)
+		if t.isKeyword("SET") && i+1 < len(toks) && toks[i+1].isPunct("(") && c.hasTable(st.Target) {
+			return fmt.Errorf("%s has encrypted columns; its columns must be set one by one", st.Target)
+		}
+
+		if (t.kind != tokWord && t.kind != tokQuoted) || (i+1 < len(toks) && toks[i+1].isPunct(".")) {
+			continue
+		}
+
+		if i+1 < len(toks) && assign[i+1] {
+			col := c.lookup(st.Target, t.val)
+			if col != nil && !assignedOperand(toks, i+2, st.Target, t.val) {
+				return fmt.Errorf("%s.%s is encrypted; it can only be set to a bind arg or NULL", col.Table, col.Column)
+			}
+			continue
+		}
+
+		table := c.tableOf(tables, t.val)
+		if table == "" {
+			continue
+		}
+		col := c.lookup(table, t.val)
+
+		// col = x
+		if op, k := rightComparison(toks, i+1); op != "" {
+			if !comparedOperand(toks, k, op, col.Deterministic) {
+				return fmt.Errorf("%s.%s is encrypted; it can only be compared with a bind arg (with = or IN)", col.Table, col.Column)
+			}
+			continue
+		}
+
+		// x = col
+		if i >= 1 && !assign[i-1] {
+			if op, k := leftComparison(toks, i-1); op != "" && !leftOperand(toks, k, op, col.Deterministic) {
+				return fmt.Errorf("%s.%s is encrypted; it can only be compared with a bind arg (with = or IN)", col.Table, col.Column)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkInsert returns an error, if an INSERT writes to an encrypted
+// column of the target other than with a lone parameter (or NULL) of
+// VALUES; i.e. a literal, or INSERT ... SELECT. An INSERT into a table
+// with encrypted columns needs a column list.
+func (c *columnCrypter) checkInsert(toks []sqlToken, target string) error {
+
+	i := 0
+	for i < len(toks) && !toks[i].isKeyword("INTO") {
+		i++
+	}
+	if i == len(toks) || !c.hasTable(target) {
+		return nil
+	}
+
+	j := i + 2
+	if j < len(toks) && toks[j].isPunct(".") {
+		j += 2
+	}
+	if j < len(toks) && toks[j].isKeyword("AS") {
+		j += 2
+	}
+	if j < len(toks) && toks[j].isKeyword("DEFAULT") {
+		return nil
+	}
+	if j >= len(toks) || !toks[j].isPunct("(") {
+		return fmt.Errorf("%s has encrypted columns; an INSERT into it needs a column list", target)
+	}
+
+	var cols []*EncryptedColumn
+	for j++; j < len(toks) && !toks[j].isPunct(")"); j++ {
+		if toks[j].isName() {
+			cols = append(cols, c.lookup(target, toks[j].val))
+		}
+	}
+	j++
+
+	var enc *EncryptedColumn
+	for k := 0; k < len(cols) && enc == nil; k++ {
+		enc = cols[k]
+	}
+	if enc == nil || (j < len(toks) && toks[j].isKeyword("DEFAULT")) {
+		return nil
+	}
+	if j >= len(toks) || !toks[j].isKeyword("VALUES") {
+		return fmt.Errorf("%s.%s is encrypted; it cannot be inserted from a SELECT", enc.Table, enc.Column)
+	}
+	j++
+
+	for j < len(toks) && toks[j].isPunct("(") {
+		k := 0
+		depth := 0
+		j++
+		elemStart := j
+		for ; j < len(toks); j++ {
+			t := toks[j]
+			if t.isPunct("(") {
+				depth++
+			} else if t.isPunct(")") && depth > 0 {
+				depth--
+			} else if depth == 0 && (t.isPunct(",") || t.isPunct(")")) {
+				if k < len(cols) && cols[k] != nil && (j-elemStart != 1 || !(toks[elemStart].kind == tokParam || toks[elemStart].isKeyword("NULL"))) {
+					return fmt.Errorf("%s.%s is encrypted; it can only be inserted as a bind arg or NULL", cols[k].Table, cols[k].Column)
+				}
+				if t.isPunct(")") {
+					break
+				}
+				k++
+				elemStart = j + 1
+			}
+		}
+		j++
+		if j >= len(toks) || !toks[j].isPunct(",") {
+			break
+		}
+		j++
+	}
+
+	return nil
+}
+
+// assignedOperand reports whether the operand at i can be assigned to an
+// encrypted column; a lone parameter, NULL, or the column itself (i.e.
+// excluded.col of an upsert).
+func assignedOperand(toks []sqlToken, i int, table string, column string) bool {
+
+	if i >= len(toks) {
+		return false
+	}
+
+	t := toks[i]
+
+	switch {
+	case t.kind == tokParam || t.isKeyword("NULL"):
+		return endsOperand(toks, i+1)
+	case i+2 < len(toks) && toks[i+1].isPunct(".") && (t.isKeyword("excluded") || strings.EqualFold(t.val, strings.Trim(table, "[]"))):
+		return sameColumn(toks[i+2], column) && endsOperand(toks, i+3)
+	}
+
+	return sameColumn(t, column) && endsOperand(toks, i+1)
+}
+
+func sameColumn(t sqlToken, column string) bool {
+	return (t.kind == tokWord || t.kind == tokQuoted) && strings.EqualFold(t.val, column)
+}
+
+// rightComparison returns the comparison operator at i, and the position
+// of its right operand; "" if there is none.
+func rightComparison(toks []sqlToken, i int) (string, int) {
+
+	if i >= len(toks) {
+		return "", i
+	}
+
+	t := toks[i]
+
+	if t.kind == tokPunct {
+		switch t.val {
+		case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			return t.val, i + 1
+		}
+		return "", i
+	}
+
+	w := strings.ToUpper(t.val)
+
+	switch {
+	case t.kind != tokWord:
+	case w == "IS" && i+1 < len(toks) && toks[i+1].isKeyword("NOT"):
+		return "IS NOT", i + 2
+	case w == "NOT" && i+1 < len(toks) && toks[i+1].kind == tokWord:
+		if op, k := rightComparison(toks, i+1); op != "" && op != "IS" && op != "IS NOT" {
+			return "NOT " + op, k
+		}
+	case w == "IS" || w == "IN" || w == "LIKE" || w == "GLOB" || w == "REGEXP" || w == "MATCH" || w == "BETWEEN":
+		return w, i + 1
+	}
+
+	return "", i
+}
+
+// leftComparison returns the comparison operator that ends at i, and the
+// position of its left operand; "" if there is none.
+func leftComparison(toks []sqlToken, i int) (string, int) {
+
+	t := toks[i]
+
+	if t.kind == tokPunct {
+		switch t.val {
+		case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			return t.val, i - 1
+		}
+		return "", i
+	}
+
+	if t.isKeyword("NOT") && i >= 1 && toks[i-1].isKeyword("IS") {
+		return "IS NOT", i - 2
+	}
+
+	for _, w := range []string{"IS", "LIKE", "GLOB", "REGEXP", "MATCH"} {
+		if t.isKeyword(w) {
+			if w != "IS" && i >= 1 && toks[i-1].isKeyword("NOT") {
+				return "NOT " + w, i - 2
+			}
+			return w, i - 1
+		}
+	}
+
+	return "", i
+}
+
+// isEqualityOp reports whether an encrypted column can be compared with
+// the operator; as its BLOBs are only equal for equal values.
+func isEqualityOp(op string) bool {
+
+	switch op {
+	case "=", "==", "!=", "<>", "IS", "IS NOT", "IN", "NOT IN":
+		return true
+	}
+
+	return false
+}
+
+// comparedOperand reports whether the right operand (at i) of a comparison
+// of an encrypted column is allowed; a lone parameter, NULL, a column (if
+// the encrypted column is Deterministic), or a list of parameters of IN.
+func comparedOperand(toks []sqlToken, i int, op string, deterministic bool) bool {
+
+	if !isEqualityOp(op) || i >= len(toks) {
+		return false
+	}
+
+	if op == "IN" || op == "NOT IN" {
+		if !toks[i].isPunct("(") {
+			return false
+		}
+		for i++; i+1 < len(toks); i += 2 {
+			if toks[i].kind != tokParam {
+				return false
+			}
+			if toks[i+1].isPunct(")") {
+				return true
+			}
+			if !toks[i+1].isPunct(",") {
+				return false
+			}
+		}
+		return false
+	}
+
+	t := toks[i]
+
+	switch {
+	case t.kind == tokParam || t.isKeyword("NULL"):
+		return endsOperand(toks, i+1)
+	case t.kind != tokWord && t.kind != tokQuoted:
+		return false
+	case t.kind == tokWord && isReservedWord(t.val):
+		return false
+	}
+
+	// A column; i.e. p.email = q.email.
+	if i+2 < len(toks) && toks[i+1].isPunct(".") {
+		i += 2
+	}
+
+	return deterministic && endsOperand(toks, i+1)
+}
+
+// leftOperand reports whether the left operand (ending at i) of a
+// comparison with an encrypted column is allowed; as comparedOperand.
+func leftOperand(toks []sqlToken, i int, op string, deterministic bool) bool {
+
+	if !isEqualityOp(op) || i < 0 {
+		return false
+	}
+
+	t := toks[i]
+
+	switch {
+	case t.kind == tokParam || t.isKeyword("NULL"):
+	case t.kind == tokQuoted || (t.kind == tokWord && !isReservedWord(t.val)):
+		if !deterministic {
+			return false
+		}
+		if i >= 2 && toks[i-1].isPunct(".") {
+			i -= 2
+		}
+	default:
+		return false
+	}
+
+	// The operand is not a part of an expression; i.e. 1 + x = col.
+	return i == 0 || toks[i-1].kind != tokPunct || toks[i-1].isPunct("(") || toks[i-1].isPunct(",")
+}
+
+// hasTable reports whether a column of the table is encrypted.
+func (c *columnCrypter) hasTable(table string) bool {
+
+	table = strings.Trim(table, "[]")
+
+	for i := 0; i < len(c.enc.Columns); i++ {
+		if strings.EqualFold(strings.Trim(c.enc.Columns[i].Table, "[]"), table) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statementTables returns the table that a statement writes to, followed
+// by the tables that it reads.
+func statementTables(st SQLStatement) []string {
+
+	var tables []string
+
+	for _, t := range append([]string{st.Target, st.Source}, st.Tables...) {
+		if t != "" && !arryElmExistsIgnoreCase(tables, t) {
+			tables = append(tables, t)
+		}
+	}
+
+	return tables
+}
+
+// paramColumn is a bind parameter that is bound to a column.
+type paramColumn struct {
+	// tok is the position of the parameter in the tokens; index is its
+	// ordinal, as SQLite numbers them; name is set for a named
+	// parameter (without the prefix).
+	tok    int
+	index  int
+	name   string
+	column string
+
+	// op is the operator of a comparison (i.e. =, IN, LIKE); "" for
+	// an assignment (the VALUES of an INSERT, SET).
+	op string
+}
+
+// paramOrdinals returns the ordinal of each parameter, by its position in
+// the tokens. ? is the next ordinal, ?NNN is NNN, and a named parameter
+// keeps the ordinal of its first appearance.
+func paramOrdinals(toks []sqlToken) map[int]int {
+
+	ords := make(map[int]int)
+	named := make(map[string]int)
+	last := 0
+
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.kind != tokParam {
+			continue
+		}
+		switch {
+		case t.val == "?":
+			last++
+			ords[i] = last
+		case t.val[0] == '?':
+			n, _ := strconv.Atoi(t.val[1:])
+			ords[i] = n
+			if n > last {
+				last = n
+			}
+		default:
+			if _, ok := named[t.val]; !ok {
+				last++
+				named[t.val] = last
+			}
+			ords[i] = named[t.val]
+		}
+	}
+
+	return ords
+}
+
+// paramColumns returns the parameters of a statement that are bound to a
+// column.
+func paramColumns(toks []sqlToken, ords map[int]int) []paramColumn {
+
+	var ret []paramColumn
+
+	add := func(i int, column string, op string) {
+		t := toks[i]
+		if t.kind != tokParam {
+			return
+		}
+		p := paramColumn{tok: i, index: ords[i], column: column, op: op}
+		if t.val[0] != '?' {
+			p.name = trimParamPrefix(t.val)
+		}
+		ret = append(ret, p)
+	}
+
+	// INSERT INTO t (a, b) VALUES (?, ?), (?, ?)
+	for i := 0; i < len(toks); i++ {
+
+		if !toks[i].isKeyword("INTO") {
+			continue
+		}
+
+		j := i + 2
+		if j < len(toks) && toks[j].isPunct(".") {
+			j += 2
+		}
+		if j < len(toks) && toks[j].isKeyword("AS") {
+			j += 2
+		}
+		if j >= len(toks) || !toks[j].isPunct("(") {
+			break
+		}
+
+		var cols []string
+		for j++; j < len(toks) && !toks[j].isPunct(")"); j++ {
+			if toks[j].isName() {
+				cols = append(cols, toks[j].val)
+			}
+		}
+		j++
+		if j >= len(toks) || !toks[j].isKeyword("VALUES") {
+			break
+		}
+		j++
+
+		for j < len(toks) && toks[j].isPunct("(") {
+			k := 0
+			depth := 0
+			j++
+			elemStart := j
+			for ; j < len(toks); j++ {
+				t := toks[j]
+				if t.isPunct("(") {
+					depth++
+				} else if t.isPunct(")") && depth > 0 {
+					depth--
+				} else if depth == 0 && (t.isPunct(",") || t.isPunct(")")) {
+					// Only a lone parameter is bound to the column.
+					if j-elemStart == 1 && k < len(cols) {
+						add(elemStart, cols[k], "")
+					}
+					if t.isPunct(")") {
+						break
+					}
+					k++
+					elemStart = j + 1
+				}
+			}
+			j++
+			if j >= len(toks) || !toks[j].isPunct(",") {
+				break
+			}
+			j++
+		}
+
+		break
+	}
+
+	// SET col = ?, and the comparisons.
+	assign := setAssignments(toks)
+
+	for i := 0; i < len(toks); i++ {
+		if toks[i].kind != tokParam || !endsOperand(toks, i+1) {
+			continue
+		}
+		if i >= 2 && assign[i-1] {
+			add(i, toks[i-2].val, "")
+			continue
+		}
+		if col, op := comparedColumn(toks, i); col != "" {
+			add(i, col, op)
+		}
+	}
+
+	return ret
+}
+
+// comparedColumn returns the column, and the operator, of a comparison of
+// the parameter at i; i.e. col = ?, col IN (?, ?), col BETWEEN ? AND ?.
+// "" is returned if the parameter is not compared with a column.
+func comparedColumn(toks []sqlToken, i int) (string, string) {
+
+	// kw reports whether the token at k is the keyword.
+	kw := func(k int, w string) bool {
+		return k >= 0 && toks[k].isKeyword(w)
+	}
+
+	k := i - 1
+	op := ""
+
+	switch {
+	case k < 0:
+		return "", ""
+
+	case toks[k].kind == tokPunct:
+		switch toks[k].val {
+		case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			op = toks[k].val
+		case "(", ",":
+			// IN (?, ?)
+			for k >= 1 && toks[k].isPunct(",") && toks[k-1].kind == tokParam {
+				k -= 2
+			}
+			if !toks[k].isPunct("(") || !kw(k-1, "IN") {
+				return "", ""
+			}
+			k--
+			op = "IN"
+		default:
+			return "", ""
+		}
+
+	case kw(k, "LIKE") || kw(k, "GLOB") || kw(k, "IS") || kw(k, "BETWEEN"):
+		op = strings.ToUpper(toks[k].val)
+
+	case kw(k, "NOT") && kw(k-1, "IS"):
+		k--
+		op = "IS NOT"
+
+	case kw(k, "AND") && k >= 2 && toks[k-1].kind == tokParam && kw(k-2, "BETWEEN"):
+		k -= 2
+		op = "BETWEEN"
+
+	default:
+		return "", ""
+	}
+
+	if op != "IS NOT" && kw(k-1, "NOT") {
+		k--
+		op = "NOT " + op
+	}
+
+	k--
+	if k < 0 || (toks[k].kind != tokQuoted && (toks[k].kind != tokWord || isReservedWord(toks[k].val))) {
+		return "", ""
+	}
+
+	return toks[k].val, op
+}
+
+// isLimitParam reports whether the parameter at i is the count of LIMIT
+// or OFFSET; which is not bound to a column.
+func isLimitParam(toks []sqlToken, i int) bool {
+
+	if i >= 1 && (toks[i-1].isKeyword("LIMIT") || toks[i-1].isKeyword("OFFSET")) {
+		return true
+	}
+
+	// LIMIT ?, ?
+	return i >= 3 && toks[i-1].isPunct(",") && toks[i-2].kind == tokParam && toks[i-3].isKeyword("LIMIT")
+}
+
+// setAssignments returns the = of the assignments of the SET clauses, by
+// token index; so that they are told from the comparisons.
+func setAssignments(toks []sqlToken) map[int]bool {
+
+	ret := make(map[int]bool)
+
+	depth := 0
+	setDepth := -1
+
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		switch {
+		case t.isPunct("("):
+			depth++
+		case t.isPunct(")"):
+			if depth == setDepth {
+				setDepth = -1
+			}
+			depth--
+		case t.isKeyword("SET"):
+			setDepth = depth
+		case depth != setDepth:
+		case t.isKeyword("WHERE") || t.isKeyword("FROM") || t.isKeyword("RETURNING") || t.isPunct(";"):
+			setDepth = -1
+		case t.isPunct("=") && i >= 2 && toks[i-1].isName() && (toks[i-2].isKeyword("SET") || toks[i-2].isPunct(",")):
+			ret[i] = true
+		}
+	}
+
+	return ret
+}
+
+// endsOperand reports whether the token at i ends an operand; so that i.e.
+// col = ? + 1 is not bound to col.
+func endsOperand(toks []sqlToken, i int) bool {
+
+	if i >= len(toks) {
+		return true
+	}
+
+	t := toks[i]
+
+	if t.kind == tokWord {
+		switch strings.ToUpper(t.val) {
+		case "OFFSET", "WHEN", "THEN", "ELSE", "END":
+			return true
+		}
+	}
+
+	return t.isPunct(",") || t.isPunct(")") || t.isPunct(";") || (t.kind == tokWord && isReservedWord(t.val))
+}
+
+// encodeColumnValue returns the type and the bytes of a value; the type
+// is zero for NULL.
+func encodeColumnValue(v interface{}) (byte, []byte, error) {
+
+	var err error
+
+	if vr, ok := v.(driver.Valuer); ok {
+		if v, err = vr.Value(); err != nil {
+			return 0, nil, err
+		}
+	}
+	if v, err = bindValue(v); err != nil {
+		return 0, nil, err
+	}
+
+	switch x := v.(type) {
+	case nil:
+		return 0, nil, nil
+	case string:
+		return colTypeText, []byte(x), nil
+	case []byte:
+		return colTypeBlob, x, nil
+	case int64:
+		return colTypeInt, binary.BigEndian.AppendUint64(nil, uint64(x)), nil
+	case bool:
+		var n uint64
+		if x {
+			n = 1
+		}
+		return colTypeInt, binary.BigEndian.AppendUint64(nil, n), nil
+	case float64:
+		return colTypeReal, binary.BigEndian.AppendUint64(nil, math.Float64bits(x)), nil
+	case time.Time:
+		return colTypeTime, []byte(x.Format(colTimeFormat)), nil
+	}
+
+	return colTypeText, []byte(fmt.Sprintf("%v", v)), nil
+}
+
+func decodeColumnValue(typ byte, b []byte) (interface{}, error) {
+
+	switch typ {
+	case colTypeText:
+		return string(b), nil
+	case colTypeBlob:
+		return b, nil
+	case colTypeInt, colTypeReal:
+		if len(b) != 8 {
+			return nil, ErrDecrypt
+		}
+		n := binary.BigEndian.Uint64(b)
+		if typ == colTypeInt {
+			return int64(n), nil
+		}
+		return math.Float64frombits(n), nil
+	case colTypeTime:
+		if t, err := time.Parse(colTimeFormat, string(b)); err == nil {
+			return t, nil
+		}
+		return string(b), nil
+	}
+
+	return nil, fmt.Errorf("unknown type of an encrypted value: %q", typ)
+}
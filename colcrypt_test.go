@@ -0,0 +1,331 @@
+package sqlitehench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// colCryptTest returns a DBAccess that encrypts p.email (Deterministic)
+// and p.ssn; and the path of a db file with the table p.
+func colCryptTest(t *testing.T) (*DBAccess, string) {
+
+	t.Helper()
+
+	key := bytes.Repeat([]byte{7}, 32)
+
+	d := NewDBAccess(DBAccess{ColumnEncryption: ColumnEncryption{
+		Keys: KeyProviderFunc(func(table string, column string) ([]byte, error) {
+			return key, nil
+		}),
+		Columns: []EncryptedColumn{
+			{Table: "p", Column: "email", Deterministic: true},
+			{Table: "p", Column: "ssn"},
+		},
+	}})
+	t.Cleanup(func() { d.Close() })
+
+	dbFilePath := filepath.Join(t.TempDir(), "colcrypt.sqlite")
+	if _, err := d.ExecuteNonQuery("CREATE TABLE p (id INTEGER PRIMARY KEY, email TEXT, ssn TEXT, note TEXT)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	return d, dbFilePath
+}
+
+// storedTypes returns the typeof() of the encrypted columns of every
+// row; as a DBAccess without ColumnEncryption reads them.
+func storedTypes(t *testing.T, dbFilePath string) []string {
+
+	t.Helper()
+
+	plain := NewDBAccess(DBAccess{})
+	defer plain.Close()
+
+	rows, err := plain.GetDataMap("SELECT typeof(email) AS e, typeof(ssn) AS s FROM p ORDER BY id", dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ret []string
+	for _, r := range rows {
+		ret = append(ret, r["e"].(string), r["s"].(string))
+	}
+
+	return ret
+}
+
+func TestColumnEncryptionStored(t *testing.T) {
+
+	d, dbFilePath := colCryptTest(t)
+
+	if _, err := d.ExecuteNonQueryWithArgs("INSERT INTO p (id, email, ssn) VALUES (?, ?, ?)", dbFilePath, 1, "a@x.com", "111-11-1111"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ExecuteNonQueryWithArgs("INSERT INTO p (id, email, ssn) VALUES (:id, :email, :ssn)", dbFilePath,
+		map[string]interface{}{"id": 2, "email": "b@x.com", "ssn": "222-22-2222"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ExecuteNonQueryWithArgs("UPDATE p SET ssn = ? WHERE email = ?", dbFilePath, "333-33-3333", "b@x.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.InsertSingleRow(personTable(10, "444-44-4444"), 0, dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.InsertDataTable(personTable(11, "555-55-5555", "666-66-6666"), dbFilePath, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	bulk, _ := collc.NewCollection().Table.Create("p")
+	bulk.Cols.Add("id")
+	bulk.Cols.Add("ssn")
+	r := bulk.Rows.New()
+	r["id"] = int64(20)
+	r["ssn"] = "777-77-7777"
+	if _, err := d.BulkInsertWithOptions(context.Background(), bulk, dbFilePath, BulkInsertOptions{KeepTable: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"blob", "blob", "blob", "blob", "blob", "blob", "blob", "blob", "blob", "blob", "null", "blob"}
+	if got := storedTypes(t, dbFilePath); !equalStrings(got, want) {
+		t.Errorf("stored types %v; want %v", got, want)
+	}
+
+	d.Close()
+	raw, err := os.ReadFile(dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"a@x.com", "111-11-1111", "333-33-3333", "444-44-4444", "666-66-6666", "777-77-7777"} {
+		if bytes.Contains(raw, []byte(s)) {
+			t.Errorf("%q is stored in plain text", s)
+		}
+	}
+
+	// The values are read back as written.
+	m, err := d.GetDataMapWithArgs("SELECT ssn FROM p WHERE email = ?", dbFilePath, "b@x.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 1 || m[0]["ssn"] != "333-33-3333" {
+		t.Errorf("got %v", m)
+	}
+}
+
+func TestColumnEncryptionFailsClosed(t *testing.T) {
+
+	d, dbFilePath := colCryptTest(t)
+
+	tests := []struct {
+		name string
+		sql  string
+		args []interface{}
+	}{
+		{name: "insert without columns", sql: "INSERT INTO p VALUES (?, ?, ?, ?)", args: []interface{}{1, "a@x.com", "111", nil}},
+		{name: "expression in values", sql: "INSERT INTO p (id, ssn) VALUES (?, upper(?))", args: []interface{}{1, "111"}},
+		{name: "expression in set", sql: "UPDATE p SET ssn = upper(?)", args: []interface{}{"111"}},
+		{name: "insert select", sql: "INSERT INTO p (id, ssn) SELECT 1, ?", args: []interface{}{"111"}},
+		{name: "several statements", sql: "INSERT INTO p (id, ssn) VALUES (1, 'x'); UPDATE p SET ssn = ?", args: []interface{}{"111"}},
+		{name: "not deterministic", sql: "SELECT * FROM p WHERE ssn = ?", args: []interface{}{"111"}},
+		{name: "not deterministic in", sql: "SELECT * FROM p WHERE ssn IN (?, ?)", args: []interface{}{"111", "222"}},
+		{name: "like", sql: "SELECT * FROM p WHERE email LIKE ?", args: []interface{}{"a%"}},
+		{name: "order", sql: "SELECT * FROM p WHERE email > ?", args: []interface{}{"a"}},
+		{name: "reversed", sql: "SELECT * FROM p WHERE ? = email", args: []interface{}{"a@x.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := d.ExecuteNonQueryWithArgs(tt.sql, dbFilePath, tt.args...); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+
+	// The statements without args are analyzed as well; a literal is
+	// not written to, nor compared with, an encrypted column.
+	literals := []struct {
+		name string
+		sql  string
+	}{
+		{name: "literal insert", sql: "INSERT INTO p (id, ssn) VALUES (1, '123-45-6789')"},
+		{name: "literal insert of several rows", sql: "INSERT INTO p (id, email) VALUES (1, NULL), (2, 'a@x.com')"},
+		{name: "literal insert without columns", sql: "INSERT INTO p VALUES (1, 'a@x.com', '111', NULL)"},
+		{name: "insert select", sql: "INSERT INTO p (id, ssn) SELECT id, note FROM p"},
+		{name: "insert select without columns", sql: "INSERT INTO p SELECT * FROM p"},
+		{name: "literal update", sql: "UPDATE p SET ssn = '123-45-6789'"},
+		{name: "expression update", sql: "UPDATE p SET note = 'x', email = lower(note) WHERE id = 1"},
+		{name: "other column update", sql: "UPDATE p SET ssn = note"},
+		{name: "row value update", sql: "UPDATE p SET (id, ssn) = (1, '111')"},
+		{name: "literal where", sql: "DELETE FROM p WHERE email = 'a@x.com'"},
+		{name: "reversed literal where", sql: "DELETE FROM p WHERE 'a@x.com' = email"},
+		{name: "literal in", sql: "DELETE FROM p WHERE email IN ('a@x.com', ?)"},
+		{name: "subquery in", sql: "DELETE FROM p WHERE email IN (SELECT note FROM p)"},
+		{name: "literal like", sql: "DELETE FROM p WHERE email LIKE 'a%'"},
+		{name: "not deterministic column", sql: "DELETE FROM p WHERE ssn = note"},
+	}
+
+	for _, tt := range literals {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := d.ExecuteNonQuery(tt.sql, dbFilePath); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+	if _, err := d.GetDataMap("SELECT * FROM p WHERE email = lower('A@X.COM')", dbFilePath); err == nil {
+		t.Error("a read with an expression got no error")
+	}
+
+	if got := storedTypes(t, dbFilePath); len(got) != 0 {
+		t.Errorf("rows were written: %v", got)
+	}
+
+	// NULL, the column itself, and the statements that do not write to
+	// or compare an encrypted column are accepted.
+	noArgs := []string{
+		"INSERT INTO p (id, note) VALUES (1, 'x')",
+		"INSERT INTO p (id, email, ssn) VALUES (2, NULL, NULL)",
+		"INSERT INTO p (id, note) SELECT id + 10, note FROM p",
+		"UPDATE p SET ssn = NULL, note = 'y' WHERE id = 1",
+		"UPDATE p SET email = email WHERE note = 'y'",
+		"INSERT INTO p (id, note) VALUES (1, 'z') ON CONFLICT (id) DO UPDATE SET note = excluded.note, email = excluded.email",
+		"DELETE FROM p WHERE ssn IS NULL AND email IS NOT NULL",
+	}
+	for _, s := range noArgs {
+		if _, err := d.ExecuteNonQuery(s, dbFilePath); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+	if _, err := d.ExecuteNonQuery("DELETE FROM p", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// The parameters that are bound to the columns, or are the LIMIT,
+	// are accepted.
+	ok := []string{
+		"SELECT * FROM p WHERE email = ? AND id > ? LIMIT ? OFFSET ?",
+		"SELECT * FROM p WHERE email IN (?, ?) AND note LIKE ? LIMIT ?, ?",
+		"SELECT * FROM p WHERE id BETWEEN ? AND ? AND email IS NOT ? LIMIT 1",
+	}
+	for _, s := range ok {
+		if _, err := d.GetDataMapWithArgs(s, dbFilePath, 1, 2, 3, 4, 5); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+}
+
+func TestColumnEncryptionReads(t *testing.T) {
+
+	d, dbFilePath := colCryptTest(t)
+
+	for i, v := range []string{"a", "b"} {
+		if _, err := d.ExecuteNonQueryWithArgs("INSERT INTO p (id, email, ssn) VALUES (?, ?, ?)", dbFilePath, i+1, v+"@x.com", v+"-ssn"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type person struct {
+		ID    int
+		Email string
+		SSN   string
+		Note  *string
+	}
+
+	ps, err := Query[person](d, "SELECT * FROM p WHERE email IN (?, ?) ORDER BY id", dbFilePath, "a@x.com", "b@x.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 || ps[0].SSN != "a-ssn" || ps[1].Email != "b@x.com" {
+		t.Errorf("Query: %+v", ps)
+	}
+
+	s, err := ScalarAs[string](d, "SELECT ssn FROM p WHERE email = ?", dbFilePath, "b@x.com")
+	if err != nil || s != "b-ssn" {
+		t.Errorf("ScalarAs: %q, %v", s, err)
+	}
+
+	// The named parameters are encrypted, as the positional ones.
+	dt, err := d.GetDataTableWithArgs("SELECT ssn FROM p WHERE email = :email", dbFilePath, map[string]interface{}{"email": "a@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := dt.Rows.GetRows(); len(rows) != 1 || rows[0]["ssn"] != "a-ssn" {
+		t.Errorf("GetDataTable: %v", rows)
+	}
+
+	var got []string
+	for m, err := range d.IterRows(context.Background(), "SELECT ssn FROM p ORDER BY id", dbFilePath) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, m["ssn"].(string))
+	}
+	if !equalStrings(got, []string{"a-ssn", "b-ssn"}) {
+		t.Errorf("IterRows: %v", got)
+	}
+
+	// An alias, and a self-join, are decrypted as the column.
+	dm, err := d.GetDataMap("SELECT ssn AS s FROM p WHERE id = 1", dbFilePath)
+	if err != nil || len(dm) != 1 || dm[0]["s"] != "a-ssn" {
+		t.Errorf("GetDataMap: %v, %v", dm, err)
+	}
+
+	dt, err = d.GetDataTable("SELECT id, ssn AS s FROM p ORDER BY id", dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := dt.Rows.GetRows(); len(rows) != 2 || rows[0]["s"] != "a-ssn" || rows[1]["s"] != "b-ssn" {
+		t.Errorf("GetDataTable: %v", rows)
+	}
+
+	type pair struct {
+		S  string
+		QS string
+	}
+	pairs, err := Query[pair](d, "SELECT p.ssn AS s, q.ssn AS qs FROM p JOIN p AS q ON q.id = p.id + 1", dbFilePath)
+	if err != nil || len(pairs) != 1 || pairs[0].S != "a-ssn" || pairs[0].QS != "b-ssn" {
+		t.Errorf("Query: %+v, %v", pairs, err)
+	}
+
+	dm, err = d.GetDataMap("SELECT p.email, q.ssn AS qs FROM p, p AS q WHERE q.id = p.id + 1", dbFilePath)
+	if err != nil || len(dm) != 1 || dm[0]["email"] != "a@x.com" || dm[0]["qs"] != "b-ssn" {
+		t.Errorf("GetDataMap: %v, %v", dm, err)
+	}
+
+	// Another key does not decrypt the values.
+	other := NewDBAccess(DBAccess{ColumnEncryption: ColumnEncryption{
+		Keys: KeyProviderFunc(func(table string, column string) ([]byte, error) {
+			return bytes.Repeat([]byte{8}, 32), nil
+		}),
+		Columns: []EncryptedColumn{{Table: "p", Column: "ssn"}},
+	}})
+	defer other.Close()
+
+	for _, sqlQuery := range []string{"SELECT ssn FROM p", "SELECT ssn AS s FROM p"} {
+		if _, err = other.GetDataMap(sqlQuery, dbFilePath); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: got %v; want %v", sqlQuery, err, ErrDecrypt)
+		}
+	}
+}
+
+// personTable returns a DataTable of p; the ids are from id on.
+func personTable(id int64, ssn ...string) *collc.Table {
+
+	tbl, _ := collc.NewCollection().Table.Create("p")
+	tbl.Cols.Add("id")
+	tbl.Cols.Add("email")
+	tbl.Cols.Add("ssn")
+	for i := 0; i < len(ssn); i++ {
+		r := tbl.Rows.New()
+		r["id"] = id + int64(i)
+		r["email"] = ssn[i] + "@x.com"
+		r["ssn"] = ssn[i]
+	}
+
+	return tbl
+}
@@ -0,0 +1,131 @@
+package sqlitehench
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// slowQuery counts to a billion; it runs until it is interrupted.
+const slowQuery = `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000000000)
+	SELECT count(*) FROM n`
+
+func TestContextCancelled(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "ctx.sqlite")
+	if _, err := d.ExecuteNonQuery("CREATE TABLE t (id INTEGER PRIMARY KEY)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	tbl, _ := collc.NewCollection().Table.Create("t")
+	tbl.Cols.Add("id")
+	tbl.Rows.New()["id"] = int64(3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"ExecuteNonQueryContext", func() error {
+			_, err := d.ExecuteNonQueryContext(ctx, "INSERT INTO t VALUES (?)", dbFilePath, 1)
+			return err
+		}},
+		{"ExecuteNonQueryNoTxContext", func() error {
+			_, err := d.ExecuteNonQueryNoTxContext(ctx, "INSERT INTO t VALUES (2)", dbFilePath)
+			return err
+		}},
+		{"ExecuteScalareContext", func() error {
+			_, err := d.ExecuteScalareContext(ctx, "SELECT count(*) FROM t", dbFilePath)
+			return err
+		}},
+		{"GetTableCountContext", func() error {
+			_, err := d.GetTableCountContext(ctx, "t", dbFilePath)
+			return err
+		}},
+		{"GetDataMapContext", func() error {
+			_, err := d.GetDataMapContext(ctx, "SELECT * FROM t", dbFilePath)
+			return err
+		}},
+		{"GetDataMapPageContext", func() error {
+			_, err := d.GetDataMapPageContext(ctx, "SELECT * FROM t", 1, 10, dbFilePath)
+			return err
+		}},
+		{"GetDataTableContext", func() error {
+			_, err := d.GetDataTableContext(ctx, "SELECT * FROM t", dbFilePath)
+			return err
+		}},
+		{"InsertDataTableContext", func() error {
+			_, err := d.InsertDataTableContext(ctx, tbl, dbFilePath, nil)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		if err := tt.fn(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v; want %v", tt.name, err, context.Canceled)
+		}
+	}
+
+	// Nothing is written.
+	if n, err := d.GetTableCount("t", dbFilePath); err != nil || n != 0 {
+		t.Errorf("the table has %d rows, %v", n, err)
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "ctx.sqlite")
+	if _, err := d.ExecuteNonQuery("CREATE TABLE t (id INTEGER PRIMARY KEY)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// The running statement is interrupted.
+	for name, fn := range map[string]func(ctx context.Context) error{
+		"ExecuteScalareContext": func(ctx context.Context) error {
+			_, err := d.ExecuteScalareContext(ctx, slowQuery, dbFilePath)
+			return err
+		},
+		"GetDataMapContext": func(ctx context.Context) error {
+			_, err := d.GetDataMapContext(ctx, slowQuery, dbFilePath)
+			return err
+		},
+		"ExecuteNonQueryContext": func(ctx context.Context) error {
+			_, err := d.ExecuteNonQueryContext(ctx, "INSERT INTO t "+slowQuery, dbFilePath)
+			return err
+		},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+
+		start := time.Now()
+		err := fn(ctx)
+		cancel()
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: got %v; want %v", name, err, context.DeadlineExceeded)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: returned after %v", name, elapsed)
+		}
+	}
+
+	// The interrupted write is rolled back; and the db file remains
+	// usable.
+	if n, err := d.GetTableCount("t", dbFilePath); err != nil || n != 0 {
+		t.Errorf("the table has %d rows, %v", n, err)
+	}
+	if _, err := d.ExecuteNonQuery("INSERT INTO t VALUES (1)", dbFilePath); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,426 @@
+package sqlitehench
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CopyTables copies the selected tables (and rows) of one database to
+// another; i.e. to extract a subset of a database. The destination file
+// is created if it does not exist. A destination table is created from
+// the source table; with its CREATE statement and indexes, unless the
+// table is renamed or its columns are mapped, in which case it is
+// created from the column definitions. Triggers are not copied.
+func (d *DBAccess) CopyTables(srcFilePath string, destFilePath string, opt CopyTablesOptions) ([]CopyTableResult, error) {
+	return d.CopyTablesContext(context.Background(), srcFilePath, destFilePath, opt)
+}
+
+// CopyTablesContext is CopyTables with a ctx.
+func (d *DBAccess) CopyTablesContext(ctx context.Context, srcFilePath string, destFilePath string, opt CopyTablesOptions) (_ []CopyTableResult, err error) {
+
+	ctx, done := d.startRetryScope(ctx, "CopyTables", destFilePath)
+	defer func() {
+		err = wrapErr("CopyTables", destFilePath, "", err)
+		done(err)
+	}()
+
+	if !fileOrDirExists(srcFilePath) {
+		return nil, &Error{Op: "CopyTables", DBFilePath: srcFilePath, Err: ErrDatabaseFileNotExists}
+	}
+
+	if sameFile(srcFilePath, destFilePath) {
+		return nil, errors.New("source and destination cannot be the same")
+	}
+
+	srcSchema, err := d.GetSchemaContext(ctx, srcFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []CopyTableResult
+	tstart := time.Now()
+
+	for i := 0; i < len(opt.Tables); i++ {
+
+		spec := opt.Tables[i]
+
+		t := srcSchema.Table(spec.Table)
+		if t == nil || t.Type != "table" {
+			return results, &Error{Op: "CopyTables", DBFilePath: srcFilePath, Err: fmt.Errorf("%w: %s", ErrTableNotFound, spec.Table)}
+		}
+
+		res, err := d.copyTable(ctx, srcFilePath, destFilePath, t, spec, opt, tstart)
+		results = append(results, res)
+		if err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// copyTable creates (or keeps) the destination table, and copies the
+// rows in batches.
+func (d *DBAccess) copyTable(ctx context.Context, srcFilePath string, destFilePath string, t *TableInfo,
+	spec CopyTableSpec, opt CopyTablesOptions, tstart time.Time) (CopyTableResult, error) {
+
+	res := CopyTableResult{Table: t.Name, DestTable: strings.Trim(spec.DestTable, "[]")}
+	if res.DestTable == "" {
+		res.DestTable = t.Name
+	}
+
+	// The source table is copied as is, unless it is renamed or its
+	// columns are mapped.
+	asIs := strings.EqualFold(res.DestTable, t.Name) && len(spec.Columns) == 0
+
+	if err := checkCopyEncryption(d.columnCrypter(), t, res.DestTable, spec.Columns); err != nil {
+		return res, err
+	}
+
+	exists := false
+	if fileOrDirExists(destFilePath) {
+		_, err := d.GetTableInfoContext(ctx, destFilePath, res.DestTable)
+		if err != nil && !errors.Is(err, ErrTableNotFound) {
+			return res, err
+		}
+		exists = err == nil
+	}
+
+	var indexes []string
+
+	if !exists || !opt.Append {
+		stmts := []string{fmt.Sprintf("DROP TABLE IF EXISTS [%s]", res.DestTable)}
+		if asIs {
+			stmts = append(stmts, t.SQL)
+			for i := 0; i < len(t.Indexes); i++ {
+				if t.Indexes[i].SQL != "" {
+					indexes = append(indexes, t.Indexes[i].SQL)
+				}
+			}
+		} else {
+			stmts = append(stmts, mappedTableSQL(t, res.DestTable, spec.Columns))
+		}
+		for i := 0; i < len(stmts); i++ {
+			if _, err := d.ExecuteNonQueryContext(ctx, stmts[i], destFilePath); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	destInfo, err := d.GetTableInfoContext(ctx, destFilePath, res.DestTable)
+	if err != nil {
+		return res, err
+	}
+
+	// The generated columns are not selected; they cannot be
+	// inserted.
+	var srcCols []string
+	for i := 0; i < len(t.Columns); i++ {
+		if t.Columns[i].Hidden == 0 {
+			srcCols = append(srcCols, t.Columns[i].Name)
+		}
+	}
+
+	sqlx := fmt.Sprintf("SELECT %s FROM [%s]", quoteColumnList(srcCols), t.Name)
+	if strings.TrimSpace(spec.Where) != "" {
+		sqlx = fmt.Sprintf("%s WHERE (%s)", sqlx, spec.Where)
+	}
+
+	// The rows are copied as stored; i.e. the values of the encrypted
+	// columns are not decrypted.
+	c, err := d.openCursor(ctx, sqlx, srcFilePath, false, spec.Args...)
+	if err != nil {
+		return res, err
+	}
+	defer c.Close()
+
+	db, release, err := d.acquireDB(destFilePath, true)
+	if err != nil {
+		return res, err
+	}
+	defer release()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return res, ctxErr(ctx, err)
+	}
+	defer conn.Close()
+
+	batchSize := opt.BatchSize
+	if batchSize < 1 {
+		batchSize = defaultBulkBatchSize
+	}
+
+	// A statement is prepared for the columns of a row; and again
+	// when a Transform returns other columns.
+	var stmt *sql.Stmt
+	var stmtCols string
+	var batch [][]interface{}
+
+	defer func() {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}()
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var n int64
+		err := d.withRetry(ctx, func() error {
+			var err error
+			n, err = execBulkBatch(ctx, conn, stmt, batch)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		res.RowsWritten += n
+		batch = batch[:0]
+
+		if opt.Notify != nil {
+			opt.Notify(fmt.Sprintf("rows copied => %s: %s, elapsed: %v", res.DestTable,
+				formatNumber(res.RowsWritten), durationToString(time.Since(tstart))))
+		}
+		return nil
+	}
+
+	for c.Next() {
+
+		m, err := c.Map()
+		if err != nil {
+			return res, err
+		}
+		res.RowsRead++
+
+		row := mapCopyRow(m, spec.Columns)
+
+		if spec.Transform != nil {
+			if row, err = spec.Transform(row); err != nil {
+				return res, fmt.Errorf("transform of %s: %w", t.Name, err)
+			}
+			if row == nil {
+				res.RowsSkipped++
+				continue
+			}
+		}
+
+		keys, names, err := copyRowColumns(row, destInfo)
+		if err != nil {
+			return res, fmt.Errorf("row %d of %s: %w", res.RowsRead, t.Name, err)
+		}
+
+		if cols := strings.Join(names, ","); stmt == nil || cols != stmtCols {
+			if err = flush(); err != nil {
+				return res, err
+			}
+			if stmt != nil {
+				stmt.Close()
+				stmt = nil
+			}
+			if stmt, err = conn.PrepareContext(ctx, copyInsertStatement(res.DestTable, names, opt.Conflict)); err != nil {
+				return res, ctxErr(ctx, err)
+			}
+			stmtCols = cols
+		}
+
+		vals := make([]interface{}, len(keys))
+		for i := 0; i < len(keys); i++ {
+			if vals[i], err = bindValue(row[keys[i]]); err != nil {
+				return res, fmt.Errorf("column %s: %w", keys[i], err)
+			}
+		}
+		batch = append(batch, vals)
+
+		if len(batch) >= batchSize {
+			if err = flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	if err = c.Err(); err != nil {
+		return res, err
+	}
+
+	if err = flush(); err != nil {
+		return res, err
+	}
+
+	for i := 0; i < len(indexes); i++ {
+		if _, err = conn.ExecContext(ctx, indexes[i]); err != nil {
+			return res, ctxErr(ctx, err)
+		}
+	}
+
+	return res, nil
+}
+
+// mapCopyRow returns a row with the destination column names; only the
+// mapped columns, if cols is set.
+func mapCopyRow(m map[string]interface{}, cols map[string]string) map[string]interface{} {
+
+	if len(cols) == 0 {
+		return m
+	}
+
+	row := make(map[string]interface{}, len(cols))
+	for k, v := range m {
+		if dest := mappedColumn(cols, k); dest != "" {
+			row[dest] = v
+		}
+	}
+
+	return row
+}
+
+// mappedColumn returns the destination name of a source column; "" if
+// the column is not copied.
+func mappedColumn(cols map[string]string, name string) string {
+
+	if len(cols) == 0 {
+		return name
+	}
+
+	for src, dest := range cols {
+		if strings.EqualFold(strings.Trim(src, "[]"), name) {
+			return strings.Trim(dest, "[]")
+		}
+	}
+
+	return ""
+}
+
+// checkCopyEncryption returns an error, if an encrypted column is copied
+// to another table or column; or a column to an encrypted one. The rows
+// are copied as stored, and an encrypted value is bound to the name of
+// its table and column; so that it could not be decrypted under
+// another name.
+func checkCopyEncryption(c *columnCrypter, t *TableInfo, destTable string, cols map[string]string) error {
+
+	if c == nil {
+		return nil
+	}
+
+	for i := 0; i < len(t.Columns); i++ {
+
+		name := t.Columns[i].Name
+		dest := mappedColumn(cols, name)
+		if dest == "" || (strings.EqualFold(destTable, t.Name) && strings.EqualFold(dest, name)) {
+			continue
+		}
+
+		if c.lookup(t.Name, name) != nil {
+			return fmt.Errorf("%s.%s is encrypted; it cannot be copied to %s.%s", t.Name, name, destTable, dest)
+		}
+		if c.lookup(destTable, dest) != nil {
+			return fmt.Errorf("%s.%s is encrypted; %s.%s cannot be copied to it", destTable, dest, t.Name, name)
+		}
+	}
+
+	return nil
+}
+
+// mappedTableSQL returns the CREATE TABLE statement of a renamed or
+// column-mapped copy of a table; from the column definitions.
+func mappedTableSQL(t *TableInfo, destTable string, cols map[string]string) string {
+
+	var defs []string
+
+	for i := 0; i < len(t.Columns); i++ {
+
+		c := t.Columns[i]
+		if c.Hidden != 0 {
+			continue
+		}
+
+		name := mappedColumn(cols, c.Name)
+		if name == "" {
+			continue
+		}
+
+		def := fmt.Sprintf("[%s] %s", name, c.DeclaredType)
+		if c.NotNull {
+			def += " NOT NULL"
+		}
+		if c.Default != nil {
+			def += " DEFAULT " + *c.Default
+		}
+		defs = append(defs, strings.TrimSpace(def))
+	}
+
+	// The primary key is kept only if all of its columns are copied;
+	// in the order of the key, not of the table.
+	pk := t.PrimaryKey()
+	for i := 0; i < len(pk); i++ {
+		if pk[i] = mappedColumn(cols, pk[i]); pk[i] == "" {
+			pk = nil
+		}
+	}
+	if len(pk) > 0 {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", quoteColumnList(pk)))
+	}
+
+	return fmt.Sprintf("CREATE TABLE [%s] (%s)", destTable, strings.Join(defs, ", "))
+}
+
+// copyRowColumns returns the keys of a row, and the names of their
+// destination columns; in the order of the destination table. An
+// error is returned, if a key is not a column of the destination.
+func copyRowColumns(row map[string]interface{}, destInfo *TableInfo) ([]string, []string, error) {
+
+	var keys []string
+	var names []string
+
+	for i := 0; i < len(destInfo.Columns); i++ {
+		c := destInfo.Columns[i]
+		if c.Hidden != 0 {
+			continue
+		}
+		for k := range row {
+			if strings.EqualFold(k, c.Name) {
+				keys = append(keys, k)
+				names = append(names, c.Name)
+				break
+			}
+		}
+	}
+
+	if len(keys) < len(row) {
+		for k := range row {
+			found := false
+			for i := 0; i < len(keys) && !found; i++ {
+				found = keys[i] == k
+			}
+			if !found {
+				return nil, nil, fmt.Errorf("%s is not a column of %s", k, destInfo.Name)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("the row has none of the columns of %s", destInfo.Name)
+	}
+
+	return keys, names, nil
+}
+
+// copyInsertStatement returns the INSERT statement of the columns of
+// the destination table.
+func copyInsertStatement(destTable string, cols []string, conflict ConflictStrategy) string {
+
+	verb := "INSERT"
+	switch conflict {
+	case ConflictReplace:
+		verb = "INSERT OR REPLACE"
+	case ConflictIgnore:
+		verb = "INSERT OR IGNORE"
+	}
+
+	return fmt.Sprintf("%s INTO [%s] (%s) VALUES (%s)", verb, destTable, quoteColumnList(cols),
+		strings.TrimSuffix(strings.Repeat("?,", len(cols)), ","))
+}
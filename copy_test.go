@@ -0,0 +1,205 @@
+package sqlitehench
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// copyTest returns the path of a db file with the table o; its primary
+// key is (a, b), in the reverse order of the columns.
+func copyTest(t *testing.T, d *DBAccess) string {
+
+	t.Helper()
+
+	return testDB(t, d, "src.sqlite", []string{
+		"CREATE TABLE o (b INTEGER NOT NULL, a INTEGER NOT NULL, v TEXT DEFAULT 'none', PRIMARY KEY (a, b))",
+		"CREATE INDEX ix_o_v ON o (v)",
+		"INSERT INTO o (a, b, v) VALUES (1, 1, 'x'), (1, 2, 'y'), (2, 1, 'z')",
+	}, "")
+}
+
+func TestCopyTables(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	srcFilePath := copyTest(t, d)
+	destFilePath := filepath.Join(t.TempDir(), "dest.sqlite")
+
+	res, err := d.CopyTables(srcFilePath, destFilePath, CopyTablesOptions{
+		Tables: []CopyTableSpec{{Table: "o", Where: "a = ?", Args: []interface{}{1}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].RowsRead != 2 || res[0].RowsWritten != 2 {
+		t.Errorf("got %+v", res)
+	}
+
+	// The table is copied as is; with its indexes.
+	info, err := d.GetTableInfo(destFilePath, "o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk := info.PrimaryKey(); !equalStrings(pk, []string{"a", "b"}) {
+		t.Errorf("primary key %v", pk)
+	}
+	found := false
+	for i := 0; i < len(info.Indexes); i++ {
+		found = found || info.Indexes[i].Name == "ix_o_v"
+	}
+	if !found {
+		t.Error("the index is not copied")
+	}
+
+	if _, err = d.CopyTables(srcFilePath, srcFilePath, CopyTablesOptions{
+		Tables: []CopyTableSpec{{Table: "o"}},
+	}); err == nil {
+		t.Error("copied a database to itself")
+	}
+}
+
+func TestCopyTablesMapped(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	srcFilePath := copyTest(t, d)
+	destFilePath := filepath.Join(t.TempDir(), "dest.sqlite")
+
+	_, err := d.CopyTables(srcFilePath, destFilePath, CopyTablesOptions{
+		Tables: []CopyTableSpec{{
+			Table:     "o",
+			DestTable: "o2",
+			Columns:   map[string]string{"a": "a2", "b": "b2", "v": "v2"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := d.GetTableInfo(destFilePath, "o2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk := info.PrimaryKey(); !equalStrings(pk, []string{"a2", "b2"}) {
+		t.Errorf("primary key %v; want [a2 b2]", pk)
+	}
+	if n := rowCount(t, d, "o2", destFilePath); n != 3 {
+		t.Errorf("copied %d rows; want 3", n)
+	}
+
+	// The primary key is not kept, when one of its columns is not
+	// copied.
+	if _, err = d.CopyTables(srcFilePath, destFilePath, CopyTablesOptions{
+		Tables: []CopyTableSpec{{Table: "o", DestTable: "o3", Columns: map[string]string{"a": "a", "v": "v"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = d.GetTableInfo(destFilePath, "o3"); err != nil || len(info.PrimaryKey()) != 0 {
+		t.Errorf("o3: %v, %v", info, err)
+	}
+}
+
+func TestCopyTablesTransform(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	srcFilePath := copyTest(t, d)
+	destFilePath := filepath.Join(t.TempDir(), "dest.sqlite")
+
+	if _, err := d.ExecuteNonQuery("CREATE TABLE o (a INTEGER, b INTEGER, v TEXT DEFAULT 'none', w TEXT)", destFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// The columns of a row can differ from the columns of the first
+	// row; the columns that a row does not have get their default.
+	res, err := d.CopyTables(srcFilePath, destFilePath, CopyTablesOptions{
+		Append: true,
+		Tables: []CopyTableSpec{{Table: "o", Transform: func(row map[string]interface{}) (map[string]interface{}, error) {
+			switch row["v"] {
+			case "x":
+				delete(row, "v")
+			case "y":
+				row["w"] = "added"
+			default:
+				return nil, nil
+			}
+			return row, nil
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].RowsWritten != 2 || res[0].RowsSkipped != 1 {
+		t.Errorf("got %+v", res[0])
+	}
+
+	m, err := d.GetDataMap("SELECT v, ifnull(w, '') AS w FROM o ORDER BY b", destFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 2 || m[0]["v"] != "none" || m[1]["v"] != "y" || m[1]["w"] != "added" {
+		t.Errorf("got %v", m)
+	}
+
+	// A column that the destination does not have is an error; it is
+	// not dropped.
+	_, err = d.CopyTables(srcFilePath, destFilePath, CopyTablesOptions{
+		Append: true,
+		Tables: []CopyTableSpec{{Table: "o", Transform: func(row map[string]interface{}) (map[string]interface{}, error) {
+			row["missing"] = 1
+			return row, nil
+		}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "missing is not a column of o") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCopyTablesEncrypted(t *testing.T) {
+
+	d, srcFilePath := colCryptTest(t)
+
+	if _, err := d.ExecuteNonQueryWithArgs("INSERT INTO p (id, email, ssn) VALUES (?, ?, ?)", srcFilePath, 1, "a@x.com", "111"); err != nil {
+		t.Fatal(err)
+	}
+
+	destFilePath := filepath.Join(t.TempDir(), "dest.sqlite")
+
+	// The values are copied as stored; so that a copy under the same
+	// names is read back.
+	if _, err := d.CopyTables(srcFilePath, destFilePath, CopyTablesOptions{
+		Tables: []CopyTableSpec{{Table: "p"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedTypes(t, destFilePath); !equalStrings(got, []string{"blob", "blob"}) {
+		t.Errorf("stored types %v", got)
+	}
+	s, err := ScalarAs[string](d, "SELECT ssn FROM p WHERE email = ?", destFilePath, "a@x.com")
+	if err != nil || s != "111" {
+		t.Errorf("got %q, %v", s, err)
+	}
+
+	for _, spec := range []CopyTableSpec{
+		{Table: "p", DestTable: "p2"},
+		{Table: "p", Columns: map[string]string{"id": "id", "ssn": "ssn2"}},
+		{Table: "p", Columns: map[string]string{"id": "id", "note": "ssn"}},
+	} {
+		if _, err = d.CopyTables(srcFilePath, destFilePath, CopyTablesOptions{
+			Tables: []CopyTableSpec{spec},
+		}); err == nil || !strings.Contains(err.Error(), "is encrypted") {
+			t.Errorf("%+v: got %v", spec, err)
+		}
+	}
+
+	// The columns that are not encrypted can be renamed.
+	if _, err = d.CopyTables(srcFilePath, destFilePath, CopyTablesOptions{
+		Tables: []CopyTableSpec{{Table: "p", DestTable: "p3", Columns: map[string]string{"id": "pid", "note": "n"}}},
+	}); err != nil {
+		t.Error(err)
+	}
+}
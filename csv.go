@@ -0,0 +1,871 @@
+package sqlitehench
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// errCSVQuote is the error of a quoted field that is not terminated.
+var errCSVQuote = errors.New("quoted field is not terminated")
+
+// errCSVFieldSize is the error of a field that is longer than
+// MaxFieldSize; i.e. of a quote that is not terminated, which would read
+// the rest of the file into the field.
+var errCSVFieldSize = errors.New("field is too large")
+
+// defaultCSVMaxFieldSize is the MaxFieldSize, when it is not set.
+const defaultCSVMaxFieldSize = 1 << 20
+
+// StreamCSVFileToDatabaseWithOptions imports a CSV (or TSV) file into a
+// table; the file is read as a stream, so that only a batch of rows (and
+// the type-inference sample) is held in memory. It returns the number
+// of rows inserted.
+func (d *DBAccess) StreamCSVFileToDatabaseWithOptions(ctx context.Context, csvFilePath string, dbFilePath string, opt CSVImportOptions) (int64, error) {
+
+	f, err := os.Open(csvFilePath)
+	if err != nil {
+		return -1, wrapErr("StreamCSVFileToDatabase", dbFilePath, "", err)
+	}
+	defer f.Close()
+
+	ext := filepath.Ext(csvFilePath)
+
+	if opt.Table == "" {
+		opt.Table = strings.TrimSuffix(filepath.Base(csvFilePath), ext)
+	}
+
+	if opt.Comma == 0 {
+		switch strings.ToLower(ext) {
+		case ".tsv", ".tab":
+			opt.Comma = '\t'
+		}
+	}
+
+	var size int64
+	if fi, err := f.Stat(); err == nil {
+		size = fi.Size()
+	}
+
+	return d.streamCSV(ctx, f, size, dbFilePath, opt)
+}
+
+// StreamCSVToDatabase is StreamCSVFileToDatabaseWithOptions for a
+// reader; opt.Table must be set.
+func (d *DBAccess) StreamCSVToDatabase(r io.Reader, dbFilePath string, opt CSVImportOptions) (int64, error) {
+	return d.StreamCSVToDatabaseContext(context.Background(), r, dbFilePath, opt)
+}
+
+// StreamCSVToDatabaseContext is StreamCSVToDatabase with a ctx.
+func (d *DBAccess) StreamCSVToDatabaseContext(ctx context.Context, r io.Reader, dbFilePath string, opt CSVImportOptions) (int64, error) {
+
+	if strings.TrimSpace(opt.Table) == "" {
+		return -1, wrapErr("StreamCSVToDatabase", dbFilePath, "", ErrTableNameNotFound)
+	}
+
+	return d.streamCSV(ctx, r, 0, dbFilePath, opt)
+}
+
+// csvImport holds the state of an import.
+type csvImport struct {
+	opt        CSVImportOptions
+	header     []string
+	inserted   int64
+	rejected   int64
+	quarantine *csv.Writer
+}
+
+func (d *DBAccess) streamCSV(ctx context.Context, r io.Reader, size int64, dbFilePath string, opt CSVImportOptions) (_ int64, err error) {
+
+	ctx, done := d.startRetryScope(ctx, "StreamCSVToDatabase", dbFilePath)
+	defer func() {
+		err = wrapErr("StreamCSVToDatabase", dbFilePath, "", err)
+		done(err)
+	}()
+
+	opt.Table = strings.Trim(opt.Table, "[]")
+
+	counter := &countingReader{r: r}
+
+	cr, err := newCSVReader(counter, opt)
+	if err != nil {
+		return -1, err
+	}
+
+	imp := &csvImport{opt: opt}
+
+	if !opt.NoHeader {
+		rec, err := cr.read()
+		if err == io.EOF {
+			return -1, fmt.Errorf("the CSV has no header; %w", ErrNoRowsFound)
+		}
+		if err != nil {
+			return -1, err
+		}
+		if rec.err != nil {
+			return -1, fmt.Errorf("header: %w", rec.err)
+		}
+		imp.header = csvColumnNames(rec.fields)
+	}
+
+	sampleSize := opt.SampleSize
+	if sampleSize < 1 {
+		sampleSize = defaultInferSampleSize
+	}
+
+	// The sample is read ahead; to infer the column types, and (with
+	// no header) the number of columns.
+	var sample []csvRecord
+	eof := false
+	for len(sample) < sampleSize {
+		rec, err := cr.read()
+		if err == io.EOF {
+			eof = true
+			break
+		}
+		if err != nil {
+			return -1, err
+		}
+		sample = append(sample, rec)
+	}
+
+	if opt.NoHeader {
+		for i := 0; i < len(sample); i++ {
+			if sample[i].err == nil {
+				imp.header = csvColumnNames(make([]string, len(sample[i].fields)))
+				break
+			}
+		}
+		if imp.header == nil {
+			return -1, fmt.Errorf("the CSV has no records; %w", ErrNoRowsFound)
+		}
+	}
+
+	srcIdx, destCols, err := csvColumnMap(imp.header, opt.Columns)
+	if err != nil {
+		return -1, err
+	}
+
+	exists := false
+	if fileOrDirExists(dbFilePath) {
+		_, err := d.GetTableInfoContext(ctx, dbFilePath, opt.Table)
+		if err != nil && !errors.Is(err, ErrTableNotFound) {
+			return -1, err
+		}
+		exists = err == nil
+	}
+
+	if !exists || opt.Replace {
+		types := imp.inferTypes(sample, srcIdx, destCols)
+		stmts := []string{csvCreateTableSQL(opt.Table, destCols, types, opt.PrimaryKey)}
+		if err = d.createImportTable(ctx, dbFilePath, opt.Table, stmts, exists); err != nil {
+			return -1, err
+		}
+	}
+
+	destInfo, err := d.GetTableInfoContext(ctx, dbFilePath, opt.Table)
+	if err != nil {
+		return -1, err
+	}
+
+	// The CSV columns that exist in the table are inserted; fields is
+	// the CSV field of each column of the INSERT statement.
+	var insCols []string
+	var fields []int
+	for i := 0; i < len(destInfo.Columns); i++ {
+		c := destInfo.Columns[i]
+		if c.Hidden != 0 {
+			continue
+		}
+		for k := 0; k < len(destCols); k++ {
+			if strings.EqualFold(destCols[k], c.Name) {
+				insCols = append(insCols, c.Name)
+				fields = append(fields, srcIdx[k])
+				break
+			}
+		}
+	}
+	if len(insCols) == 0 {
+		return -1, fmt.Errorf("none of the CSV columns exist in %s", opt.Table)
+	}
+	sqlx := copyInsertStatement(opt.Table, insCols, opt.Conflict)
+
+	db, release, err := d.acquireDB(dbFilePath, true)
+	if err != nil {
+		return -1, err
+	}
+	defer release()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return -1, ctxErr(ctx, err)
+	}
+	defer conn.Close()
+
+	stmt, err := conn.PrepareContext(ctx, sqlx)
+	if err != nil {
+		return -1, ctxErr(ctx, err)
+	}
+	defer stmt.Close()
+
+	if opt.Quarantine != nil {
+		imp.quarantine = csv.NewWriter(opt.Quarantine)
+		if opt.Comma != 0 {
+			imp.quarantine.Comma = opt.Comma
+		}
+	}
+
+	batchSize := opt.BatchSize
+	if batchSize < 1 {
+		batchSize = defaultBulkBatchSize
+	}
+
+	var batch []csvRecord
+	var vals [][]interface{}
+	tstart := time.Now()
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var n int64
+		var rejects []csvReject
+		err := d.withRetry(ctx, func() error {
+			var err error
+			n, rejects, err = execCSVBatch(ctx, conn, stmt, batch, vals, opt.Quarantine != nil)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		imp.inserted += n
+		for i := 0; i < len(rejects); i++ {
+			if err = imp.reject(rejects[i].rec, rejects[i].err); err != nil {
+				return err
+			}
+		}
+		if err = imp.flushQuarantine(); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		vals = vals[:0]
+
+		if opt.Notify != nil {
+			status := fmt.Sprintf("rows imported => %s: %s, rejected: %s", opt.Table,
+				formatNumber(imp.inserted), formatNumber(imp.rejected))
+			if size > 0 {
+				status += fmt.Sprintf(", read: %d%%", counter.n*100/size)
+			}
+			opt.Notify(fmt.Sprintf("%s, elapsed: %v", status, durationToString(time.Since(tstart))))
+		}
+		return nil
+	}
+
+	add := func(rec csvRecord) error {
+		if rec.err == nil && len(rec.fields) != len(imp.header) {
+			rec.err = fmt.Errorf("wrong number of fields: %d, expected %d", len(rec.fields), len(imp.header))
+		}
+		if rec.err != nil {
+			return imp.reject(rec, rec.err)
+		}
+		v := make([]interface{}, len(fields))
+		for i := 0; i < len(fields); i++ {
+			if !imp.isNull(rec, fields[i]) {
+				v[i] = rec.fields[fields[i]]
+			}
+		}
+		batch = append(batch, rec)
+		vals = append(vals, v)
+		if len(batch) >= batchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	for i := 0; i < len(sample); i++ {
+		if err = add(sample[i]); err != nil {
+			return imp.inserted, err
+		}
+	}
+	sample = nil
+
+	for !eof {
+		if err = ctx.Err(); err != nil {
+			return imp.inserted, err
+		}
+		rec, err := cr.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imp.inserted, err
+		}
+		if err = add(rec); err != nil {
+			return imp.inserted, err
+		}
+	}
+
+	if err = flush(); err != nil {
+		return imp.inserted, err
+	}
+
+	return imp.inserted, imp.flushQuarantine()
+}
+
+// isNull reports whether a field is NULL; i.e. the NullMarker, or an
+// empty field that is not quoted.
+func (imp *csvImport) isNull(rec csvRecord, i int) bool {
+	return !rec.quoted[i] && rec.fields[i] == imp.opt.NullMarker
+}
+
+// reject writes a record to the quarantine; or returns the error, if
+// there is no quarantine.
+func (imp *csvImport) reject(rec csvRecord, err error) error {
+
+	if imp.quarantine == nil {
+		return fmt.Errorf("line %d: %w", rec.line, err)
+	}
+
+	if imp.rejected == 0 && !imp.opt.NoHeader {
+		if err := imp.quarantine.Write(append(append([]string{}, imp.header...), "Error")); err != nil {
+			return err
+		}
+	}
+
+	imp.rejected++
+
+	if err := imp.quarantine.Write(append(append([]string{}, rec.fields...), fmt.Sprintf("line %d: %v", rec.line, err))); err != nil {
+		return err
+	}
+
+	if imp.opt.MaxErrors > 0 && imp.rejected > imp.opt.MaxErrors {
+		imp.flushQuarantine()
+		return fmt.Errorf("too many rejected records: %s", formatNumber(imp.rejected))
+	}
+
+	return nil
+}
+
+func (imp *csvImport) flushQuarantine() error {
+
+	if imp.quarantine == nil {
+		return nil
+	}
+
+	imp.quarantine.Flush()
+
+	return imp.quarantine.Error()
+}
+
+// inferTypes returns the declared type of each column, from the values
+// of the sample; TEXT when they are mixed, or all NULL.
+func (imp *csvImport) inferTypes(sample []csvRecord, srcIdx []int, destCols []string) []string {
+
+	types := make([]string, len(destCols))
+
+	for i := 0; i < len(destCols); i++ {
+
+		if t, ok := imp.opt.ColumnTypes[destCols[i]]; ok {
+			types[i] = t
+			continue
+		}
+
+		types[i] = "TEXT"
+		if imp.opt.NoInference {
+			continue
+		}
+
+		seen := 0
+		for k := 0; k < len(sample); k++ {
+			rec := sample[k]
+			if rec.err != nil || len(rec.fields) != len(imp.header) || imp.isNull(rec, srcIdx[i]) {
+				continue
+			}
+			seen |= csvValueClass(rec.fields[srcIdx[i]])
+		}
+
+		if t := declaredTypeFromValues(seen); t != "" {
+			types[i] = t
+		}
+	}
+
+	return types
+}
+
+// csvValueClass returns the storage class that a CSV value converts to.
+func csvValueClass(s string) int {
+
+	digits := strings.TrimLeft(s, "+-")
+
+	// Leading zeros are kept; i.e. zip codes.
+	if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+		return seenText
+	}
+
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return seenInteger
+	}
+
+	// Not "Inf", "NaN", or hex.
+	if strings.IndexFunc(s, func(r rune) bool { return !strings.ContainsRune("0123456789+-.eE", r) }) == -1 {
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return seenReal
+		}
+	}
+
+	if _, ok := parseTimeValue(s); ok {
+		return seenTime
+	}
+
+	return seenText
+}
+
+// csvColumnNames returns the column names of a header; an empty name is
+// named by its position, and the duplicates get a _2, _3 ... suffix.
+func csvColumnNames(header []string) []string {
+
+	var names []string
+
+	for i := 0; i < len(header); i++ {
+
+		name := strings.Trim(strings.TrimSpace(header[i]), "[]")
+		if name == "" {
+			name = fmt.Sprintf("Column%d", i+1)
+		}
+
+		namePart := name
+		for indx := 2; arryElmExistsIgnoreCase(names, name); indx++ {
+			name = fmt.Sprintf("%s_%d", namePart, indx)
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// csvColumnMap returns the CSV fields that are imported, and the table
+// column of each.
+func csvColumnMap(header []string, cols map[string]string) ([]int, []string, error) {
+
+	var srcIdx []int
+	var destCols []string
+
+	if len(cols) == 0 {
+		for i := 0; i < len(header); i++ {
+			srcIdx = append(srcIdx, i)
+			destCols = append(destCols, header[i])
+		}
+		return srcIdx, destCols, nil
+	}
+
+	for src := range cols {
+		if !arryElmExistsIgnoreCase(header, src) {
+			return nil, nil, fmt.Errorf("column %s is not in the CSV header", src)
+		}
+	}
+
+	for i := 0; i < len(header); i++ {
+		for src, dest := range cols {
+			if strings.EqualFold(src, header[i]) {
+				srcIdx = append(srcIdx, i)
+				destCols = append(destCols, strings.Trim(dest, "[]"))
+				break
+			}
+		}
+	}
+
+	return srcIdx, destCols, nil
+}
+
+// createImportTable creates the table of an import; with replace, the
+// existing table is dropped first, in the same transaction; so that a
+// CREATE that fails leaves the table as it was.
+func (d *DBAccess) createImportTable(ctx context.Context, dbFilePath string, table string, stmts []string, replace bool) error {
+
+	if replace {
+		stmts = append([]string{fmt.Sprintf("DROP TABLE IF EXISTS [%s]", table)}, stmts...)
+	}
+
+	_, err := d.ExecuteNonQueryContext(ctx, strings.Join(stmts, ";\n"), dbFilePath)
+
+	return err
+}
+
+func csvCreateTableSQL(table string, cols []string, types []string, pk []string) string {
+
+	defs := make([]string, len(cols))
+	for i := 0; i < len(cols); i++ {
+		defs[i] = fmt.Sprintf("[%s] %s", cols[i], types[i])
+	}
+
+	if len(pk) > 0 {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", quoteColumnList(pk)))
+	}
+
+	return fmt.Sprintf("CREATE TABLE [%s] (%s)", table, strings.Join(defs, ", "))
+}
+
+// csvReject is a record that the database did not accept.
+type csvReject struct {
+	rec csvRecord
+	err error
+}
+
+// execCSVBatch inserts a batch of records in one transaction. When
+// tolerate is set, the records that fail on a constraint (or type) are
+// returned, and the others are committed; otherwise the transaction is
+// rolled back.
+func execCSVBatch(ctx context.Context, conn *sql.Conn, stmt *sql.Stmt, batch []csvRecord, vals [][]interface{}, tolerate bool) (int64, []csvReject, error) {
+
+	if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return -1, nil, ctxErr(ctx, err)
+	}
+
+	rollback := func() {
+		// The ctx may have been cancelled.
+		conn.ExecContext(context.Background(), "ROLLBACK")
+	}
+
+	var rowsAffected int64
+	var rejects []csvReject
+
+	for i := 0; i < len(vals); i++ {
+		result, err := stmt.ExecContext(ctx, vals[i]...)
+		if err != nil {
+			code, _ := resultCodes(err)
+			rowErr := code == sqliteConstraint || code == sqliteMismatch || code == sqliteTooBig
+			if tolerate && rowErr && ctx.Err() == nil {
+				rejects = append(rejects, csvReject{rec: batch[i], err: err})
+				continue
+			}
+			rollback()
+			if rowErr {
+				err = fmt.Errorf("line %d: %w", batch[i].line, err)
+			}
+			return -1, nil, ctxErr(ctx, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			rollback()
+			return -1, nil, err
+		}
+		rowsAffected += n
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		rollback()
+		return -1, nil, ctxErr(ctx, err)
+	}
+
+	return rowsAffected, rejects, nil
+}
+
+// csvRecord is a record of a CSV file; err is set when it cannot be
+// parsed.
+type csvRecord struct {
+	// line is the line number where the record starts.
+	line   int
+	fields []string
+	quoted []bool
+	err    error
+}
+
+// csvReader reads the records of a CSV file. Unlike encoding/csv, the
+// quote character can be set, and it reports the quoted fields; so that
+// "" is told from NULL. A quote within an unquoted field, or after the
+// closing quote, is read as data.
+type csvReader struct {
+	r        *bufio.Reader
+	comma    rune
+	quote    rune
+	noQuotes bool
+	maxField int
+
+	// line is the number of lines read.
+	line  int
+	field strings.Builder
+}
+
+func newCSVReader(r io.Reader, opt CSVImportOptions) (*csvReader, error) {
+
+	br, err := decodeText(bufio.NewReaderSize(r, 64*1024), opt.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &csvReader{r: br, comma: opt.Comma, quote: opt.Quote, noQuotes: opt.NoQuotes, maxField: opt.MaxFieldSize}
+	if cr.maxField < 1 {
+		cr.maxField = defaultCSVMaxFieldSize
+	}
+	if cr.comma == 0 {
+		cr.comma = ','
+	}
+	if cr.quote == 0 {
+		cr.quote = '"'
+	}
+	if cr.comma == cr.quote || cr.comma == '\n' || cr.comma == '\r' {
+		return nil, fmt.Errorf("invalid delimiter %q", cr.comma)
+	}
+
+	return cr, nil
+}
+
+// read returns the next record; blank lines are skipped. The error is
+// io.EOF at the end of the file, or a read error; which includes
+// errCSVFieldSize, as the reader cannot find the next record after it.
+func (cr *csvReader) read() (csvRecord, error) {
+
+	rec := csvRecord{line: cr.line + 1}
+
+	for {
+		s, quoted, eol, err := cr.readField()
+		if err == errCSVFieldSize {
+			return rec, fmt.Errorf("line %d: %w (MaxFieldSize %s bytes)", rec.line, err, formatNumber(int64(cr.maxField)))
+		}
+		if err != nil && err != io.EOF && err != errCSVQuote {
+			return rec, err
+		}
+		if err == io.EOF && len(rec.fields) == 0 && s == "" && !quoted {
+			return rec, io.EOF
+		}
+
+		rec.fields = append(rec.fields, s)
+		rec.quoted = append(rec.quoted, quoted)
+
+		if err == errCSVQuote {
+			rec.err = err
+			return rec, nil
+		}
+
+		if eol {
+			if len(rec.fields) == 1 && s == "" && !quoted {
+				if err == io.EOF {
+					return rec, io.EOF
+				}
+				rec = csvRecord{line: cr.line + 1}
+				continue
+			}
+			return rec, nil
+		}
+	}
+}
+
+// readField returns the next field, and whether it ends the record.
+func (cr *csvReader) readField() (string, bool, bool, error) {
+
+	cr.field.Reset()
+
+	quoted := false
+
+	rn, _, err := cr.r.ReadRune()
+
+	if err == nil && !cr.noQuotes && rn == cr.quote {
+		quoted = true
+		for {
+			rn, _, err = cr.r.ReadRune()
+			if err == io.EOF {
+				return cr.field.String(), true, true, errCSVQuote
+			}
+			if err != nil {
+				return "", true, true, err
+			}
+			if rn == cr.quote {
+				next, _, err := cr.r.ReadRune()
+				if err == nil && next == cr.quote {
+					cr.field.WriteRune(rn)
+					continue
+				}
+				if err == nil {
+					cr.r.UnreadRune()
+				}
+				break
+			}
+			if rn == '\n' {
+				cr.line++
+			}
+			cr.field.WriteRune(rn)
+			if cr.field.Len() > cr.maxField {
+				return "", true, true, errCSVFieldSize
+			}
+		}
+		rn, _, err = cr.r.ReadRune()
+	}
+
+	for {
+		if err != nil {
+			return cr.field.String(), quoted, true, err
+		}
+		if rn == cr.comma {
+			return cr.field.String(), quoted, false, nil
+		}
+		if rn == '\n' {
+			cr.line++
+			return strings.TrimSuffix(cr.field.String(), "\r"), quoted, true, nil
+		}
+		cr.field.WriteRune(rn)
+		if cr.field.Len() > cr.maxField {
+			return "", quoted, true, errCSVFieldSize
+		}
+		rn, _, err = cr.r.ReadRune()
+	}
+}
+
+// countingReader counts the bytes read; for the progress of an import.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decodeText returns a UTF-8 reader of a text in the given encoding. The
+// BOM is skipped; with no encoding, a UTF-16 BOM selects UTF-16.
+func decodeText(br *bufio.Reader, encoding string) (*bufio.Reader, error) {
+
+	bom, _ := br.Peek(3)
+
+	enc := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(encoding), "_", "-"))
+
+	if enc == "" || enc == "utf-16" {
+		switch {
+		case len(bom) >= 2 && bom[0] == 0xFF && bom[1] == 0xFE:
+			enc = "utf-16le"
+		case len(bom) >= 2 && bom[0] == 0xFE && bom[1] == 0xFF:
+			enc = "utf-16be"
+		case enc == "utf-16":
+			enc = "utf-16le"
+		}
+	}
+
+	switch enc {
+	case "", "utf-8", "utf8":
+		if len(bom) == 3 && bom[0] == 0xEF && bom[1] == 0xBB && bom[2] == 0xBF {
+			br.Discard(3)
+		}
+		return br, nil
+
+	case "utf-16le", "utf-16be":
+		le := enc == "utf-16le"
+		if len(bom) >= 2 && ((le && bom[0] == 0xFF && bom[1] == 0xFE) || (!le && bom[0] == 0xFE && bom[1] == 0xFF)) {
+			br.Discard(2)
+		}
+		return bufio.NewReader(&textDecoder{r: br, decode: func(r *bufio.Reader) (rune, error) {
+			return readUTF16(r, le)
+		}}), nil
+
+	case "latin1", "latin-1", "iso-8859-1", "iso8859-1":
+		return bufio.NewReader(&textDecoder{r: br, decode: func(r *bufio.Reader) (rune, error) {
+			b, err := r.ReadByte()
+			return rune(b), err
+		}}), nil
+
+	case "windows-1252", "cp1252":
+		return bufio.NewReader(&textDecoder{r: br, decode: func(r *bufio.Reader) (rune, error) {
+			b, err := r.ReadByte()
+			if err == nil && b >= 0x80 && b < 0xA0 && cp1252[b-0x80] != 0 {
+				return cp1252[b-0x80], nil
+			}
+			return rune(b), err
+		}}), nil
+	}
+
+	return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+}
+
+// cp1252 holds the characters of 0x80-0x9F in windows-1252; zero where
+// the byte is the Latin-1 control character.
+var cp1252 = [32]rune{
+	0x20AC, 0, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017D, 0,
+	0, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0, 0x017E, 0x0178,
+}
+
+// readUTF16 reads one character of UTF-16 text; a surrogate pair is read
+// as one.
+func readUTF16(r *bufio.Reader, le bool) (rune, error) {
+
+	unit := func() (rune, error) {
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return utf8.RuneError, nil
+			}
+			return 0, err
+		}
+		if le {
+			return rune(b[0]) | rune(b[1])<<8, nil
+		}
+		return rune(b[0])<<8 | rune(b[1]), nil
+	}
+
+	r1, err := unit()
+	if err != nil || !utf16.IsSurrogate(r1) {
+		return r1, err
+	}
+
+	r2, err := unit()
+	if err == io.EOF {
+		return utf8.RuneError, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return utf16.DecodeRune(r1, r2), nil
+}
+
+// textDecoder is a reader of the UTF-8 form of the characters that the
+// decode func reads.
+type textDecoder struct {
+	r       *bufio.Reader
+	decode  func(r *bufio.Reader) (rune, error)
+	pending []byte
+}
+
+func (t *textDecoder) Read(p []byte) (int, error) {
+
+	n := 0
+
+	for n < len(p) {
+
+		if len(t.pending) > 0 {
+			c := copy(p[n:], t.pending)
+			t.pending = t.pending[c:]
+			n += c
+			continue
+		}
+
+		rn, err := t.decode(t.r)
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+
+		var buf [utf8.UTFMax]byte
+		w := utf8.EncodeRune(buf[:], rn)
+		c := copy(p[n:], buf[:w])
+		t.pending = append(t.pending[:0], buf[c:w]...)
+		n += c
+	}
+
+	return n, nil
+}
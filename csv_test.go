@@ -0,0 +1,337 @@
+package sqlitehench
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestCSVReader(t *testing.T) {
+
+	type record struct {
+		line   int
+		fields []string
+		quoted []bool
+		err    error
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		opt  CSVImportOptions
+		want []record
+	}{
+		{
+			name: "doubled quotes",
+			in:   "a,\"b \"\"x\"\" c\",\"\"\"\"\n",
+			want: []record{
+				{line: 1, fields: []string{"a", `b "x" c`, `"`}, quoted: []bool{false, true, true}},
+			},
+		},
+		{
+			name: "crlf inside quotes",
+			in:   "a,\"x\r\ny\"\r\nb,c\r\n",
+			want: []record{
+				{line: 1, fields: []string{"a", "x\r\ny"}, quoted: []bool{false, true}},
+				{line: 3, fields: []string{"b", "c"}, quoted: []bool{false, false}},
+			},
+		},
+		{
+			name: "blank lines and no final newline",
+			in:   "a,b\n\n\r\nc,d",
+			want: []record{
+				{line: 1, fields: []string{"a", "b"}, quoted: []bool{false, false}},
+				{line: 4, fields: []string{"c", "d"}, quoted: []bool{false, false}},
+			},
+		},
+		{
+			name: "empty and quoted empty",
+			in:   ",\"\",x\n",
+			want: []record{
+				{line: 1, fields: []string{"", "", "x"}, quoted: []bool{false, true, false}},
+			},
+		},
+		{
+			name: "quote within a field is data",
+			in:   "a\"b,\"c\"d\n",
+			want: []record{
+				{line: 1, fields: []string{`a"b`, "cd"}, quoted: []bool{false, true}},
+			},
+		},
+		{
+			name: "unterminated quote",
+			in:   "a,b\nc,\"d\ne,f\n",
+			want: []record{
+				{line: 1, fields: []string{"a", "b"}, quoted: []bool{false, false}},
+				{line: 2, fields: []string{"c", "d\ne,f\n"}, quoted: []bool{false, true}, err: errCSVQuote},
+			},
+		},
+		{
+			name: "custom quote and delimiter",
+			in:   "'a;b';c\n",
+			opt:  CSVImportOptions{Comma: ';', Quote: '\''},
+			want: []record{
+				{line: 1, fields: []string{"a;b", "c"}, quoted: []bool{true, false}},
+			},
+		},
+		{
+			name: "no quotes",
+			in:   "\"a\"\t\"b\n",
+			opt:  CSVImportOptions{Comma: '\t', NoQuotes: true},
+			want: []record{
+				{line: 1, fields: []string{`"a"`, `"b`}, quoted: []bool{false, false}},
+			},
+		},
+		{
+			name: "utf-8 bom",
+			in:   "\xEF\xBB\xBFa,b\n",
+			want: []record{
+				{line: 1, fields: []string{"a", "b"}, quoted: []bool{false, false}},
+			},
+		},
+		{
+			name: "utf-16le bom",
+			in:   utf16Text("a,\"é\"\n€,𝄞\n", true, true),
+			want: []record{
+				{line: 1, fields: []string{"a", "é"}, quoted: []bool{false, true}},
+				{line: 2, fields: []string{"€", "𝄞"}, quoted: []bool{false, false}},
+			},
+		},
+		{
+			name: "utf-16be bom",
+			in:   utf16Text("a,b\n", false, true),
+			want: []record{
+				{line: 1, fields: []string{"a", "b"}, quoted: []bool{false, false}},
+			},
+		},
+		{
+			name: "utf-16be without bom",
+			in:   utf16Text("a,b\n", false, false),
+			opt:  CSVImportOptions{Encoding: "utf-16be"},
+			want: []record{
+				{line: 1, fields: []string{"a", "b"}, quoted: []bool{false, false}},
+			},
+		},
+		{
+			name: "windows-1252",
+			in:   "\x80,\xE9\n",
+			opt:  CSVImportOptions{Encoding: "windows-1252"},
+			want: []record{
+				{line: 1, fields: []string{"€", "é"}, quoted: []bool{false, false}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			cr, err := newCSVReader(strings.NewReader(tt.in), tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []record
+			for {
+				rec, err := cr.read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, record{line: rec.line, fields: rec.fields, quoted: rec.quoted, err: rec.err})
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// utf16Text returns s in UTF-16; with a BOM if bom is set.
+func utf16Text(s string, le bool, bom bool) string {
+
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+
+	b := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		if le {
+			b = append(b, byte(u), byte(u>>8))
+		} else {
+			b = append(b, byte(u>>8), byte(u))
+		}
+	}
+
+	return string(b)
+}
+
+// endlessReader reads the same byte forever.
+type endlessReader byte
+
+func (r endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+func TestCSVReaderMaxFieldSize(t *testing.T) {
+
+	// An unterminated quote is not read to the end of the input; which
+	// is endless here.
+	in := io.MultiReader(strings.NewReader("a,b\nc,\""), endlessReader('x'))
+
+	cr, err := newCSVReader(in, CSVImportOptions{MaxFieldSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cr.read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cr.read(); !errors.Is(err, errCSVFieldSize) {
+		t.Fatalf("got %v; want %v", err, errCSVFieldSize)
+	}
+
+	// The same for an unquoted field.
+	cr, _ = newCSVReader(endlessReader('y'), CSVImportOptions{})
+	if _, err = cr.read(); !errors.Is(err, errCSVFieldSize) {
+		t.Fatalf("got %v; want %v", err, errCSVFieldSize)
+	}
+}
+
+func TestStreamCSVNullMarker(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		marker string
+		// want is whether a is NULL, by id.
+		want []interface{}
+	}{
+		{name: "empty", marker: "", want: []interface{}{int64(1), int64(0), int64(0)}},
+		{name: "marker", marker: `\N`, want: []interface{}{int64(0), int64(0), int64(1)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			dbFilePath := filepath.Join(t.TempDir(), "csv.sqlite")
+			d := NewDBAccess(DBAccess{})
+			defer d.Close()
+
+			in := "id,a\n1,\n2,\"\"\n3,\\N\n"
+			opt := CSVImportOptions{Table: "t", NullMarker: tt.marker}
+			if _, err := d.StreamCSVToDatabase(strings.NewReader(in), dbFilePath, opt); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := d.GetDataMap("select a is null as nul from t order by id", dbFilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []interface{}
+			for _, r := range rows {
+				got = append(got, r["nul"])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamCSVQuarantine(t *testing.T) {
+
+	dbFilePath := filepath.Join(t.TempDir(), "csv.sqlite")
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	in := "id,name\n" +
+		"1,a\n" +
+		"2,b,extra\n" +
+		"3,c\n" +
+		"1,dup\n" +
+		"4,\"unterminated\n"
+
+	var quarantine bytes.Buffer
+	opt := CSVImportOptions{Table: "t", PrimaryKey: []string{"id"}, Quarantine: &quarantine, BatchSize: 2}
+
+	n, err := d.StreamCSVToDatabase(strings.NewReader(in), dbFilePath, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("inserted %d rows; want 2", n)
+	}
+
+	// The records that cannot be parsed are quarantined as they are
+	// read; the ones that fail to insert, as their batch is written.
+	want := "id,name,Error\n" +
+		"2,b,extra,\"line 3: wrong number of fields: 3, expected 2\"\n" +
+		"4,\"unterminated\n\",line 6: quoted field is not terminated\n" +
+		"1,dup,line 5: UNIQUE constraint failed: t.id\n"
+	if got := quarantine.String(); got != want {
+		t.Errorf("quarantine:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Without a quarantine, the first bad record stops the import.
+	opt.Quarantine = nil
+	if _, err = d.StreamCSVToDatabase(strings.NewReader(in), dbFilePath, opt); err == nil {
+		t.Error("got no error without a quarantine")
+	}
+}
+
+func TestStreamCSVAppendReplace(t *testing.T) {
+
+	dbFilePath := filepath.Join(t.TempDir(), "csv.sqlite")
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	if _, err := d.ExecuteNonQuery("CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, note TEXT)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ExecuteNonQuery("INSERT INTO t (id, name, note) VALUES (1, 'kept', 'x')", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// An existing table is appended to, by default.
+	in := "id,name\n2,b\n3,c\n"
+	if _, err := d.StreamCSVToDatabase(strings.NewReader(in), dbFilePath, CSVImportOptions{Table: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	if c := rowCount(t, d, "t", dbFilePath); c != 3 {
+		t.Errorf("the table has %d rows; want 3", c)
+	}
+
+	// A failed CREATE leaves the table as it was.
+	opt := CSVImportOptions{Table: "t", Replace: true, PrimaryKey: []string{"nope"}}
+	if _, err := d.StreamCSVToDatabase(strings.NewReader(in), dbFilePath, opt); err == nil {
+		t.Error("got no error for a primary key that is not a column")
+	}
+	if c := rowCount(t, d, "t", dbFilePath); c != 3 {
+		t.Errorf("the table has %d rows; want 3", c)
+	}
+
+	// Replace creates the table again, from the file.
+	opt.PrimaryKey = nil
+	if _, err := d.StreamCSVToDatabase(strings.NewReader(in), dbFilePath, opt); err != nil {
+		t.Fatal(err)
+	}
+	cols, err := d.GetColumnNames(dbFilePath, "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := rowCount(t, d, "t", dbFilePath); c != 2 || !reflect.DeepEqual(cols, []string{"id", "name"}) {
+		t.Errorf("the table has %d rows, columns %v; want 2, [id name]", c, cols)
+	}
+}
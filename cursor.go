@@ -0,0 +1,346 @@
+package sqlitehench
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"reflect"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// Cursor reads the rows of a query one at a time; so that large
+// results can be processed without reading them all into memory.
+// The cursor reads on a connection of its own, which it holds until
+// it is closed; so that the other operations on the db file can run
+// while it is open.
+//
+//	c, err := d.OpenCursor("SELECT * FROM Customer", dbFilePath)
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	for c.Next() {
+//		m, err := c.Map()
+//		...
+//	}
+//	err = c.Err()
+type Cursor struct {
+	ctx        context.Context
+	rows       *sql.Rows
+	release    func()
+	dbFilePath string
+	sqlQuery   string
+
+	cols []string
+	vals []interface{}
+	ptrs []interface{}
+
+	// scanners holds a rowScanner for each struct type
+	// that the rows are scanned into.
+	scanners map[reflect.Type]*rowScanner
+
+	// colsOf is the DataTable that the columns of the result were
+	// last added to (by AppendTo); so that they are checked once.
+	colsOf *collc.Table
+
+	// crypter decrypts the values of the encrypted columns
+	// of the tables of the query; nil if there are none.
+	crypter *columnCrypter
+	tables  []string
+
+	scanned bool
+	err     error
+	closed  bool
+}
+
+// OpenCursor runs a query and returns a Cursor over its rows.
+func (d *DBAccess) OpenCursor(sqlQuery string, dbFilePath string, args ...interface{}) (*Cursor, error) {
+	return d.OpenCursorContext(context.Background(), sqlQuery, dbFilePath, args...)
+}
+
+// OpenCursorContext is OpenCursor with a ctx. Cancelling the ctx stops
+// the cursor; Next returns false and Err returns the ctx error.
+func (d *DBAccess) OpenCursorContext(ctx context.Context, sqlQuery string, dbFilePath string, args ...interface{}) (*Cursor, error) {
+	return d.openCursor(ctx, sqlQuery, dbFilePath, true, args...)
+}
+
+// openCursor opens a Cursor; the values of the encrypted columns are read
+// as stored, unless decrypt is set (the args are encrypted either way).
+func (d *DBAccess) openCursor(ctx context.Context, sqlQuery string, dbFilePath string, decrypt bool, args ...interface{}) (_ *Cursor, err error) {
+
+	defer func() { err = wrapErr("OpenCursor", dbFilePath, sqlQuery, err) }()
+
+	if !fileOrDirExists(dbFilePath) {
+		return nil, ErrDatabaseFileNotExists
+	}
+
+	crypter := d.columnCrypter()
+	if args, err = crypter.encryptArgs(sqlQuery, args); err != nil {
+		return nil, err
+	}
+	if args, err = bindArgs(args); err != nil {
+		return nil, err
+	}
+
+	db, release, err := d.acquireOwnDB(dbFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		release()
+		return nil, ctxErr(ctx, err)
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		release()
+		return nil, err
+	}
+
+	c := &Cursor{
+		ctx:        ctx,
+		rows:       rows,
+		release:    release,
+		dbFilePath: dbFilePath,
+		sqlQuery:   sqlQuery,
+		cols:       cols,
+		vals:       make([]interface{}, len(cols)),
+		ptrs:       make([]interface{}, len(cols)),
+		scanners:   make(map[reflect.Type]*rowScanner),
+	}
+	for i := 0; i < len(cols); i++ {
+		c.ptrs[i] = &c.vals[i]
+	}
+
+	if decrypt && crypter != nil {
+		c.crypter = crypter
+		c.tables = statementTables(analyzeSQL(sqlQuery))
+	}
+
+	return c, nil
+}
+
+// Columns returns the column names of the result.
+func (c *Cursor) Columns() []string {
+	return c.cols
+}
+
+// ColumnTypes returns the column types of the result; i.e. the declared
+// type (DatabaseTypeName) of each column.
+func (c *Cursor) ColumnTypes() ([]*sql.ColumnType, error) {
+	return c.rows.ColumnTypes()
+}
+
+// Next moves to the next row; it returns false when there are no more
+// rows, or an error has occurred (see Err). The cursor is closed once
+// the last row has been read.
+func (c *Cursor) Next() bool {
+
+	if c.closed {
+		return false
+	}
+
+	c.scanned = false
+
+	if !c.rows.Next() {
+		if err := c.rows.Err(); err != nil {
+			c.err = wrapErr("Cursor", c.dbFilePath, c.sqlQuery, ctxErr(c.ctx, err))
+		}
+		c.Close()
+		return false
+	}
+
+	return true
+}
+
+// Values returns the values of the current row in column order. The
+// slice is reused by the next row.
+func (c *Cursor) Values() ([]interface{}, error) {
+
+	if err := c.scanRow(); err != nil {
+		return nil, err
+	}
+
+	return c.vals, nil
+}
+
+// Map returns the current row as a map of column name to value.
+func (c *Cursor) Map() (map[string]interface{}, error) {
+
+	if err := c.scanRow(); err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]interface{}, len(c.cols))
+	for i := 0; i < len(c.cols); i++ {
+		m[c.cols[i]] = c.vals[i]
+	}
+
+	return m, nil
+}
+
+// Scan reads the current row into dst, which must be a pointer; to a
+// struct (mapped by the `db` tags, as in Query), or to a single-column
+// type.
+func (c *Cursor) Scan(dst interface{}) error {
+
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("Cursor.Scan needs a non-nil pointer; got %T", dst)
+	}
+	v = v.Elem()
+
+	sc, ok := c.scanners[v.Type()]
+	if !ok {
+		var err error
+		if sc, err = newRowScanner(v.Type(), c.cols); err != nil {
+			return err
+		}
+		c.scanners[v.Type()] = sc
+	}
+
+	if err := c.scanRow(); err != nil {
+		return err
+	}
+
+	return sc.convert(c.vals, v)
+}
+
+// AppendTo adds the current row to a DataTable; the columns of the
+// result are added to the table, if not already there.
+func (c *Cursor) AppendTo(tbl *collc.Table) error {
+
+	if tbl == nil {
+		return errors.New("DataTable is nil")
+	}
+
+	if err := c.scanRow(); err != nil {
+		return err
+	}
+
+	if tbl != c.colsOf {
+		cols := tbl.Cols.Get()
+		for i := 0; i < len(c.cols); i++ {
+			if !valueExistsInArry(cols, c.cols[i], false) {
+				tbl.Cols.Add(c.cols[i])
+			}
+		}
+		c.colsOf = tbl
+	}
+
+	oneRow := tbl.Rows.New()
+	for i := 0; i < len(c.cols); i++ {
+		oneRow[c.cols[i]] = c.vals[i]
+	}
+
+	return nil
+}
+
+// Err returns the error (if any) that stopped Next.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close closes the cursor and releases its database connection. It is
+// safe to call more than once.
+func (c *Cursor) Close() error {
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	err := c.rows.Close()
+	c.release()
+
+	return err
+}
+
+// scanRow reads the values of the current row (once per row).
+func (c *Cursor) scanRow() error {
+
+	if c.closed {
+		return errors.New("cursor is closed")
+	}
+
+	if c.scanned {
+		return nil
+	}
+
+	if err := c.rows.Scan(c.ptrs...); err != nil {
+		return wrapErr("Cursor", c.dbFilePath, c.sqlQuery, ctxErr(c.ctx, err))
+	}
+	if err := c.crypter.decryptValues(c.tables, c.cols, c.vals); err != nil {
+		return wrapErr("Cursor", c.dbFilePath, c.sqlQuery, err)
+	}
+	c.scanned = true
+
+	return nil
+}
+
+// IterRows returns the rows of a query as a range func; the cursor is
+// closed when the loop ends (including on break).
+//
+//	for m, err := range d.IterRows(ctx, "SELECT * FROM Customer", dbFilePath) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func (d *DBAccess) IterRows(ctx context.Context, sqlQuery string, dbFilePath string, args ...interface{}) iter.Seq2[map[string]interface{}, error] {
+
+	return func(yield func(map[string]interface{}, error) bool) {
+
+		c, err := d.OpenCursorContext(ctx, sqlQuery, dbFilePath, args...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer c.Close()
+
+		for c.Next() {
+			m, err := c.Map()
+			if !yield(m, err) || err != nil {
+				return
+			}
+		}
+
+		if err = c.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// QueryIter is Query as a range func; the rows are read one at a time.
+func QueryIter[T any](ctx context.Context, d *DBAccess, sqlQuery string, dbFilePath string, args ...interface{}) iter.Seq2[T, error] {
+
+	return func(yield func(T, error) bool) {
+
+		var zero T
+
+		c, err := d.OpenCursorContext(ctx, sqlQuery, dbFilePath, args...)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer c.Close()
+
+		for c.Next() {
+			var v T
+			err := c.Scan(&v)
+			if !yield(v, err) || err != nil {
+				return
+			}
+		}
+
+		if err = c.Err(); err != nil {
+			yield(zero, err)
+		}
+	}
+}
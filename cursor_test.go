@@ -0,0 +1,208 @@
+package sqlitehench
+
+import (
+	"context"
+	"testing"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// cursorTest returns the path of a db file with the table t; the rows
+// (1, a), (2, b), (3, a), ..., of n rows.
+func cursorTest(t *testing.T, d *DBAccess, n int) string {
+
+	t.Helper()
+
+	rows := make([][]interface{}, n)
+	for i := 0; i < n; i++ {
+		v := "a"
+		if i%2 == 1 {
+			v = "b"
+		}
+		rows[i] = []interface{}{i + 1, v}
+	}
+
+	return testDB(t, d, "cursor.sqlite", []string{"CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT)"}, "INSERT INTO t (id, v) VALUES (?, ?)", rows...)
+}
+
+func TestCursor(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := cursorTest(t, d, 5)
+
+	c, err := d.OpenCursor("SELECT id, v FROM t WHERE id > ? ORDER BY id", dbFilePath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if cols := c.Columns(); len(cols) != 2 || cols[0] != "id" || cols[1] != "v" {
+		t.Errorf("Columns = %v", cols)
+	}
+
+	type row struct {
+		ID int
+		V  string
+	}
+
+	var ids []int
+	for c.Next() {
+		var r row
+		if err = c.Scan(&r); err != nil {
+			t.Fatal(err)
+		}
+		// The row is read once; Map returns the same values.
+		m, err := c.Map()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m["id"] != int64(r.ID) {
+			t.Errorf("Map %v; Scan %v", m, r)
+		}
+		ids = append(ids, r.ID)
+	}
+	if err = c.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 4 || ids[0] != 2 || ids[3] != 5 {
+		t.Errorf("got ids %v", ids)
+	}
+
+	// The cursor is closed after the last row.
+	if c.Next() {
+		t.Error("Next after the last row")
+	}
+	if err = c.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCursorGroupBy(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := cursorTest(t, d, 5)
+
+	const q = "SELECT v, count(*) AS n FROM t GROUP BY v ORDER BY v"
+
+	type group struct {
+		V string
+		N int
+	}
+
+	want := []group{{"a", 3}, {"b", 2}}
+
+	check := func(api string, got []group, err error) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s: %v", api, err)
+			return
+		}
+		if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("%s: got %v; want %v", api, got, want)
+		}
+	}
+
+	got, err := Query[group](d, q, dbFilePath)
+	check("Query", got, err)
+
+	got = nil
+	for g, err := range QueryIter[group](context.Background(), d, q, dbFilePath) {
+		if err != nil {
+			check("QueryIter", nil, err)
+			break
+		}
+		got = append(got, g)
+	}
+	check("QueryIter", got, nil)
+
+	got = nil
+	for m, err := range d.IterRows(context.Background(), q, dbFilePath) {
+		if err != nil {
+			check("IterRows", nil, err)
+			break
+		}
+		got = append(got, group{m["v"].(string), int(m["n"].(int64))})
+	}
+	check("IterRows", got, nil)
+
+	dt, err := d.GetDataTableLongQuery(q, dbFilePath, 1, func(LonqQueryArgs) {})
+	got = nil
+	if err == nil {
+		for _, m := range dt.Rows.GetRows() {
+			got = append(got, group{m["v"].(string), int(m["n"].(int64))})
+		}
+	}
+	check("GetDataTableLongQuery", got, err)
+}
+
+func TestCursorAppendTo(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := cursorTest(t, d, 3)
+
+	tbl, _ := collc.NewCollection().Table.Create("t")
+	tbl.Cols.Add("id")
+
+	c, err := d.OpenCursor("SELECT id, v FROM t", dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for c.Next() {
+		if err = c.AppendTo(tbl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := tbl.Cols.Count(); n != 2 {
+		t.Errorf("the table has %d columns; want 2", n)
+	}
+	if n := tbl.Rows.Count(); n != 3 {
+		t.Errorf("the table has %d rows; want 3", n)
+	}
+}
+
+func TestGetDataTableLongQueryNotify(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := cursorTest(t, d, 10)
+
+	var calls []LonqQueryArgs
+	dt, err := d.GetDataTableLongQuery("SELECT * FROM t", dbFilePath, 4, func(a LonqQueryArgs) {
+		if a.ResultTable.Rows.Count() != int(a.RowsFetched) {
+			t.Errorf("the table has %d rows; RowsFetched %d", a.ResultTable.Rows.Count(), a.RowsFetched)
+		}
+		calls = append(calls, a)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dt.Rows.Count() != 10 {
+		t.Fatalf("read %d rows; want 10", dt.Rows.Count())
+	}
+
+	// Two full pages, and the last notification; in order.
+	if len(calls) != 3 {
+		t.Fatalf("got %d notifications; want 3", len(calls))
+	}
+	for i, want := range []int64{4, 8, 10} {
+		if calls[i].RowsFetched != want {
+			t.Errorf("notification %d: RowsFetched %d; want %d", i, calls[i].RowsFetched, want)
+		}
+	}
+	// The total is known from the first notification.
+	for i := 0; i < len(calls); i++ {
+		if calls[i].TotalToFetch != 10 || calls[i].TotalPages != 3 {
+			t.Errorf("notification %d: TotalToFetch %d, TotalPages %d; want 10, 3", i, calls[i].TotalToFetch, calls[i].TotalPages)
+		}
+	}
+}
@@ -0,0 +1,750 @@
+package sqlitehench
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	collc "github.com/kambahr/go-collections"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// RowChangeKind is the kind of a row difference.
+type RowChangeKind int
+
+const (
+	// RowInserted is a row that is only in the source.
+	RowInserted RowChangeKind = iota + 1
+	// RowDeleted is a row that is only in the destination.
+	RowDeleted
+	// RowChanged is a row that is in both, with different values.
+	RowChanged
+)
+
+func (k RowChangeKind) String() string {
+	switch k {
+	case RowInserted:
+		return "inserted"
+	case RowDeleted:
+		return "deleted"
+	case RowChanged:
+		return "changed"
+	}
+	return "unknown"
+}
+
+// ColumnChange is the old (destination) and new (source) value of a
+// column of a changed row.
+type ColumnChange struct {
+	Column string
+	Old    interface{}
+	New    interface{}
+}
+
+// RowDiff is one row difference; as a change to the destination, that
+// makes it match the source.
+type RowDiff struct {
+	Kind RowChangeKind
+
+	// Table is the destination table.
+	Table string
+
+	// Key holds the values of the key columns.
+	Key map[string]interface{}
+
+	// Old is the destination row (nil for an inserted row); New is
+	// the source row (nil for a deleted row).
+	Old map[string]interface{}
+	New map[string]interface{}
+
+	// Changes are the changed columns of a RowChanged.
+	Changes []ColumnChange
+
+	keys []string
+	cols []string
+}
+
+// SQL returns the INSERT, DELETE or UPDATE statement that applies the
+// difference to the destination table.
+func (r RowDiff) SQL() string {
+
+	var where []string
+	for i := 0; i < len(r.keys); i++ {
+		where = append(where, fmt.Sprintf("[%s] = %s", r.keys[i], sqlLiteral(r.Key[r.keys[i]])))
+	}
+
+	switch r.Kind {
+	case RowInserted:
+		cols := r.cols
+		vals := make([]string, len(cols))
+		for i := 0; i < len(cols); i++ {
+			vals[i] = sqlLiteral(r.New[cols[i]])
+		}
+		return fmt.Sprintf("INSERT INTO [%s] (%s) VALUES (%s)", r.Table, quoteColumnList(cols), strings.Join(vals, ", "))
+
+	case RowDeleted:
+		return fmt.Sprintf("DELETE FROM [%s] WHERE %s", r.Table, strings.Join(where, " AND "))
+
+	case RowChanged:
+		set := make([]string, len(r.Changes))
+		for i := 0; i < len(r.Changes); i++ {
+			set[i] = fmt.Sprintf("[%s] = %s", r.Changes[i].Column, sqlLiteral(r.Changes[i].New))
+		}
+		return fmt.Sprintf("UPDATE [%s] SET %s WHERE %s", r.Table, strings.Join(set, ", "), strings.Join(where, " AND "))
+	}
+
+	return ""
+}
+
+// DataDiff compares the rows of a table in two databases; by the key
+// columns (the primary key of the source table, unless opt.Keys is
+// set). The differences are read as the tables are read (both in key
+// order); so that large tables can be compared. The columns that are
+// in both tables are compared; values of a different storage class
+// (i.e. 5 and '5') are different.
+//
+//	for r, err := range d.DataDiff(dbFilePath, cloneFilePath, "Customer", sqlitehench.DataDiffOptions{}) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(r.Kind, r.Key, r.Changes)
+//	}
+func (d *DBAccess) DataDiff(srcFilePath string, destFilePath string, tblName string, opt DataDiffOptions) iter.Seq2[RowDiff, error] {
+	return d.DataDiffContext(context.Background(), srcFilePath, destFilePath, tblName, opt)
+}
+
+// DataDiffContext is DataDiff with a ctx.
+func (d *DBAccess) DataDiffContext(ctx context.Context, srcFilePath string, destFilePath string, tblName string, opt DataDiffOptions) iter.Seq2[RowDiff, error] {
+
+	return func(yield func(RowDiff, error) bool) {
+
+		err := d.dataDiff(ctx, srcFilePath, destFilePath, tblName, opt, yield)
+		if err != nil && !errors.Is(err, errStopDiff) {
+			yield(RowDiff{}, wrapErr("DataDiff", destFilePath, "", err))
+		}
+	}
+}
+
+// errStopDiff is returned when the range loop stops early.
+var errStopDiff = errors.New("stopped")
+
+func (d *DBAccess) dataDiff(ctx context.Context, srcFilePath string, destFilePath string, tblName string,
+	opt DataDiffOptions, yield func(RowDiff, error) bool) error {
+
+	destTable := opt.DestTable
+	if destTable == "" {
+		destTable = tblName
+	}
+
+	srcInfo, err := d.GetTableInfoContext(ctx, srcFilePath, tblName)
+	if err != nil {
+		return err
+	}
+	destInfo, err := d.GetTableInfoContext(ctx, destFilePath, destTable)
+	if err != nil {
+		return err
+	}
+
+	keys := opt.Keys
+	if len(keys) == 0 {
+		keys = srcInfo.PrimaryKey()
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%s has no primary key; set DataDiffOptions.Keys", tblName)
+	}
+
+	order := make([]string, len(keys))
+	for i := 0; i < len(keys); i++ {
+		if destInfo.Column(keys[i]) == nil {
+			return fmt.Errorf("key column %s is not in %s", keys[i], destTable)
+		}
+		order[i] = fmt.Sprintf("[%s] COLLATE BINARY", strings.Trim(keys[i], "[]"))
+	}
+
+	where := ""
+	if strings.TrimSpace(opt.Where) != "" {
+		where = fmt.Sprintf(" WHERE (%s)", opt.Where)
+	}
+
+	src, err := d.openDiffReader(ctx, srcFilePath, fmt.Sprintf("SELECT %s FROM [%s]%s ORDER BY %s",
+		diffColumns(srcInfo), srcInfo.Name, where, strings.Join(order, ", ")), opt.Args)
+	if err != nil {
+		return err
+	}
+	defer src.close()
+
+	dest, err := d.openDiffReader(ctx, destFilePath, fmt.Sprintf("SELECT %s FROM [%s]%s ORDER BY %s",
+		diffColumns(destInfo), destInfo.Name, where, strings.Join(order, ", ")), opt.Args)
+	if err != nil {
+		return err
+	}
+	defer dest.close()
+
+	cmp := newDiffComparer(src.cols, dest.cols, keys, opt.Ignore)
+	for i := 0; i < len(cmp.destKeys); i++ {
+		if cmp.destKeys[i] == "" {
+			return fmt.Errorf("key column %s is not in both tables", keys[i])
+		}
+	}
+
+	emit := func(r RowDiff) error {
+		r.Table = destInfo.Name
+		r.keys = cmp.destKeys
+		if opt.Patch != nil {
+			if _, err := io.WriteString(opt.Patch, r.SQL()+";\n"); err != nil {
+				return err
+			}
+		}
+		if !yield(r, nil) {
+			return errStopDiff
+		}
+		return nil
+	}
+
+	src.next()
+	dest.next()
+
+	for src.ok || dest.ok {
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		c := 0
+		switch {
+		case !dest.ok:
+			c = -1
+		case !src.ok:
+			c = 1
+		default:
+			c = cmp.compareKeys(src.vals, dest.vals)
+		}
+
+		var r *RowDiff
+		switch {
+		case c < 0:
+			r = cmp.inserted(src.vals)
+			src.next()
+		case c > 0:
+			r = cmp.deleted(dest.vals)
+			dest.next()
+		default:
+			r = cmp.changed(src.vals, dest.vals)
+			src.next()
+			dest.next()
+		}
+
+		if r != nil {
+			if err = emit(*r); err != nil {
+				return err
+			}
+		}
+	}
+
+	if src.err != nil {
+		return src.err
+	}
+
+	return dest.err
+}
+
+// DataDiffTables compares the rows of two DataTables by the key columns
+// (opt.Keys); as DataDiff does for the tables of two databases.
+// opt.Where and opt.Args do not apply.
+func DataDiffTables(src *collc.Table, dest *collc.Table, opt DataDiffOptions) ([]RowDiff, error) {
+
+	if src == nil || dest == nil {
+		return nil, errors.New("DataTable is nil")
+	}
+	if len(opt.Keys) == 0 {
+		return nil, errors.New("DataDiffOptions.Keys is required")
+	}
+
+	srcCols := columnNames(src.Cols.Get())
+	destCols := columnNames(dest.Cols.Get())
+
+	cmp := newDiffComparer(srcCols, destCols, opt.Keys, opt.Ignore)
+	for i := 0; i < len(cmp.destKeys); i++ {
+		if cmp.destKeys[i] == "" {
+			return nil, fmt.Errorf("key column %s is not in both tables", opt.Keys[i])
+		}
+	}
+
+	destTable := opt.DestTable
+	if destTable == "" {
+		destTable = dest.Name
+	}
+
+	rowValues := func(m map[string]interface{}, cols []string) []interface{} {
+		v := make([]interface{}, len(cols))
+		for i := 0; i < len(cols); i++ {
+			v[i] = m[cols[i]]
+		}
+		return v
+	}
+
+	// The destination rows by key.
+	destRows := dest.Rows.GetRows()
+	byKey := make(map[string]int, len(destRows))
+	for i := 0; i < len(destRows); i++ {
+		byKey[cmp.keyString(rowValues(destRows[i], destCols), cmp.destKeyIdx)] = i
+	}
+
+	var ret []RowDiff
+	matched := make([]bool, len(destRows))
+
+	add := func(r *RowDiff) error {
+		if r == nil {
+			return nil
+		}
+		r.Table = destTable
+		r.keys = cmp.destKeys
+		ret = append(ret, *r)
+		if opt.Patch != nil {
+			if _, err := io.WriteString(opt.Patch, r.SQL()+";\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	srcRows := src.Rows.GetRows()
+	for i := 0; i < len(srcRows); i++ {
+		sv := rowValues(srcRows[i], srcCols)
+		k, ok := byKey[cmp.keyString(sv, cmp.srcKeyIdx)]
+		var r *RowDiff
+		if ok {
+			matched[k] = true
+			r = cmp.changed(sv, rowValues(destRows[k], destCols))
+		} else {
+			r = cmp.inserted(sv)
+		}
+		if err := add(r); err != nil {
+			return ret, err
+		}
+	}
+
+	for i := 0; i < len(destRows); i++ {
+		if !matched[i] {
+			if err := add(cmp.deleted(rowValues(destRows[i], destCols))); err != nil {
+				return ret, err
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func columnNames(cols []collc.Column) []string {
+
+	names := make([]string, len(cols))
+	for i := 0; i < len(cols); i++ {
+		names[i] = cols[i].Name
+	}
+
+	return names
+}
+
+// diffColumns returns the select list of a side of a DataDiff; the
+// columns of SELECT *, each with a unary plus. A unary plus leaves the
+// value as it is, but makes the column an expression, which has no
+// declared type; so the driver returns the values as they are stored
+// (i.e. a DATETIME column is not converted to time.Time), and they are
+// compared in the order of the ORDER BY.
+func diffColumns(t *TableInfo) string {
+
+	var sel []string
+	for i := 0; i < len(t.Columns); i++ {
+		if t.Columns[i].Hidden != 1 {
+			sel = append(sel, fmt.Sprintf("+[%s] AS [%s]", t.Columns[i].Name, t.Columns[i].Name))
+		}
+	}
+
+	return strings.Join(sel, ", ")
+}
+
+// diffReader reads the rows of one side of a DataDiff.
+type diffReader struct {
+	rows    *sql.Rows
+	release func()
+	cols    []string
+	vals    []interface{}
+	ok      bool
+	err     error
+}
+
+// openDiffReader opens a side of a DataDiff; on a handle of its own, as
+// both sides are read at once (and may be of the same file).
+func (d *DBAccess) openDiffReader(ctx context.Context, dbFilePath string, sqlQuery string, args []interface{}) (*diffReader, error) {
+
+	var err error
+
+	if args, err = bindArgs(args); err != nil {
+		return nil, err
+	}
+
+	db, release, err := d.acquireOwnDB(dbFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		release()
+		return nil, ctxErr(ctx, err)
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		release()
+		return nil, err
+	}
+
+	return &diffReader{rows: rows, release: release, cols: cols}, nil
+}
+
+func (r *diffReader) next() {
+
+	r.ok = false
+
+	if r.err != nil || !r.rows.Next() {
+		if r.err == nil {
+			r.err = r.rows.Err()
+		}
+		return
+	}
+
+	vals := make([]interface{}, len(r.cols))
+	ptrs := make([]interface{}, len(r.cols))
+	for i := 0; i < len(vals); i++ {
+		ptrs[i] = &vals[i]
+	}
+	if r.err = r.rows.Scan(ptrs...); r.err != nil {
+		return
+	}
+
+	r.vals = vals
+	r.ok = true
+}
+
+func (r *diffReader) close() {
+	r.rows.Close()
+	r.release()
+}
+
+// diffComparer compares the rows of two sides by their column positions.
+type diffComparer struct {
+	srcCols  []string
+	destCols []string
+
+	srcKeyIdx  []int
+	destKeyIdx []int
+	destKeys   []string
+
+	// the compared columns; by position in each side
+	cmpSrc  []int
+	cmpDest []int
+}
+
+func newDiffComparer(srcCols []string, destCols []string, keys []string, ignore []string) *diffComparer {
+
+	find := func(cols []string, name string) int {
+		name = strings.Trim(name, "[]")
+		for i := 0; i < len(cols); i++ {
+			if strings.EqualFold(cols[i], name) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	c := &diffComparer{srcCols: srcCols, destCols: destCols}
+
+	for i := 0; i < len(keys); i++ {
+		s, t := find(srcCols, keys[i]), find(destCols, keys[i])
+		c.srcKeyIdx = append(c.srcKeyIdx, s)
+		c.destKeyIdx = append(c.destKeyIdx, t)
+		if t >= 0 && s >= 0 {
+			c.destKeys = append(c.destKeys, destCols[t])
+		} else {
+			c.destKeys = append(c.destKeys, "")
+		}
+	}
+
+	for i := 0; i < len(srcCols); i++ {
+		t := find(destCols, srcCols[i])
+		if t < 0 || find(keys, srcCols[i]) >= 0 || find(ignore, srcCols[i]) >= 0 {
+			continue
+		}
+		c.cmpSrc = append(c.cmpSrc, i)
+		c.cmpDest = append(c.cmpDest, t)
+	}
+
+	return c
+}
+
+func (c *diffComparer) compareKeys(src []interface{}, dest []interface{}) int {
+
+	for i := 0; i < len(c.srcKeyIdx); i++ {
+		if n := compareValues(src[c.srcKeyIdx[i]], dest[c.destKeyIdx[i]]); n != 0 {
+			return n
+		}
+	}
+
+	return 0
+}
+
+// keyString returns the key of a row as a string; for the map of rows
+// by key.
+func (c *diffComparer) keyString(vals []interface{}, idx []int) string {
+
+	var sb strings.Builder
+	for i := 0; i < len(idx); i++ {
+		v := vals[idx[i]]
+		switch x := v.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			// 1 and 1.0 are the same key
+			f, _ := toFloat(x)
+			sb.WriteString("n:" + strconv.FormatFloat(f, 'g', -1, 64))
+		default:
+			sb.WriteString(fmt.Sprintf("%T:%v", v, v))
+		}
+		sb.WriteByte(0)
+	}
+
+	return sb.String()
+}
+
+func (c *diffComparer) keyMap(vals []interface{}, idx []int) map[string]interface{} {
+
+	m := make(map[string]interface{}, len(idx))
+	for i := 0; i < len(idx); i++ {
+		m[c.destKeys[i]] = vals[idx[i]]
+	}
+
+	return m
+}
+
+func rowMap(cols []string, vals []interface{}) map[string]interface{} {
+
+	m := make(map[string]interface{}, len(cols))
+	for i := 0; i < len(cols); i++ {
+		m[cols[i]] = vals[i]
+	}
+
+	return m
+}
+
+func (c *diffComparer) inserted(src []interface{}) *RowDiff {
+
+	// The source row with the destination column names.
+	m := make(map[string]interface{})
+	var cols []string
+	for i := 0; i < len(c.srcKeyIdx); i++ {
+		m[c.destKeys[i]] = src[c.srcKeyIdx[i]]
+		cols = append(cols, c.destKeys[i])
+	}
+	for i := 0; i < len(c.cmpSrc); i++ {
+		m[c.destCols[c.cmpDest[i]]] = src[c.cmpSrc[i]]
+		cols = append(cols, c.destCols[c.cmpDest[i]])
+	}
+
+	return &RowDiff{Kind: RowInserted, Key: c.keyMap(src, c.srcKeyIdx), New: m, cols: cols}
+}
+
+func (c *diffComparer) deleted(dest []interface{}) *RowDiff {
+	return &RowDiff{Kind: RowDeleted, Key: c.keyMap(dest, c.destKeyIdx), Old: rowMap(c.destCols, dest)}
+}
+
+// changed returns the difference of two rows of the same key; nil if
+// the compared columns are the same.
+func (c *diffComparer) changed(src []interface{}, dest []interface{}) *RowDiff {
+
+	var changes []ColumnChange
+
+	for i := 0; i < len(c.cmpSrc); i++ {
+		s, t := src[c.cmpSrc[i]], dest[c.cmpDest[i]]
+		if compareValues(s, t) != 0 {
+			changes = append(changes, ColumnChange{Column: c.destCols[c.cmpDest[i]], Old: t, New: s})
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return &RowDiff{
+		Kind:    RowChanged,
+		Key:     c.keyMap(dest, c.destKeyIdx),
+		Old:     rowMap(c.destCols, dest),
+		New:     rowMap(c.srcCols, src),
+		Changes: changes,
+	}
+}
+
+// compareValues compares two values in the order of SQLite's ORDER BY
+// (BINARY collation); NULL, numbers, text, blobs.
+func compareValues(a interface{}, b interface{}) int {
+
+	ca, cb := valueClass(a), valueClass(b)
+	if ca != cb {
+		if ca < cb {
+			return -1
+		}
+		return 1
+	}
+
+	switch ca {
+	case 0:
+		return 0
+	case 1:
+		x, _ := toFloat(a)
+		y, _ := toFloat(b)
+		ix, okx := a.(int64)
+		iy, oky := b.(int64)
+		if okx && oky {
+			// exact, beyond the float precision
+			return cmpInt(ix, iy)
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case 2:
+		return strings.Compare(valueText(a), valueText(b))
+	}
+
+	return bytes.Compare(a.([]byte), b.([]byte))
+}
+
+func cmpInt(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// valueClass returns the order of the storage class of a value; 0 NULL,
+// 1 number, 2 text, 3 blob.
+func valueClass(v interface{}) int {
+
+	switch v.(type) {
+	case nil:
+		return 0
+	case []byte:
+		return 3
+	case string, time.Time:
+		return 2
+	}
+
+	if _, ok := toFloat(v); ok {
+		return 1
+	}
+
+	return 2
+}
+
+func valueText(v interface{}) string {
+
+	switch x := v.(type) {
+	case string:
+		return x
+	case time.Time:
+		return x.Format(sqlite3.SQLiteTimestampFormats[0])
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	case int:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int16:
+		return float64(x), true
+	case int8:
+		return float64(x), true
+	case uint:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case uint32:
+		return float64(x), true
+	case uint16:
+		return float64(x), true
+	case uint8:
+		return float64(x), true
+	case float32:
+		return float64(x), true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// sqlLiteral returns a value as an SQL literal.
+func sqlLiteral(v interface{}) string {
+
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return "X'" + hex.EncodeToString(x) + "'"
+	case string:
+		return "'" + strings.ReplaceAll(x, "'", "''") + "'"
+	case time.Time:
+		return "'" + x.Format(sqlite3.SQLiteTimestampFormats[0]) + "'"
+	case float64:
+		// ±Inf as the sqlite3 shell writes it; NaN is NULL
+		switch {
+		case math.IsInf(x, 1):
+			return "9.0e+999"
+		case math.IsInf(x, -1):
+			return "-9.0e+999"
+		case math.IsNaN(x):
+			return "NULL"
+		}
+		s := strconv.FormatFloat(x, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eE") {
+			// keep it REAL
+			s += ".0"
+		}
+		return s
+	}
+
+	if f, ok := toFloat(v); ok {
+		if _, isBool := v.(bool); isBool {
+			return strconv.FormatFloat(f, 'f', 0, 64)
+		}
+		return fmt.Sprintf("%v", v)
+	}
+
+	return "'" + strings.ReplaceAll(fmt.Sprintf("%v", v), "'", "''") + "'"
+}
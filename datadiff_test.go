@@ -0,0 +1,176 @@
+package sqlitehench
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// dataDiffTest returns the paths of two db files; with the statements
+// of each run in it.
+func dataDiffTest(t *testing.T, d *DBAccess, src []string, dest []string) (string, string) {
+
+	t.Helper()
+
+	return testDB(t, d, "src.sqlite", src, ""), testDB(t, d, "dest.sqlite", dest, "")
+}
+
+// collectDiff returns the differences of DataDiff.
+func collectDiff(t *testing.T, d *DBAccess, srcFilePath string, destFilePath string, tblName string, opt DataDiffOptions) []RowDiff {
+
+	t.Helper()
+
+	var ret []RowDiff
+	for r, err := range d.DataDiff(srcFilePath, destFilePath, tblName, opt) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, r)
+	}
+
+	return ret
+}
+
+func TestDataDiff(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	const create = "CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT, seen TEXT)"
+	srcFilePath, destFilePath := dataDiffTest(t, d,
+		[]string{create, "INSERT INTO t VALUES (1, 'a', 'x'), (2, 'b', 'x'), (4, 'd', 'x')"},
+		[]string{create, "INSERT INTO t VALUES (1, 'a', 'y'), (2, 'B', 'y'), (3, 'c', 'y')"})
+
+	var patch bytes.Buffer
+	diffs := collectDiff(t, d, srcFilePath, destFilePath, "t", DataDiffOptions{Ignore: []string{"seen"}, Patch: &patch})
+
+	want := []struct {
+		kind RowChangeKind
+		id   int64
+	}{{RowChanged, 2}, {RowDeleted, 3}, {RowInserted, 4}}
+
+	if len(diffs) != len(want) {
+		t.Fatalf("got %d differences; want %d: %+v", len(diffs), len(want), diffs)
+	}
+	for i := range want {
+		if diffs[i].Kind != want[i].kind || diffs[i].Key["id"] != want[i].id {
+			t.Errorf("difference %d: %v %v; want %v %d", i, diffs[i].Kind, diffs[i].Key, want[i].kind, want[i].id)
+		}
+	}
+	if c := diffs[0].Changes; len(c) != 1 || c[0].Column != "v" || c[0].Old != "B" || c[0].New != "b" {
+		t.Errorf("changes %+v", c)
+	}
+
+	// The patch makes the destination match the source.
+	if _, err := d.ExecuteNonQuery(patch.String(), destFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if diffs = collectDiff(t, d, srcFilePath, destFilePath, "t", DataDiffOptions{Ignore: []string{"seen"}}); len(diffs) != 0 {
+		t.Errorf("after the patch: %+v", diffs)
+	}
+
+	// The loop can stop early.
+	n := 0
+	for range d.DataDiff(srcFilePath, destFilePath, "t", DataDiffOptions{}) {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf("read %d differences", n)
+	}
+}
+
+func TestDataDiffDateTimeKey(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	// In the order of SQLite, ' ' is before 'T'; as time.Time values
+	// 10:00 would be after 09:00.
+	const create = "CREATE TABLE e (at DATETIME PRIMARY KEY, v TEXT)"
+	srcFilePath, destFilePath := dataDiffTest(t, d,
+		[]string{create, "INSERT INTO e VALUES ('2024-01-01 10:00:00', 'a'), ('2024-01-01T09:00:00', 'b')"},
+		[]string{create, "INSERT INTO e VALUES ('2024-01-01T09:00:00', 'b')"})
+
+	diffs := collectDiff(t, d, srcFilePath, destFilePath, "e", DataDiffOptions{})
+	if len(diffs) != 1 || diffs[0].Kind != RowInserted || diffs[0].Key["at"] != "2024-01-01 10:00:00" {
+		t.Errorf("got %+v", diffs)
+	}
+}
+
+func TestDataDiffKeys(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	srcFilePath, destFilePath := dataDiffTest(t, d,
+		[]string{"CREATE TABLE t (code TEXT, v INTEGER)", "INSERT INTO t VALUES ('a', 1), ('b', 2)"},
+		[]string{"CREATE TABLE u (code TEXT, v INTEGER)", "INSERT INTO u VALUES ('a', 1), ('b', 3)"})
+
+	// A table without a primary key needs the Keys.
+	for _, err := range d.DataDiff(srcFilePath, destFilePath, "t", DataDiffOptions{DestTable: "u"}) {
+		if err == nil {
+			t.Error("got no error")
+		}
+	}
+
+	diffs := collectDiff(t, d, srcFilePath, destFilePath, "t", DataDiffOptions{DestTable: "u", Keys: []string{"code"}})
+	if len(diffs) != 1 || diffs[0].Table != "u" || diffs[0].SQL() != "UPDATE [u] SET [v] = 2 WHERE [code] = 'b'" {
+		t.Errorf("got %+v", diffs)
+	}
+}
+
+func TestDataDiffTables(t *testing.T) {
+
+	table := func(vals ...interface{}) *collc.Table {
+		tbl, _ := collc.NewCollection().Table.Create("t")
+		tbl.Cols.Add("id")
+		tbl.Cols.Add("v")
+		for i := 0; i < len(vals); i += 2 {
+			r := tbl.Rows.New()
+			r["id"] = vals[i]
+			r["v"] = vals[i+1]
+		}
+		return tbl
+	}
+
+	diffs, err := DataDiffTables(table(int64(1), "a", int64(2), "b"), table(int64(2), "c", int64(3), "d"), DataDiffOptions{Keys: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := map[RowChangeKind]int{}
+	for i := 0; i < len(diffs); i++ {
+		kinds[diffs[i].Kind]++
+	}
+	if len(diffs) != 3 || kinds[RowInserted] != 1 || kinds[RowDeleted] != 1 || kinds[RowChanged] != 1 {
+		t.Errorf("got %+v", diffs)
+	}
+
+	// ±Inf is written as the sqlite3 shell writes it; not as NULL.
+	var b bytes.Buffer
+	if _, err = DataDiffTables(table(int64(1), math.Inf(1), int64(2), math.Inf(-1)), table(), DataDiffOptions{Keys: []string{"id"}, Patch: &b}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "9.0e+999") || !strings.Contains(b.String(), "-9.0e+999") || strings.Contains(b.String(), "NULL") {
+		t.Errorf("got %s", b.String())
+	}
+
+	// The error of the patch writer is returned.
+	if _, err = DataDiffTables(table(int64(1), "a"), table(), DataDiffOptions{Keys: []string{"id"}, Patch: failingWriter{}}); !errors.Is(err, errWriter) {
+		t.Errorf("got %v; want %v", err, errWriter)
+	}
+}
+
+var errWriter = errors.New("no space")
+
+// failingWriter is an io.Writer that returns errWriter.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errWriter
+}
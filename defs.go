@@ -2,6 +2,7 @@ package sqlitehench
 
 import (
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -19,8 +20,99 @@ type DBAccess struct {
 	// ShrinkWatchList keeps a list of sqlite database
 	// file paths that are to be shrinked in a set internval.
 	ShrinkWatchList []string
+
+	// ConnPolicy determines whether a database is kept open
+	// (per db file path) or closed after an operation.
+	ConnPolicy ConnPolicy
+
+	// ConnIdleTimeout is how long a pooled database stays open
+	// without being used; the default is two minutes.
+	ConnIdleTimeout time.Duration
+
+	// RetryPolicy retries the write operations that fail
+	// because the database is busy or locked.
+	RetryPolicy RetryPolicy
+
+	// ColumnEncryption declares the columns whose values are
+	// encrypted on write, and decrypted on read; it is read once,
+	// by NewDBAccess.
+	ColumnEncryption ColumnEncryption
+
+	pool *connPool
+
+	// pragmaDriver is the driver of GetDB; which runs the PRAGMA on
+	// every connection (see connDriver).
+	pragmaDriver string
+
+	// crypter holds the ciphers of the ColumnEncryption; nil when
+	// no column is encrypted.
+	crypter *columnCrypter
+
+	// sessions holds the open EncryptedDB sessions.
+	sessions *encryptedSessions
+}
+
+// RetryPolicy applies to ExecuteNonQuery, InsertDataTable, BulkInsert and
+// CloneDatabase. Each statement is retried on its own (it runs in a
+// transaction that is rolled back on failure); so rows that have already
+// been written are not written again.
+type RetryPolicy struct {
+	// BusyTimeout is set as PRAGMA busy_timeout; SQLite waits up to
+	// this long for a lock, before a statement fails with SQLITE_BUSY.
+	BusyTimeout time.Duration
+
+	// MaxAttempts is the number of times a statement is run
+	// (including the first attempt); less than 2 disables retries.
+	MaxAttempts int
+
+	// InitialBackoff is the wait time before the first retry (default 50ms);
+	// it is multiplied by Multiplier (default 2) for each further retry,
+	// up to MaxBackoff (default 2s).
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter randomises the wait time by +/- this fraction (0 to 1);
+	// 0 is no jitter, and a negative value is the default of 0.2.
+	Jitter float64
+
+	// RetryOn decides whether an error is to be retried; the default
+	// retries on "database is locked" (SQLITE_BUSY/SQLITE_LOCKED).
+	RetryOn func(err error) bool
+
+	// Notify is called once an operation is completed, with the number
+	// of retries it took.
+	Notify func(r RetryReport)
 }
 
+// RetryReport is passed to RetryPolicy.Notify.
+type RetryReport struct {
+	Operation  string
+	DBFilePath string
+	Retries    int
+	Elapsed    time.Duration
+
+	// Err is the error that the operation returned (if any).
+	Err error
+}
+
+// ConnPolicy is the open/close policy of the database files
+// that are accessed via the non-PointToDB functions.
+type ConnPolicy int
+
+const (
+	// ConnPolicyCloseAfterWrite (the default) keeps the database open
+	// for reads; but closes it after each write operation.
+	ConnPolicyCloseAfterWrite ConnPolicy = iota
+
+	// ConnPolicyPooled keeps one database handle per db file path open;
+	// it is closed after it has been idle for ConnIdleTimeout.
+	ConnPolicyPooled
+
+	// ConnPolicyCloseAfterOp opens and closes the database on every call.
+	ConnPolicyCloseAfterOp
+)
+
 // CollectionInfo holds Grid info for use in the client javascript.
 type CollectionInfo struct {
 	RecordCount  int
@@ -29,11 +121,109 @@ type CollectionInfo struct {
 	PageNo       int
 	PositionFrom int
 	PositionTo   int
+
+	// Approximate is set when RecordCount (and TotalPages) are
+	// an estimate; see KeysetOptions.CountLimit.
+	Approximate bool
+}
+
+// BulkInsertOptions configures BulkInsertWithOptions.
+type BulkInsertOptions struct {
+	// BatchSize is the number of rows that are written per
+	// transaction; the default is 10,000.
+	BatchSize int
+
+	// Workers is the number of goroutines that prepare the row values
+	// of the next batches, while a batch is being written; less than 2
+	// prepares them on the writer goroutine.
+	Workers int
+
+	// KeepTable inserts into the existing table; otherwise the table
+	// is (re-)created from the DataTable columns, as in BulkInsert.
+	KeepTable bool
+
+	// Notify is called after each batch is committed.
+	Notify func(p BulkInsertProgress)
+}
+
+// BulkInsertProgress is passed to BulkInsertOptions.Notify.
+type BulkInsertProgress struct {
+	RowsInserted int64
+	TotalRows    int64
+	Elapsed      time.Duration
+
+	// RowsPerSec is the average insert rate so far.
+	RowsPerSec float64
+
+	// Status is the progress in text form (as passed to the
+	// BulkInsert notify func).
+	Status string
+}
+
+// CreateTableOptions configures the CREATE TABLE statement of
+// CreateNewDatabaseWithOptions and ExportDataTableToDatabaseWithOptions.
+type CreateTableOptions struct {
+	// SampleSize is the number of rows that are read to infer the
+	// column types; the default is 1,000, and -1 reads all rows.
+	// NOT NULL is only declared, when all rows have been read.
+	SampleSize int
+
+	// ColumnTypes sets the declared type of columns (by name); i.e.
+	// {"Price": "NUMERIC"}. They take precedence over the Column.Type
+	// metadata and the inferred types.
+	ColumnTypes map[string]string
+
+	// NoInference declares every column as Blob NULL.
+	NoInference bool
+
+	// PrimaryKey is the column(s) of the primary key.
+	PrimaryKey []string
+
+	Indexes []IndexSpec
 }
 
+// IndexSpec describes an index of CreateTableOptions.
+type IndexSpec struct {
+	// Name is the index name; the default is ix_<table>_<columns>.
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// KeysetOptions describes a keyset (seek) page request; see
+// GetDataMapPageKeyset.
+type KeysetOptions struct {
+	// Keys are the columns that the rows are ordered by; together
+	// they must be unique (i.e. the primary key, or Name and ID),
+	// and must be selected by the query.
+	Keys []KeysetKey
+
+	PageSize int
+
+	// Token is the continuation token returned with the previous
+	// page; empty for the first page.
+	Token string
+
+	// CountLimit caps the row count of the first page request; when
+	// the query has more rows, the record count is estimated from
+	// the table statistics (CollectionInfo.Approximate). Zero counts
+	// all rows. The count is carried in the token; so it is done once.
+	CountLimit int
+}
+
+// KeysetKey is an order-by column of a keyset page.
+type KeysetKey struct {
+	Column string
+	Desc   bool
+}
+
+// LonqQueryArgs is passed to the notify func of GetDataTableLongQuery.
 type LonqQueryArgs struct {
-	ResultTable  *collections.Table
-	RowsFetched  int64
+	ResultTable *collections.Table
+	RowsFetched int64
+
+	// TotalToFetch and TotalPages are of the rows counted before the
+	// query is read.
 	TotalToFetch int64
 	TotalPages   int
 	PageSize     int
@@ -94,3 +284,405 @@ func (d *DBAccess) convertStringToTime(dateTimeString string) (time.Time, error)
 
 	return t, err
 }
+
+// MigratorOptions configures a Migrator.
+type MigratorOptions struct {
+	// Table is the table that records the applied versions (with
+	// their names and times); when empty, PRAGMA user_version holds
+	// the latest applied version.
+	Table string
+
+	// DryRun runs the pending migrations in one transaction that is
+	// rolled back; so that the SQL is checked without changing the
+	// database.
+	DryRun bool
+
+	// StaleLockAfter is the age, after which the lock file of a
+	// runner that did not finish is removed; the default is 10
+	// minutes.
+	StaleLockAfter time.Duration
+
+	// Notify is called after each migration.
+	Notify func(status string)
+}
+
+// CloneMode is the way that CloneDatabaseWithOptions copies a database.
+type CloneMode int
+
+const (
+	// CloneBackup copies the database pages with the SQLite online
+	// backup API; in steps, so that the source remains available to
+	// the other connections.
+	CloneBackup CloneMode = iota
+
+	// CloneVacuumInto copies the database with VACUUM INTO; in one
+	// read transaction. The copy is compacted.
+	CloneVacuumInto
+
+	// CloneRows creates the tables and copies the rows page by page;
+	// as CloneDatabase did before the backup modes.
+	CloneRows
+)
+
+// CloneOptions configures CloneDatabaseWithOptions.
+type CloneOptions struct {
+	Mode CloneMode
+
+	// PagesPerStep is the number of pages that the backup copies per
+	// step (default 100); -1 copies all pages in one step.
+	PagesPerStep int
+
+	// StepDelay is the pause between the backup steps; so that
+	// writers to the source are not held off.
+	StepDelay time.Duration
+
+	// Progress is called after each backup step, and once for
+	// VACUUM INTO.
+	Progress func(CloneProgress)
+
+	// Notify receives the status text; of each step, or of each page
+	// of rows in CloneRows mode.
+	Notify func(status string)
+}
+
+// EncryptedDBOptions configures OpenEncryptedDB.
+type EncryptedDBOptions struct {
+	// Create creates an empty database, if the file does not exist.
+	Create bool
+
+	// CheckpointInterval is how often the database is encrypted to its
+	// file, if it has changed; zero leaves it to Checkpoint and Close.
+	CheckpointInterval time.Duration
+
+	// CloseOnSignal closes the session (which encrypts the changes to
+	// the file) on SIGINT or SIGTERM; and then raises the signal again.
+	// It is meant for programs that do not handle the signals.
+	CloseOnSignal bool
+
+	// OnError receives the errors of the interval and signal
+	// checkpoints.
+	OnError func(err error)
+}
+
+// ColumnEncryption declares the encrypted columns, and the provider of
+// their keys.
+type ColumnEncryption struct {
+	Keys    KeyProvider
+	Columns []EncryptedColumn
+}
+
+// EncryptedColumn declares a column whose values are encrypted with
+// AES-GCM; they are stored as BLOBs, and NULL stays NULL.
+type EncryptedColumn struct {
+	Table  string
+	Column string
+
+	// Deterministic encrypts equal values to equal BLOBs (the nonce
+	// is an HMAC of the value); so that the column can be looked up
+	// with = or IN, and have a UNIQUE index. It reveals which rows
+	// hold equal values. A column that is not Deterministic is only
+	// assigned; comparing it with a bind arg returns an error.
+	Deterministic bool
+}
+
+// KeyProvider returns the key of an encrypted column; 16, 24 or 32 bytes
+// (AES-128, AES-192 or AES-256).
+type KeyProvider interface {
+	ColumnKey(table string, column string) ([]byte, error)
+}
+
+// KeyProviderFunc is a func that is a KeyProvider.
+type KeyProviderFunc func(table string, column string) ([]byte, error)
+
+// ColumnKey calls f(table, column).
+func (f KeyProviderFunc) ColumnKey(table string, column string) ([]byte, error) {
+	return f(table, column)
+}
+
+// CloneProgress is the progress of a backup.
+type CloneProgress struct {
+	PagesCopied int
+	TotalPages  int
+	Elapsed     time.Duration
+	Status      string
+}
+
+// ConflictStrategy is what CopyTables does with a row that violates a
+// UNIQUE or PRIMARY KEY constraint of the destination table.
+type ConflictStrategy int
+
+const (
+	// ConflictFail stops the copy with ErrConstraint.
+	ConflictFail ConflictStrategy = iota
+	// ConflictReplace replaces the existing row.
+	ConflictReplace
+	// ConflictIgnore skips the row.
+	ConflictIgnore
+)
+
+// CopyTableSpec selects a table for CopyTables, and how its rows are
+// copied.
+type CopyTableSpec struct {
+	// Table is the source table.
+	Table string
+
+	// DestTable is the destination table; the default is Table.
+	DestTable string
+
+	// Where filters the source rows (i.e. "CustomerID = ?"); with
+	// Args as its bind parameters.
+	Where string
+	Args  []interface{}
+
+	// Columns maps the source columns to the destination columns;
+	// when set, only these columns are copied. The encrypted columns
+	// (see ColumnEncryption) cannot be renamed; as their values are
+	// copied as stored.
+	Columns map[string]string
+
+	// Transform is called with each row (keyed by the destination
+	// column names); it can change the values, add or remove columns,
+	// or return a nil map to skip the row. A column that is not in the
+	// destination table is an error.
+	Transform func(row map[string]interface{}) (map[string]interface{}, error)
+}
+
+// CopyTablesOptions configures CopyTables.
+type CopyTablesOptions struct {
+	Tables []CopyTableSpec
+
+	Conflict ConflictStrategy
+
+	// Append keeps the destination tables that exist and adds the
+	// rows to them; otherwise they are dropped and created again.
+	Append bool
+
+	// BatchSize is the number of rows per transaction (default
+	// 10,000).
+	BatchSize int
+
+	Notify func(status string)
+}
+
+// CopyTableResult is the outcome of copying one table.
+type CopyTableResult struct {
+	Table       string
+	DestTable   string
+	RowsRead    int64
+	RowsWritten int64
+	// RowsSkipped counts the rows that Transform skipped.
+	RowsSkipped int64
+}
+
+// DataDiffOptions configures DataDiff and DataDiffTables.
+type DataDiffOptions struct {
+	// DestTable is the destination table; the default is the source
+	// table name.
+	DestTable string
+
+	// Keys are the columns that identify a row; the default is the
+	// primary key of the source table. The key values must be unique.
+	Keys []string
+
+	// Ignore are the columns that are not compared.
+	Ignore []string
+
+	// Where filters the rows of both tables; with Args as its bind
+	// parameters.
+	Where string
+	Args  []interface{}
+
+	// Patch receives the SQL statements that apply the differences
+	// to the destination; one per line.
+	Patch io.Writer
+}
+
+// CSVImportOptions configures StreamCSVFileToDatabaseWithOptions and
+// StreamCSVToDatabase.
+type CSVImportOptions struct {
+	// Table is the destination table; the default is the file name
+	// without its extension.
+	Table string
+
+	// Comma is the field delimiter; the default is ',', or '\t' for
+	// the .tsv and .tab files.
+	Comma rune
+
+	// Quote is the quote character (default '"'); a quote within a
+	// quoted field is doubled. NoQuotes reads quotes as data; i.e.
+	// TSV files.
+	Quote    rune
+	NoQuotes bool
+
+	// NoHeader is set when the first record is data; the columns are
+	// then named Column1, Column2, ...
+	NoHeader bool
+
+	// Encoding is the text encoding of the file: utf-8 (default; the
+	// BOM is skipped), utf-16 (by its BOM), utf-16le, utf-16be, latin1
+	// (iso-8859-1), or windows-1252.
+	Encoding string
+
+	// NullMarker is the field value that is imported as NULL; i.e.
+	// "\N" or "NULL". When not set, empty fields that are not quoted
+	// are NULL.
+	NullMarker string
+
+	// Columns maps the CSV columns (by header name) to the table
+	// columns; only the mapped columns are imported.
+	Columns map[string]string
+
+	// SampleSize is the number of records that are read (and held) to
+	// infer the column types of a new table; the default is 1,000.
+	SampleSize int
+
+	// ColumnTypes sets the declared type of columns (by table column
+	// name); they take precedence over the inferred types.
+	ColumnTypes map[string]string
+
+	// NoInference declares every column of a new table as TEXT.
+	NoInference bool
+
+	PrimaryKey []string
+
+	// Replace drops the table, if it exists, and creates it again (in
+	// the same transaction); otherwise the rows are added to it.
+	Replace bool
+
+	Conflict ConflictStrategy
+
+	// BatchSize is the number of rows per transaction (default
+	// 10,000).
+	BatchSize int
+
+	// Quarantine receives the records that cannot be imported (i.e.
+	// wrong field count, constraint failure) in CSV form; with the
+	// line number and the error in an added last field. When it is
+	// nil, the import stops at the first bad record.
+	Quarantine io.Writer
+
+	// MaxErrors stops the import when more records than this are
+	// quarantined; zero is no limit.
+	MaxErrors int64
+
+	// MaxFieldSize is the maximum length of a field in bytes (default
+	// 1 MiB); a longer field (i.e. of a quote that is not terminated)
+	// stops the import, so that it is not read into memory.
+	MaxFieldSize int
+
+	// Notify is called after each batch is committed.
+	Notify func(status string)
+}
+
+// BlobEncoding is the text form of the BLOB values in an export.
+type BlobEncoding int
+
+const (
+	// BlobHex writes the bytes as lowercase hex digits.
+	BlobHex BlobEncoding = iota
+	// BlobBase64 writes the bytes in standard base64.
+	BlobBase64
+)
+
+// CSVExportOptions configures ExportQueryToCSV and ExportDataTableToCSV.
+type CSVExportOptions struct {
+	// Comma is the field delimiter; the default is ','.
+	Comma rune
+
+	NoHeader bool
+
+	// Null is the text of NULL values; the default is an empty field.
+	// An empty string is then written as "", so that it is told from
+	// NULL (as StreamCSVFileToDatabase does).
+	Null string
+
+	Blob BlobEncoding
+
+	// TimeFormat is the layout of time values; the default is
+	// time.RFC3339Nano.
+	TimeFormat string
+
+	// UseCRLF ends the records with \r\n (as in RFC 4180), rather
+	// than \n.
+	UseCRLF bool
+}
+
+// JSONOptions configures ExportQueryToJSON and ExportDataTableToJSON.
+type JSONOptions struct {
+	// NDJSON writes one object per line (newline-delimited JSON);
+	// otherwise the rows are written as an array.
+	NDJSON bool
+
+	Blob BlobEncoding
+
+	// TimeFormat is the layout of time values; the default is
+	// time.RFC3339Nano. "unix" and "unixmilli" write the time as a
+	// number of seconds (or milliseconds) since the epoch.
+	TimeFormat string
+
+	// OmitNull leaves the NULL columns out of the row objects; rather
+	// than writing them as null.
+	OmitNull bool
+
+	// Metadata writes the column names and types ahead of the rows; as
+	// {"columns":[...],"rows":[...]}, or (with NDJSON) as a first line
+	// of {"columns":[...]}.
+	Metadata bool
+}
+
+// JSONImportOptions configures ImportJSON.
+type JSONImportOptions struct {
+	// Table is the destination table.
+	Table string
+
+	// Flatten writes the members of nested objects to columns of
+	// their own; named by the path of the member (i.e. address.city).
+	// Otherwise nested objects (and arrays) are written as JSON text,
+	// which the JSON1 functions can read.
+	Flatten bool
+
+	// Separator joins the names of a flattened path; the default is
+	// ".".
+	Separator string
+
+	// SampleSize is the number of records that are read (and held) to
+	// infer the column types of a new table; the default is 1,000.
+	SampleSize int
+
+	// ColumnTypes sets the declared type of columns (by name); they
+	// take precedence over the inferred types.
+	ColumnTypes map[string]string
+
+	PrimaryKey []string
+
+	// Replace drops the table, if it exists, and creates it again (in
+	// the same transaction); otherwise the rows are added to it.
+	Replace bool
+
+	// BatchSize is the number of rows per transaction (default
+	// 10,000).
+	BatchSize int
+
+	// OnError is called with the records that cannot be imported (i.e.
+	// not an object, a member that is not a column of the table, a
+	// constraint failure); record is the line number of NDJSON, or the
+	// index (from 1) in the array. The record is skipped, unless it
+	// returns an error, which stops the import. When it is nil, the
+	// import stops at the first bad record.
+	OnError func(record int64, raw []byte, err error) error
+
+	// Notify is called after each batch is committed.
+	Notify func(status string)
+}
+
+// DumpOptions configures DumpDatabase.
+type DumpOptions struct {
+	// Tables limits the dump to these tables (and views), and their
+	// indexes and triggers; the default is all.
+	Tables []string
+
+	// SchemaOnly leaves out the rows; DataOnly leaves out the CREATE
+	// statements.
+	SchemaOnly bool
+	DataOnly   bool
+}
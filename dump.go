@@ -0,0 +1,540 @@
+package sqlitehench
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DumpDatabase writes a database as an SQL script; in the form of the
+// sqlite3 shell .dump command, so that either can restore it. The output
+// is deterministic (objects in creation order, rows in rowid or primary
+// key order, one INSERT per line); so that dumps diff cleanly.
+func (d *DBAccess) DumpDatabase(dbFilePath string, w io.Writer, opt DumpOptions) error {
+	return d.DumpDatabaseContext(context.Background(), dbFilePath, w, opt)
+}
+
+// DumpDatabaseContext is DumpDatabase with a ctx.
+func (d *DBAccess) DumpDatabaseContext(ctx context.Context, dbFilePath string, w io.Writer, opt DumpOptions) (err error) {
+
+	defer func() { err = wrapErr("DumpDatabase", dbFilePath, "", err) }()
+
+	if !fileOrDirExists(dbFilePath) {
+		return ErrDatabaseFileNotExists
+	}
+
+	db, release, err := d.acquireDB(dbFilePath, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer conn.Close()
+
+	// One read transaction; so that the dump is a snapshot.
+	if _, err = conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+	dw := &dumpWriter{conn: conn, w: bufio.NewWriterSize(w, 64*1024), opt: opt}
+
+	if err = dw.dump(ctx); err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	return dw.w.Flush()
+}
+
+// dumpWriter writes the statements of a dump.
+type dumpWriter struct {
+	conn *sql.Conn
+	w    *bufio.Writer
+	opt  DumpOptions
+
+	// writableSchema is set once a virtual table has been written.
+	writableSchema bool
+}
+
+func (dw *dumpWriter) dump(ctx context.Context) error {
+
+	rows, err := dw.conn.QueryContext(ctx,
+		`SELECT type, name, tbl_name, sql FROM sqlite_master WHERE sql NOT NULL
+		ORDER BY tbl_name = 'sqlite_sequence', rowid`)
+	if err != nil {
+		return err
+	}
+
+	var objs []masterRow
+	for rows.Next() {
+		var o masterRow
+		if err = rows.Scan(&o.Type, &o.Name, &o.TblName, &o.SQL); err != nil {
+			rows.Close()
+			return err
+		}
+		objs = append(objs, o)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	dw.w.WriteString("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n")
+
+	for i := 0; i < len(objs); i++ {
+		o := objs[i]
+		if o.Type != "table" || !dw.selected(o.TblName) {
+			continue
+		}
+		if err = dw.dumpTable(ctx, o); err != nil {
+			return err
+		}
+	}
+
+	if !dw.opt.DataOnly {
+
+		var views []masterRow
+
+		for i := 0; i < len(objs); i++ {
+			o := objs[i]
+			if o.Type == "index" && dw.selected(o.TblName) {
+				dw.writeStmt(o.SQL.String)
+			}
+			if o.Type == "view" && dw.selected(o.Name) {
+				views = append(views, o)
+			}
+		}
+
+		views = orderViews(views)
+		for i := 0; i < len(views); i++ {
+			dw.writeStmt(views[i].SQL.String)
+		}
+
+		// INSTEAD OF triggers need their view.
+		for i := 0; i < len(objs); i++ {
+			o := objs[i]
+			if o.Type == "trigger" && dw.selected(o.TblName) {
+				dw.writeStmt(o.SQL.String)
+			}
+		}
+
+		var userVersion int64
+		if err = dw.conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&userVersion); err != nil {
+			return err
+		}
+		if userVersion != 0 {
+			fmt.Fprintf(dw.w, "PRAGMA user_version=%d;\n", userVersion)
+		}
+	}
+
+	if dw.writableSchema {
+		dw.w.WriteString("PRAGMA writable_schema=OFF;\n")
+	}
+
+	_, err = dw.w.WriteString("COMMIT;\n")
+
+	return err
+}
+
+// selected reports whether a table (or view) is in opt.Tables.
+func (dw *dumpWriter) selected(name string) bool {
+	return len(dw.opt.Tables) == 0 || arryElmExistsIgnoreCase(dw.opt.Tables, name)
+}
+
+func (dw *dumpWriter) writeStmt(sqlx string) {
+	dw.w.WriteString(sqlx)
+	dw.w.WriteString(";\n")
+}
+
+// dumpTable writes the CREATE statement and the rows of a table; as the
+// sqlite3 shell does for the sqlite_ tables and virtual tables.
+func (dw *dumpWriter) dumpTable(ctx context.Context, o masterRow) error {
+
+	name := strings.ToLower(o.Name)
+	sqlx := o.SQL.String
+
+	switch {
+	case name == "sqlite_sequence":
+		if !dw.opt.SchemaOnly {
+			dw.w.WriteString("DELETE FROM sqlite_sequence;\n")
+		}
+	case name == "sqlite_stat1":
+		if !dw.opt.DataOnly {
+			dw.w.WriteString("ANALYZE sqlite_schema;\n")
+		}
+	case strings.HasPrefix(name, "sqlite_"):
+		return nil
+	case strings.HasPrefix(strings.ToUpper(sqlx), "CREATE VIRTUAL TABLE"):
+		// The rows are in its shadow tables.
+		if dw.opt.DataOnly {
+			return nil
+		}
+		if !dw.writableSchema {
+			dw.w.WriteString("PRAGMA writable_schema=ON;\n")
+			dw.writableSchema = true
+		}
+		fmt.Fprintf(dw.w, "INSERT INTO sqlite_schema(type,name,tbl_name,rootpage,sql)VALUES('table',%s,%s,0,%s);\n",
+			dumpLiteral(o.Name), dumpLiteral(o.Name), dumpLiteral(sqlx))
+		return nil
+	default:
+		if !dw.opt.DataOnly {
+			dw.writeStmt(sqlx)
+		}
+	}
+
+	if dw.opt.SchemaOnly {
+		return nil
+	}
+
+	return dw.dumpRows(ctx, o)
+}
+
+// dumpRows writes the INSERT statements of the rows of a table; in rowid
+// order, or primary key order for a WITHOUT ROWID table.
+func (dw *dumpWriter) dumpRows(ctx context.Context, o masterRow) error {
+
+	rows, err := dw.conn.QueryContext(ctx, `SELECT name, hidden, pk FROM pragma_table_xinfo(?) ORDER BY cid`, o.Name)
+	if err != nil {
+		return err
+	}
+
+	var cols []string
+	pk := map[int]string{}
+
+	for rows.Next() {
+		var name string
+		var h, k int
+		if err = rows.Scan(&name, &h, &k); err != nil {
+			rows.Close()
+			return err
+		}
+		if h != 0 {
+			// The generated columns are not in the VALUES of
+			// an INSERT without a column list.
+			continue
+		}
+		cols = append(cols, name)
+		if k > 0 {
+			pk[k] = name
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// Unary plus leaves the values as they are stored; the columns
+	// then have no declared type, so that the driver does not convert
+	// them (i.e. DATETIME to time.Time).
+	sel := make([]string, len(cols))
+	for i := 0; i < len(cols); i++ {
+		sel[i] = fmt.Sprintf("+[%s]", cols[i])
+	}
+
+	order := "_rowid_"
+	if tableWithoutRowID(o.SQL.String) {
+		var keys []string
+		for k := 1; k <= len(pk); k++ {
+			keys = append(keys, pk[k])
+		}
+		order = quoteColumnList(keys)
+	}
+
+	rows, err = dw.conn.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM [%s] ORDER BY %s", strings.Join(sel, ", "), o.Name, order))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	prefix := "INSERT INTO " + dumpIdent(o.Name) + " VALUES("
+
+	vals := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := 0; i < len(cols); i++ {
+		ptrs[i] = &vals[i]
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		dw.w.WriteString(prefix)
+		for i := 0; i < len(vals); i++ {
+			if i > 0 {
+				dw.w.WriteByte(',')
+			}
+			dw.w.WriteString(dumpLiteral(vals[i]))
+		}
+		dw.w.WriteString(");\n")
+	}
+
+	return rows.Err()
+}
+
+// tableWithoutRowID reports whether a CREATE TABLE statement ends with
+// WITHOUT ROWID.
+func tableWithoutRowID(sqlx string) bool {
+
+	toks := tokenizeSQL(sqlx)
+	for i := 0; i+1 < len(toks); i++ {
+		if toks[i].isKeyword("WITHOUT") && toks[i+1].isKeyword("ROWID") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// orderViews returns the views in creation order; except that a view
+// follows the views that it selects from.
+func orderViews(views []masterRow) []masterRow {
+
+	var ret []masterRow
+	done := make(map[string]bool)
+	visiting := make(map[string]bool)
+
+	var visit func(v masterRow)
+	visit = func(v masterRow) {
+		key := strings.ToLower(v.Name)
+		if done[key] || visiting[key] {
+			return
+		}
+		visiting[key] = true
+
+		toks := tokenizeSQL(v.SQL.String)
+		for i := 0; i < len(toks); i++ {
+			if toks[i].kind != tokWord && toks[i].kind != tokQuoted {
+				continue
+			}
+			for k := 0; k < len(views); k++ {
+				if strings.EqualFold(views[k].Name, toks[i].val) && !strings.EqualFold(views[k].Name, v.Name) {
+					visit(views[k])
+				}
+			}
+		}
+
+		done[key] = true
+		ret = append(ret, v)
+	}
+
+	for i := 0; i < len(views); i++ {
+		visit(views[i])
+	}
+
+	return ret
+}
+
+// dumpIdent returns a table or column name; quoted, if it is not a plain
+// identifier (or is a keyword).
+func dumpIdent(name string) string {
+
+	plain := name != "" && !isDigit(name[0]) && !isReservedWord(name)
+	for i := 0; i < len(name) && plain; i++ {
+		c := name[i]
+		plain = c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+	}
+
+	if plain {
+		return name
+	}
+
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// dumpLiteral returns the SQL literal of a value, as the sqlite3 shell
+// writes it: a REAL keeps its type and precision, and the line breaks of
+// a text are written with replace(), so that a row is on one line.
+func dumpLiteral(v interface{}) string {
+
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1e15 {
+			return strconv.FormatFloat(x, 'f', 1, 64)
+		}
+		// ±Inf and NaN as sqlLiteral writes them
+		return sqlLiteral(x)
+	case []byte:
+		return "X'" + hex.EncodeToString(x) + "'"
+	case time.Time:
+		return sqlLiteral(x)
+	case string:
+		return dumpText(x)
+	}
+
+	return sqlLiteral(v)
+}
+
+func dumpText(s string) string {
+
+	nl := strings.Contains(s, "\n")
+	cr := strings.Contains(s, "\r")
+
+	if !nl && !cr {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+
+	nlMark := unusedMark(s, `\n`, `\012`)
+	crMark := unusedMark(s, `\r`, `\015`)
+
+	q := strings.ReplaceAll(s, "'", "''")
+	q = strings.ReplaceAll(q, "\n", nlMark)
+	q = strings.ReplaceAll(q, "\r", crMark)
+	q = "'" + q + "'"
+
+	if cr {
+		q = fmt.Sprintf("replace(%s,'%s',char(13))", q, crMark)
+	}
+	if nl {
+		q = fmt.Sprintf("replace(%s,'%s',char(10))", q, nlMark)
+	}
+
+	return q
+}
+
+// unusedMark returns a mark that is not in s.
+func unusedMark(s string, a string, b string) string {
+
+	if !strings.Contains(s, a) {
+		return a
+	}
+	if !strings.Contains(s, b) {
+		return b
+	}
+
+	for i := 1; ; i++ {
+		m := fmt.Sprintf("(%s%d)", b, i)
+		if !strings.Contains(s, m) {
+			return m
+		}
+	}
+}
+
+// RestoreDump runs an SQL script (i.e. of DumpDatabase, or the sqlite3
+// shell .dump) on a database, which is created if it does not exist. The
+// script is read as a stream, and run one statement at a time; a CREATE
+// TRIGGER statement, and semicolons in strings, comments and quoted
+// names, are taken into account. When a statement fails, an open
+// transaction is rolled back.
+func (d *DBAccess) RestoreDump(r io.Reader, dbFilePath string) error {
+	return d.RestoreDumpContext(context.Background(), r, dbFilePath)
+}
+
+// RestoreDumpContext is RestoreDump with a ctx.
+func (d *DBAccess) RestoreDumpContext(ctx context.Context, r io.Reader, dbFilePath string) (err error) {
+
+	ctx, done := d.startRetryScope(ctx, "RestoreDump", dbFilePath)
+	defer func() {
+		err = wrapErr("RestoreDump", dbFilePath, "", err)
+		done(err)
+	}()
+
+	// The script runs on a connection of its own; as it sets PRAGMA
+	// (i.e. foreign_keys, writable_schema) on the connection.
+	db, err := sql.Open(d.driverName, d.dataSource(dbFilePath))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer conn.Close()
+
+	busyTimeout := d.RetryPolicy.BusyTimeout
+	if busyTimeout <= 0 {
+		busyTimeout = 5 * time.Second
+	}
+	if _, err = conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d;", busyTimeout.Milliseconds())); err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	exec := func(stmt string, line int) error {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			// The ctx may have been cancelled.
+			conn.ExecContext(context.Background(), "ROLLBACK")
+			return wrapErr("RestoreDump", dbFilePath, stmt, fmt.Errorf("line %d: %w", line, ctxErr(ctx, err)))
+		}
+		return nil
+	}
+
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	var stmt strings.Builder
+	line := 0
+	stmtLine := 0
+
+	for {
+		s, rerr := br.ReadString('\n')
+		if rerr != nil && rerr != io.EOF {
+			return rerr
+		}
+		if s != "" {
+			line++
+			// Blank lines between statements are skipped.
+			if stmt.Len() > 0 || strings.TrimSpace(s) != "" {
+				if stmt.Len() == 0 {
+					stmtLine = line
+				}
+				stmt.WriteString(s)
+			}
+		}
+
+		if stmt.Len() > 0 && (rerr == io.EOF || sqlComplete(stmt.String())) {
+			if len(tokenizeSQL(stmt.String())) > 0 {
+				if err = exec(stmt.String(), stmtLine); err != nil {
+					return err
+				}
+			}
+			stmt.Reset()
+		}
+
+		if rerr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// sqlComplete reports whether SQL text ends with a complete statement;
+// i.e. it ends with a semicolon, which is not in a string, a comment or
+// the body of a CREATE TRIGGER.
+func sqlComplete(s string) bool {
+
+	s = strings.TrimRight(s, " \t\r\n\f\v")
+	if !strings.HasSuffix(s, ";") {
+		return false
+	}
+
+	toks := tokenizeSQL(s)
+	if len(toks) == 0 {
+		return false
+	}
+	last := toks[len(toks)-1]
+	if !last.isPunct(";") || last.end != len(s) {
+		return false
+	}
+
+	stmts := splitTokens(s, toks)
+	if len(stmts) == 0 {
+		return true
+	}
+	lastStmt := stmts[len(stmts)-1]
+
+	return lastStmt[len(lastStmt)-1].end <= last.start
+}
@@ -0,0 +1,216 @@
+package sqlitehench
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dumpTest returns the path of a db file with a rowid table (with
+// AUTOINCREMENT and a generated column), a WITHOUT ROWID table, an
+// index, two views (the second created first), a trigger and a
+// user_version.
+func dumpTest(t *testing.T, d *DBAccess) string {
+
+	t.Helper()
+
+	return testDB(t, d, "dump.sqlite", []string{
+		`CREATE TABLE "my t" (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, r REAL, b BLOB, dt DATETIME, g INT GENERATED ALWAYS AS (id * 2) VIRTUAL)`,
+		"CREATE TABLE w (k TEXT, n INT, PRIMARY KEY (k, n)) WITHOUT ROWID",
+		`CREATE INDEX ix_name ON "my t" (name)`,
+		"CREATE VIEW v2 AS SELECT 1 AS id",
+		`CREATE TRIGGER tr AFTER INSERT ON w BEGIN UPDATE "my t" SET name = name || ';' WHERE id = NEW.n; END`,
+		"PRAGMA user_version = 7",
+		"DROP VIEW v2",
+		`CREATE VIEW v1 AS SELECT id, name FROM "my t"`,
+		"CREATE VIEW v2 AS SELECT * FROM v1",
+		"INSERT INTO w VALUES ('b', 2), ('a', 1)",
+	}, `INSERT INTO "my t" (name, r, b, dt) VALUES (?, ?, ?, ?)`,
+		[]interface{}{"it's\r\na \\n test", 2.0, []byte{1, 2}, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		[]interface{}{"x", 0.1, nil, 5},
+		[]interface{}{"inf", math.Inf(1), nil, nil})
+}
+
+func TestDumpRestore(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := dumpTest(t, d)
+
+	var b bytes.Buffer
+	if err := d.DumpDatabase(dbFilePath, &b, DumpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	dump := b.String()
+
+	for _, s := range []string{
+		"PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n",
+		`INSERT INTO "my t" VALUES(1,replace(replace('it''s\r\012a \n test','\r',char(13)),'\012',char(10)),2.0,X'0102','2024-01-02 03:04:05+00:00');` + "\n",
+		`INSERT INTO "my t" VALUES(3,'inf',9.0e+999,NULL,NULL);` + "\n",
+		// in primary key order
+		"INSERT INTO w VALUES('a',1);\nINSERT INTO w VALUES('b',2);\n",
+		"DELETE FROM sqlite_sequence;\nINSERT INTO sqlite_sequence VALUES('my t',3);\n",
+		// v1 before the view that selects from it
+		"CREATE VIEW v1 AS SELECT id, name FROM \"my t\";\nCREATE VIEW v2 AS SELECT * FROM v1;\n",
+		"PRAGMA user_version=7;\nCOMMIT;\n",
+	} {
+		if !strings.Contains(dump, s) {
+			t.Errorf("the dump has no %q", s)
+		}
+	}
+
+	restored := filepath.Join(t.TempDir(), "restored.sqlite")
+	if err := d.RestoreDump(strings.NewReader(dump), restored); err != nil {
+		t.Fatal(err)
+	}
+
+	// The dump of the restored database is the same.
+	b.Reset()
+	if err := d.DumpDatabase(restored, &b, DumpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != dump {
+		t.Errorf("got %s; want %s", b.String(), dump)
+	}
+
+	name, err := ScalarAs[string](d, "SELECT name FROM v2 WHERE id = 1", restored)
+	if err != nil || name != "it's\r\na \\n test" {
+		t.Errorf("got %q, %v", name, err)
+	}
+	if g, err := ScalarAs[int](d, `SELECT g FROM "my t" WHERE id = 3`, restored); err != nil || g != 6 {
+		t.Errorf("got %d, %v", g, err)
+	}
+}
+
+func TestDumpOptions(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := dumpTest(t, d)
+
+	tests := []struct {
+		name    string
+		opt     DumpOptions
+		has     []string
+		hasNone []string
+	}{
+		{"tables", DumpOptions{Tables: []string{"W"}},
+			[]string{"CREATE TABLE w", "INSERT INTO w", "CREATE TRIGGER tr"},
+			[]string{`CREATE TABLE "my t"`, "CREATE VIEW", "sqlite_sequence"}},
+		{"schema only", DumpOptions{SchemaOnly: true},
+			[]string{"CREATE TABLE w", "CREATE INDEX ix_name", "CREATE VIEW v1"},
+			[]string{"INSERT INTO", "DELETE FROM sqlite_sequence"}},
+		{"data only", DumpOptions{DataOnly: true},
+			[]string{"INSERT INTO w", "INSERT INTO sqlite_sequence"},
+			[]string{"CREATE", "user_version"}},
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := d.DumpDatabase(dbFilePath, &b, tt.opt); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, s := range tt.has {
+			if !strings.Contains(b.String(), s) {
+				t.Errorf("%s: the dump has no %q", tt.name, s)
+			}
+		}
+		for _, s := range tt.hasNone {
+			if strings.Contains(b.String(), s) {
+				t.Errorf("%s: the dump has %q", tt.name, s)
+			}
+		}
+	}
+}
+
+func TestRestoreDumpError(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "restore.sqlite")
+
+	// The failed statement rolls back the transaction.
+	script := "BEGIN;\nCREATE TABLE z (a);\nINSERT INTO z VALUES ('a;\nb');\n\nINSERT INTO none VALUES (1);\nCOMMIT;\n"
+
+	err := d.RestoreDump(strings.NewReader(script), dbFilePath)
+
+	var e *Error
+	if !errors.As(err, &e) || !strings.Contains(err.Error(), "line 6") || e.SQL != "INSERT INTO none VALUES (1);\n" {
+		t.Errorf("got %v", err)
+	}
+	if _, err = d.GetTableInfo(dbFilePath, "z"); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("got %v; want %v", err, ErrTableNotFound)
+	}
+}
+
+func TestDumpDatabaseError(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	if err := d.DumpDatabase(filepath.Join(t.TempDir(), "none.sqlite"), io.Discard, DumpOptions{}); !errors.Is(err, ErrDatabaseFileNotExists) {
+		t.Errorf("got %v; want %v", err, ErrDatabaseFileNotExists)
+	}
+}
+
+func TestDumpLiteral(t *testing.T) {
+
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, "NULL"},
+		{int64(-3), "-3"},
+		{2.0, "2.0"},
+		{0.1, "0.1"},
+		{1e20, "1e+20"},
+		{math.Inf(-1), "-9.0e+999"},
+		{math.NaN(), "NULL"},
+		{[]byte{0xab}, "X'ab'"},
+		{"it's", "'it''s'"},
+		{"a\nb", "replace('a\\nb','\\n',char(10))"},
+		{"\\n\n", "replace('\\n\\012','\\012',char(10))"},
+	}
+
+	for _, tt := range tests {
+		if got := dumpLiteral(tt.in); got != tt.want {
+			t.Errorf("dumpLiteral(%#v) = %s; want %s", tt.in, got, tt.want)
+		}
+	}
+
+	for name, want := range map[string]string{"t": "t", "my t": `"my t"`, "order": `"order"`, "1a": `"1a"`, `a"b`: `"a""b"`} {
+		if got := dumpIdent(name); got != want {
+			t.Errorf("dumpIdent(%q) = %s; want %s", name, got, want)
+		}
+	}
+}
+
+func TestSQLComplete(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"SELECT 1;", true},
+		{"SELECT 1", false},
+		{"SELECT ';", false},
+		{"SELECT ';';  \n", true},
+		{"SELECT 1; -- x;", false},
+		{"CREATE TRIGGER tr AFTER INSERT ON t BEGIN SELECT 1;", false},
+		{"CREATE TRIGGER tr AFTER INSERT ON t BEGIN SELECT 1; END;", true},
+	}
+
+	for _, tt := range tests {
+		if got := sqlComplete(tt.in); got != tt.want {
+			t.Errorf("sqlComplete(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
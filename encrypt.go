@@ -0,0 +1,339 @@
+package sqlitehench
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// The encrypted format is a header, followed by the plain data in chunks
+// that are sealed with AES-256-GCM:
+//
+//	magic       8 bytes  "SQLHENC\x00"
+//	version     1 byte   1
+//	kdf         1 byte   1 (scrypt)
+//	log2(N)     1 byte   scrypt cost
+//	r           1 byte   scrypt block size
+//	p           1 byte   scrypt parallelization
+//	salt       16 bytes
+//	chunk size  4 bytes  big-endian; of the plain data
+//	nonce       7 bytes  random; the prefix of the nonce of each chunk
+//
+// The nonce of a chunk is the prefix, the chunk number (4 bytes,
+// big-endian) and a byte that is 1 for the last chunk; so that chunks
+// cannot be reordered, and a truncated file does not decrypt. The header
+// is the additional data of each chunk; so that its parameters cannot be
+// changed. Every chunk but the last holds chunk size bytes of plain data;
+// the last may be empty.
+var encMagic = []byte("SQLHENC\x00")
+
+const (
+	encVersion         = 1
+	encKDFScrypt       = 1
+	encSaltSize        = 16
+	encNoncePrefixSize = 7
+	encHeaderSize      = 8 + 5 + encSaltSize + 4 + encNoncePrefixSize
+
+	encChunkSize    = 64 * 1024
+	encMaxChunkSize = 16 * 1024 * 1024
+
+	// The scrypt parameters of new files; as recommended for
+	// interactive logins (about 100 ms).
+	encScryptLogN = 15
+	encScryptR    = 8
+	encScryptP    = 1
+
+	// The limits of the cost that a header can ask for; the memory of
+	// scrypt is 128*N*r bytes, and its time is of N*r*p.
+	encMaxScryptLogN = 22
+	encMaxScryptR    = 32
+	encMaxScryptP    = 16
+	encMaxScryptMem  = 1 << 30
+)
+
+// encHeader holds the parameters of an encrypted file.
+type encHeader struct {
+	version     byte
+	kdf         byte
+	logN        byte
+	r           byte
+	p           byte
+	salt        [encSaltSize]byte
+	chunkSize   uint32
+	noncePrefix [encNoncePrefixSize]byte
+}
+
+func (h *encHeader) marshal() []byte {
+
+	b := make([]byte, 0, encHeaderSize)
+	b = append(b, encMagic...)
+	b = append(b, h.version, h.kdf, h.logN, h.r, h.p)
+	b = append(b, h.salt[:]...)
+	b = binary.BigEndian.AppendUint32(b, h.chunkSize)
+	b = append(b, h.noncePrefix[:]...)
+
+	return b
+}
+
+// readEncHeader reads and checks the header of an encrypted file; before
+// the key is derived, so that a header cannot ask for an unbounded cost.
+func readEncHeader(r io.Reader) (*encHeader, []byte, error) {
+
+	b := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, ErrNotEncrypted
+		}
+		return nil, nil, err
+	}
+
+	if !bytes.Equal(b[:len(encMagic)], encMagic) {
+		return nil, nil, ErrNotEncrypted
+	}
+
+	h := &encHeader{}
+	i := len(encMagic)
+	h.version, h.kdf, h.logN, h.r, h.p = b[i], b[i+1], b[i+2], b[i+3], b[i+4]
+	i += 5
+	i += copy(h.salt[:], b[i:])
+	h.chunkSize = binary.BigEndian.Uint32(b[i:])
+	i += 4
+	copy(h.noncePrefix[:], b[i:])
+
+	if h.version != encVersion {
+		return nil, nil, fmt.Errorf("unsupported encryption format version: %d", h.version)
+	}
+	if h.kdf != encKDFScrypt {
+		return nil, nil, fmt.Errorf("unsupported key derivation: %d", h.kdf)
+	}
+	if h.logN < 1 || h.logN > encMaxScryptLogN || h.r < 1 || h.r > encMaxScryptR || h.p < 1 || h.p > encMaxScryptP ||
+		128*(int64(1)<<h.logN)*int64(h.r) > encMaxScryptMem {
+		return nil, nil, fmt.Errorf("invalid scrypt parameters: N=2^%d, r=%d, p=%d", h.logN, h.r, h.p)
+	}
+	if h.chunkSize < 1 || h.chunkSize > encMaxChunkSize {
+		return nil, nil, fmt.Errorf("invalid chunk size: %d", h.chunkSize)
+	}
+
+	return h, b, nil
+}
+
+// aead derives the key of a passphrase, and returns the cipher.
+func (h *encHeader) aead(passphrase string) (cipher.AEAD, error) {
+
+	key, err := scrypt.Key([]byte(passphrase), h.salt[:], 1<<h.logN, int(h.r), int(h.p), 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (h *encHeader) nonce(buf []byte, chunk uint32, last bool) []byte {
+
+	buf = append(buf[:0], h.noncePrefix[:]...)
+	buf = binary.BigEndian.AppendUint32(buf, chunk)
+	if last {
+		return append(buf, 1)
+	}
+
+	return append(buf, 0)
+}
+
+// encryptStream writes the encrypted form of r to w; a chunk at a time.
+func encryptStream(w io.Writer, r io.Reader, passphrase string) error {
+
+	h := &encHeader{
+		version:   encVersion,
+		kdf:       encKDFScrypt,
+		logN:      encScryptLogN,
+		r:         encScryptR,
+		p:         encScryptP,
+		chunkSize: encChunkSize,
+	}
+	if _, err := rand.Read(h.salt[:]); err != nil {
+		return err
+	}
+	if _, err := rand.Read(h.noncePrefix[:]); err != nil {
+		return err
+	}
+
+	gcm, err := h.aead(passphrase)
+	if err != nil {
+		return err
+	}
+
+	header := h.marshal()
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, int(h.chunkSize))
+	plain := make([]byte, h.chunkSize)
+	sealed := make([]byte, 0, int(h.chunkSize)+gcm.Overhead())
+	nonce := make([]byte, 0, gcm.NonceSize())
+
+	for chunk := uint32(0); ; chunk++ {
+
+		n, err := io.ReadFull(br, plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		// The chunk is the last, when nothing follows it.
+		_, err = br.Peek(1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		last := err == io.EOF
+
+		if !last && chunk == 1<<32-1 {
+			return fmt.Errorf("the data is too large to encrypt")
+		}
+
+		sealed = gcm.Seal(sealed[:0], h.nonce(nonce, chunk, last), plain[:n], header)
+		if _, err = w.Write(sealed); err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// decryptStream writes the plain form of an encrypted r to w; a chunk at
+// a time. Nothing is written of a chunk that fails to authenticate.
+func decryptStream(w io.Writer, r io.Reader, passphrase string) error {
+
+	h, header, err := readEncHeader(r)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := h.aead(passphrase)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, int(h.chunkSize)+gcm.Overhead())
+	sealed := make([]byte, int(h.chunkSize)+gcm.Overhead())
+	plain := make([]byte, 0, h.chunkSize)
+	nonce := make([]byte, 0, gcm.NonceSize())
+
+	for chunk := uint32(0); ; chunk++ {
+
+		n, err := io.ReadFull(br, sealed)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		last := n < len(sealed)
+		if !last {
+			_, err = br.Peek(1)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			last = err == io.EOF
+		}
+
+		// A truncated file fails here; as the chunk that it ends
+		// with was not sealed as the last.
+		plain, err = gcm.Open(plain[:0], h.nonce(nonce, chunk, last), sealed[:n], header)
+		if err != nil {
+			return ErrDecrypt
+		}
+		if _, err = w.Write(plain); err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// isEncrypted reports whether data begins with the magic of the
+// encrypted format.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encMagic)
+}
+
+// rewriteFile replaces a file with the output of fn, which reads the
+// file; see writeFileAtomic.
+func rewriteFile(p string, fn func(w io.Writer, f *os.File) error) error {
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(p, fi.Mode().Perm(), func(w io.Writer) error {
+		err := fn(w, f)
+		// Closed before the rename; which fails on Windows, while
+		// the file is open.
+		f.Close()
+		return err
+	})
+}
+
+// writeFileAtomic writes a file with the output of fn; which is written
+// to a temporary file in the same directory, synced to disk, and renamed
+// over the file. So the file is left as is, if fn fails.
+func writeFileAtomic(p string, perm os.FileMode, fn func(w io.Writer) error) (err error) {
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriterSize(tmp, 64*1024)
+	if err = fn(bw); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), p); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(p))
+
+	return nil
+}
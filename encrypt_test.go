@@ -0,0 +1,361 @@
+package sqlitehench
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+// encryptTest returns n bytes of random data, and its encrypted form.
+func encryptTest(t *testing.T, n int) ([]byte, []byte) {
+
+	t.Helper()
+
+	plain := make([]byte, n)
+	rand.Read(plain)
+
+	sealed, err := Encrypt(plain, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return plain, sealed
+}
+
+// sealedChunk returns the bounds of chunk i of an encrypted form.
+func sealedChunk(i int) (int, int) {
+
+	size := encChunkSize + 16
+	start := encHeaderSize + i*size
+
+	return start, start + size
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+
+	for _, n := range []int{0, 1, encChunkSize - 1, encChunkSize, encChunkSize + 1, 3*encChunkSize + 17} {
+
+		plain, sealed := encryptTest(t, n)
+
+		chunks := n/encChunkSize + 1
+		if n > 0 && n%encChunkSize == 0 {
+			chunks--
+		}
+		if want := encHeaderSize + n + 16*chunks; len(sealed) != want {
+			t.Errorf("%d bytes: encrypted to %d bytes; want %d", n, len(sealed), want)
+		}
+		if !bytes.HasPrefix(sealed, encMagic) {
+			t.Errorf("%d bytes: no magic", n)
+		}
+
+		got, err := Decrypt(sealed, testPassphrase)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: the decrypted data differs", n)
+		}
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+
+	_, sealed := encryptTest(t, 2*encChunkSize+100)
+
+	tests := []struct {
+		name   string
+		tamper func(b []byte) []byte
+	}{
+		{
+			name: "truncated at a chunk boundary",
+			tamper: func(b []byte) []byte {
+				_, end := sealedChunk(1)
+				return b[:end]
+			},
+		},
+		{
+			name: "truncated after the first chunk",
+			tamper: func(b []byte) []byte {
+				_, end := sealedChunk(0)
+				return b[:end]
+			},
+		},
+		{
+			name: "truncated within a chunk",
+			tamper: func(b []byte) []byte {
+				return b[:len(b)-1]
+			},
+		},
+		{
+			name: "header only",
+			tamper: func(b []byte) []byte {
+				return b[:encHeaderSize]
+			},
+		},
+		{
+			name: "chunks reordered",
+			tamper: func(b []byte) []byte {
+				s0, e0 := sealedChunk(0)
+				s1, e1 := sealedChunk(1)
+				out := append([]byte{}, b[:s0]...)
+				out = append(out, b[s1:e1]...)
+				out = append(out, b[s0:e0]...)
+				return append(out, b[e1:]...)
+			},
+		},
+		{
+			name: "chunk modified",
+			tamper: func(b []byte) []byte {
+				s, _ := sealedChunk(1)
+				b[s+10] ^= 1
+				return b
+			},
+		},
+		{
+			name: "data appended",
+			tamper: func(b []byte) []byte {
+				return append(b, 0)
+			},
+		},
+		{
+			name: "salt modified",
+			tamper: func(b []byte) []byte {
+				b[len(encMagic)+5] ^= 1
+				return b
+			},
+		},
+		{
+			name: "chunk size modified",
+			tamper: func(b []byte) []byte {
+				b[len(encMagic)+5+encSaltSize+3] ^= 1
+				return b
+			},
+		},
+		{
+			name: "nonce prefix modified",
+			tamper: func(b []byte) []byte {
+				b[encHeaderSize-1] ^= 1
+				return b
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			b := tt.tamper(append([]byte{}, sealed...))
+
+			if _, err := Decrypt(b, testPassphrase); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("got %v; want %v", err, ErrDecrypt)
+			}
+		})
+	}
+}
+
+func TestDecryptWrongPassphrase(t *testing.T) {
+
+	_, sealed := encryptTest(t, 100)
+
+	if _, err := Decrypt(sealed, testPassphrase+"x"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("got %v; want %v", err, ErrDecrypt)
+	}
+}
+
+func TestDecryptInvalidHeader(t *testing.T) {
+
+	_, sealed := encryptTest(t, 100)
+
+	// params is the offset of the version byte.
+	params := len(encMagic)
+
+	tests := []struct {
+		name string
+		// set is the header bytes to set, by offset.
+		set map[int]byte
+	}{
+		{name: "version", set: map[int]byte{params: 2}},
+		{name: "kdf", set: map[int]byte{params + 1: 2}},
+		{name: "logN", set: map[int]byte{params + 2: encMaxScryptLogN + 1}},
+		{name: "r", set: map[int]byte{params + 3: 255}},
+		{name: "p", set: map[int]byte{params + 4: 0}},
+		// 128 * 2^22 * 32 bytes
+		{name: "memory", set: map[int]byte{params + 2: encMaxScryptLogN, params + 3: encMaxScryptR}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			b := append([]byte{}, sealed...)
+			for off, v := range tt.set {
+				b[off] = v
+			}
+
+			// The header is refused before the key is derived.
+			start := time.Now()
+			_, err := Decrypt(b, testPassphrase)
+			if err == nil || errors.Is(err, ErrDecrypt) {
+				t.Errorf("got %v; want an invalid header", err)
+			}
+			if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+				t.Errorf("took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+
+	plain := []byte("the legacy format; a nonce and the sealed data")
+
+	block, err := aes.NewCipher([]byte(createHash(testPassphrase)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	legacy := gcm.Seal(nonce, nonce, plain, nil)
+
+	got, err := Decrypt(legacy, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("got %q; want %q", got, plain)
+	}
+
+	if _, err = Decrypt(legacy, testPassphrase+"x"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("got %v; want %v", err, ErrDecrypt)
+	}
+
+	// A file of the legacy format is decrypted in place.
+	p := filepath.Join(t.TempDir(), "legacy.bin")
+	if err = os.WriteFile(p, legacy, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = DecryptFile(p, testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(p); !bytes.Equal(b, plain) {
+		t.Errorf("got %q; want %q", b, plain)
+	}
+}
+
+func TestEncryptFile(t *testing.T) {
+
+	plain := bytes.Repeat([]byte("0123456789"), encChunkSize/5)
+
+	p := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(p, plain, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EncryptFile(p, testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptFile(p, testPassphrase); err == nil {
+		t.Error("an encrypted file was encrypted again")
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("mode %v; want %v", fi.Mode().Perm(), os.FileMode(0640))
+	}
+
+	// The file is left as is, when it fails to decrypt.
+	sealed, _ := os.ReadFile(p)
+	if err = DecryptFile(p, testPassphrase+"x"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("got %v; want %v", err, ErrDecrypt)
+	}
+	if b, _ := os.ReadFile(p); !bytes.Equal(b, sealed) {
+		t.Error("the file was changed by a failed decryption")
+	}
+
+	if err = DecryptFile(p, testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(p); !bytes.Equal(b, plain) {
+		t.Error("the decrypted file differs")
+	}
+
+	if matches, _ := filepath.Glob(p + ".*.tmp"); len(matches) > 0 {
+		t.Errorf("temporary files are left: %v", matches)
+	}
+}
+
+func TestReplaceWithOpenCursor(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{RetryPolicy: RetryPolicy{BusyTimeout: 200 * time.Millisecond}})
+	defer d.Close()
+
+	srcFilePath := backupTest(t, d, 50)
+	destFilePath := filepath.Join(t.TempDir(), "dest.sqlite")
+	if err := d.CloneDatabase(srcFilePath, destFilePath, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{srcFilePath, destFilePath} {
+		c, err := d.OpenCursor("SELECT id FROM t ORDER BY id", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.Next() {
+			t.Fatal(c.Err())
+		}
+
+		// The file is not replaced under the cursor; nor are its
+		// -wal and -shm removed.
+		if path == srcFilePath {
+			err = d.EncryptDatabase(path, testPassphrase)
+		} else {
+			err = d.CloneDatabase(srcFilePath, path, nil)
+		}
+		if !errors.Is(err, ErrDatabaseIsLocked) {
+			t.Errorf("%s: got %v; want %v", filepath.Base(path), err, ErrDatabaseIsLocked)
+		}
+		if _, err = os.Stat(path + "-shm"); err != nil {
+			t.Errorf("%s: %v", filepath.Base(path), err)
+		}
+
+		n := 1
+		for c.Next() {
+			n++
+		}
+		if c.Err() != nil || n != 50 {
+			t.Errorf("%s: read %d rows; %v", filepath.Base(path), n, c.Err())
+		}
+
+		// The cursor is waited for.
+		time.AfterFunc(50*time.Millisecond, func() { c.Close() })
+		d.RetryPolicy.BusyTimeout = 5 * time.Second
+		if path == srcFilePath {
+			if err = d.EncryptDatabase(path, testPassphrase); err == nil {
+				err = d.DecryptDatabase(path, testPassphrase)
+			}
+		} else {
+			err = d.CloneDatabase(srcFilePath, path, nil)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(path), err)
+		}
+		d.RetryPolicy.BusyTimeout = 200 * time.Millisecond
+
+		if v, err := d.ExecuteScalare("SELECT count(*) FROM t", path); err != nil || v != int64(50) {
+			t.Errorf("%s: got %v, %v", filepath.Base(path), v, err)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package sqlitehench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// EncryptedDB is a session on an encrypted db file. The file is decrypted
+// into an in-memory database (of the memdb VFS), and the operations of
+// the DBAccess on the db file path are routed to it; so that the plain
+// data is never written to disk, and nothing of it is left behind when
+// the process crashes or is killed. The database is encrypted to its
+// file (as EncryptFile does) by Checkpoint and Close, and at the
+// CheckpointInterval; the changes since the last checkpoint are lost,
+// if the process ends without one.
+type EncryptedDB struct {
+	d          *DBAccess
+	dbFilePath string
+	passphrase string
+	opt        EncryptedDBOptions
+
+	// dsn is the name of the in-memory database; which lives as long
+	// as conn is open.
+	dsn  string
+	db   *sql.DB
+	conn *sql.Conn
+
+	mu sync.Mutex
+	// dataVersion is the PRAGMA data_version of the last checkpoint;
+	// -1 when the file is to be written regardless (i.e. legacy).
+	dataVersion int64
+	closed      bool
+	stop        chan struct{}
+}
+
+// encryptedSessions maps the db file paths to their open sessions.
+type encryptedSessions struct {
+	mu sync.Mutex
+	m  map[string]*EncryptedDB
+}
+
+func (s *encryptedSessions) get(dbFilePath string) *EncryptedDB {
+
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.m[dbFilePath]
+}
+
+// dataSource returns the name that a db file is opened with; that of the
+// in-memory database, when it has an open session.
+func (d *DBAccess) dataSource(dbFilePath string) string {
+
+	if e := d.sessions.get(dbFilePath); e != nil {
+		return e.dsn
+	}
+
+	return dbFilePath
+}
+
+// OpenEncryptedDB decrypts a db file (of the encrypted format, or the
+// legacy format; which is written in the encrypted format on the first
+// checkpoint) into a session. Until the session is closed, dbFilePath
+// refers to the decrypted database in the methods of d.
+func (d *DBAccess) OpenEncryptedDB(dbFilePath string, pwdPhrase string, opt EncryptedDBOptions) (_ *EncryptedDB, err error) {
+
+	defer func() { err = wrapErr("OpenEncryptedDB", dbFilePath, "", err) }()
+
+	if d.sessions == nil {
+		return nil, errors.New("the DBAccess was not created with NewDBAccess")
+	}
+
+	plain, legacy, err := readEncryptedFile(dbFilePath, pwdPhrase, opt.Create)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(plain)
+
+	var name [16]byte
+	if _, err = rand.Read(name[:]); err != nil {
+		return nil, err
+	}
+
+	e := &EncryptedDB{
+		d:          d,
+		dbFilePath: dbFilePath,
+		passphrase: pwdPhrase,
+		opt:        opt,
+		dsn:        fmt.Sprintf("file:/sqlitehench-%s?vfs=memdb", hex.EncodeToString(name[:])),
+		stop:       make(chan struct{}),
+	}
+
+	ctx := context.Background()
+
+	if e.db, err = sql.Open(d.driverName, e.dsn); err != nil {
+		return nil, err
+	}
+	if e.conn, err = e.db.Conn(ctx); err != nil {
+		e.db.Close()
+		return nil, err
+	}
+
+	if err = e.load(ctx, plain); err == nil {
+		err = e.conn.QueryRowContext(ctx, "PRAGMA data_version").Scan(&e.dataVersion)
+	}
+	if err != nil {
+		e.conn.Close()
+		e.db.Close()
+		return nil, err
+	}
+
+	// A new file, or one of the legacy format, is written now.
+	if legacy || plain == nil {
+		e.dataVersion = -1
+	}
+
+	d.sessions.mu.Lock()
+	if d.sessions.m[dbFilePath] != nil {
+		d.sessions.mu.Unlock()
+		e.conn.Close()
+		e.db.Close()
+		return nil, ErrEncryptedDBOpen
+	}
+	d.sessions.m[dbFilePath] = e
+	d.sessions.mu.Unlock()
+
+	// The pooled handle of the encrypted file.
+	d.CloseDB(dbFilePath)
+
+	if e.dataVersion == -1 {
+		if err = e.Checkpoint(); err != nil {
+			e.discard()
+			return nil, err
+		}
+	}
+
+	if opt.CheckpointInterval > 0 {
+		go e.checkpointLoop()
+	}
+	if opt.CloseOnSignal {
+		go e.closeOnSignal()
+	}
+
+	return e, nil
+}
+
+// readEncryptedFile returns the plain data of an encrypted file; nil, if
+// it does not exist and create is set. legacy is set for a file of the
+// legacy format.
+func readEncryptedFile(dbFilePath string, pwdPhrase string, create bool) ([]byte, bool, error) {
+
+	f, err := os.Open(dbFilePath)
+	if errors.Is(err, os.ErrNotExist) && create {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, 64*1024)
+	magic, _ := br.Peek(len(encMagic))
+
+	if isEncrypted(magic) {
+		var buf bytes.Buffer
+		if fi, err := f.Stat(); err == nil {
+			buf.Grow(int(fi.Size()))
+		}
+		if err = decryptStream(&buf, br, pwdPhrase); err != nil {
+			wipe(buf.Bytes())
+			return nil, false, err
+		}
+		return buf.Bytes(), false, nil
+	}
+
+	b, err := io.ReadAll(br)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(b) == 0 || bytes.HasPrefix(b, []byte("SQLite format 3\x00")) {
+		return nil, false, ErrNotEncrypted
+	}
+
+	plain, err := decryptLegacy(b, pwdPhrase)
+
+	return plain, true, err
+}
+
+// load copies a database image into the in-memory database; through a
+// connection of its own, as a deserialized database is private to its
+// connection.
+func (e *EncryptedDB) load(ctx context.Context, plain []byte) error {
+
+	if len(plain) == 0 {
+		return nil
+	}
+
+	// The memdb VFS does not support WAL; the file format version
+	// (bytes 18 and 19 of the header) of a WAL database is set back to
+	// that of the rollback journal, as journal_mode=DELETE does.
+	if len(plain) >= 100 && plain[18] == 2 && plain[19] == 2 {
+		plain[18], plain[19] = 1, 1
+	}
+
+	srcDB, err := sql.Open(e.d.driverName, ":memory:")
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+
+	srcConn, err := srcDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return e.conn.Raw(func(destDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+			dest, ok := destDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errBackupNotSupported
+			}
+			src, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errBackupNotSupported
+			}
+			if err := src.Deserialize(plain, "main"); err != nil {
+				return err
+			}
+			return runBackup(ctx, dest, src, CloneOptions{PagesPerStep: -1})
+		})
+	})
+}
+
+// Checkpoint encrypts the database to its file, if it has changed since
+// the last checkpoint. The file is replaced atomically; so it holds
+// either checkpoint, if the process ends while it is written.
+func (e *EncryptedDB) Checkpoint() error {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return wrapErr("Checkpoint", e.dbFilePath, "", errors.New("the EncryptedDB is closed"))
+	}
+
+	return wrapErr("Checkpoint", e.dbFilePath, "", e.save())
+}
+
+func (e *EncryptedDB) save() error {
+
+	ctx := context.Background()
+
+	// The read transaction holds off the writers; so that the image
+	// is of committed pages.
+	if _, err := e.conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return err
+	}
+	defer e.conn.ExecContext(ctx, "ROLLBACK")
+
+	var n, version int64
+	if err := e.conn.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master").Scan(&n); err != nil {
+		return err
+	}
+	if err := e.conn.QueryRowContext(ctx, "PRAGMA data_version").Scan(&version); err != nil {
+		return err
+	}
+	if version == e.dataVersion {
+		return nil
+	}
+
+	var plain []byte
+	err := e.conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return errBackupNotSupported
+		}
+		var err error
+		plain, err = c.Serialize("main")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer wipe(plain)
+
+	perm := os.FileMode(0600)
+	if fi, err := os.Stat(e.dbFilePath); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	err = writeFileAtomic(e.dbFilePath, perm, func(w io.Writer) error {
+		return encryptStream(w, bytes.NewReader(plain), e.passphrase)
+	})
+	if err != nil {
+		return err
+	}
+
+	e.dataVersion = version
+
+	return nil
+}
+
+// Close encrypts the database to its file, and ends the session; the
+// in-memory database is freed, once the operations that are using it
+// have completed. If the checkpoint fails, the session stays open; so
+// that it can be retried.
+func (e *EncryptedDB) Close() error {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil
+	}
+
+	if err := e.save(); err != nil {
+		return wrapErr("Close", e.dbFilePath, "", err)
+	}
+
+	e.closeLocked()
+
+	return nil
+}
+
+// discard ends the session without a checkpoint.
+func (e *EncryptedDB) discard() {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.closed {
+		e.closeLocked()
+	}
+}
+
+func (e *EncryptedDB) closeLocked() {
+
+	e.closed = true
+	close(e.stop)
+
+	e.d.sessions.mu.Lock()
+	delete(e.d.sessions.m, e.dbFilePath)
+	e.d.sessions.mu.Unlock()
+
+	e.d.CloseDB(e.dbFilePath)
+	e.conn.Close()
+	e.db.Close()
+}
+
+func (e *EncryptedDB) checkpointLoop() {
+
+	ticker := time.NewTicker(e.opt.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			e.mu.Lock()
+			var err error
+			if !e.closed {
+				err = wrapErr("Checkpoint", e.dbFilePath, "", e.save())
+			}
+			e.mu.Unlock()
+			if err != nil && e.opt.OnError != nil {
+				e.opt.OnError(err)
+			}
+		}
+	}
+}
+
+// closeOnSignal closes the session on SIGINT or SIGTERM; then the signal
+// is raised again, so that the process ends as it would have (or exits,
+// where a signal cannot be sent to the process).
+func (e *EncryptedDB) closeOnSignal() {
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(ch)
+
+	select {
+	case <-e.stop:
+		return
+	case sig := <-ch:
+		if err := e.Close(); err != nil && e.opt.OnError != nil {
+			e.opt.OnError(err)
+		}
+		signal.Stop(ch)
+		p, err := os.FindProcess(os.Getpid())
+		if err == nil {
+			err = p.Signal(sig)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	}
+}
+
+// wipe zeroes the plain data that is no longer needed.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
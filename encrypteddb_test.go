@@ -0,0 +1,137 @@
+package sqlitehench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+func TestEncryptedDBSession(t *testing.T) {
+
+	dir := t.TempDir()
+	dbFilePath := filepath.Join(dir, "session.sqlite")
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	e, err := d.OpenEncryptedDB(dbFilePath, testPassphrase, EncryptedDBOptions{Create: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	if _, err = d.OpenEncryptedDB(dbFilePath, testPassphrase, EncryptedDBOptions{}); !errors.Is(err, ErrEncryptedDBOpen) {
+		t.Errorf("second session: got %v; want %v", err, ErrEncryptedDBOpen)
+	}
+
+	if _, err = d.ExecuteNonQuery("CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	tbl, _ := collc.NewCollection().Table.Create("t")
+	tbl.Cols.Add("id")
+	tbl.Cols.Add("v")
+	for i := 0; i < 50; i++ {
+		r := tbl.Rows.New()
+		r["id"] = int64(i + 1)
+		r["v"] = fmt.Sprintf("plain-row-%d", i+1)
+	}
+	if _, err = d.BulkInsertWithOptions(context.Background(), tbl, dbFilePath, BulkInsertOptions{KeepTable: true, BatchSize: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if n := rowCount(t, d, "t", dbFilePath); n != 50 {
+		t.Fatalf("session has %d rows; want 50", n)
+	}
+
+	dt, err := d.GetDataTableLongQuery("SELECT * FROM t", dbFilePath, 10, func(LonqQueryArgs) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dt.Rows.Count() != 50 {
+		t.Errorf("GetDataTableLongQuery read %d rows; want 50", dt.Rows.Count())
+	}
+
+	// The clones are of the in-memory database.
+	for _, mode := range []CloneMode{CloneBackup, CloneRows} {
+		dest := filepath.Join(dir, fmt.Sprintf("clone-%d.sqlite", mode))
+		if err = d.CloneDatabaseWithOptions(context.Background(), dbFilePath, dest, CloneOptions{Mode: mode}); err != nil {
+			t.Fatalf("mode %d: %v", mode, err)
+		}
+		if n := rowCount(t, d, "t", dest); n != 50 {
+			t.Errorf("mode %d: the clone has %d rows; want 50", mode, n)
+		}
+	}
+
+	// The file holds no plain data; before and after the checkpoint.
+	for i := 0; i < 2; i++ {
+		raw, err := os.ReadFile(dbFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isEncrypted(raw) || bytes.Contains(raw, []byte("plain-row-")) {
+			t.Error("the db file holds plain data")
+		}
+		if err = e.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = d.OpenEncryptedDB(dbFilePath, testPassphrase+"x", EncryptedDBOptions{}); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("wrong passphrase: got %v; want %v", err, ErrDecrypt)
+	}
+
+	e, err = d.OpenEncryptedDB(dbFilePath, testPassphrase, EncryptedDBOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	if n := rowCount(t, d, "t", dbFilePath); n != 50 {
+		t.Errorf("the reopened session has %d rows; want 50", n)
+	}
+}
+
+func TestEncryptedDBDiscard(t *testing.T) {
+
+	dbFilePath := filepath.Join(t.TempDir(), "session.sqlite")
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	e, err := d.OpenEncryptedDB(dbFilePath, testPassphrase, EncryptedDBOptions{Create: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.ExecuteNonQuery("CREATE TABLE t (id INTEGER)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The changes after the last checkpoint are lost, when the
+	// session ends without one.
+	if _, err = d.ExecuteNonQuery("INSERT INTO t VALUES (1)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+	e.discard()
+
+	if e, err = d.OpenEncryptedDB(dbFilePath, testPassphrase, EncryptedDBOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	if n := rowCount(t, d, "t", dbFilePath); n != 0 {
+		t.Errorf("got %d rows; want 0", n)
+	}
+}
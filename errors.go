@@ -0,0 +1,190 @@
+package sqlitehench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Sentinel errors for use with errors.Is. The ones that have an Err_
+// string constant keep the same text; so that comparing err.Error()
+// against the constants still works.
+var (
+	ErrDatabaseIsLocked      = errors.New(Err_DatabaseIsLocked)
+	ErrFileIsNotDatabase     = errors.New(Err_FileIsNotDatabase)
+	ErrDatabaseFileNotExists = errors.New(Err_DatabaseFileNotExists)
+	ErrNoRowsFound           = errors.New(Err_NoRowsFound)
+
+	ErrConstraint        = errors.New("constraint failed")
+	ErrCorrupt           = errors.New("database disk image is malformed")
+	ErrTableNotFound     = errors.New("table not found")
+	ErrTableNameNotFound = errors.New("table-name not found")
+
+	// ErrInvalidPageToken is returned when a keyset token cannot be
+	// decoded, or was issued for a different query.
+	ErrInvalidPageToken = errors.New("invalid page token")
+
+	// ErrMigrationLocked is returned when another runner is migrating
+	// the same database.
+	ErrMigrationLocked = errors.New("migration is in progress")
+
+	// ErrDecrypt is returned when encrypted data does not authenticate;
+	// i.e. the passphrase is wrong, or the data was changed.
+	ErrDecrypt = errors.New("wrong passphrase, or the encrypted data is corrupted")
+
+	// ErrNotEncrypted is returned when the data is not in the encrypted
+	// format.
+	ErrNotEncrypted = errors.New("the data is not encrypted")
+
+	// ErrEncryptedDBOpen is returned when a database has an open
+	// EncryptedDB session, and the operation would replace its file.
+	ErrEncryptedDBOpen = errors.New("the database has an open encrypted session")
+)
+
+// SQLite primary result codes; see https://sqlite.org/rescode.html.
+const (
+	sqliteBusy       = 5
+	sqliteLocked     = 6
+	sqliteCorrupt    = 11
+	sqliteCantOpen   = 14
+	sqliteTooBig     = 18
+	sqliteConstraint = 19
+	sqliteMismatch   = 20
+	sqliteNotADB     = 26
+)
+
+// maxErrorSQLLen is the length, to which the SQL is truncated in an Error.
+const maxErrorSQLLen = 256
+
+// Error is returned by the DBAccess functions when an operation fails. It
+// wraps the driver error (or one of the sentinel errors) and works with
+// errors.Is; i.e. errors.Is(err, ErrDatabaseIsLocked) for SQLITE_BUSY or
+// SQLITE_LOCKED, ErrConstraint, ErrCorrupt, ErrFileIsNotDatabase.
+type Error struct {
+	// Op is the name of the function that failed (i.e. ExecuteNonQuery).
+	Op         string
+	DBFilePath string
+
+	// SQL is the statement that failed; truncated to 256 bytes.
+	SQL string
+
+	// Code and ExtendedCode are the SQLite result codes; zero when
+	// the error was not returned by SQLite.
+	Code         int
+	ExtendedCode int
+
+	Err error
+}
+
+func (e *Error) Error() string {
+
+	s := e.Op
+	if e.DBFilePath != "" {
+		s = fmt.Sprintf("%s %s", s, e.DBFilePath)
+	}
+
+	return fmt.Sprintf("%s: %v", s, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is maps the SQLite result code to the sentinel errors.
+func (e *Error) Is(target error) bool {
+
+	switch target {
+	case ErrDatabaseIsLocked:
+		return e.Code == sqliteBusy || e.Code == sqliteLocked
+	case ErrConstraint:
+		return e.Code == sqliteConstraint
+	case ErrCorrupt:
+		return e.Code == sqliteCorrupt
+	case ErrFileIsNotDatabase:
+		return e.Code == sqliteNotADB
+	case ErrDatabaseFileNotExists:
+		return e.Code == sqliteCantOpen || errors.Is(e.Err, fs.ErrNotExist)
+	}
+
+	return false
+}
+
+// wrapErr returns err as an *Error with the op, db file path and SQL
+// statement. A nil error, a ctx error, an *Error, and the sentinel
+// errors are returned as is.
+func wrapErr(op string, dbFilePath string, sqlText string, err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	switch err {
+	case ErrDatabaseIsLocked, ErrFileIsNotDatabase, ErrDatabaseFileNotExists, ErrNoRowsFound,
+		ErrConstraint, ErrCorrupt, ErrTableNotFound, ErrTableNameNotFound, ErrInvalidPageToken, ErrMigrationLocked,
+		ErrDecrypt, ErrNotEncrypted, ErrEncryptedDBOpen:
+		return err
+	}
+
+	if len(sqlText) > maxErrorSQLLen {
+		sqlText = sqlText[:maxErrorSQLLen] + "..."
+	}
+
+	code, extCode := resultCodes(err)
+
+	return &Error{
+		Op:           op,
+		DBFilePath:   dbFilePath,
+		SQL:          sqlText,
+		Code:         code,
+		ExtendedCode: extCode,
+		Err:          err,
+	}
+}
+
+// resultCodes returns the SQLite primary and extended result codes of a
+// driver error. For drivers other than go-sqlite3, the primary code is
+// derived from the error message.
+func resultCodes(err error) (int, int) {
+
+	var se sqlite3.Error
+	if errors.As(err, &se) {
+		return int(se.Code), int(se.ExtendedCode)
+	}
+
+	var pse *sqlite3.Error
+	if errors.As(err, &pse) && pse != nil {
+		return int(pse.Code), int(pse.ExtendedCode)
+	}
+
+	s := strings.ToLower(err.Error())
+
+	switch {
+	case strings.Contains(s, "database table is locked"):
+		return sqliteLocked, 0
+	case strings.Contains(s, Err_DatabaseIsLocked), strings.Contains(s, "database is busy"):
+		return sqliteBusy, 0
+	case strings.Contains(s, Err_FileIsNotDatabase):
+		return sqliteNotADB, 0
+	case strings.Contains(s, "database disk image is malformed"):
+		return sqliteCorrupt, 0
+	case strings.Contains(s, "constraint failed"):
+		return sqliteConstraint, 0
+	case strings.Contains(s, "unable to open database file"):
+		return sqliteCantOpen, 0
+	}
+
+	return 0, 0
+}
@@ -0,0 +1,97 @@
+package sqlitehench
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWrapErr(t *testing.T) {
+
+	sentinels := []error{
+		ErrDatabaseIsLocked, ErrFileIsNotDatabase, ErrDatabaseFileNotExists, ErrNoRowsFound,
+		ErrConstraint, ErrCorrupt, ErrTableNotFound, ErrTableNameNotFound, ErrInvalidPageToken,
+		ErrMigrationLocked, ErrDecrypt, ErrNotEncrypted, ErrEncryptedDBOpen,
+		context.Canceled, context.DeadlineExceeded,
+	}
+	for _, err := range sentinels {
+		if got := wrapErr("Op", "x.sqlite", "", err); got != err {
+			t.Errorf("%v is wrapped: %v", err, got)
+		}
+	}
+
+	if wrapErr("Op", "x.sqlite", "", nil) != nil {
+		t.Error("nil is wrapped")
+	}
+
+	// An *Error is not wrapped again.
+	e := &Error{Op: "Inner", Err: errors.New("x")}
+	if got := wrapErr("Outer", "x.sqlite", "", e); got != e {
+		t.Errorf("got %v", got)
+	}
+
+	// The SQL is truncated.
+	long := strings.Repeat("x", maxErrorSQLLen+10)
+	var got *Error
+	if !errors.As(wrapErr("Op", "x.sqlite", long, errors.New("near \"x\": syntax error")), &got) {
+		t.Fatal("not an *Error")
+	}
+	if len(got.SQL) != maxErrorSQLLen+3 || got.Op != "Op" || got.DBFilePath != "x.sqlite" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+
+	tests := []struct {
+		msg  string
+		want error
+	}{
+		{"database is locked", ErrDatabaseIsLocked},
+		{"database table is locked: t", ErrDatabaseIsLocked},
+		{"UNIQUE constraint failed: t.id", ErrConstraint},
+		{"database disk image is malformed", ErrCorrupt},
+		{"file is not a database", ErrFileIsNotDatabase},
+		{"unable to open database file", ErrDatabaseFileNotExists},
+	}
+
+	for _, tt := range tests {
+		err := wrapErr("Op", "x.sqlite", "", errors.New(tt.msg))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%q is not %v", tt.msg, tt.want)
+		}
+		if errors.Is(err, ErrNoRowsFound) {
+			t.Errorf("%q is %v", tt.msg, ErrNoRowsFound)
+		}
+	}
+}
+
+func TestErrorFromDriver(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "errors.sqlite")
+	if _, err := d.ExecuteNonQuery("CREATE TABLE t (id INTEGER PRIMARY KEY)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ExecuteNonQuery("INSERT INTO t VALUES (1)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	const dup = "INSERT INTO t VALUES (1)"
+	_, err := d.ExecuteNonQuery(dup, dbFilePath)
+
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got %T: %v", err, err)
+	}
+	if !errors.Is(err, ErrConstraint) || e.Code != sqliteConstraint || e.ExtendedCode == 0 {
+		t.Errorf("got %+v", e)
+	}
+	if e.Op != "ExecuteNonQuery" || e.DBFilePath != dbFilePath || e.SQL != dup {
+		t.Errorf("got %+v", e)
+	}
+}
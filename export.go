@@ -0,0 +1,490 @@
+package sqlitehench
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// ExportQueryToCSV writes the rows of a query to w as CSV; the rows are
+// read with a cursor, so that they are not held in memory. It returns
+// the number of rows written.
+func (d *DBAccess) ExportQueryToCSV(sqlQuery string, dbFilePath string, w io.Writer, opt CSVExportOptions, args ...interface{}) (int64, error) {
+	return d.ExportQueryToCSVContext(context.Background(), sqlQuery, dbFilePath, w, opt, args...)
+}
+
+// ExportQueryToCSVContext is ExportQueryToCSV with a ctx.
+func (d *DBAccess) ExportQueryToCSVContext(ctx context.Context, sqlQuery string, dbFilePath string, w io.Writer, opt CSVExportOptions, args ...interface{}) (_ int64, err error) {
+
+	defer func() { err = wrapErr("ExportQueryToCSV", dbFilePath, sqlQuery, err) }()
+
+	c, err := d.OpenCursorContext(ctx, sqlQuery, dbFilePath, args...)
+	if err != nil {
+		return -1, err
+	}
+	defer c.Close()
+
+	cw := newCSVWriter(w, c.Columns(), opt)
+
+	if !opt.NoHeader {
+		cw.writeHeader()
+	}
+
+	var n int64
+
+	for c.Next() {
+		vals, err := c.Values()
+		if err != nil {
+			return n, err
+		}
+		if err = cw.writeRow(vals); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	if err = c.Err(); err != nil {
+		return n, err
+	}
+
+	return n, cw.w.Flush()
+}
+
+// ExportDataTableToCSV writes the rows of a DataTable to w as CSV; in the
+// order of its columns. It returns the number of rows written.
+func (d *DBAccess) ExportDataTableToCSV(tbl *collc.Table, w io.Writer, opt CSVExportOptions) (int64, error) {
+
+	if tbl == nil {
+		return -1, ErrTableNameNotFound
+	}
+
+	cols := tbl.Cols.Get()
+	names := make([]string, len(cols))
+	for i := 0; i < len(cols); i++ {
+		names[i] = cols[i].Name
+	}
+
+	cw := newCSVWriter(w, names, opt)
+
+	if !opt.NoHeader {
+		cw.writeHeader()
+	}
+
+	rows := tbl.Rows.GetRows()
+	vals := make([]interface{}, len(names))
+
+	for i := 0; i < len(rows); i++ {
+		for j := 0; j < len(names); j++ {
+			vals[j] = rows[i][names[j]]
+		}
+		if err := cw.writeRow(vals); err != nil {
+			return int64(i), err
+		}
+	}
+
+	return int64(len(rows)), cw.w.Flush()
+}
+
+// csvWriter writes CSV records; a field is quoted (as in RFC 4180) when
+// it holds the delimiter, a quote, a line break, or leading or trailing
+// spaces.
+type csvWriter struct {
+	w     *bufio.Writer
+	opt   CSVExportOptions
+	names []string
+	comma string
+	eol   string
+}
+
+func newCSVWriter(w io.Writer, names []string, opt CSVExportOptions) *csvWriter {
+
+	cw := &csvWriter{w: bufio.NewWriterSize(w, 64*1024), opt: opt, names: names, comma: ",", eol: "\n"}
+	if opt.Comma != 0 {
+		cw.comma = string(opt.Comma)
+	}
+	if opt.UseCRLF {
+		cw.eol = "\r\n"
+	}
+	if cw.opt.TimeFormat == "" {
+		cw.opt.TimeFormat = time.RFC3339Nano
+	}
+
+	return cw
+}
+
+func (cw *csvWriter) writeHeader() error {
+
+	for i := 0; i < len(cw.names); i++ {
+		if i > 0 {
+			cw.w.WriteString(cw.comma)
+		}
+		cw.writeField(cw.names[i], false)
+	}
+
+	_, err := cw.w.WriteString(cw.eol)
+
+	return err
+}
+
+// writeRow writes a record; an empty string (or one that reads as the
+// NULL text) is quoted, so that it is told from NULL. A value that cannot
+// be exported (i.e. a driver.Valuer that fails) is returned as an error;
+// the record may then be partly written.
+func (cw *csvWriter) writeRow(vals []interface{}) error {
+
+	for i := 0; i < len(vals); i++ {
+		if i > 0 {
+			cw.w.WriteString(cw.comma)
+		}
+		s, null, err := exportText(vals[i], cw.opt.Blob, cw.opt.TimeFormat)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", cw.names[i], err)
+		}
+		if null {
+			cw.writeField(cw.opt.Null, false)
+			continue
+		}
+		cw.writeField(s, s == "" || s == cw.opt.Null)
+	}
+
+	_, err := cw.w.WriteString(cw.eol)
+
+	return err
+}
+
+func (cw *csvWriter) writeField(s string, quote bool) {
+
+	if !quote {
+		quote = strings.Contains(s, cw.comma) || strings.ContainsAny(s, "\"\r\n") ||
+			(s != "" && (s[0] == ' ' || s[0] == '\t' || s[len(s)-1] == ' ' || s[len(s)-1] == '\t'))
+	}
+
+	if !quote {
+		cw.w.WriteString(s)
+		return
+	}
+
+	cw.w.WriteByte('"')
+	cw.w.WriteString(strings.ReplaceAll(s, `"`, `""`))
+	cw.w.WriteByte('"')
+}
+
+// exportValue returns the value of a driver.Valuer, as bindValue does
+// for the other types; and their error.
+func exportValue(v interface{}) (interface{}, error) {
+
+	if vr, ok := v.(driver.Valuer); ok {
+		var err error
+		if v, err = vr.Value(); err != nil {
+			return nil, err
+		}
+	}
+
+	return bindValue(v)
+}
+
+// exportText returns the text form of a value; null is set for NULL.
+func exportText(v interface{}, blob BlobEncoding, timeFormat string) (s string, null bool, err error) {
+
+	if v, err = exportValue(v); err != nil {
+		return "", false, err
+	}
+
+	switch x := v.(type) {
+	case string:
+		return x, false, nil
+	case []byte:
+		return encodeBlob(x, blob), false, nil
+	case time.Time:
+		return x.Format(timeFormat), false, nil
+	case int64:
+		return strconv.FormatInt(x, 10), false, nil
+	case float64:
+		return formatFloat(x), false, nil
+	case bool:
+		return strconv.FormatBool(x), false, nil
+	}
+
+	return "", true, nil
+}
+
+func encodeBlob(b []byte, blob BlobEncoding) string {
+
+	if blob == BlobBase64 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// formatFloat returns a float without an exponent, unless it is very
+// large or small (as encoding/json does).
+func formatFloat(f float64) string {
+
+	if a := math.Abs(f); a != 0 && (a < 1e-6 || a >= 1e21) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ExportQueryToJSON writes the rows of a query to w as a JSON array of
+// objects (or as NDJSON); the rows are read with a cursor, so that they
+// are not held in memory. It returns the number of rows written.
+func (d *DBAccess) ExportQueryToJSON(sqlQuery string, dbFilePath string, w io.Writer, opt JSONOptions, args ...interface{}) (int64, error) {
+	return d.ExportQueryToJSONContext(context.Background(), sqlQuery, dbFilePath, w, opt, args...)
+}
+
+// ExportQueryToJSONContext is ExportQueryToJSON with a ctx.
+func (d *DBAccess) ExportQueryToJSONContext(ctx context.Context, sqlQuery string, dbFilePath string, w io.Writer, opt JSONOptions, args ...interface{}) (_ int64, err error) {
+
+	defer func() { err = wrapErr("ExportQueryToJSON", dbFilePath, sqlQuery, err) }()
+
+	c, err := d.OpenCursorContext(ctx, sqlQuery, dbFilePath, args...)
+	if err != nil {
+		return -1, err
+	}
+	defer c.Close()
+
+	var types []string
+	if opt.Metadata {
+		ct, err := c.ColumnTypes()
+		if err != nil {
+			return -1, err
+		}
+		types = make([]string, len(ct))
+		for i := 0; i < len(ct); i++ {
+			types[i] = ct[i].DatabaseTypeName()
+		}
+	}
+
+	jw := newJSONWriter(w, c.Columns(), types, opt)
+	if err = jw.begin(); err != nil {
+		return -1, err
+	}
+
+	var n int64
+
+	for c.Next() {
+		vals, err := c.Values()
+		if err != nil {
+			return n, err
+		}
+		if err = jw.writeRow(vals); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	if err = c.Err(); err != nil {
+		return n, err
+	}
+
+	return n, jw.end()
+}
+
+// ExportDataTableToJSON writes the rows of a DataTable to w as a JSON
+// array of objects (or as NDJSON); the members are in the order of the
+// columns. It returns the number of rows written.
+func (d *DBAccess) ExportDataTableToJSON(tbl *collc.Table, w io.Writer, opt JSONOptions) (int64, error) {
+
+	if tbl == nil {
+		return -1, ErrTableNameNotFound
+	}
+
+	cols := tbl.Cols.Get()
+	names := make([]string, len(cols))
+	for i := 0; i < len(cols); i++ {
+		names[i] = cols[i].Name
+	}
+
+	// The types are those of the Column.Type metadata; or inferred
+	// from the values.
+	var types []string
+	if opt.Metadata {
+		types = inferColumnTypes(tbl, cols, CreateTableOptions{SampleSize: -1})
+		for i := 0; i < len(types); i++ {
+			types[i] = strings.TrimSuffix(types[i], " NOT NULL")
+		}
+	}
+
+	jw := newJSONWriter(w, names, types, opt)
+	if err := jw.begin(); err != nil {
+		return -1, err
+	}
+
+	rows := tbl.Rows.GetRows()
+	vals := make([]interface{}, len(names))
+
+	for i := 0; i < len(rows); i++ {
+		for j := 0; j < len(names); j++ {
+			vals[j] = rows[i][names[j]]
+		}
+		if err := jw.writeRow(vals); err != nil {
+			return int64(i), err
+		}
+	}
+
+	return int64(len(rows)), jw.end()
+}
+
+// jsonWriter writes rows as JSON objects.
+type jsonWriter struct {
+	w     *bufio.Writer
+	opt   JSONOptions
+	names []string
+	types []string
+
+	// keys holds the encoded member name of each column.
+	keys []string
+	rows int64
+}
+
+func newJSONWriter(w io.Writer, names []string, types []string, opt JSONOptions) *jsonWriter {
+
+	jw := &jsonWriter{w: bufio.NewWriterSize(w, 64*1024), opt: opt, names: names, types: types}
+	if jw.opt.TimeFormat == "" {
+		jw.opt.TimeFormat = time.RFC3339Nano
+	}
+
+	jw.keys = make([]string, len(names))
+	for i := 0; i < len(names); i++ {
+		jw.keys[i] = jsonString(names[i]) + ":"
+	}
+
+	return jw
+}
+
+// begin writes the column metadata (if set), and opens the array.
+func (jw *jsonWriter) begin() error {
+
+	if jw.opt.Metadata {
+		jw.w.WriteString(`{"columns":[`)
+		for i := 0; i < len(jw.names); i++ {
+			if i > 0 {
+				jw.w.WriteByte(',')
+			}
+			jw.w.WriteString(`{"name":` + jsonString(jw.names[i]))
+			if i < len(jw.types) && jw.types[i] != "" {
+				jw.w.WriteString(`,"type":` + jsonString(jw.types[i]))
+			}
+			jw.w.WriteByte('}')
+		}
+		jw.w.WriteByte(']')
+		if jw.opt.NDJSON {
+			jw.w.WriteString("}\n")
+		} else {
+			jw.w.WriteString(`,"rows":`)
+		}
+	}
+
+	if !jw.opt.NDJSON {
+		jw.w.WriteByte('[')
+	}
+
+	return nil
+}
+
+func (jw *jsonWriter) writeRow(vals []interface{}) error {
+
+	if jw.rows > 0 && !jw.opt.NDJSON {
+		jw.w.WriteByte(',')
+	}
+	jw.rows++
+
+	jw.w.WriteByte('{')
+
+	first := true
+	for i := 0; i < len(vals); i++ {
+		s, err := jw.value(vals[i])
+		if err != nil {
+			return fmt.Errorf("column %s: %w", jw.names[i], err)
+		}
+		if s == "null" && jw.opt.OmitNull {
+			continue
+		}
+		if !first {
+			jw.w.WriteByte(',')
+		}
+		first = false
+		jw.w.WriteString(jw.keys[i])
+		jw.w.WriteString(s)
+	}
+
+	jw.w.WriteByte('}')
+
+	if jw.opt.NDJSON {
+		jw.w.WriteByte('\n')
+	}
+
+	return nil
+}
+
+// end closes the array and flushes the writer.
+func (jw *jsonWriter) end() error {
+
+	if !jw.opt.NDJSON {
+		jw.w.WriteByte(']')
+		if jw.opt.Metadata {
+			jw.w.WriteByte('}')
+		}
+	}
+
+	return jw.w.Flush()
+}
+
+// value returns the JSON form of a value; NaN and infinity (which JSON
+// does not have) are null.
+func (jw *jsonWriter) value(v interface{}) (string, error) {
+
+	v, err := exportValue(v)
+	if err != nil {
+		return "", err
+	}
+
+	switch x := v.(type) {
+	case nil:
+		return "null", nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return "null", nil
+		}
+		return formatFloat(x), nil
+	case time.Time:
+		switch jw.opt.TimeFormat {
+		case "unix":
+			return strconv.FormatInt(x.Unix(), 10), nil
+		case "unixmilli":
+			return strconv.FormatInt(x.UnixMilli(), 10), nil
+		}
+	}
+
+	s, _, err := exportText(v, jw.opt.Blob, jw.opt.TimeFormat)
+
+	return jsonString(s), err
+}
+
+// jsonString returns s as a JSON string; i.e. quoted and escaped.
+func jsonString(s string) string {
+
+	// A string does not fail to marshal; invalid UTF-8 is
+	// written as U+FFFD.
+	b, _ := json.Marshal(s)
+
+	return string(b)
+}
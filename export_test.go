@@ -0,0 +1,221 @@
+package sqlitehench
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// exportTest returns the path of a db file with the table T; its rows
+// hold quotes, empty strings, NULLs, blobs and line breaks.
+func exportTest(t *testing.T, d *DBAccess) string {
+
+	t.Helper()
+
+	at := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	return testDB(t, d, "export.sqlite", []string{"CREATE TABLE T (a INT, b TEXT, c BLOB, d DATETIME, e REAL)"}, "INSERT INTO T VALUES (?, ?, ?, ?, ?)",
+		[]interface{}{1, `x, "y"`, []byte{1, 2}, at, 1.5},
+		[]interface{}{2, "", nil, nil, 1e25},
+		[]interface{}{nil, " sp", "ab", nil, 0.1},
+		[]interface{}{4, "line\ntwo", nil, nil, nil})
+}
+
+func TestExportQueryToCSV(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := exportTest(t, d)
+
+	var b bytes.Buffer
+	n, err := d.ExportQueryToCSV("SELECT * FROM T WHERE a > ? OR a IS NULL", dbFilePath, &b, CSVExportOptions{UseCRLF: true}, 0)
+	if err != nil || n != 4 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+
+	want := "a,b,c,d,e\r\n" +
+		"1,\"x, \"\"y\"\"\",0102,2024-05-06T07:08:09Z,1.5\r\n" +
+		"2,\"\",,,1e+25\r\n" +
+		",\" sp\",ab,,0.1\r\n" +
+		"4,\"line\ntwo\",,,\r\n"
+	if b.String() != want {
+		t.Errorf("got %q; want %q", b.String(), want)
+	}
+
+	// The CSV reads back the same; an empty string is told from NULL.
+	if _, err = d.StreamCSVToDatabase(&b, dbFilePath, CSVImportOptions{Table: "T2"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Query[string](d, "SELECT quote(a) || '|' || quote(b) FROM T2 ORDER BY rowid", dbFilePath)
+	if err != nil || !equalStrings(got, []string{`1|'x, "y"'`, `2|''`, `NULL|' sp'`, "4|'line\ntwo'"}) {
+		t.Errorf("got %q, %v", got, err)
+	}
+
+	var e *Error
+	if _, err = d.ExportQueryToCSV("SELECT * FROM none", dbFilePath, &b, CSVExportOptions{}); !errors.As(err, &e) || e.SQL != "SELECT * FROM none" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestExportDataTableToCSV(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := exportTest(t, d)
+
+	tbl, err := d.GetDataTable("SELECT * FROM T", dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	n, err := d.ExportDataTableToCSV(tbl, &b, CSVExportOptions{Comma: '\t', Null: `\N`, Blob: BlobBase64, TimeFormat: "2006-01-02", NoHeader: true})
+	if err != nil || n != 4 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+
+	want := "1\t\"x, \"\"y\"\"\"\tAQI=\t2024-05-06\t1.5\n" +
+		"2\t\"\"\t\\N\t\\N\t1e+25\n" +
+		"\\N\t\" sp\"\tab\t\\N\t0.1\n" +
+		"4\t\"line\ntwo\"\t\\N\t\\N\t\\N\n"
+	if b.String() != want {
+		t.Errorf("got %q; want %q", b.String(), want)
+	}
+
+	if _, err = d.ExportDataTableToCSV(nil, &b, CSVExportOptions{}); !errors.Is(err, ErrTableNameNotFound) {
+		t.Errorf("got %v; want %v", err, ErrTableNameNotFound)
+	}
+
+	// The error of a value is returned; rather than written as the
+	// field.
+	tbl.Rows.GetRows()[1]["b"] = failingValuer{}
+	if _, err = d.ExportDataTableToCSV(tbl, &b, CSVExportOptions{}); !errors.Is(err, errValuer) {
+		t.Errorf("got %v; want %v", err, errValuer)
+	}
+}
+
+var errValuer = errors.New("no value")
+
+// failingValuer is a driver.Valuer that returns errValuer.
+type failingValuer struct{}
+
+func (failingValuer) Value() (driver.Value, error) {
+	return nil, errValuer
+}
+
+func TestFormatFloat(t *testing.T) {
+
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0"},
+		{1.5, "1.5"},
+		{-100, "-100"},
+		{1e20, "100000000000000000000"},
+		{1e21, "1e+21"},
+		{1e-7, "1e-07"},
+	}
+
+	for _, tt := range tests {
+		if got := formatFloat(tt.in); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExportQueryToJSON(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := exportTest(t, d)
+
+	const sqlQuery = "SELECT a, b, c, d, e, a + 1 AS x FROM T WHERE a IN (1, 2)"
+
+	tests := []struct {
+		name string
+		opt  JSONOptions
+		want string
+	}{
+		{"array", JSONOptions{},
+			`[{"a":1,"b":"x, \"y\"","c":"0102","d":"2024-05-06T07:08:09Z","e":1.5,"x":2},` +
+				`{"a":2,"b":"","c":null,"d":null,"e":1e+25,"x":3}]`},
+		{"ndjson", JSONOptions{NDJSON: true, OmitNull: true, Metadata: true, TimeFormat: "unix"},
+			`{"columns":[{"name":"a","type":"INT"},{"name":"b","type":"TEXT"},{"name":"c","type":"BLOB"},{"name":"d","type":"DATETIME"},{"name":"e","type":"REAL"},{"name":"x"}]}` + "\n" +
+				`{"a":1,"b":"x, \"y\"","c":"0102","d":1714979289,"e":1.5,"x":2}` + "\n" +
+				`{"a":2,"b":"","e":1e+25,"x":3}` + "\n"},
+		{"metadata", JSONOptions{Metadata: true, Blob: BlobBase64, TimeFormat: "unixmilli"},
+			`{"columns":[{"name":"a","type":"INT"},{"name":"b","type":"TEXT"},{"name":"c","type":"BLOB"},{"name":"d","type":"DATETIME"},{"name":"e","type":"REAL"},{"name":"x"}],` +
+				`"rows":[{"a":1,"b":"x, \"y\"","c":"AQI=","d":1714979289000,"e":1.5,"x":2},{"a":2,"b":"","c":null,"d":null,"e":1e+25,"x":3}]}`},
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		n, err := d.ExportQueryToJSON(sqlQuery, dbFilePath, &b, tt.opt)
+		if err != nil || n != 2 {
+			t.Fatalf("%s: got %d, %v", tt.name, n, err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("%s: got %s; want %s", tt.name, b.String(), tt.want)
+		}
+		if !tt.opt.NDJSON && !json.Valid(b.Bytes()) {
+			t.Errorf("%s: not valid JSON", tt.name)
+		}
+	}
+
+	// No rows is an empty array.
+	var b bytes.Buffer
+	if _, err := d.ExportQueryToJSON("SELECT * FROM T WHERE a > ?", dbFilePath, &b, JSONOptions{}, 10); err != nil || b.String() != "[]" {
+		t.Errorf("got %q, %v", b.String(), err)
+	}
+}
+
+func TestExportDataTableToJSON(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	tbl, _ := collc.NewCollection().Table.Create("x")
+	tbl.Cols.Add("n")
+	tbl.Cols.Add("f")
+	tbl.Cols.Add("s")
+	tbl.Cols.Add("tm")
+	r := tbl.Rows.New()
+	r["n"], r["f"], r["s"], r["tm"] = int32(5), math.NaN(), "<\u0001>", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var b bytes.Buffer
+	n, err := d.ExportDataTableToJSON(tbl, &b, JSONOptions{Metadata: true})
+	if err != nil || n != 1 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+
+	// NaN is null, the HTML characters are escaped; and the types are
+	// inferred from the values.
+	want := `{"columns":[{"name":"n","type":"INTEGER"},{"name":"f","type":"REAL"},{"name":"s","type":"TEXT"},{"name":"tm","type":"DATETIME"}],` +
+		`"rows":[{"n":5,"f":null,"s":"\u003c\u0001\u003e","tm":"2024-01-02T03:04:05Z"}]}`
+	if b.String() != want {
+		t.Errorf("got %s; want %s", b.String(), want)
+	}
+
+	if got := d.GetDataTableJSON(tbl); got != `[{"n":5,"f":null,"s":"\u003c\u0001\u003e","tm":"2024-01-02T03:04:05Z"}]` {
+		t.Errorf("got %s", got)
+	}
+
+	if _, err = d.ExportDataTableToJSON(nil, &b, JSONOptions{}); !errors.Is(err, ErrTableNameNotFound) {
+		t.Errorf("got %v; want %v", err, ErrTableNameNotFound)
+	}
+
+	r["s"] = failingValuer{}
+	if _, err = d.ExportDataTableToJSON(tbl, &b, JSONOptions{}); !errors.Is(err, errValuer) {
+		t.Errorf("got %v; want %v", err, errValuer)
+	}
+}
@@ -0,0 +1,56 @@
+package sqlitehench
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// testDB returns the path of the db file name, in a temp dir of the
+// test; the schema statements are run in it, and then the insert
+// statement with each of the rows as its args.
+func testDB(t *testing.T, d *DBAccess, name string, schema []string, insert string, rows ...[]interface{}) string {
+
+	t.Helper()
+
+	dbFilePath := filepath.Join(t.TempDir(), name)
+	for i := 0; i < len(schema); i++ {
+		if _, err := d.ExecuteNonQuery(schema[i], dbFilePath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < len(rows); i++ {
+		if _, err := d.ExecuteNonQueryWithArgs(insert, dbFilePath, rows[i]...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dbFilePath
+}
+
+// rowCount returns the number of rows of a table.
+func rowCount(t *testing.T, d *DBAccess, table string, dbFilePath string) int64 {
+
+	t.Helper()
+
+	n, err := d.GetTableCount(table, dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+// equalStrings reports whether a and b hold the same strings, in order.
+func equalStrings(a []string, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,360 @@
+package sqlitehench
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// defaultInferSampleSize is the number of rows that are read to infer
+// the column types, when CreateTableOptions.SampleSize is not set.
+const defaultInferSampleSize = 1000
+
+// CreateNewDatabaseWithOptions creates a table (and the db file, if it
+// does not exist) from the columns of a DataTable; an existing table of
+// the same name is dropped. The column types are inferred from the
+// values of the rows (INTEGER, REAL, TEXT, BLOB, DATETIME), unless set
+// in opt.ColumnTypes or the Column.Type metadata.
+func (d *DBAccess) CreateNewDatabaseWithOptions(ctx context.Context, tbl *collc.Table, dbFilePath string, opt CreateTableOptions) (int64, error) {
+
+	if tbl == nil || tbl.Cols.Count() == 0 {
+		return -1, wrapErr("CreateNewDatabase", dbFilePath, "", fmt.Errorf("malformed DataTable; %w", ErrTableNameNotFound))
+	}
+
+	if fileOrDirExists(dbFilePath) {
+		// drop the target table
+		sqlx := fmt.Sprintf("DROP TABLE IF EXISTS [%s]", tbl.Name)
+		d.ExecuteNonQueryContext(ctx, sqlx, dbFilePath)
+	}
+
+	stmts := d.createTableSQL(tbl, opt)
+
+	// Create the table
+	rowsAffected, err := d.ExecuteNonQueryContext(ctx, stmts[0], dbFilePath)
+	if err != nil {
+		return -1, err
+	}
+
+	// and the indexes
+	for i := 1; i < len(stmts); i++ {
+		if _, err = d.ExecuteNonQueryContext(ctx, stmts[i], dbFilePath); err != nil {
+			return -1, err
+		}
+	}
+
+	return rowsAffected, nil
+}
+
+// ExportDataTableToDatabaseWithOptions is ExportDataTableToDatabase with
+// the options of the CREATE TABLE statement.
+func (d *DBAccess) ExportDataTableToDatabaseWithOptions(ctx context.Context, tbl *collc.Table, dbFilePath string, opt CreateTableOptions) (int64, error) {
+
+	if tbl == nil || tbl.Rows.Count() < 1 {
+		return -1, ErrNoRowsFound
+	}
+
+	if _, err := d.CreateNewDatabaseWithOptions(ctx, tbl, dbFilePath, opt); err != nil {
+		return -1, err
+	}
+
+	return d.InsertDataTableContext(ctx, tbl, dbFilePath, nil)
+}
+
+// createTableSQL returns the CREATE TABLE statement of a DataTable,
+// followed by the CREATE INDEX statements.
+func (d *DBAccess) createTableSQL(tbl *collc.Table, opt CreateTableOptions) []string {
+
+	cols := tbl.Cols.Get()
+	colNames := d.getDeDupedColNames(cols)
+
+	types := make([]string, len(cols))
+	if !opt.NoInference {
+		types = inferColumnTypes(tbl, cols, opt)
+	}
+
+	var defs []string
+	for i := 0; i < len(colNames); i++ {
+		if types[i] == "" {
+			defs = append(defs, fmt.Sprintf("[%s] Blob NULL", colNames[i]))
+			continue
+		}
+		defs = append(defs, fmt.Sprintf("[%s] %s", colNames[i], types[i]))
+	}
+
+	if len(opt.PrimaryKey) > 0 {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", quoteColumnList(opt.PrimaryKey)))
+	}
+
+	stmts := []string{fmt.Sprintf("CREATE TABLE [%s] (%s);", tbl.Name, strings.Join(defs, ", "))}
+
+	for i := 0; i < len(opt.Indexes); i++ {
+		ix := opt.Indexes[i]
+		if len(ix.Columns) == 0 {
+			continue
+		}
+		name := ix.Name
+		if name == "" {
+			name = fmt.Sprintf("ix_%s_%s", tbl.Name, strings.Join(ix.Columns, "_"))
+		}
+		unique := ""
+		if ix.Unique {
+			unique = "UNIQUE "
+		}
+		stmts = append(stmts, fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS [%s] ON [%s] (%s);",
+			unique, name, tbl.Name, quoteColumnList(ix.Columns)))
+	}
+
+	return stmts
+}
+
+func quoteColumnList(cols []string) string {
+
+	q := make([]string, len(cols))
+	for i := 0; i < len(cols); i++ {
+		q[i] = fmt.Sprintf("[%s]", strings.Trim(cols[i], "[]"))
+	}
+
+	return strings.Join(q, ", ")
+}
+
+// The storage classes that are seen in the values of a column.
+const (
+	seenInteger = 1 << iota
+	seenReal
+	seenText
+	seenBlob
+	seenTime
+	seenNull
+)
+
+// inferColumnTypes returns the declared type of each column (with NOT
+// NULL where the data permits); an empty string where it cannot be
+// inferred (i.e. no values, or mixed text and numbers).
+func inferColumnTypes(tbl *collc.Table, cols []collc.Column, opt CreateTableOptions) []string {
+
+	types := make([]string, len(cols))
+
+	rows := tbl.Rows.GetRows()
+
+	sampleSize := opt.SampleSize
+	if sampleSize == 0 {
+		sampleSize = defaultInferSampleSize
+	}
+	if sampleSize < 0 || sampleSize > len(rows) {
+		sampleSize = len(rows)
+	}
+
+	// NOT NULL can only be told from all rows.
+	allRows := sampleSize == len(rows) && len(rows) > 0
+
+	for i := 0; i < len(cols); i++ {
+
+		if t, ok := opt.ColumnTypes[cols[i].Name]; ok {
+			types[i] = t
+			continue
+		}
+
+		seen := 0
+		for k := 0; k < sampleSize; k++ {
+			seen |= storageClassOf(rows[k][cols[i].Name])
+		}
+
+		t := declaredTypeFromMeta(cols[i].Type)
+		if t == "" {
+			t = declaredTypeFromValues(seen)
+		}
+		if t == "" {
+			continue
+		}
+
+		if allRows && seen&seenNull == 0 {
+			t += " NOT NULL"
+		}
+		types[i] = t
+	}
+
+	return types
+}
+
+// declaredTypeFromValues returns the declared type of the storage
+// classes that a column holds.
+func declaredTypeFromValues(seen int) string {
+
+	switch seen &^ seenNull {
+	case seenInteger:
+		return "INTEGER"
+	case seenReal, seenInteger | seenReal:
+		return "REAL"
+	case seenText:
+		return "TEXT"
+	case seenBlob:
+		return "BLOB"
+	case seenTime:
+		// NUMERIC affinity; the driver reads DATETIME columns as time.Time.
+		return "DATETIME"
+	}
+
+	return ""
+}
+
+// declaredTypeFromMeta returns the declared type of a Column.Type; i.e.
+// "int64", reflect.TypeOf(time.Time{}), or an SQL type name. The Go types
+// are matched exactly; only the SQL type names are matched by the affinity
+// rules of SQLite (i.e. a name that contains "INT").
+func declaredTypeFromMeta(t interface{}) string {
+
+	switch x := t.(type) {
+	case nil:
+		return ""
+	case reflect.Type:
+		return declaredTypeOfGoType(x)
+	case reflect.Kind:
+		return declaredTypeOfKind(x)
+	}
+
+	s := strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", t)))
+
+	if typ, ok := goTypeNames[strings.TrimLeft(s, "*")]; ok {
+		return typ
+	}
+	if isGoTypeName(s) {
+		return ""
+	}
+
+	return declaredTypeOfSQLName(s)
+}
+
+// goTypeNames are the Go type names that have a declared type.
+var goTypeNames = map[string]string{
+	"bool":   "INTEGER",
+	"int":    "INTEGER",
+	"int8":   "INTEGER",
+	"int16":  "INTEGER",
+	"int32":  "INTEGER",
+	"int64":  "INTEGER",
+	"uint":   "INTEGER",
+	"uint8":  "INTEGER",
+	"uint16": "INTEGER",
+	"uint32": "INTEGER",
+	"uint64": "INTEGER",
+
+	"float32": "REAL",
+	"float64": "REAL",
+	"string":  "TEXT",
+	"[]byte":  "BLOB",
+	"[]uint8": "BLOB",
+
+	"time.time":     "DATETIME",
+	"time.duration": "INTEGER",
+}
+
+// isGoTypeName reports whether s is the name of a Go type (that is not in
+// goTypeNames); i.e. interface {}, map[string]interface {}, []string.
+func isGoTypeName(s string) bool {
+
+	for _, prefix := range []string{"interface", "map[", "[", "*", "struct", "func", "chan"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+
+	// A package-qualified type; i.e. sql.nullstring.
+	return strings.Contains(s, ".") && !strings.Contains(s, "(")
+}
+
+func declaredTypeOfGoType(rt reflect.Type) string {
+
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+
+	switch {
+	case rt == reflect.TypeOf(time.Time{}):
+		return "DATETIME"
+	case rt.Kind() == reflect.Slice && rt.Elem().Kind() == reflect.Uint8:
+		return "BLOB"
+	}
+
+	return declaredTypeOfKind(rt.Kind())
+}
+
+func declaredTypeOfKind(k reflect.Kind) string {
+
+	switch k {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER"
+	case reflect.Float32, reflect.Float64:
+		return "REAL"
+	case reflect.String:
+		return "TEXT"
+	}
+
+	return ""
+}
+
+// declaredTypeOfSQLName returns the declared type of an SQL type name; in
+// the order of the affinity rules (https://sqlite.org/datatype3.html);
+// followed by BOOLEAN, DATE and TIME, which have no affinity of their own.
+func declaredTypeOfSQLName(s string) string {
+
+	switch {
+	case s == "" || s == "<nil>":
+		return ""
+	case strings.Contains(s, "int"):
+		return "INTEGER"
+	case strings.Contains(s, "char") || strings.Contains(s, "clob") || strings.Contains(s, "text"):
+		return "TEXT"
+	case strings.Contains(s, "blob") || s == "bytes":
+		return "BLOB"
+	case strings.Contains(s, "real") || strings.Contains(s, "floa") || strings.Contains(s, "doub"):
+		return "REAL"
+	case strings.Contains(s, "bool"):
+		return "INTEGER"
+	case strings.Contains(s, "time") || strings.Contains(s, "date"):
+		return "DATETIME"
+	case strings.Contains(s, "decimal") || strings.Contains(s, "numeric") || strings.Contains(s, "number"):
+		return "NUMERIC"
+	}
+
+	return ""
+}
+
+// storageClassOf returns the storage class that a value is written as.
+func storageClassOf(v interface{}) int {
+
+	switch x := v.(type) {
+	case nil:
+		return seenNull
+	case time.Time:
+		return seenTime
+	case *time.Time:
+		if x == nil {
+			return seenNull
+		}
+		return seenTime
+	case []byte:
+		return seenBlob
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return seenNull
+		}
+		return storageClassOf(rv.Elem().Interface())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Bool:
+		return seenInteger
+	case reflect.Float32, reflect.Float64:
+		return seenReal
+	}
+
+	return seenText
+}
@@ -0,0 +1,186 @@
+package sqlitehench
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// inferTable returns a DataTable of typed values; the column n has a
+// NULL in the second row, and m holds text and numbers.
+func inferTable() *collc.Table {
+
+	tbl, _ := collc.NewCollection().Table.Create("inf")
+	for _, c := range []string{"i", "f", "s", "b", "at", "n", "m"} {
+		tbl.Cols.Add(c)
+	}
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	r := tbl.Rows.New()
+	r["i"], r["f"], r["s"], r["b"], r["at"], r["n"], r["m"] = int64(1), 1, "a", []byte{1}, at, int32(7), "x"
+
+	r = tbl.Rows.New()
+	r["i"], r["f"], r["s"], r["b"], r["at"], r["n"], r["m"] = int64(2), 2.5, "b", []byte{2}, &at, nil, 3
+
+	return tbl
+}
+
+func TestCreateNewDatabaseWithOptions(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "infer.sqlite")
+
+	opt := CreateTableOptions{
+		ColumnTypes: map[string]string{"s": "VARCHAR(10)"},
+		PrimaryKey:  []string{"i"},
+		Indexes:     []IndexSpec{{Columns: []string{"s"}, Unique: true}, {Name: "ix_f", Columns: []string{"f", "at"}}},
+	}
+
+	// Twice; the table is dropped and created again.
+	for i := 0; i < 2; i++ {
+		n, err := d.ExportDataTableToDatabaseWithOptions(context.Background(), inferTable(), dbFilePath, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("inserted %d rows; want 2", n)
+		}
+	}
+
+	type column struct {
+		Name    string `db:"name"`
+		Type    string `db:"type"`
+		NotNull bool   `db:"notnull"`
+		PK      int    `db:"pk"`
+	}
+	cols, err := Query[column](d, "SELECT name, type, \"notnull\", pk FROM pragma_table_info('inf')", dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]struct {
+		typ     string
+		notNull bool
+	}{
+		"i":  {"INTEGER", true},
+		"f":  {"REAL", true},
+		"s":  {"VARCHAR(10)", false},
+		"b":  {"BLOB", true},
+		"at": {"DATETIME", true},
+		"n":  {"INTEGER", false},
+		"m":  {"BLOB", false},
+	}
+	var pk []string
+	for _, c := range cols {
+		w := want[c.Name]
+		if c.Type != w.typ || c.NotNull != w.notNull {
+			t.Errorf("%s: got %s, not null %v; want %s, %v", c.Name, c.Type, c.NotNull, w.typ, w.notNull)
+		}
+		if c.PK > 0 {
+			pk = append(pk, c.Name)
+		}
+	}
+	if !equalStrings(pk, []string{"i"}) {
+		t.Errorf("got the primary key %v", pk)
+	}
+
+	ixNames, err := Query[string](d, "SELECT name FROM pragma_index_list('inf') WHERE origin = 'c'", dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(ixNames)
+	if !equalStrings(ixNames, []string{"ix_f", "ix_inf_s"}) {
+		t.Errorf("got the indexes %v", ixNames)
+	}
+
+	if at, err := ScalarAs[time.Time](d, "SELECT at FROM inf WHERE i = 2", dbFilePath); err != nil || at.Year() != 2024 {
+		t.Errorf("got %v, %v", at, err)
+	}
+
+	if _, err = d.CreateNewDatabaseWithOptions(context.Background(), nil, dbFilePath, opt); !errors.Is(err, ErrTableNameNotFound) {
+		t.Errorf("got %v; want %v", err, ErrTableNameNotFound)
+	}
+	if _, err = d.ExportDataTableToDatabaseWithOptions(context.Background(), bulkTable(0), dbFilePath, opt); !errors.Is(err, ErrNoRowsFound) {
+		t.Errorf("got %v; want %v", err, ErrNoRowsFound)
+	}
+}
+
+func TestInferColumnTypes(t *testing.T) {
+
+	tbl := inferTable()
+	cols := tbl.Cols.Get()
+
+	tests := []struct {
+		name string
+		opt  CreateTableOptions
+		want []string
+	}{
+		{"all rows", CreateTableOptions{},
+			[]string{"INTEGER NOT NULL", "REAL NOT NULL", "TEXT NOT NULL", "BLOB NOT NULL", "DATETIME NOT NULL", "INTEGER", ""}},
+		// NOT NULL is not declared from a sample.
+		{"sample", CreateTableOptions{SampleSize: 1},
+			[]string{"INTEGER", "INTEGER", "TEXT", "BLOB", "DATETIME", "INTEGER", "TEXT"}},
+		{"column types", CreateTableOptions{ColumnTypes: map[string]string{"m": "NUMERIC", "i": "INT"}},
+			[]string{"INT", "REAL NOT NULL", "TEXT NOT NULL", "BLOB NOT NULL", "DATETIME NOT NULL", "INTEGER", "NUMERIC"}},
+	}
+
+	for _, tt := range tests {
+		if got := inferColumnTypes(tbl, cols, tt.opt); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q; want %q", tt.name, got, tt.want)
+		}
+	}
+
+	stmts := (&DBAccess{}).createTableSQL(tbl, CreateTableOptions{NoInference: true})
+	if len(stmts) != 1 || stmts[0] != "CREATE TABLE [inf] ([i] Blob NULL, [f] Blob NULL, [s] Blob NULL, [b] Blob NULL, [at] Blob NULL, [n] Blob NULL, [m] Blob NULL);" {
+		t.Errorf("got %q", stmts)
+	}
+}
+
+func TestDeclaredTypeFromMeta(t *testing.T) {
+
+	var e interface{}
+
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, ""},
+		{"", ""},
+		{"int64", "INTEGER"},
+		{"*int64", "INTEGER"},
+		{"time.Time", "DATETIME"},
+		{"time.Duration", "INTEGER"},
+		{"interface {}", ""},
+		{"map[string]interface {}", ""},
+		{"[]string", ""},
+		{"sql.NullString", ""},
+		{"VARCHAR(20)", "TEXT"},
+		{"bigint", "INTEGER"},
+		{"double precision", "REAL"},
+		{"DECIMAL(10,2)", "NUMERIC"},
+		{"BOOLEAN", "INTEGER"},
+		{"timestamp", "DATETIME"},
+		{reflect.TypeOf(time.Time{}), "DATETIME"},
+		{reflect.TypeOf(&time.Time{}), "DATETIME"},
+		{reflect.TypeOf(time.Duration(0)), "INTEGER"},
+		{reflect.TypeOf([]byte{}), "BLOB"},
+		{reflect.TypeOf(map[string]int{}), ""},
+		{reflect.TypeOf(&e).Elem(), ""},
+		{reflect.String, "TEXT"},
+	}
+
+	for _, tt := range tests {
+		if got := declaredTypeFromMeta(tt.in); got != tt.want {
+			t.Errorf("declaredTypeFromMeta(%v) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package sqlitehench
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 func NewDBAccess(d DBAccess) *DBAccess {
@@ -44,8 +45,26 @@ func NewDBAccess(d DBAccess) *DBAccess {
 		d.driverName = "sqlite3"
 	}
 
+	if d.ConnIdleTimeout <= 0 {
+		d.ConnIdleTimeout = 2 * time.Minute
+	}
+	d.pool = newConnPool(d.ConnIdleTimeout)
+	d.sessions = &encryptedSessions{m: make(map[string]*EncryptedDB)}
+
+	if d.crypter == nil {
+		d.crypter = newColumnCrypter(d.ColumnEncryption)
+	}
+
 	d.PRAGMA = fixPragmaTextAndOrder(d.PRAGMA)
 
+	// The PRAGMA are run on every connection that is opened; the busy
+	// timeout first, so that the others wait for a lock.
+	var connPragma []string
+	if d.RetryPolicy.BusyTimeout > 0 {
+		connPragma = append(connPragma, fmt.Sprintf("pragma busy_timeout = %d;", d.RetryPolicy.BusyTimeout.Milliseconds()))
+	}
+	d.pragmaDriver = connDriver(append(connPragma, d.PRAGMA...))
+
 	// Shrik databases?
 	for i := 0; i < len(d.PRAGMA); i++ {
 		if !strings.Contains(strings.ToUpper(d.PRAGMA[i]), strings.ToUpper("PRAGMA auto_vacuum")) {
@@ -0,0 +1,516 @@
+package sqlitehench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	collc "github.com/kambahr/go-collections"
+)
+
+// ImportJSON imports a JSON array of objects, or NDJSON (one object per
+// line), into a table; the records are read as a stream, and written in
+// batches through the insert path of InsertDataTable and BulkInsert.
+// The table is created from the members of the first records (with the
+// types inferred from their values), if it does not exist; otherwise
+// the rows are appended to it, unless opt.Replace is set. A record with
+// a member that is not a column of the table (i.e. one first seen after
+// the sample) is a bad record (see OnError); rather than its value being
+// dropped. It returns the number of rows inserted.
+func (d *DBAccess) ImportJSON(r io.Reader, dbFilePath string, opt JSONImportOptions) (int64, error) {
+	return d.ImportJSONContext(context.Background(), r, dbFilePath, opt)
+}
+
+// ImportJSONContext is ImportJSON with a ctx.
+func (d *DBAccess) ImportJSONContext(ctx context.Context, r io.Reader, dbFilePath string, opt JSONImportOptions) (_ int64, err error) {
+
+	ctx, done := d.startRetryScope(ctx, "ImportJSON", dbFilePath)
+	defer func() {
+		err = wrapErr("ImportJSON", dbFilePath, "", err)
+		done(err)
+	}()
+
+	opt.Table = strings.Trim(strings.TrimSpace(opt.Table), "[]")
+	if opt.Table == "" {
+		return -1, ErrTableNameNotFound
+	}
+
+	if opt.Separator == "" {
+		opt.Separator = "."
+	}
+
+	jr, err := newJSONRecordReader(r)
+	if err != nil {
+		return -1, err
+	}
+
+	imp := &jsonImport{opt: opt, dbFilePath: dbFilePath, names: make(map[string]string)}
+
+	sampleSize := opt.SampleSize
+	if sampleSize < 1 {
+		sampleSize = defaultInferSampleSize
+	}
+
+	var sample []jsonRecord
+	eof := false
+	for len(sample) < sampleSize {
+		rec, err := jr.next()
+		if err == io.EOF {
+			eof = true
+			break
+		}
+		if err != nil {
+			return -1, err
+		}
+		rec.row, rec.err = imp.parse(rec.raw)
+		sample = append(sample, rec)
+	}
+
+	exists := false
+	if fileOrDirExists(dbFilePath) {
+		_, err := d.GetTableInfoContext(ctx, dbFilePath, opt.Table)
+		if err != nil && !errors.Is(err, ErrTableNotFound) {
+			return -1, err
+		}
+		exists = err == nil
+	}
+
+	if !exists || opt.Replace {
+		if len(imp.cols) == 0 {
+			return -1, fmt.Errorf("the JSON has no records; %w", ErrNoRowsFound)
+		}
+		if err = imp.createTable(ctx, d, dbFilePath, sample, eof, exists); err != nil {
+			return -1, err
+		}
+	}
+
+	destInfo, err := d.GetTableInfoContext(ctx, dbFilePath, opt.Table)
+	if err != nil {
+		return -1, err
+	}
+
+	db, release, err := d.acquireDB(dbFilePath, true)
+	if err != nil {
+		return -1, err
+	}
+	defer release()
+
+	batchSize := opt.BatchSize
+	if batchSize < 1 {
+		batchSize = defaultBulkBatchSize
+	}
+
+	var batch []jsonRecord
+	tstart := time.Now()
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := imp.insert(ctx, d, db, destInfo, batch); err != nil {
+			return err
+		}
+		batch = batch[:0]
+
+		if opt.Notify != nil {
+			opt.Notify(fmt.Sprintf("rows imported => %s: %s, rejected: %s, elapsed: %v", opt.Table,
+				formatNumber(imp.inserted), formatNumber(imp.rejected), durationToString(time.Since(tstart))))
+		}
+		return nil
+	}
+
+	add := func(rec jsonRecord) error {
+		if rec.err == nil {
+			rec.err = imp.checkColumns(destInfo, rec.row)
+		}
+		if rec.err != nil {
+			return imp.reject(rec, rec.err)
+		}
+		batch = append(batch, rec)
+		if len(batch) >= batchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	for i := 0; i < len(sample); i++ {
+		if err = add(sample[i]); err != nil {
+			return imp.inserted, err
+		}
+	}
+	sample = nil
+
+	for !eof {
+		if err = ctx.Err(); err != nil {
+			return imp.inserted, err
+		}
+		rec, err := jr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imp.inserted, err
+		}
+		rec.row, rec.err = imp.parse(rec.raw)
+		if err = add(rec); err != nil {
+			return imp.inserted, err
+		}
+	}
+
+	if err = flush(); err != nil {
+		return imp.inserted, err
+	}
+
+	return imp.inserted, nil
+}
+
+// jsonImport holds the state of an import.
+type jsonImport struct {
+	opt        JSONImportOptions
+	dbFilePath string
+
+	// cols are the column names in the order they were seen; names
+	// maps their lower case to them, as SQLite column names are not
+	// case sensitive.
+	cols  []string
+	names map[string]string
+
+	inserted int64
+	rejected int64
+}
+
+// jsonRecord is a record of the JSON; row holds its column values, or
+// err is set when it cannot be read.
+type jsonRecord struct {
+	n   int64
+	raw []byte
+	row map[string]interface{}
+	err error
+}
+
+// createTable (re-)creates the table from the columns and values of the
+// sample; NOT NULL is only declared when the sample holds all records.
+func (imp *jsonImport) createTable(ctx context.Context, d *DBAccess, dbFilePath string, sample []jsonRecord, eof bool, replace bool) error {
+
+	tbl := imp.newTable(sample)
+	cols := tbl.Cols.Get()
+
+	types := inferColumnTypes(tbl, cols, CreateTableOptions{SampleSize: -1, ColumnTypes: imp.opt.ColumnTypes})
+
+	colTypes := make(map[string]string, len(cols))
+	for i := 0; i < len(cols); i++ {
+		t, ok := imp.opt.ColumnTypes[cols[i].Name]
+		if !ok {
+			t = types[i]
+			if !eof {
+				t = strings.TrimSuffix(t, " NOT NULL")
+			}
+		}
+		if t != "" {
+			colTypes[cols[i].Name] = t
+		}
+	}
+
+	stmts := d.createTableSQL(tbl, CreateTableOptions{ColumnTypes: colTypes, PrimaryKey: imp.opt.PrimaryKey})
+
+	return d.createImportTable(ctx, dbFilePath, imp.opt.Table, stmts, replace)
+}
+
+// newTable returns a DataTable of records; with the columns seen so far.
+func (imp *jsonImport) newTable(recs []jsonRecord) *collc.Table {
+
+	tbl, _ := collc.NewCollection().Table.Create(imp.opt.Table)
+
+	for i := 0; i < len(imp.cols); i++ {
+		tbl.Cols.Add(imp.cols[i])
+	}
+
+	for i := 0; i < len(recs); i++ {
+		if recs[i].err != nil {
+			continue
+		}
+		row := tbl.Rows.New()
+		for k, v := range recs[i].row {
+			row[k] = v
+		}
+	}
+
+	return tbl
+}
+
+// insert writes a batch of records in one transaction. When it fails on
+// a constraint (or type), and there is an OnError func, the records are
+// written one at a time; so that the bad records are told.
+func (imp *jsonImport) insert(ctx context.Context, d *DBAccess, db *sql.DB, destInfo *TableInfo, batch []jsonRecord) error {
+
+	write := func(recs []jsonRecord) (int64, error) {
+		if !imp.hasTableColumns(destInfo) {
+			return 0, nil
+		}
+		// writeTableRows retries each batch on its own.
+		return d.writeTableRows(ctx, db, imp.newTable(recs), BulkInsertOptions{BatchSize: len(recs)})
+	}
+
+	n, err := write(batch)
+	if err == nil {
+		imp.inserted += n
+		return nil
+	}
+	if !isRecordErr(err) || imp.opt.OnError == nil {
+		return err
+	}
+
+	for i := 0; i < len(batch); i++ {
+		n, err := write(batch[i : i+1])
+		if err != nil {
+			if !isRecordErr(err) {
+				return err
+			}
+			// an *Error; so that OnError can tell it by errors.Is
+			if err = imp.reject(batch[i], wrapErr("ImportJSON", imp.dbFilePath, "", err)); err != nil {
+				return err
+			}
+			continue
+		}
+		imp.inserted += n
+	}
+
+	return nil
+}
+
+// hasTableColumns reports whether any column seen is in the table.
+func (imp *jsonImport) hasTableColumns(destInfo *TableInfo) bool {
+
+	for i := 0; i < len(imp.cols); i++ {
+		if destInfo.Column(imp.cols[i]) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkColumns returns an error, if a member of a record is not a column
+// of the table; so that its value is not dropped.
+func (imp *jsonImport) checkColumns(destInfo *TableInfo, row map[string]interface{}) error {
+
+	for i := 0; i < len(imp.cols); i++ {
+		if _, ok := row[imp.cols[i]]; ok && destInfo.Column(imp.cols[i]) == nil {
+			return fmt.Errorf("%s is not a column of %s", imp.cols[i], imp.opt.Table)
+		}
+	}
+
+	return nil
+}
+
+// reject passes a record to OnError; or returns the error, if there is
+// no OnError.
+func (imp *jsonImport) reject(rec jsonRecord, err error) error {
+
+	imp.rejected++
+
+	if imp.opt.OnError == nil {
+		return fmt.Errorf("record %d: %w", rec.n, err)
+	}
+
+	return imp.opt.OnError(rec.n, rec.raw, err)
+}
+
+// isRecordErr reports whether an insert failed on the values of a
+// record; rather than on the database.
+func isRecordErr(err error) bool {
+	code, _ := resultCodes(err)
+	return code == sqliteConstraint || code == sqliteMismatch || code == sqliteTooBig
+}
+
+// parse returns the column values of a record; new members are added to
+// the columns.
+func (imp *jsonImport) parse(raw []byte) (map[string]interface{}, error) {
+
+	row := make(map[string]interface{})
+
+	if err := imp.parseObject(raw, "", row); err != nil {
+		return nil, err
+	}
+
+	return row, nil
+}
+
+func (imp *jsonImport) parseObject(raw []byte, prefix string, row map[string]interface{}) error {
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		return errors.New("the record is not an object")
+	}
+
+	for dec.More() {
+
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+
+		var v json.RawMessage
+		if err = dec.Decode(&v); err != nil {
+			return err
+		}
+
+		name := prefix + key
+
+		if v[0] == '{' && imp.opt.Flatten {
+			if err = imp.parseObject(v, name+imp.opt.Separator, row); err != nil {
+				return err
+			}
+			continue
+		}
+
+		val, err := jsonValue(v)
+		if err != nil {
+			return err
+		}
+
+		row[imp.column(name)] = val
+	}
+
+	return nil
+}
+
+// column returns the column name of a member; as it was first seen.
+func (imp *jsonImport) column(name string) string {
+
+	if c, ok := imp.names[strings.ToLower(name)]; ok {
+		return c
+	}
+
+	imp.names[strings.ToLower(name)] = name
+	imp.cols = append(imp.cols, name)
+
+	return name
+}
+
+// jsonValue returns the column value of a JSON value; objects and arrays
+// are returned as (compact) JSON text.
+func jsonValue(v json.RawMessage) (interface{}, error) {
+
+	switch v[0] {
+	case 'n':
+		return nil, nil
+	case 't':
+		return true, nil
+	case 'f':
+		return false, nil
+	case '"':
+		var s string
+		err := json.Unmarshal(v, &s)
+		return s, err
+	case '{', '[':
+		var b bytes.Buffer
+		err := json.Compact(&b, v)
+		return b.String(), err
+	}
+
+	s := string(v)
+	if !strings.ContainsAny(s, ".eE") {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, nil
+		}
+	}
+
+	return strconv.ParseFloat(s, 64)
+}
+
+// jsonRecordReader reads the records of a JSON array, or of NDJSON.
+type jsonRecordReader struct {
+	br  *bufio.Reader
+	dec *json.Decoder
+
+	// n is the number of records (or lines of NDJSON) read.
+	n int64
+}
+
+// newJSONRecordReader returns a reader of the records; a JSON array is
+// told by its first character.
+func newJSONRecordReader(r io.Reader) (*jsonRecordReader, error) {
+
+	jr := &jsonRecordReader{br: bufio.NewReaderSize(r, 64*1024)}
+
+	if bom, _ := jr.br.Peek(3); bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		jr.br.Discard(3)
+	}
+
+	for {
+		b, err := jr.br.Peek(1)
+		if err == io.EOF {
+			return jr, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == '[' {
+			break
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			// NDJSON
+			return jr, nil
+		}
+		jr.br.Discard(1)
+	}
+
+	jr.dec = json.NewDecoder(jr.br)
+	if _, err := jr.dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return jr, nil
+}
+
+// next returns the next record; the error is io.EOF at the end, or an
+// error that stops the reading (i.e. malformed JSON in an array).
+func (jr *jsonRecordReader) next() (jsonRecord, error) {
+
+	if jr.dec != nil {
+		if !jr.dec.More() {
+			if _, err := jr.dec.Token(); err != nil && err != io.EOF {
+				return jsonRecord{}, fmt.Errorf("record %d: %w", jr.n+1, err)
+			}
+			return jsonRecord{}, io.EOF
+		}
+		jr.n++
+		var raw json.RawMessage
+		if err := jr.dec.Decode(&raw); err != nil {
+			return jsonRecord{}, fmt.Errorf("record %d: %w", jr.n, err)
+		}
+		return jsonRecord{n: jr.n, raw: raw}, nil
+	}
+
+	for {
+		line, err := jr.br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return jsonRecord{}, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err == io.EOF {
+				return jsonRecord{}, io.EOF
+			}
+			jr.n++
+			continue
+		}
+		jr.n++
+		return jsonRecord{n: jr.n, raw: line}, nil
+	}
+}
@@ -0,0 +1,158 @@
+package sqlitehench
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rejectedRecord is a record that ImportJSON passed to OnError.
+type rejectedRecord struct {
+	n   int64
+	raw string
+	err error
+}
+
+func TestImportJSON(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "json.sqlite")
+
+	// A BOM; a member that differs in case only; a record that is not
+	// an object, and one that fails the primary key.
+	in := "\xEF\xBB\xBF" + ` [ {"id": 1, "name": "a \"q\"", "price": 1.5, "ok": true, "addr": {"city": "X", "geo": {"lat": 1}}, "tags": ["a", "b"]},
+		{"ID": 2, "name": null, "price": 2, "addr": {"city": "Y"}, "extra": "later"},
+		5,
+		{"id": 1, "name": "dup"} ]`
+
+	var rejected []rejectedRecord
+	n, err := d.ImportJSON(strings.NewReader(in), dbFilePath, JSONImportOptions{
+		Table:      "A",
+		PrimaryKey: []string{"id"},
+		SampleSize: 2,
+		OnError: func(record int64, raw []byte, err error) error {
+			rejected = append(rejected, rejectedRecord{record, string(raw), err})
+			return nil
+		},
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+
+	if len(rejected) != 2 || rejected[0].n != 3 || rejected[0].raw != "5" ||
+		rejected[1].n != 4 || !errors.Is(rejected[1].err, ErrConstraint) {
+		t.Errorf("got the rejected records %+v", rejected)
+	}
+
+	ti, err := d.GetTableInfo(dbFilePath, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "CREATE TABLE [A] ([id] INTEGER, [name] TEXT, [price] REAL, [ok] INTEGER, [addr] TEXT, [tags] TEXT, [extra] TEXT, PRIMARY KEY ([id]))"; ti.SQL != want {
+		t.Errorf("got %s; want %s", ti.SQL, want)
+	}
+
+	// The nested objects and arrays are JSON text.
+	got, err := Query[string](d, `SELECT name || '|' || json_extract(addr, '$.geo.lat') || '|' || json_extract(tags, '$[1]') FROM A WHERE id = 1`, dbFilePath)
+	if err != nil || !equalStrings(got, []string{`a "q"|1|b`}) {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if extra, err := ScalarAs[string](d, "SELECT extra FROM A WHERE id = 2", dbFilePath); err != nil || extra != "later" {
+		t.Errorf("got %q, %v", extra, err)
+	}
+}
+
+func TestImportNDJSON(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "json.sqlite")
+
+	in := "{\"id\":1,\"a\":{\"b\":{\"c\":3}},\"s\":\"x\"}\n\nnot json\n{\"id\":2,\"a\":{\"b\":{\"c\":4.5}}}\n{\"id\":3}"
+
+	var rejected []rejectedRecord
+	onError := func(record int64, raw []byte, err error) error {
+		rejected = append(rejected, rejectedRecord{record, string(raw), err})
+		return nil
+	}
+
+	n, err := d.ImportJSON(strings.NewReader(in), dbFilePath, JSONImportOptions{Table: "B", Flatten: true, OnError: onError})
+	if err != nil || n != 3 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+
+	// The record is the line number.
+	if len(rejected) != 1 || rejected[0].n != 3 || rejected[0].raw != "not json" {
+		t.Errorf("got the rejected records %+v", rejected)
+	}
+
+	ti, err := d.GetTableInfo(dbFilePath, "B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "CREATE TABLE [B] ([id] INTEGER NOT NULL, [a.b.c] REAL, [s] TEXT)"; ti.SQL != want {
+		t.Errorf("got %s; want %s", ti.SQL, want)
+	}
+
+	// The rows are added to the existing table; without OnError the
+	// import stops at the bad record.
+	n, err = d.ImportJSON(strings.NewReader(in), dbFilePath, JSONImportOptions{Table: "B", Flatten: true})
+	if err == nil || !strings.Contains(err.Error(), "record 3") || n != 0 {
+		t.Errorf("got %d, %v", n, err)
+	}
+	n, err = d.ImportJSON(strings.NewReader(in), dbFilePath, JSONImportOptions{Table: "B", Flatten: true, OnError: onError})
+	if err != nil || n != 3 {
+		t.Errorf("got %d, %v", n, err)
+	}
+	if c := rowCount(t, d, "B", dbFilePath); c != 6 {
+		t.Errorf("the table has %d rows; want 6", c)
+	}
+
+	// Replace drops the table and creates it again.
+	n, err = d.ImportJSON(strings.NewReader(in), dbFilePath, JSONImportOptions{Table: "B", Flatten: true, Replace: true, OnError: onError})
+	if err != nil || n != 3 {
+		t.Errorf("got %d, %v", n, err)
+	}
+	if c := rowCount(t, d, "B", dbFilePath); c != 3 {
+		t.Errorf("the table has %d rows; want 3", c)
+	}
+
+	// A member that is not a column (i.e. of a nested object first
+	// seen after the sample) is a bad record; its value is not
+	// dropped.
+	in = "{\"id\":4,\"s\":\"y\"}\n{\"id\":5,\"new\":1}\n{\"id\":6,\"a\":{\"x\":1}}"
+	n, err = d.ImportJSON(strings.NewReader(in), dbFilePath, JSONImportOptions{Table: "B", Flatten: true})
+	if err == nil || !strings.Contains(err.Error(), "new is not a column") || n != 0 {
+		t.Errorf("got %d, %v", n, err)
+	}
+	rejected = nil
+	n, err = d.ImportJSON(strings.NewReader(in), dbFilePath, JSONImportOptions{Table: "B", Flatten: true, OnError: onError})
+	if err != nil || n != 1 {
+		t.Errorf("got %d, %v", n, err)
+	}
+	if len(rejected) != 2 || rejected[0].n != 2 || rejected[1].n != 3 || !strings.Contains(rejected[1].err.Error(), "a.x is not a column") {
+		t.Errorf("got the rejected records %+v", rejected)
+	}
+}
+
+func TestImportJSONErrors(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "json.sqlite")
+
+	if _, err := d.ImportJSON(strings.NewReader(`[{"a":1}, {"a":2`), dbFilePath, JSONImportOptions{Table: "C"}); err == nil || !strings.Contains(err.Error(), "record 2") {
+		t.Errorf("got %v", err)
+	}
+	if _, err := d.ImportJSON(strings.NewReader(`[]`), dbFilePath, JSONImportOptions{Table: "C"}); !errors.Is(err, ErrNoRowsFound) {
+		t.Errorf("got %v; want %v", err, ErrNoRowsFound)
+	}
+	if _, err := d.ImportJSON(strings.NewReader(`[{"a":1}]`), dbFilePath, JSONImportOptions{}); !errors.Is(err, ErrTableNameNotFound) {
+		t.Errorf("got %v; want %v", err, ErrTableNameNotFound)
+	}
+}
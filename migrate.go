@@ -0,0 +1,632 @@
+package sqlitehench
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStaleLockAfter is the age of a stale migration lock file, when
+// MigratorOptions.StaleLockAfter is not set.
+const defaultStaleLockAfter = 10 * time.Minute
+
+// Migration is one versioned schema change. Up (or UpSQL) applies it,
+// and Down (or DownSQL) reverts it; the funcs are called with the
+// transaction of the migration.
+type Migration struct {
+	// Version orders the migrations; it must be greater than zero
+	// and unique.
+	Version int64
+	Name    string
+
+	UpSQL   string
+	DownSQL string
+
+	Up   func(ctx context.Context, tx *sql.Tx) error
+	Down func(ctx context.Context, tx *sql.Tx) error
+}
+
+// MigrationStatus is the state of a migration in a database.
+type MigrationStatus struct {
+	Version int64
+	Name    string
+	Applied bool
+
+	// AppliedAt is set when the versions are recorded in a table
+	// (see MigratorOptions.Table).
+	AppliedAt time.Time
+
+	// Unknown is set for a version that is recorded in the database,
+	// but is not one of the migrations of the Migrator.
+	Unknown bool
+}
+
+// Migrator applies and reverts migrations in order; each in its own
+// transaction, together with the record of its version. Only one
+// Migrator can run on a db file at a time; the others fail with
+// ErrMigrationLocked.
+//
+//	m, err := d.NewMigrator([]sqlitehench.Migration{
+//		{Version: 1, Name: "customer", UpSQL: "CREATE TABLE Customer (...)", DownSQL: "DROP TABLE Customer"},
+//	}, sqlitehench.MigratorOptions{})
+//	...
+//	applied, err := m.Up(ctx, dbFilePath)
+type Migrator struct {
+	d          *DBAccess
+	migrations []Migration
+	opt        MigratorOptions
+}
+
+// NewMigrator returns a Migrator for a set of migrations.
+func (d *DBAccess) NewMigrator(migrations []Migration, opt MigratorOptions) (*Migrator, error) {
+
+	m := &Migrator{
+		d:          d,
+		migrations: append([]Migration(nil), migrations...),
+		opt:        opt,
+	}
+
+	if m.opt.StaleLockAfter == 0 {
+		m.opt.StaleLockAfter = defaultStaleLockAfter
+	}
+
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+
+	for i := 0; i < len(m.migrations); i++ {
+		if m.migrations[i].Version < 1 {
+			return nil, fmt.Errorf("migration %q: version must be greater than zero", m.migrations[i].Name)
+		}
+		if i > 0 && m.migrations[i].Version == m.migrations[i-1].Version {
+			return nil, fmt.Errorf("migration version %d is not unique", m.migrations[i].Version)
+		}
+	}
+
+	return m, nil
+}
+
+// MigrationsFromFS reads the .sql files of a directory as migrations;
+// the files are named <version>_<name>.up.sql and <version>_<name>.down.sql
+// (i.e. 0001_create_customer.up.sql). The down file is optional.
+//
+//	//go:embed migrations/*.sql
+//	var migrationFiles embed.FS
+//	...
+//	migrations, err := sqlitehench.MigrationsFromFS(migrationFiles, "migrations")
+func MigrationsFromFS(fsys fs.FS, dir string) ([]Migration, error) {
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	var versions []int64
+
+	for i := 0; i < len(entries); i++ {
+
+		fileName := entries[i].Name()
+		if entries[i].IsDir() || !strings.HasSuffix(fileName, ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, ".sql")
+		up := true
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			base = strings.TrimSuffix(base, ".up")
+		case strings.HasSuffix(base, ".down"):
+			base = strings.TrimSuffix(base, ".down")
+			up = false
+		default:
+			return nil, fmt.Errorf("migration file %s: the name must end with .up.sql or .down.sql", fileName)
+		}
+
+		v, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s: the name must start with the version number", fileName)
+		}
+
+		b, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+			versions = append(versions, version)
+		}
+		if up {
+			m.UpSQL = string(b)
+		} else {
+			m.DownSQL = string(b)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	migrations := make([]Migration, len(versions))
+	for i := 0; i < len(versions); i++ {
+		migrations[i] = *byVersion[versions[i]]
+		if migrations[i].UpSQL == "" {
+			return nil, fmt.Errorf("migration %d has no .up.sql file", versions[i])
+		}
+	}
+
+	return migrations, nil
+}
+
+// Status returns the migrations with their state in a database; in
+// version order.
+func (m *Migrator) Status(ctx context.Context, dbFilePath string) (_ []MigrationStatus, err error) {
+
+	defer func() { err = wrapErr("Migrate", dbFilePath, "", err) }()
+
+	if !fileOrDirExists(dbFilePath) {
+		return nil, ErrDatabaseFileNotExists
+	}
+
+	db, release, err := m.d.acquireDB(dbFilePath, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := m.readApplied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.status(applied), nil
+}
+
+// Up applies the pending migrations; it returns the ones that were
+// applied (or would be, with DryRun).
+func (m *Migrator) Up(ctx context.Context, dbFilePath string) ([]MigrationStatus, error) {
+	return m.migrate(ctx, dbFilePath, -1, false)
+}
+
+// UpTo applies the pending migrations up to (and including) a version.
+func (m *Migrator) UpTo(ctx context.Context, dbFilePath string, version int64) ([]MigrationStatus, error) {
+	return m.migrate(ctx, dbFilePath, version, false)
+}
+
+// Down reverts the last applied migration.
+func (m *Migrator) Down(ctx context.Context, dbFilePath string) ([]MigrationStatus, error) {
+	return m.migrate(ctx, dbFilePath, -1, true)
+}
+
+// DownTo reverts the applied migrations above a version; zero reverts
+// all of them.
+func (m *Migrator) DownTo(ctx context.Context, dbFilePath string, version int64) ([]MigrationStatus, error) {
+	return m.migrate(ctx, dbFilePath, version, true)
+}
+
+// migrate applies (or reverts) the migrations up to (or down to) target;
+// -1 is all pending migrations for up, and the last one for down.
+func (m *Migrator) migrate(ctx context.Context, dbFilePath string, target int64, down bool) (_ []MigrationStatus, err error) {
+
+	ctx, done := m.d.startRetryScope(ctx, "Migrate", dbFilePath)
+	defer func() {
+		err = wrapErr("Migrate", dbFilePath, "", err)
+		done(err)
+	}()
+
+	unlock, err := lockMigration(dbFilePath, m.opt.StaleLockAfter)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	db, release, err := m.d.acquireDB(dbFilePath, true)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := m.readApplied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, err := m.plan(applied, target, down)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.opt.DryRun {
+		return m.dryRun(ctx, db, steps, down)
+	}
+
+	var ret []MigrationStatus
+
+	for i := 0; i < len(steps); i++ {
+
+		if err = ctx.Err(); err != nil {
+			return ret, err
+		}
+
+		err = m.d.withRetry(ctx, func() error {
+			return m.runStep(ctx, db, steps[i], down)
+		})
+		if err != nil {
+			return ret, fmt.Errorf("migration %d (%s): %w", steps[i].Version, steps[i].Name, err)
+		}
+
+		ret = append(ret, MigrationStatus{Version: steps[i].Version, Name: steps[i].Name, Applied: !down})
+
+		if m.opt.Notify != nil {
+			verb := "applied"
+			if down {
+				verb = "reverted"
+			}
+			m.opt.Notify(fmt.Sprintf("%s migration %d %s (%d of %d)", verb, steps[i].Version, steps[i].Name, i+1, len(steps)))
+		}
+	}
+
+	return ret, nil
+}
+
+// plan returns the migrations to apply, in version order; or to revert,
+// in reverse order.
+func (m *Migrator) plan(applied map[int64]time.Time, target int64, down bool) ([]Migration, error) {
+
+	var steps []Migration
+
+	if !down {
+		for i := 0; i < len(m.migrations); i++ {
+			if target >= 0 && m.migrations[i].Version > target {
+				break
+			}
+			if _, ok := applied[m.migrations[i].Version]; !ok {
+				steps = append(steps, m.migrations[i])
+			}
+		}
+		return steps, nil
+	}
+
+	known := make(map[int64]bool, len(m.migrations))
+	for i := 0; i < len(m.migrations); i++ {
+		known[m.migrations[i].Version] = true
+	}
+
+	for v := range applied {
+		if !known[v] && v > target {
+			return nil, fmt.Errorf("applied migration %d is unknown; it cannot be reverted", v)
+		}
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mg := m.migrations[i]
+		if _, ok := applied[mg.Version]; !ok || (target >= 0 && mg.Version <= target) {
+			continue
+		}
+		if mg.Down == nil && mg.DownSQL == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no down migration", mg.Version, mg.Name)
+		}
+		steps = append(steps, mg)
+		if target < 0 {
+			// only the last one
+			break
+		}
+	}
+
+	return steps, nil
+}
+
+// dryRun runs the steps in one transaction, which is rolled back.
+func (m *Migrator) dryRun(ctx context.Context, db *sql.DB, steps []Migration, down bool) ([]MigrationStatus, error) {
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var ret []MigrationStatus
+
+	for i := 0; i < len(steps); i++ {
+		if err = m.execStep(ctx, tx, steps[i], down); err != nil {
+			return ret, fmt.Errorf("migration %d (%s): %w", steps[i].Version, steps[i].Name, err)
+		}
+		ret = append(ret, MigrationStatus{Version: steps[i].Version, Name: steps[i].Name, Applied: !down})
+	}
+
+	return ret, nil
+}
+
+// runStep runs one migration and records its version in a transaction.
+// The applied versions are read again in the transaction; so that a
+// change by another runner (that does not use the lock file) fails the
+// step, rather than running it twice.
+func (m *Migrator) runStep(ctx context.Context, db *sql.DB, mg Migration, down bool) error {
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer tx.Rollback()
+
+	applied, err := m.readApplied(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if _, ok := applied[mg.Version]; ok != down {
+		// applied (or reverted) since the plan was made
+		return ErrMigrationLocked
+	}
+
+	if err = m.execStep(ctx, tx, mg, down); err != nil {
+		return err
+	}
+
+	return ctxErr(ctx, tx.Commit())
+}
+
+// execStep runs the up (or down) of a migration, and records it.
+func (m *Migrator) execStep(ctx context.Context, tx *sql.Tx, mg Migration, down bool) error {
+
+	var err error
+
+	fn, sqlx := mg.Up, mg.UpSQL
+	if down {
+		fn, sqlx = mg.Down, mg.DownSQL
+	}
+
+	if fn != nil {
+		err = fn(ctx, tx)
+	} else if strings.TrimSpace(sqlx) != "" {
+		_, err = tx.ExecContext(ctx, sqlx)
+	}
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	return m.recordVersion(ctx, tx, mg, down)
+}
+
+// sqlQueryer is implemented by *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// readApplied returns the applied versions; with the time they were
+// applied (zero with PRAGMA user_version).
+func (m *Migrator) readApplied(ctx context.Context, q sqlQueryer) (map[int64]time.Time, error) {
+
+	applied := make(map[int64]time.Time)
+
+	if m.opt.Table == "" {
+		var uv int64
+		if err := queryOne(ctx, q, "PRAGMA user_version", &uv); err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(m.migrations) && m.migrations[i].Version <= uv; i++ {
+			applied[m.migrations[i].Version] = time.Time{}
+		}
+		return applied, nil
+	}
+
+	var exists int64
+	err := queryOne(ctx, q, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", &exists, m.opt.Table)
+	if err != nil || exists == 0 {
+		return applied, err
+	}
+
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("SELECT Version, AppliedAt FROM [%s]", m.opt.Table))
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v int64
+		var at interface{}
+		if err = rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		t, _ := parseTimeValue(at)
+		applied[v] = t
+	}
+
+	return applied, ctxErr(ctx, rows.Err())
+}
+
+// recordVersion records that a migration was applied (or reverted).
+func (m *Migrator) recordVersion(ctx context.Context, tx *sql.Tx, mg Migration, down bool) error {
+
+	var err error
+
+	if m.opt.Table == "" {
+		// user_version is the version below the reverted one.
+		v := mg.Version
+		if down {
+			v = 0
+			for i := 0; i < len(m.migrations) && m.migrations[i].Version < mg.Version; i++ {
+				v = m.migrations[i].Version
+			}
+		}
+		// PRAGMA does not take bind parameters.
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", v))
+		return ctxErr(ctx, err)
+	}
+
+	if down {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM [%s] WHERE Version = ?", m.opt.Table), mg.Version)
+		return ctxErr(ctx, err)
+	}
+
+	sqlx := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS [%s] (
+		Version INTEGER NOT NULL PRIMARY KEY,
+		Name TEXT NOT NULL,
+		AppliedAt TEXT NOT NULL
+	)`, m.opt.Table)
+	if _, err = tx.ExecContext(ctx, sqlx); err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	sqlx = fmt.Sprintf("INSERT INTO [%s] (Version, Name, AppliedAt) VALUES (?, ?, strftime('%%Y-%%m-%%d %%H:%%M:%%f','now'))", m.opt.Table)
+	_, err = tx.ExecContext(ctx, sqlx, mg.Version, mg.Name)
+
+	return ctxErr(ctx, err)
+}
+
+// status merges the migrations with the applied versions.
+func (m *Migrator) status(applied map[int64]time.Time) []MigrationStatus {
+
+	var ret []MigrationStatus
+
+	known := make(map[int64]bool, len(m.migrations))
+	for i := 0; i < len(m.migrations); i++ {
+		mg := m.migrations[i]
+		known[mg.Version] = true
+		at, ok := applied[mg.Version]
+		ret = append(ret, MigrationStatus{Version: mg.Version, Name: mg.Name, Applied: ok, AppliedAt: at})
+	}
+
+	for v, at := range applied {
+		if !known[v] {
+			ret = append(ret, MigrationStatus{Version: v, Applied: true, AppliedAt: at, Unknown: true})
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Version < ret[j].Version })
+
+	return ret
+}
+
+// queryOne reads the first column of the first row of a query into dst.
+func queryOne(ctx context.Context, q sqlQueryer, sqlQuery string, dst interface{}, args ...interface{}) error {
+
+	rows, err := q.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return ctxErr(ctx, err)
+		}
+		return ErrNoRowsFound
+	}
+
+	return rows.Scan(dst)
+}
+
+// lockMigration creates the lock file of a db file (<dbFilePath>-migrate.lock);
+// it fails with ErrMigrationLocked if the file exists and is not older
+// than staleAfter. The lock file is touched while it is held; so that a
+// long migration does not become stale. The returned func removes the
+// file, unless it has been taken over by another runner.
+func lockMigration(dbFilePath string, staleAfter time.Duration) (func(), error) {
+
+	lockPath := dbFilePath + "-migrate.lock"
+
+	// The token tells this runner from the others of the same process.
+	var token [8]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return nil, err
+	}
+	content := fmt.Sprintf("pid: %d\ntoken: %x\nstarted: %s\n", os.Getpid(), token, time.Now().Format(time.RFC3339))
+
+	for attempt := 0; attempt < 2; attempt++ {
+
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_, err = f.WriteString(content)
+			if e := f.Close(); err == nil {
+				err = e
+			}
+			if err != nil {
+				os.Remove(lockPath)
+				return nil, err
+			}
+			return holdMigrationLock(lockPath, content, staleAfter), nil
+		}
+
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		fi, err := os.Stat(lockPath)
+		if err != nil || time.Since(fi.ModTime()) < staleAfter {
+			break
+		}
+
+		// The runner that created it did not finish.
+		os.Remove(lockPath)
+	}
+
+	return nil, fmt.Errorf("%w: %s exists", ErrMigrationLocked, lockPath)
+}
+
+// holdMigrationLock touches the lock file (at a third of staleAfter) until
+// the returned func is called; which removes the file, if it still holds
+// the content of this runner.
+func holdMigrationLock(lockPath string, content string, staleAfter time.Duration) func() {
+
+	interval := staleAfter / 3
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if !ownsMigrationLock(lockPath, content) {
+					return
+				}
+				now := time.Now()
+				os.Chtimes(lockPath, now, now)
+			}
+		}
+	}()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+			if ownsMigrationLock(lockPath, content) {
+				os.Remove(lockPath)
+			}
+		})
+	}
+}
+
+// ownsMigrationLock reports whether the lock file holds content; it does
+// not, once it has been removed as stale and created by another runner.
+func ownsMigrationLock(lockPath string, content string) bool {
+
+	b, err := os.ReadFile(lockPath)
+
+	return err == nil && string(b) == content
+}
@@ -0,0 +1,263 @@
+package sqlitehench
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testMigrations returns three migrations; the second by funcs.
+func testMigrations() []Migration {
+
+	return []Migration{
+		{Version: 3, Name: "note", UpSQL: "ALTER TABLE a ADD COLUMN note TEXT", DownSQL: "ALTER TABLE a DROP COLUMN note"},
+		{Version: 1, Name: "a", UpSQL: "CREATE TABLE a (id INTEGER PRIMARY KEY)", DownSQL: "DROP TABLE a"},
+		{Version: 2, Name: "b",
+			Up: func(ctx context.Context, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "CREATE TABLE b (id INTEGER)")
+				return err
+			},
+			Down: func(ctx context.Context, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "DROP TABLE b")
+				return err
+			},
+		},
+	}
+}
+
+// appliedVersions returns the versions of the applied migrations.
+func appliedVersions(t *testing.T, m *Migrator, dbFilePath string) []int64 {
+
+	t.Helper()
+
+	st, err := m.Status(context.Background(), dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v []int64
+	for _, s := range st {
+		if s.Applied {
+			v = append(v, s.Version)
+		}
+	}
+
+	return v
+}
+
+func TestMigrator(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	ctx := context.Background()
+
+	for _, table := range []string{"", "schema_migrations"} {
+
+		dbFilePath := filepath.Join(t.TempDir(), "migrate.sqlite")
+		if _, err := d.ExecuteNonQuery("CREATE TABLE x (id INTEGER)", dbFilePath); err != nil {
+			t.Fatal(err)
+		}
+
+		var notes []string
+		m, err := d.NewMigrator(testMigrations(), MigratorOptions{Table: table, Notify: func(s string) { notes = append(notes, s) }})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ret, err := m.UpTo(ctx, dbFilePath, 2)
+		if err != nil || len(ret) != 2 || ret[0].Version != 1 || ret[1].Name != "b" {
+			t.Fatalf("%q: got %+v, %v", table, ret, err)
+		}
+		if ret, err = m.Up(ctx, dbFilePath); err != nil || len(ret) != 1 || ret[0].Version != 3 {
+			t.Fatalf("%q: got %+v, %v", table, ret, err)
+		}
+		// Nothing is pending.
+		if ret, err = m.Up(ctx, dbFilePath); err != nil || len(ret) != 0 {
+			t.Errorf("%q: got %+v, %v", table, ret, err)
+		}
+		if len(notes) != 3 || notes[2] != "applied migration 3 note (1 of 1)" {
+			t.Errorf("%q: got %q", table, notes)
+		}
+
+		st, err := m.Status(ctx, dbFilePath)
+		if err != nil || len(st) != 3 {
+			t.Fatalf("%q: got %+v, %v", table, st, err)
+		}
+		if table != "" && st[0].AppliedAt.IsZero() {
+			t.Errorf("%q: got %+v", table, st[0])
+		}
+		if _, err = d.ExecuteNonQuery("INSERT INTO a (id, note) VALUES (1, 'x')", dbFilePath); err != nil {
+			t.Errorf("%q: %v", table, err)
+		}
+
+		// Down reverts the last one; DownTo all above a version.
+		if ret, err = m.Down(ctx, dbFilePath); err != nil || len(ret) != 1 || ret[0].Version != 3 || ret[0].Applied {
+			t.Errorf("%q: got %+v, %v", table, ret, err)
+		}
+		if v := appliedVersions(t, m, dbFilePath); len(v) != 2 {
+			t.Errorf("%q: applied %v", table, v)
+		}
+		if ret, err = m.DownTo(ctx, dbFilePath, 0); err != nil || len(ret) != 2 || ret[0].Version != 2 {
+			t.Errorf("%q: got %+v, %v", table, ret, err)
+		}
+		if v := appliedVersions(t, m, dbFilePath); len(v) != 0 {
+			t.Errorf("%q: applied %v", table, v)
+		}
+		if _, err = d.GetTableInfo(dbFilePath, "a"); !errors.Is(err, ErrTableNotFound) {
+			t.Errorf("%q: got %v", table, err)
+		}
+	}
+}
+
+func TestMigratorFailure(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	ctx := context.Background()
+
+	dbFilePath := filepath.Join(t.TempDir(), "migrate.sqlite")
+	if _, err := d.ExecuteNonQuery("CREATE TABLE x (id INTEGER)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations := append(testMigrations(), Migration{Version: 4, Name: "bad", UpSQL: "CREATE TABLE c (id INTEGER); INSERT INTO none VALUES (1)"})
+
+	// A dry run does not change the database.
+	dry, _ := d.NewMigrator(migrations[:3], MigratorOptions{Table: "m", DryRun: true})
+	if ret, err := dry.Up(ctx, dbFilePath); err != nil || len(ret) != 3 {
+		t.Errorf("got %+v, %v", ret, err)
+	}
+	if _, err := d.GetTableInfo(dbFilePath, "a"); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("got %v", err)
+	}
+
+	// The failed migration is rolled back; the ones before remain.
+	m, _ := d.NewMigrator(migrations, MigratorOptions{Table: "m"})
+	ret, err := m.Up(ctx, dbFilePath)
+	if err == nil || !strings.Contains(err.Error(), "migration 4 (bad)") || len(ret) != 3 {
+		t.Errorf("got %+v, %v", ret, err)
+	}
+	if _, err = d.GetTableInfo(dbFilePath, "c"); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("got %v", err)
+	}
+	if v := appliedVersions(t, m, dbFilePath); len(v) != 3 {
+		t.Errorf("applied %v", v)
+	}
+
+	// A version that this Migrator does not know is not reverted.
+	m, _ = d.NewMigrator(migrations[1:2], MigratorOptions{Table: "m"})
+	st, err := m.Status(ctx, dbFilePath)
+	if err != nil || len(st) != 3 || !st[1].Unknown || !st[2].Unknown {
+		t.Errorf("got %+v, %v", st, err)
+	}
+	if _, err = m.DownTo(ctx, dbFilePath, 0); err == nil {
+		t.Error("reverted an unknown migration")
+	}
+
+	// Nor one without a down migration.
+	m, _ = d.NewMigrator([]Migration{{Version: 1, Name: "a", UpSQL: "SELECT 1"}, migrations[0], migrations[2]}, MigratorOptions{Table: "m"})
+	if _, err = m.DownTo(ctx, dbFilePath, 0); err == nil || !strings.Contains(err.Error(), "has no down migration") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestNewMigrator(t *testing.T) {
+
+	d := &DBAccess{}
+
+	for _, migrations := range [][]Migration{
+		{{Version: 0, Name: "zero"}},
+		{{Version: 1, Name: "a"}, {Version: 1, Name: "b"}},
+	} {
+		if _, err := d.NewMigrator(migrations, MigratorOptions{}); err == nil {
+			t.Errorf("%+v: no error", migrations)
+		}
+	}
+
+	m, err := d.NewMigrator(testMigrations(), MigratorOptions{})
+	if err != nil || m.opt.StaleLockAfter != defaultStaleLockAfter || m.migrations[0].Version != 1 {
+		t.Errorf("got %+v, %v", m, err)
+	}
+}
+
+func TestMigrationsFromFS(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"m/0002_b.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER)")},
+		"m/0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER)")},
+		"m/0001_a.down.sql": {Data: []byte("DROP TABLE a")},
+		"m/README.md":       {Data: []byte("not a migration")},
+	}
+
+	migrations, err := MigrationsFromFS(fsys, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[0].Name != "a" || migrations[0].DownSQL != "DROP TABLE a" ||
+		migrations[1].Version != 2 || migrations[1].DownSQL != "" {
+		t.Errorf("got %+v", migrations)
+	}
+
+	for name, bad := range map[string]string{
+		"no direction": "m/0003_c.sql",
+		"no version":   "m/c.up.sql",
+		"no up":        "m/0003_c.down.sql",
+	} {
+		fsys := fstest.MapFS{bad: {Data: []byte("SELECT 1")}}
+		if _, err = MigrationsFromFS(fsys, "m"); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestMigrationLock(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "migrate.sqlite")
+	lockPath := dbFilePath + "-migrate.lock"
+
+	unlock, err := lockMigration(dbFilePath, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another runner is refused while the lock is held.
+	m, _ := d.NewMigrator(testMigrations(), MigratorOptions{})
+	if _, err = m.Up(context.Background(), dbFilePath); !errors.Is(err, ErrMigrationLocked) {
+		t.Errorf("got %v; want %v", err, ErrMigrationLocked)
+	}
+
+	// A stale lock is taken over; and is not removed by the runner
+	// that lost it.
+	old := time.Now().Add(-2 * time.Minute)
+	os.Chtimes(lockPath, old, old)
+
+	unlock2, err := lockMigration(dbFilePath, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if _, err = os.Stat(lockPath); err != nil {
+		t.Errorf("the lock file is removed: %v", err)
+	}
+
+	unlock2()
+	unlock2()
+	if _, err = os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("the lock file remains: %v", err)
+	}
+
+	if _, err = m.Up(context.Background(), dbFilePath); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,15 +1,37 @@
 package sqlitehench
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // GetPagingInfo returns pageSize, offset, and collection info.
 func (d *DBAccess) GetPagingInfo(pageSize int, pageNo int, tableName string,
 	countColName string, filter string, dbFilePath string) (int, int, CollectionInfo) {
 
+	pageSize, offset, ci, err := d.GetPagingInfoContext(context.Background(), pageSize, pageNo, tableName, countColName, filter, dbFilePath)
+	if err != nil {
+		fmt.Println("GetPagingInfo()=>", err)
+	}
+
+	return pageSize, offset, ci
+}
+
+// GetPagingInfoContext returns pageSize, offset, and collection info. On
+// failure (including a cancelled ctx) the offset is -1 and the error is returned.
+func (d *DBAccess) GetPagingInfoContext(ctx context.Context, pageSize int, pageNo int, tableName string,
+	countColName string, filter string, dbFilePath string) (int, int, CollectionInfo, error) {
+
 	var ci CollectionInfo
 
 	if pageSize < 1 {
@@ -20,7 +42,7 @@ func (d *DBAccess) GetPagingInfo(pageSize int, pageNo int, tableName string,
 		pageNo = 1
 	}
 
-	sc := fmt.Sprintf("select count(_rowid_) from [%s]", tableName)
+	sc := fmt.Sprintf("select count(*) from [%s]", tableName)
 
 	if filter != "" && filter != "$get_all$" {
 		sc = fmt.Sprintf("select count(%s) from [%s] WHERE (%s)", countColName, tableName, filter)
@@ -28,10 +50,9 @@ func (d *DBAccess) GetPagingInfo(pageSize int, pageNo int, tableName string,
 
 	recordCount := 0
 
-	rObj, err := d.ExecuteScalare(sc, dbFilePath)
+	rObj, err := d.ExecuteScalareContext(ctx, sc, dbFilePath)
 	if err != nil {
-		fmt.Println("GetPagingInfo()=>", err)
-		return pageSize, -1, ci
+		return pageSize, -1, ci, err
 	}
 	if rObj != nil {
 		recordCount = int(rObj.(int64))
@@ -58,7 +79,7 @@ func (d *DBAccess) GetPagingInfo(pageSize int, pageNo int, tableName string,
 		ci.PositionTo = recordCount
 	}
 
-	return pageSize, offset, ci
+	return pageSize, offset, ci, nil
 }
 
 // GetPageInfoFromQuery --
@@ -131,3 +152,355 @@ func (d *DBAccess) GetPageOffset(recordCount int, pageSize int, pageNo int) (int
 
 	return totalPages, offset, pageNo
 }
+
+// keysetToken is the content of a keyset continuation token.
+type keysetToken struct {
+	Hash   string   `json:"h"`
+	PageNo int      `json:"p"`
+	Count  int      `json:"n"`
+	Approx bool     `json:"a,omitempty"`
+	Keys   []string `json:"k"`
+}
+
+// GetDataMapPageKeyset returns a page of a query, using keyset (seek)
+// pagination; the rows are ordered by opt.Keys and each page starts
+// after the last row of the previous page, rather than at an OFFSET.
+// So deep pages are as fast as the first one, and rows inserted between
+// requests do not shift the pages. sqlQuery is the query without an
+// ORDER BY or LIMIT clause; i.e.
+//
+//	opt := sqlitehench.KeysetOptions{Keys: []sqlitehench.KeysetKey{{Column: "ID"}}, PageSize: 50}
+//	rows, token, ci, err := d.GetDataMapPageKeyset("SELECT * FROM Customer WHERE Active = 1", opt, dbFilePath)
+//	...
+//	opt.Token = token // the next page; token is empty after the last page.
+func (d *DBAccess) GetDataMapPageKeyset(sqlQuery string, opt KeysetOptions, dbFilePath string, args ...interface{}) ([]map[string]interface{}, string, CollectionInfo, error) {
+	return d.GetDataMapPageKeysetContext(context.Background(), sqlQuery, opt, dbFilePath, args...)
+}
+
+// GetDataMapPageKeysetContext is GetDataMapPageKeyset with a ctx.
+func (d *DBAccess) GetDataMapPageKeysetContext(ctx context.Context, sqlQuery string, opt KeysetOptions,
+	dbFilePath string, args ...interface{}) (_ []map[string]interface{}, _ string, _ CollectionInfo, err error) {
+
+	var ci CollectionInfo
+	var tok keysetToken
+
+	defer func() { err = wrapErr("GetDataMapPageKeyset", dbFilePath, sqlQuery, err) }()
+
+	if len(opt.Keys) == 0 {
+		return nil, "", ci, errors.New("keyset paging needs at least one key column")
+	}
+
+	pageSize := opt.PageSize
+	if pageSize < 1 {
+		pageSize = 10
+	}
+
+	if args, err = bindArgs(args); err != nil {
+		return nil, "", ci, err
+	}
+
+	sqlQuery = strings.TrimSuffix(strings.TrimSpace(sqlQuery), ";")
+
+	// The token is bound to the query, the keys, the page size and the
+	// args; so that it is not replayed with other args (i.e. of
+	// WHERE Active = ?), whose count and keys it would carry over.
+	sbKeys := strings.Builder{}
+	for i := 0; i < len(opt.Keys); i++ {
+		sbKeys.WriteString(fmt.Sprintf("|%s %v", opt.Keys[i].Column, opt.Keys[i].Desc))
+	}
+	sbKeys.WriteString(fmt.Sprintf("|%d|", pageSize))
+	sbKeys.WriteString(keysetArgsText(args))
+	hash := createHash(sqlQuery + sbKeys.String())[:12]
+
+	if opt.Token != "" {
+		if tok, err = decodeKeysetToken(opt.Token); err != nil {
+			return nil, "", ci, err
+		}
+		if tok.Hash != hash || len(tok.Keys) != len(opt.Keys) {
+			return nil, "", ci, fmt.Errorf("%w; it was issued for a different query", ErrInvalidPageToken)
+		}
+	} else {
+		tok.Hash = hash
+		if tok.Count, tok.Approx, err = d.keysetCount(ctx, sqlQuery, opt.CountLimit, dbFilePath, args); err != nil {
+			return nil, "", ci, err
+		}
+	}
+
+	// The key values are selected a second time, through a unary plus;
+	// so that the driver returns them as stored (i.e. a DATETIME column
+	// as TEXT rather than time.Time), and the token compares them as is.
+	keySel := make([]string, len(opt.Keys))
+	orderBy := make([]string, len(opt.Keys))
+	for i := 0; i < len(opt.Keys); i++ {
+		keySel[i] = fmt.Sprintf("+[%s] AS [__keyset%d]", opt.Keys[i].Column, i)
+		orderBy[i] = fmt.Sprintf("[%s]", opt.Keys[i].Column)
+		if opt.Keys[i].Desc {
+			orderBy[i] += " DESC"
+		}
+	}
+
+	// (k1 > :k1) OR (k1 = :k1 AND k2 > :k2) ...
+	where := ""
+	if len(tok.Keys) > 0 {
+		or := make([]string, len(opt.Keys))
+		for i := 0; i < len(opt.Keys); i++ {
+			and := make([]string, 0, i+1)
+			for j := 0; j < i; j++ {
+				and = append(and, fmt.Sprintf("[%s] = :keyset%d", opt.Keys[j].Column, j))
+			}
+			op := ">"
+			if opt.Keys[i].Desc {
+				op = "<"
+			}
+			and = append(and, fmt.Sprintf("[%s] %s :keyset%d", opt.Keys[i].Column, op, i))
+			or[i] = "(" + strings.Join(and, " AND ") + ")"
+		}
+		where = " WHERE " + strings.Join(or, " OR ")
+
+		for i := 0; i < len(tok.Keys); i++ {
+			v, err := decodeKeyValue(tok.Keys[i])
+			if err != nil {
+				return nil, "", ci, err
+			}
+			args = append(args, sql.Named(fmt.Sprintf("keyset%d", i), v))
+		}
+	}
+
+	// One extra row tells whether there is a next page.
+	sqlx := fmt.Sprintf("SELECT *, %s FROM (%s)%s ORDER BY %s LIMIT %d",
+		strings.Join(keySel, ", "), sqlQuery, where, strings.Join(orderBy, ", "), pageSize+1)
+
+	mx, err := d.GetDataMapContext(ctx, sqlx, dbFilePath, args...)
+	if err != nil {
+		return nil, "", ci, err
+	}
+
+	hasNext := len(mx) > pageSize
+	if hasNext {
+		mx = mx[:pageSize]
+	}
+
+	var lastKeys []interface{}
+	for i := 0; i < len(mx); i++ {
+		if i == len(mx)-1 {
+			lastKeys = make([]interface{}, len(opt.Keys))
+		}
+		for j := 0; j < len(opt.Keys); j++ {
+			k := fmt.Sprintf("__keyset%d", j)
+			if lastKeys != nil {
+				lastKeys[j] = mx[i][k]
+			}
+			delete(mx[i], k)
+		}
+	}
+
+	tok.PageNo++
+
+	ci.PageSize = pageSize
+	ci.PageNo = tok.PageNo
+	ci.RecordCount = tok.Count
+	ci.Approximate = tok.Approx
+	ci.TotalPages, _, _ = d.GetPageOffset(tok.Count, pageSize, 1)
+	if len(mx) > 0 {
+		ci.PositionFrom = (tok.PageNo-1)*pageSize + 1
+		ci.PositionTo = ci.PositionFrom + len(mx) - 1
+	}
+	if ci.TotalPages < ci.PageNo {
+		ci.TotalPages = ci.PageNo
+	}
+
+	if !hasNext {
+		return mx, "", ci, nil
+	}
+
+	tok.Keys = make([]string, len(lastKeys))
+	for i := 0; i < len(lastKeys); i++ {
+		if tok.Keys[i], err = encodeKeyValue(lastKeys[i]); err != nil {
+			return nil, "", ci, fmt.Errorf("key column %s: %w", opt.Keys[i].Column, err)
+		}
+	}
+
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return nil, "", ci, err
+	}
+
+	return mx, base64.RawURLEncoding.EncodeToString(b), ci, nil
+}
+
+// keysetCount returns the row count of a query; or, when it exceeds
+// countLimit (> 0), an estimate.
+func (d *DBAccess) keysetCount(ctx context.Context, sqlQuery string, countLimit int, dbFilePath string, args []interface{}) (int, bool, error) {
+
+	sc := fmt.Sprintf("SELECT count(*) FROM (%s)", sqlQuery)
+	if countLimit > 0 {
+		sc = fmt.Sprintf("SELECT count(*) FROM (SELECT 1 FROM (%s) LIMIT %d)", sqlQuery, countLimit+1)
+	}
+
+	rObj, err := d.ExecuteScalareContext(ctx, sc, dbFilePath, args...)
+	if err != nil {
+		return 0, false, err
+	}
+
+	n := 0
+	if rObj != nil {
+		n = int(rObj.(int64))
+	}
+
+	if countLimit < 1 || n <= countLimit {
+		return n, false, nil
+	}
+
+	if est := d.estimateRowCount(ctx, analyzeSQL(sqlQuery).Source, dbFilePath); est > n {
+		n = est
+	}
+
+	return n, true, nil
+}
+
+// estimateRowCount returns the approximate row count of a table; from
+// sqlite_stat1 (see ANALYZE), or else the largest rowid. Zero is
+// returned if neither is available.
+func (d *DBAccess) estimateRowCount(ctx context.Context, tableName string, dbFilePath string) int {
+
+	tableName = strings.Trim(tableName, "[]`\"")
+	if tableName == "" {
+		return 0
+	}
+
+	// The first number in the stat column is the row count.
+	rObj, err := d.ExecuteScalareContext(ctx,
+		"SELECT max(CAST(stat AS INTEGER)) FROM sqlite_stat1 WHERE tbl = ? COLLATE NOCASE", dbFilePath, tableName)
+	if err == nil && rObj != nil {
+		if n, ok := rObj.(int64); ok && n > 0 {
+			return int(n)
+		}
+	}
+
+	rObj, err = d.ExecuteScalareContext(ctx, fmt.Sprintf("SELECT max(_rowid_) FROM [%s]", tableName), dbFilePath)
+	if err == nil && rObj != nil {
+		if n, ok := rObj.(int64); ok {
+			return int(n)
+		}
+	}
+
+	return 0
+}
+
+// keysetArgsText returns a canonical text of the bind args of a keyset
+// query; the named args are sorted by name (those of a map are in no
+// order), and the values are converted as they are bound.
+func keysetArgsText(args []interface{}) string {
+
+	var pos []string
+	var named []string
+
+	for i := 0; i < len(args); i++ {
+		if na, ok := args[i].(sql.NamedArg); ok {
+			named = append(named, na.Name+"="+keysetArgText(na.Value))
+			continue
+		}
+		pos = append(pos, keysetArgText(args[i]))
+	}
+	sort.Strings(named)
+
+	b, _ := json.Marshal(append(pos, named...))
+
+	return string(b)
+}
+
+func keysetArgText(v interface{}) string {
+
+	if vr, ok := v.(driver.Valuer); ok {
+		if x, err := vr.Value(); err == nil {
+			v = x
+		}
+	}
+
+	if x, err := bindValue(v); err == nil {
+		v = x
+	}
+
+	if t, ok := v.(time.Time); ok {
+		return "t:" + t.Format(time.RFC3339Nano)
+	}
+	if s, err := encodeKeyValue(v); err == nil {
+		return s
+	}
+
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+func decodeKeysetToken(token string) (keysetToken, error) {
+
+	var tok keysetToken
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return tok, ErrInvalidPageToken
+	}
+	if err = json.Unmarshal(b, &tok); err != nil || tok.PageNo < 1 {
+		return tok, ErrInvalidPageToken
+	}
+
+	return tok, nil
+}
+
+// encodeKeyValue encodes a key value with its storage class; so that
+// it is bound with the same type, when the token is decoded.
+func encodeKeyValue(v interface{}) (string, error) {
+
+	switch x := v.(type) {
+	case int64:
+		return "i:" + strconv.FormatInt(x, 10), nil
+	case float64:
+		return "f:" + strconv.FormatFloat(x, 'g', -1, 64), nil
+	case string:
+		return "s:" + x, nil
+	case []byte:
+		return "b:" + base64.StdEncoding.EncodeToString(x), nil
+	case bool:
+		if x {
+			return "i:1", nil
+		}
+		return "i:0", nil
+	case nil:
+		return "", errors.New("NULL key values are not supported by keyset paging")
+	}
+
+	return "", fmt.Errorf("unsupported key value type %T", v)
+}
+
+func decodeKeyValue(s string) (interface{}, error) {
+
+	if len(s) < 2 || s[1] != ':' {
+		return nil, ErrInvalidPageToken
+	}
+
+	v := s[2:]
+
+	switch s[0] {
+	case 'i':
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidPageToken
+		}
+		return n, nil
+	case 'f':
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, ErrInvalidPageToken
+		}
+		return f, nil
+	case 's':
+		return v, nil
+	case 'b':
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, ErrInvalidPageToken
+		}
+		return b, nil
+	}
+
+	return nil, ErrInvalidPageToken
+}
@@ -0,0 +1,187 @@
+package sqlitehench
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// pagingTest returns the path of a db file with the table t of n rows;
+// the names repeat every 3 rows, and the dates are DATETIME text.
+func pagingTest(t *testing.T, d *DBAccess, n int) string {
+
+	t.Helper()
+
+	return testDB(t, d, "paging.sqlite", []string{
+		"CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, at DATETIME)",
+		fmt.Sprintf(`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < %d)
+			INSERT INTO t SELECT i, 'name-' || (i %% 3), datetime('2024-01-01', '+' || i || ' hours') FROM n`, n),
+	}, "")
+}
+
+// readPages returns the ids of every page; and the CollectionInfo of
+// each page.
+func readPages(t *testing.T, d *DBAccess, sqlQuery string, opt KeysetOptions, dbFilePath string, args ...interface{}) ([]int64, []CollectionInfo) {
+
+	t.Helper()
+
+	var ids []int64
+	var cis []CollectionInfo
+
+	for i := 0; i < 100; i++ {
+		rows, token, ci, err := d.GetDataMapPageKeyset(sqlQuery, opt, dbFilePath, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range rows {
+			if _, ok := r["__keyset0"]; ok {
+				t.Fatal("the key columns are returned")
+			}
+			ids = append(ids, r["id"].(int64))
+		}
+		cis = append(cis, ci)
+		if token == "" {
+			return ids, cis
+		}
+		opt.Token = token
+	}
+
+	t.Fatal("the pages do not end")
+	return nil, nil
+}
+
+func TestKeysetPaging(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := pagingTest(t, d, 10)
+
+	ids, cis := readPages(t, d, "SELECT * FROM t WHERE id > ?", KeysetOptions{
+		Keys:     []KeysetKey{{Column: "name"}, {Column: "id", Desc: true}},
+		PageSize: 3,
+	}, dbFilePath, 1)
+
+	// name-0: 9, 6, 3; name-1: 10, 7, 4; name-2: 8, 5, 2
+	want := []int64{9, 6, 3, 10, 7, 4, 8, 5, 2}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v; want %v", ids, want)
+	}
+
+	if len(cis) != 3 {
+		t.Fatalf("got %d pages", len(cis))
+	}
+	last := cis[2]
+	if last.PageNo != 3 || last.TotalPages != 3 || last.RecordCount != 9 || last.PositionFrom != 7 || last.PositionTo != 9 {
+		t.Errorf("last page %+v", last)
+	}
+
+	// A DATETIME key is compared as stored.
+	ids, _ = readPages(t, d, "SELECT * FROM t", KeysetOptions{Keys: []KeysetKey{{Column: "at", Desc: true}}, PageSize: 4}, dbFilePath)
+	if len(ids) != 10 || ids[0] != 10 || ids[9] != 1 {
+		t.Errorf("got %v", ids)
+	}
+}
+
+func TestKeysetPagingInserts(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := pagingTest(t, d, 6)
+
+	opt := KeysetOptions{Keys: []KeysetKey{{Column: "id"}}, PageSize: 2}
+	_, token, _, err := d.GetDataMapPageKeyset("SELECT * FROM t", opt, dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A row inserted before the next page does not shift it.
+	if _, err = d.ExecuteNonQuery("INSERT INTO t (id, name) VALUES (0, 'new')", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	opt.Token = token
+	rows, _, ci, err := d.GetDataMapPageKeyset("SELECT * FROM t", opt, dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["id"] != int64(3) || ci.PageNo != 2 {
+		t.Errorf("got %v, %+v", rows, ci)
+	}
+}
+
+func TestKeysetPagingTokens(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := pagingTest(t, d, 5)
+
+	opt := KeysetOptions{Keys: []KeysetKey{{Column: "id"}}, PageSize: 2}
+	_, token, _, err := d.GetDataMapPageKeyset("SELECT * FROM t", opt, dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := "SELECT * FROM t WHERE name = :name"
+	_, argsToken, _, err := d.GetDataMapPageKeyset(byName, KeysetOptions{Keys: opt.Keys, PageSize: 1}, dbFilePath, map[string]interface{}{"name": "name-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range map[string]struct {
+		sqlQuery string
+		opt      KeysetOptions
+		args     []interface{}
+	}{
+		"other query":     {"SELECT * FROM t WHERE id > 0", KeysetOptions{Keys: opt.Keys, PageSize: 2, Token: token}, nil},
+		"other keys":      {"SELECT * FROM t", KeysetOptions{Keys: []KeysetKey{{Column: "id", Desc: true}}, PageSize: 2, Token: token}, nil},
+		"other page size": {"SELECT * FROM t", KeysetOptions{Keys: opt.Keys, PageSize: 3, Token: token}, nil},
+		"other args":      {byName, KeysetOptions{Keys: opt.Keys, PageSize: 1, Token: argsToken}, []interface{}{sql.Named("name", "name-2")}},
+		"no args":         {byName, KeysetOptions{Keys: opt.Keys, PageSize: 1, Token: argsToken}, nil},
+		"garbage":         {"SELECT * FROM t", KeysetOptions{Keys: opt.Keys, PageSize: 2, Token: "not-a-token"}, nil},
+	} {
+		if _, _, _, err = d.GetDataMapPageKeyset(tt.sqlQuery, tt.opt, dbFilePath, tt.args...); !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("%s: got %v; want %v", name, err, ErrInvalidPageToken)
+		}
+	}
+
+	// The same args are accepted; as a map, or as named args.
+	rows, _, ci, err := d.GetDataMapPageKeyset(byName, KeysetOptions{Keys: opt.Keys, PageSize: 1, Token: argsToken}, dbFilePath, sql.Named("name", "name-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["id"] != int64(4) || ci.PageNo != 2 {
+		t.Errorf("got %v, %+v", rows, ci)
+	}
+
+	if _, _, _, err = d.GetDataMapPageKeyset("SELECT * FROM t", KeysetOptions{}, dbFilePath); err == nil {
+		t.Error("paged without keys")
+	}
+}
+
+func TestKeyValueEncoding(t *testing.T) {
+
+	for _, v := range []interface{}{int64(-5), 1.25, "a:b", []byte{0, 1}} {
+		s, err := encodeKeyValue(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeKeyValue(s)
+		if err != nil || !reflect.DeepEqual(got, v) {
+			t.Errorf("%v: got %v, %v", v, got, err)
+		}
+	}
+
+	if _, err := encodeKeyValue(nil); err == nil {
+		t.Error("encoded a NULL key")
+	}
+	for _, s := range []string{"", "x", "q:1", "i:x"} {
+		if _, err := decodeKeyValue(s); !errors.Is(err, ErrInvalidPageToken) {
+			t.Errorf("%q: got %v", s, err)
+		}
+	}
+}
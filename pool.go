@@ -0,0 +1,340 @@
+package sqlitehench
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// connPool keeps one *sql.DB per db file path. A handle is reference
+// counted while an operation is using it; and closed by the eviction
+// loop once it has been idle for longer than idleTimeout.
+type connPool struct {
+	mu          sync.Mutex
+	entries     map[string]*pooledDB
+	idleTimeout time.Duration
+	evicting    bool
+
+	// open counts the handles of each path that are open; pooled (in
+	// use, idle or retired) or of acquireOwnDB. closed is closed, and
+	// replaced, each time a handle is closed; see waitClosed.
+	open   map[string]int
+	closed chan struct{}
+}
+
+type pooledDB struct {
+	db       *sql.DB
+	refs     int
+	lastUsed time.Time
+
+	// retired is set when the handle has been taken out of the pool
+	// (close-after-write, CloseDB); it is closed when refs reaches zero.
+	retired bool
+}
+
+func newConnPool(idleTimeout time.Duration) *connPool {
+	return &connPool{
+		entries:     make(map[string]*pooledDB),
+		idleTimeout: idleTimeout,
+		open:        make(map[string]int),
+		closed:      make(chan struct{}),
+	}
+}
+
+// acquireDB returns a database handle for dbFilePath and a release func
+// that must be called once the operation is completed. write indicates
+// that the operation writes to the database; which, under the
+// ConnPolicyCloseAfterWrite policy, closes the handle on release.
+func (d *DBAccess) acquireDB(dbFilePath string, write bool) (*sql.DB, func(), error) {
+
+	if d.pool == nil {
+		db, err := d.GetDB(dbFilePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, func() { db.Close() }, nil
+	}
+
+	if d.ConnPolicy == ConnPolicyCloseAfterOp {
+		db, err := d.GetDB(dbFilePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, d.pool.track(dbFilePath, db), nil
+	}
+
+	p := d.pool
+
+	p.mu.Lock()
+	e, ok := p.entries[dbFilePath]
+	if !ok {
+		// Opened while holding the lock; so that there is only
+		// one handle (and one set of PRAGMA) per file.
+		db, err := d.GetDB(dbFilePath)
+		if err != nil {
+			p.mu.Unlock()
+			return nil, nil, err
+		}
+		e = &pooledDB{db: db}
+		p.entries[dbFilePath] = e
+		p.open[dbFilePath]++
+
+		if !p.evicting {
+			p.evicting = true
+			go p.evictIdle()
+		}
+	}
+	e.refs++
+	e.lastUsed = time.Now()
+
+	if write && d.ConnPolicy == ConnPolicyCloseAfterWrite {
+		// The next caller gets a fresh handle.
+		e.retired = true
+		delete(p.entries, dbFilePath)
+	}
+	p.mu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			p.mu.Lock()
+			e.refs--
+			e.lastUsed = time.Now()
+			closeNow := e.retired && e.refs == 0
+			p.mu.Unlock()
+
+			if closeNow {
+				e.db.Close()
+				p.handleClosed(dbFilePath)
+			}
+		})
+	}
+
+	return e.db, release, nil
+}
+
+// acquireOwnDB returns a handle of dbFilePath that is not pooled; for the
+// reads that hold a connection until they are closed (i.e. a Cursor), so
+// that the pooled handle (of MaxOpenConns connections) stays free for
+// the other operations on the file.
+func (d *DBAccess) acquireOwnDB(dbFilePath string) (*sql.DB, func(), error) {
+
+	db, err := d.GetDB(dbFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if d.pool == nil {
+		return db, func() { db.Close() }, nil
+	}
+
+	return db, d.pool.track(dbFilePath, db), nil
+}
+
+// track counts a handle of dbFilePath that is not pooled, until it is
+// closed by the returned release func; so that the file is not replaced
+// under it (see waitClosed).
+func (p *connPool) track(dbFilePath string, db *sql.DB) func() {
+
+	p.mu.Lock()
+	p.open[dbFilePath]++
+	p.mu.Unlock()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			db.Close()
+			p.handleClosed(dbFilePath)
+		})
+	}
+}
+
+// handleClosed is called once a handle of dbFilePath has been closed.
+func (p *connPool) handleClosed(dbFilePath string) {
+
+	p.mu.Lock()
+	if p.open[dbFilePath]--; p.open[dbFilePath] <= 0 {
+		delete(p.open, dbFilePath)
+	}
+	close(p.closed)
+	p.closed = make(chan struct{})
+	p.mu.Unlock()
+}
+
+// waitClosed waits until no handle of dbFilePath is open; i.e. of an
+// operation that is running, or of a Cursor that has not been closed. It
+// returns ErrDatabaseIsLocked if a handle is still open after timeout;
+// or ctx.Err() if the ctx is done before.
+func (p *connPool) waitClosed(ctx context.Context, dbFilePath string, timeout time.Duration) error {
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		p.mu.Lock()
+		n := p.open[dbFilePath]
+		closed := p.closed
+		p.mu.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-closed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return fmt.Errorf("%d handle(s) of the database are still open; %w", n, ErrDatabaseIsLocked)
+		}
+	}
+}
+
+// evictIdle closes the handles that have not been used for longer than
+// the idle timeout. It exits when the pool is empty; and is restarted
+// by acquireDB.
+func (p *connPool) evictIdle() {
+
+	interval := p.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	for {
+		time.Sleep(interval)
+
+		var idle []*sql.DB
+		var idlePaths []string
+
+		p.mu.Lock()
+		for k, e := range p.entries {
+			if e.refs == 0 && time.Since(e.lastUsed) > p.idleTimeout {
+				idle = append(idle, e.db)
+				idlePaths = append(idlePaths, k)
+				delete(p.entries, k)
+			}
+		}
+		empty := len(p.entries) == 0
+		if empty {
+			p.evicting = false
+		}
+		p.mu.Unlock()
+
+		for i := 0; i < len(idle); i++ {
+			idle[i].Close()
+			p.handleClosed(idlePaths[i])
+		}
+
+		if empty {
+			return
+		}
+	}
+}
+
+// closeEntry takes a db file out of the pool. The handle is closed now
+// if it is not in use; otherwise when its last user releases it.
+func (p *connPool) closeEntry(dbFilePath string) error {
+
+	p.mu.Lock()
+	e, ok := p.entries[dbFilePath]
+	if !ok {
+		p.mu.Unlock()
+		return nil
+	}
+	delete(p.entries, dbFilePath)
+	e.retired = true
+	closeNow := e.refs == 0
+	p.mu.Unlock()
+
+	if closeNow {
+		err := e.db.Close()
+		p.handleClosed(dbFilePath)
+		return err
+	}
+
+	return nil
+}
+
+// CloseDB closes the pooled handle of a database file; i.e. before the
+// file is moved, replaced or deleted. A handle that is in use is closed
+// as soon as the running operation is completed.
+func (d *DBAccess) CloseDB(dbFilePath string) error {
+
+	if d.pool == nil {
+		return nil
+	}
+
+	return d.pool.closeEntry(dbFilePath)
+}
+
+// Close closes all pooled database handles.
+func (d *DBAccess) Close() error {
+
+	if d.pool == nil {
+		return nil
+	}
+
+	d.pool.mu.Lock()
+	var paths []string
+	for k := range d.pool.entries {
+		paths = append(paths, k)
+	}
+	d.pool.mu.Unlock()
+
+	var err error
+	for i := 0; i < len(paths); i++ {
+		if e := d.pool.closeEntry(paths[i]); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// connDrivers maps a set of connection statements to the name of the
+// driver that runs them; so that the instances with the same PRAGMA share
+// a driver, as a driver cannot be unregistered.
+var connDrivers = struct {
+	mu sync.Mutex
+	m  map[string]string
+}{m: make(map[string]string)}
+
+// connDriver returns the name of a driver whose ConnectHook runs stmts on
+// every new connection. A *sql.DB holds a pool of connections; so that a
+// statement that is run on the *sql.DB reaches only one of them.
+func connDriver(stmts []string) string {
+
+	key := strings.Join(stmts, "\n")
+
+	connDrivers.mu.Lock()
+	defer connDrivers.mu.Unlock()
+
+	if name, ok := connDrivers.m[key]; ok {
+		return name
+	}
+
+	name := fmt.Sprintf("sqlitehench-%d", len(connDrivers.m)+1)
+	stmts = append([]string(nil), stmts...)
+
+	sql.Register(name, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// The errors are ignored, as they were when the PRAGMA
+			// were run on the *sql.DB; i.e. journal_mode of an
+			// in-memory database.
+			for i := 0; i < len(stmts); i++ {
+				conn.Exec(stmts[i], nil)
+			}
+			return nil
+		},
+	})
+	connDrivers.m[key] = name
+
+	return name
+}
@@ -0,0 +1,137 @@
+package sqlitehench
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// poolTest returns the path of a db file with the table t of n rows.
+func poolTest(t *testing.T, d *DBAccess, n int) string {
+
+	t.Helper()
+
+	rows := make([][]interface{}, n)
+	for i := 0; i < n; i++ {
+		rows[i] = []interface{}{i + 1, "x"}
+	}
+
+	return testDB(t, d, "pool.sqlite", []string{"CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT)"}, "INSERT INTO t (id, v) VALUES (?, ?)", rows...)
+}
+
+// withTimeout fails the test, if fn does not return within a few
+// seconds; i.e. it waits for a connection that is never released. fn
+// runs on a goroutine of its own; so it must report with t.Error (and
+// return), rather than with t.Fatal.
+func withTimeout(t *testing.T, fn func()) {
+
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+}
+
+func TestPoolQueryWhileCursorOpen(t *testing.T) {
+
+	for _, policy := range []ConnPolicy{ConnPolicyCloseAfterWrite, ConnPolicyPooled, ConnPolicyCloseAfterOp} {
+
+		d := NewDBAccess(DBAccess{ConnPolicy: policy})
+		dbFilePath := poolTest(t, d, 3)
+
+		withTimeout(t, func() {
+
+			n := 0
+			for m, err := range d.IterRows(context.Background(), "SELECT id FROM t ORDER BY id", dbFilePath) {
+				if err != nil {
+					t.Errorf("policy %d: %v", policy, err)
+					return
+				}
+
+				v, err := d.ExecuteScalareWithArgs("SELECT v FROM t WHERE id = ?", dbFilePath, m["id"])
+				if err != nil || v != "x" {
+					t.Errorf("policy %d: %v, %v", policy, v, err)
+					return
+				}
+				if _, err = d.ExecuteNonQueryWithArgs("UPDATE t SET v = ? WHERE id = ?", dbFilePath, "x", m["id"]); err != nil {
+					t.Errorf("policy %d: %v", policy, err)
+					return
+				}
+				n++
+			}
+			if n != 3 {
+				t.Errorf("policy %d: read %d rows; want 3", policy, n)
+			}
+		})
+
+		d.Close()
+	}
+}
+
+func TestPoolHandleIsShared(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{ConnPolicy: ConnPolicyPooled})
+	defer d.Close()
+
+	dbFilePath := poolTest(t, d, 1)
+
+	db1, release1, err := d.acquireDB(dbFilePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db2, release2, err := d.acquireDB(dbFilePath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db1 != db2 {
+		t.Error("the pooled handle is not shared")
+	}
+	release1()
+	release2()
+
+	// CloseDB takes the handle out of the pool.
+	d.CloseDB(dbFilePath)
+	db3, release3, err := d.acquireDB(dbFilePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release3()
+	if db3 == db1 {
+		t.Error("CloseDB kept the handle")
+	}
+}
+
+func TestPoolCloseAfterWrite(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := poolTest(t, d, 1)
+
+	db1, release1, err := d.acquireDB(dbFilePath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release1()
+
+	// The handle of a write is closed on release.
+	if err = db1.Ping(); err == nil {
+		t.Error("the handle of a write is open")
+	}
+
+	db2, release2, _ := d.acquireDB(dbFilePath, false)
+	release2()
+	db3, release3, _ := d.acquireDB(dbFilePath, false)
+	release3()
+	if db2 != db3 {
+		t.Error("the handle of a read is not kept")
+	}
+}
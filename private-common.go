@@ -3,26 +3,39 @@ package sqlitehench
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"reflect"
 	"strings"
+	"time"
 )
 
 // executeScalare returns one value and closes the database.
-func executeScalare(sqlStatement string, db *sql.DB) (interface{}, error) {
+func executeScalare(ctx context.Context, sqlStatement string, db *sql.DB, args ...interface{}) (interface{}, error) {
 
 	var rows *sql.Rows
 	var err error
 	var item interface{}
 
-	if rows, err = db.Query(sqlStatement); err != nil {
+	if args, err = bindArgs(args); err != nil {
 		return nil, err
 	}
 
+	if rows, err = db.QueryContext(ctx, sqlStatement, args...); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
 	if rows.Next() {
 		rows.Scan(&item)
 	}
+	err = rows.Err()
 	rows.Close()
 
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
 	if item == nil {
 		return nil, nil
 	}
@@ -30,7 +43,7 @@ func executeScalare(sqlStatement string, db *sql.DB) (interface{}, error) {
 	return item, nil
 }
 
-func executeNonQuery(sqlStatement string, db *sql.DB) (int64, error) {
+func executeNonQuery(ctx context.Context, sqlStatement string, db *sql.DB, args ...interface{}) (int64, error) {
 
 	// this is a rough estimate (https://sqlite.org/limits.html),
 	// but it'd be good to prevent this to go thru.
@@ -40,19 +53,21 @@ func executeNonQuery(sqlStatement string, db *sql.DB) (int64, error) {
 
 	var err error
 
-	ctx := context.Background()
-	if ctx == nil {
-		return -1, errors.New("failed to get a background context")
+	if args, err = bindArgs(args); err != nil {
+		return -1, err
 	}
+
+	// The transaction is rolled back by database/sql, if
+	// the ctx is cancelled before it is committed.
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return -1, err
+		return -1, ctxErr(ctx, err)
 	}
 
-	result, err := tx.ExecContext(ctx, sqlStatement)
+	result, err := tx.ExecContext(ctx, sqlStatement, args...)
 	if err != nil {
 		tx.Rollback()
-		return -1, err
+		return -1, ctxErr(ctx, err)
 	}
 
 	var rowsAffected int64 = -1
@@ -64,29 +79,165 @@ func executeNonQuery(sqlStatement string, db *sql.DB) (int64, error) {
 			return -1, err
 		}
 	}
-	tx.Commit()
+
+	if err = tx.Commit(); err != nil {
+		return -1, ctxErr(ctx, err)
+	}
 
 	return rowsAffected, nil
 }
+
+// executeNonQueryNoTx executes an SQL statement without a transaction context.
+func executeNonQueryNoTx(ctx context.Context, sqlStatement string, db *sql.DB, args ...interface{}) (int64, error) {
+
+	if len(sqlStatement) > 1000000000 {
+		return -1, errors.New("query length exceeded max length of 1000000000 bytes")
+	}
+
+	var err error
+
+	if args, err = bindArgs(args); err != nil {
+		return -1, err
+	}
+
+	result, err := db.ExecContext(ctx, sqlStatement, args...)
+	if err != nil {
+		return -1, ctxErr(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+
+	return rowsAffected, nil
+}
+
+// fixSQLQuery quotes the Group keyword when it is used as a column name
+// (i.e. select Group from...); GROUP BY, the string literals and the
+// quoted names are left as they are.
 func fixSQLQuery(sqlQuery string) string {
 
-	sqlQuery = strings.ReplaceAll(strings.ToLower(sqlQuery), " group ", " [Group] ")
-	sqlQuery = strings.ReplaceAll(strings.ToLower(sqlQuery), " group;", " [Group];")
+	toks := tokenizeSQL(sqlQuery)
+
+	var b strings.Builder
+	last := 0
 
-	return sqlQuery
+	for i := 0; i < len(toks); i++ {
+		if !toks[i].isKeyword("GROUP") || (i+1 < len(toks) && toks[i+1].isKeyword("BY")) {
+			continue
+		}
+		b.WriteString(sqlQuery[last:toks[i].start])
+		b.WriteString("[Group]")
+		last = toks[i].end
+	}
+
+	if last == 0 {
+		return sqlQuery
+	}
+	b.WriteString(sqlQuery[last:])
+
+	return b.String()
+}
+
+// bindArgs prepares the bind arguments for database/sql. A single map
+// (with string keys) or a single struct is expanded into named arguments;
+// so that :name, @name and $name placeholders can be used in the SQL
+// statement. Struct fields are named by their `db` tag (or the field name);
+// fields tagged with `db:"-"` are skipped. Anything else is passed through
+// as positional arguments.
+func bindArgs(args []interface{}) ([]interface{}, error) {
+
+	if len(args) != 1 || args[0] == nil {
+		return args, nil
+	}
+
+	switch args[0].(type) {
+	case sql.NamedArg, time.Time, []byte, driver.Valuer:
+		return args, nil
+	}
+
+	v := reflect.ValueOf(args[0])
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return args, nil
+		}
+		v = v.Elem()
+	}
+
+	var named []interface{}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("bind arguments map must have string keys; got %s", v.Type())
+		}
+		keys := v.MapKeys()
+		for i := 0; i < len(keys); i++ {
+			name := trimParamPrefix(keys[i].String())
+			named = append(named, sql.Named(name, v.MapIndex(keys[i]).Interface()))
+		}
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				// unexported
+				continue
+			}
+			name := strings.Split(f.Tag.Get("db"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			named = append(named, sql.Named(trimParamPrefix(name), v.Field(i).Interface()))
+		}
+
+	default:
+		return args, nil
+	}
+
+	return named, nil
+}
+
+// trimParamPrefix removes the SQLite parameter prefix from a name.
+func trimParamPrefix(name string) string {
+	return strings.TrimLeft(name, ":@$")
+}
+
+// ctxErr returns ctx.Err() in place of err, when the failure was caused
+// by the ctx being cancelled (or its deadline exceeded); the driver
+// reports those as "interrupted".
+func ctxErr(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
 }
 
 // getDataMap gets a selected range of table in form of rows and columns.
-func getDataMap(sqlQuery string, db *sql.DB) ([]map[string]interface{}, error) {
+func getDataMap(ctx context.Context, sqlQuery string, db *sql.DB, args ...interface{}) ([]map[string]interface{}, error) {
 
 	var err error
 
 	var mRet []map[string]interface{}
 
-	rows, err := db.Query(sqlQuery)
-	if err != nil {
+	if args, err = bindArgs(args); err != nil {
 		return nil, err
 	}
+
+	rows, err := db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	defer rows.Close()
+
 	cols, err := rows.Columns()
 	if err != nil {
 		return nil, err
@@ -102,7 +253,7 @@ func getDataMap(sqlQuery string, db *sql.DB) ([]map[string]interface{}, error) {
 		m := make(map[string]interface{})
 
 		if err := rows.Scan(columnPointers...); err != nil {
-			return nil, err
+			return nil, ctxErr(ctx, err)
 		}
 
 		for i := 0; i < len(cols); i++ {
@@ -113,7 +264,9 @@ func getDataMap(sqlQuery string, db *sql.DB) ([]map[string]interface{}, error) {
 		mRet = append(mRet, m)
 	}
 
-	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
 
 	return mRet, nil
 }
@@ -0,0 +1,138 @@
+package sqlitehench
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBindArgs(t *testing.T) {
+
+	type person struct {
+		Name string `db:"name"`
+		Age  int
+		Note string `db:"-"`
+		id   int
+	}
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		in   []interface{}
+		want []interface{}
+	}{
+		{nil, nil},
+		{[]interface{}{1, "a"}, []interface{}{1, "a"}},
+		{[]interface{}{at}, []interface{}{at}},
+		{[]interface{}{[]byte{1}}, []interface{}{[]byte{1}}},
+		{[]interface{}{sql.Named("a", 1)}, []interface{}{sql.Named("a", 1)}},
+		{[]interface{}{map[string]interface{}{":a": 1}}, []interface{}{sql.Named("a", 1)}},
+		{[]interface{}{map[string]string{"@b": "x"}}, []interface{}{sql.Named("b", "x")}},
+		{[]interface{}{person{Name: "n", Age: 3, Note: "-", id: 1}}, []interface{}{sql.Named("name", "n"), sql.Named("Age", 3)}},
+		{[]interface{}{&person{Name: "p"}}, []interface{}{sql.Named("name", "p"), sql.Named("Age", 0)}},
+		{[]interface{}{(*person)(nil)}, []interface{}{(*person)(nil)}},
+	}
+
+	for _, tt := range tests {
+		if got, err := bindArgs(tt.in); err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bindArgs(%#v) = %#v, %v; want %#v", tt.in, got, err, tt.want)
+		}
+	}
+
+	if _, err := bindArgs([]interface{}{map[int]string{1: "a"}}); err == nil {
+		t.Error("bound a map without string keys")
+	}
+}
+
+func TestWithArgs(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+
+	dbFilePath := filepath.Join(t.TempDir(), "args.sqlite")
+	if _, err := d.ExecuteNonQuery("CREATE TABLE T (name TEXT, age INT, data BLOB, at DATETIME)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	type person struct {
+		Name string `db:"name"`
+		Age  int    `db:"age"`
+	}
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Positional, a map, a struct and a pointer to a struct; the values
+	// are not spliced into the SQL.
+	inserts := []struct {
+		sqlStatement string
+		args         []interface{}
+	}{
+		{"INSERT INTO T VALUES (?, ?, ?, ?)", []interface{}{"a", 1, []byte{0, 1}, at}},
+		{"INSERT INTO T VALUES (:name, :age, NULL, NULL)", []interface{}{map[string]interface{}{"@name": "b", "age": 2}}},
+		{"INSERT INTO T (name, age) VALUES ($name, $age)", []interface{}{person{Name: "c'; DROP TABLE T; --", Age: 3}}},
+		{"INSERT INTO T (name, age) VALUES (@name, @age)", []interface{}{&person{Name: "d", Age: 4}}},
+	}
+	for i := 0; i < len(inserts); i++ {
+		if _, err := d.ExecuteNonQueryWithArgs(inserts[i].sqlStatement, dbFilePath, inserts[i].args...); err != nil {
+			t.Fatalf("%s: %v", inserts[i].sqlStatement, err)
+		}
+	}
+
+	rows, err := d.GetDataMapWithArgs("SELECT * FROM T WHERE age >= ? ORDER BY age", dbFilePath, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows; want 4", len(rows))
+	}
+
+	// A BLOB, a time and NULL read back as written.
+	if b, ok := rows[0]["data"].([]byte); !ok || !reflect.DeepEqual(b, []byte{0, 1}) {
+		t.Errorf("got the blob %#v", rows[0]["data"])
+	}
+	if a, ok := rows[0]["at"].(time.Time); !ok || !a.Equal(at) {
+		t.Errorf("got the time %#v", rows[0]["at"])
+	}
+	if rows[1]["data"] != nil || rows[1]["at"] != nil {
+		t.Errorf("got %v; want NULLs", rows[1])
+	}
+	if rows[2]["name"] != "c'; DROP TABLE T; --" {
+		t.Errorf("got the name %v", rows[2]["name"])
+	}
+
+	tbl, err := d.GetDataTableWithArgs("SELECT name FROM T WHERE name = :name", dbFilePath, map[string]interface{}{"name": "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := tbl.Rows.GetRows(); len(r) != 1 || r[0]["name"] != "d" {
+		t.Errorf("got %v", r)
+	}
+
+	n, err := d.ExecuteScalareWithArgs("SELECT count(*) FROM T WHERE data IS NULL AND age > ?", dbFilePath, 1)
+	if err != nil || n != int64(3) {
+		t.Errorf("got %v, %v", n, err)
+	}
+}
+
+func TestFixSQLQuery(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"select Group from t", "select [Group] from t"},
+		{"select a, group from t order by group;", "select a, [Group] from t order by [Group];"},
+		{"SELECT v FROM t GROUP BY v", "SELECT v FROM t GROUP BY v"},
+		{"select v from t group\n  by v", "select v from t group\n  by v"},
+		{"select 'a group b', [group] from t where x = :Name", "select 'a group b', [group] from t where x = :Name"},
+		// The rest of the query is not lower cased.
+		{"SELECT Name FROM T WHERE Name = :Name AND x = 'Mixed Case'", "SELECT Name FROM T WHERE Name = :Name AND x = 'Mixed Case'"},
+	}
+
+	for _, tt := range tests {
+		if got := fixSQLQuery(tt.in); got != tt.want {
+			t.Errorf("fixSQLQuery(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
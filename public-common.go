@@ -1,21 +1,40 @@
 package sqlitehench
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"errors"
-	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
-	"time"
 )
 
-// Decrypt decryptes an array of bytes using the AES algorythm.
+// Decrypt decryptes an array of bytes using the AES algorythm. The data
+// of the legacy format (of the versions before the encrypted format) is
+// also decrypted.
 func Decrypt(data []byte, passphrase string) ([]byte, error) {
-	var plain []byte
+
 	if len(data) == 0 {
 		return data, nil
 	}
+
+	if !isEncrypted(data) {
+		return decryptLegacy(data, passphrase)
+	}
+
+	var buf bytes.Buffer
+	if err := decryptStream(&buf, bytes.NewReader(data), passphrase); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decryptLegacy decrypts the legacy format; i.e. a nonce, followed by the
+// AES-GCM sealed data, with the key of createHash.
+func decryptLegacy(data []byte, passphrase string) ([]byte, error) {
+	var plain []byte
 	key := []byte(createHash(passphrase))
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -34,93 +53,66 @@ func Decrypt(data []byte, passphrase string) ([]byte, error) {
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
 	plain, err = gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return plain, err
+		return plain, ErrDecrypt
 	}
 	return plain, nil
 }
 
-// Encrypt encryptes an array of bytes using the AES algorythm.
+// Encrypt encryptes an array of bytes using the AES algorythm; in the
+// encrypted format (see encrypt.go). The key is derived with scrypt,
+// which takes about 100 ms per call by design.
 func Encrypt(plainData []byte, passphrase string) ([]byte, error) {
-	var cipherData []byte
-	block, _ := aes.NewCipher([]byte(createHash(passphrase)))
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return cipherData, err
+
+	var buf bytes.Buffer
+	if err := encryptStream(&buf, bytes.NewReader(plainData), passphrase); err != nil {
+		return nil, err
 	}
-	nonceBytes := make([]byte, gcm.NonceSize())
-	cipherData = gcm.Seal(nonceBytes, nonceBytes, plainData, nil)
 
-	return cipherData, nil
+	return buf.Bytes(), nil
 }
 
-// Encrypt encryptes a file using the AES algorythm.
+// EncryptFile encryptes a file using the AES algorythm. The file is read
+// as a stream, and replaced once the encrypted file is written and synced
+// to disk; so it is left as is, if the encryption fails. A file that is
+// already encrypted is not encrypted again.
 func EncryptFile(p string, pwdPhrase string) error {
 
-	var b []byte
-	var binc []byte
-	var err error
-
-	if b, err = ioutil.ReadFile(p); err != nil {
-		return err
-	}
-
-	if binc, err = Encrypt(b, pwdPhrase); err != nil {
-		return err
-	}
-
-	np := fmt.Sprintf("%s@~%vtmp", p, time.Now().Nanosecond())
-	f, err := os.Create(np)
-	if err != nil {
-		os.Remove(np)
-		fmt.Println(err)
-	}
+	return rewriteFile(p, func(w io.Writer, f *os.File) error {
 
-	if err = os.Remove(p); err != nil {
-		return err
-	}
+		br := bufio.NewReaderSize(f, 64*1024)
+		if magic, _ := br.Peek(len(encMagic)); isEncrypted(magic) {
+			return errors.New("the file is already encrypted")
+		}
 
-	if err = os.Rename(np, p); err != nil {
-		return err
-	}
-
-	f.Write(binc)
-	f.Close()
-
-	return nil
+		return encryptStream(w, br, pwdPhrase)
+	})
 }
 
-// DecryptFile decryptes a file using the AES algorythm.
+// DecryptFile decryptes a file using the AES algorythm; as EncryptFile.
+// A file of the legacy format is also decrypted; which is read into
+// memory, as that format is not chunked.
 func DecryptFile(p string, pwdPhrase string) error {
 
-	var b []byte
-	var binc []byte
-	var err error
+	return rewriteFile(p, func(w io.Writer, f *os.File) error {
 
-	if b, err = ioutil.ReadFile(p); err != nil {
-		return err
-	}
+		br := bufio.NewReaderSize(f, 64*1024)
+		if magic, _ := br.Peek(len(encMagic)); isEncrypted(magic) {
+			return decryptStream(w, br, pwdPhrase)
+		}
 
-	if binc, err = Decrypt(b, pwdPhrase); err != nil {
-		return err
-	}
-
-	np := fmt.Sprintf("%s@~%vtmp", p, time.Now().Nanosecond())
-	f, err := os.Create(np)
-	if err != nil {
-		os.Remove(np)
-		return err
-	}
+		b, err := io.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		if len(b) == 0 {
+			return ErrNotEncrypted
+		}
+		if b, err = decryptLegacy(b, pwdPhrase); err != nil {
+			return err
+		}
 
-	if err = os.Remove(p); err != nil {
-		return err
-	}
+		_, err = w.Write(b)
 
-	if err = os.Rename(np, p); err != nil {
 		return err
-	}
-
-	f.Write(binc)
-	f.Close()
-
-	return nil
+	})
 }
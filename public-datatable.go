@@ -3,11 +3,8 @@ package sqlitehench
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
-	"reflect"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,120 +14,131 @@ import (
 
 func (d *DBAccess) GetDataTable(sqlQuery string, dbFilePath string) (*collc.Table, error) {
 
-	return d.getDataTable(sqlQuery, dbFilePath, "")
+	return d.getDataTable(context.Background(), sqlQuery, dbFilePath, "")
 }
 
-func (d *DBAccess) GetDataTableJSON(tbl *collc.Table) string {
-	cols := tbl.Cols.Get()
-	rows := tbl.Rows.GetRows()
+// GetDataTableWithArgs is GetDataTable with bind parameters.
+func (d *DBAccess) GetDataTableWithArgs(sqlQuery string, dbFilePath string, args ...interface{}) (*collc.Table, error) {
 
-	var jsnArry []string
+	return d.getDataTable(context.Background(), sqlQuery, dbFilePath, "", args...)
+}
 
-	for i := 0; i < len(rows); i++ {
-		var sa []string
-		for j := 0; j < len(cols); j++ {
-			v := rows[i][cols[j].Name]
-			if fmt.Sprintf("%v", cols[j].Type) == "string" {
-				s := fmt.Sprintf("%v", v)
-				if !strings.HasPrefix(s, "{") {
-					v = fmt.Sprintf(`"%v"`, s)
-				}
-			}
-			sa = append(sa, fmt.Sprintf(`"%s":%v`, cols[j].Name, v))
-		}
-		oneJsn := fmt.Sprintf(`{%s}`, strings.Join(sa, ","))
+// GetDataTableContext is GetDataTable with a ctx and bind parameters.
+func (d *DBAccess) GetDataTableContext(ctx context.Context, sqlQuery string, dbFilePath string, args ...interface{}) (*collc.Table, error) {
+
+	return d.getDataTable(ctx, sqlQuery, dbFilePath, "", args...)
+}
 
-		jsnArry = append(jsnArry, oneJsn)
+// GetDataTableJSON returns the rows of a DataTable as a JSON array; see
+// ExportDataTableToJSON.
+func (d *DBAccess) GetDataTableJSON(tbl *collc.Table) string {
+
+	var b bytes.Buffer
+	if _, err := d.ExportDataTableToJSON(tbl, &b, JSONOptions{}); err != nil {
+		return ""
 	}
-	allJson := fmt.Sprintf(`[%s]`, strings.Join(jsnArry, ","))
 
-	return allJson
+	return b.String()
 }
 
 func (d *DBAccess) GetDataTableWithTag(sqlQuery string, dbFilePath string, tag string) (*collc.Table, error) {
 
-	return d.getDataTable(sqlQuery, dbFilePath, tag)
+	return d.getDataTable(context.Background(), sqlQuery, dbFilePath, tag)
 }
 
+// GetDataTableLongQuery reads a query into a DataTable in one pass; notify
+// is called each time a page of rows has been fetched, and once more when
+// the query has been read. It is called on the reading goroutine; so that
+// the ResultTable is not added to while notify reads it. When notify is
+// set, the rows of the query are counted beforehand; for TotalToFetch and
+// TotalPages.
 func (dc *DBAccess) GetDataTableLongQuery(sqlQuery string, dbFilePath string, pageSize int, notify func(status LonqQueryArgs)) (*collc.Table, error) {
+	return dc.GetDataTableLongQueryContext(context.Background(), sqlQuery, dbFilePath, pageSize, notify)
+}
+
+// GetDataTableLongQueryContext is GetDataTableLongQuery with a ctx; it
+// stops fetching pages when the ctx is cancelled and returns ctx.Err().
+func (dc *DBAccess) GetDataTableLongQueryContext(ctx context.Context, sqlQuery string, dbFilePath string, pageSize int, notify func(status LonqQueryArgs)) (_ *collc.Table, err error) {
+
+	defer func() { err = wrapErr("GetDataTableLongQuery", dbFilePath, sqlQuery, err) }()
 
 	if pageSize < 1 {
 		pageSize = 1
 	}
 
 	var lqArgs LonqQueryArgs
-	var sqlRCount string
 	var coll = collc.NewCollection()
 
-	// Make a new instance for this.
-	var pragma []string = []string{
-		"PRAGMA journal_mode = WALL;",
-		"PRAGMA synchronous = OFF;",
-	}
-
-	d := NewDBAccess(DBAccess{
-		MaxIdleConns: 100, MaxOpenConns: 100,
-		ShrinkDatabaseFiles: false,
-		PRAGMA:              pragma,
-	})
-
 	if !fileOrDirExists(dbFilePath) {
-		return nil, errors.New(Err_DatabaseFileNotExists)
+		return nil, ErrDatabaseFileNotExists
 	}
 	sqlQuery = strings.ReplaceAll(sqlQuery, "\n", " ")
 	sqlQuery = strings.ReplaceAll(sqlQuery, "\t", " ")
-	sqlRCount = fmt.Sprintf("select count(*) from (%s)", sqlQuery)
 
-	// Get the record count
-	mx, err := d.ExecuteScalare(sqlRCount, dbFilePath)
+	dtLng, err := coll.Table.Create("x23343")
 	if err != nil {
 		return nil, err
 	}
-	if mx == nil || mx.(int64) < 1 {
-		return nil, errors.New(Err_NoRowsFound)
-	}
-	recCnt := int(mx.(int64))
-
-	// GetPageOffset returns totalPages, offset, pageNo
-	totalPages, offset, _ := dc.GetPageOffset(recCnt, pageSize, 1)
 
-	if recCnt < 1 {
-		return nil, errors.New("source data-table has no rows")
+	// The rows are counted for the caller; the query is then read
+	// once, with a cursor, and the caller notified each time a page
+	// of rows has been fetched.
+	var recCnt int64
+	if notify != nil {
+		sqlx := fmt.Sprintf("select count(*) from (%s)", strings.TrimSuffix(strings.TrimSpace(sqlQuery), ";"))
+		if recCnt, err = ScalarAsContext[int64](ctx, dc, sqlx, dbFilePath); err != nil {
+			return nil, err
+		}
 	}
 
-	dtLng, err := coll.Table.Create("x23343")
+	c, err := dc.OpenCursorContext(ctx, sqlQuery, dbFilePath)
 	if err != nil {
 		return nil, err
 	}
+	defer c.Close()
 
 	var allRowsAffected int64
-	var dt *collc.Table
 	tstart := time.Now()
-	for i := 0; i < totalPages; i++ {
 
-		_, offset, _ = dc.GetPageOffset(recCnt, pageSize, i+1)
+	notifyPage := func(done bool) {
 
-		sqlx := fmt.Sprintf("select * from (%s) limit %d offset %d", sqlQuery, pageSize, offset)
-		dt, err = d.GetDataTable(sqlx, dbFilePath)
-		if err != nil {
-			return nil, err
+		lqArgs.ResultTable = dtLng
+		lqArgs.RowsFetched = allRowsAffected
+		lqArgs.PageSize = pageSize
+
+		// The rows that were read, if the table has changed since
+		// it was counted.
+		if done || allRowsAffected > recCnt {
+			recCnt = allRowsAffected
 		}
-		dtLng, err = dc.AppendDataTable(dt, dtLng)
-		allRowsAffected += int64(dt.Rows.Count())
-		if notify != nil {
+		lqArgs.TotalToFetch = recCnt
+		lqArgs.TotalPages, _, _ = dc.GetPageOffset(int(recCnt), pageSize, 1)
 
-			lqArgs.ResultTable = dtLng
-			lqArgs.RowsFetched = allRowsAffected
-			lqArgs.TotalToFetch = int64(recCnt)
+		lqArgs.Status = fmt.Sprintf("copied => rows: %s of %s, elapsed: %v",
+			formatNumber(allRowsAffected), formatNumber(recCnt), durationToString(time.Since(tstart)))
 
-			msg := fmt.Sprintf("copied => rows: %s of %s, elapsed: %v",
-				formatNumber(allRowsAffected), formatNumber(int64(recCnt)), durationToString(time.Since(tstart)))
+		notify(lqArgs)
+	}
 
-			lqArgs.Status = msg
+	for c.Next() {
+		if err = c.AppendTo(dtLng); err != nil {
+			return nil, err
+		}
+		allRowsAffected++
 
-			go notify(lqArgs)
+		if notify != nil && allRowsAffected%int64(pageSize) == 0 {
+			notifyPage(false)
 		}
-		offset += pageSize + 1
+	}
+	if err = c.Err(); err != nil {
+		return nil, err
+	}
+
+	if allRowsAffected < 1 {
+		return nil, fmt.Errorf("source data-table has no rows; %w", ErrNoRowsFound)
+	}
+	if notify != nil {
+		notifyPage(true)
 	}
 
 	return dtLng, nil
@@ -144,7 +152,7 @@ func (d *DBAccess) CloneDataTable(dtSrce *collc.Table) (*collc.Table, error) {
 
 	tableName := dtSrce.Name
 	if tableName == "" {
-		return nil, errors.New("malfomred DataTable; table-name not found")
+		return nil, fmt.Errorf("malformed DataTable; %w", ErrTableNameNotFound)
 	}
 
 	dtDest, err := coll.Table.Create(tableName)
@@ -179,7 +187,7 @@ func (d *DBAccess) GetDataTableRange(dtSrc *collc.Table, dtDest *collc.Table, fr
 
 	tableName := dtSrc.Name
 	if tableName == "" {
-		return errors.New("malfomred DataTable; table-name not found")
+		return fmt.Errorf("malformed DataTable; %w", ErrTableNameNotFound)
 	}
 
 	srcCols := dtSrc.Cols.Get()
@@ -209,7 +217,7 @@ func (d *DBAccess) AppendDataTable(dtSrce *collc.Table, dtDest *collc.Table) (*c
 
 	tableName := dtSrce.Name
 	if tableName == "" {
-		return nil, errors.New("malfomred DataTable; table-name not found")
+		return nil, fmt.Errorf("malformed DataTable; %w", ErrTableNameNotFound)
 	}
 
 	destCols := dtDest.Cols.Get()
@@ -266,65 +274,37 @@ func (d *DBAccess) getDeDupedColNames(cols []collc.Column) []string {
 	return c
 }
 func (d *DBAccess) CreateNewDatabase(tbl *collc.Table, dbFilePath string) (int64, error) {
-	if fileOrDirExists(dbFilePath) {
-		// drop the target table
-		sqlx := fmt.Sprintf("DROP TABLE IF EXISTS [%s]", tbl.Name)
-		d.ExecuteNonQuery(sqlx, dbFilePath)
-	}
-	// Get the DataTable columns
-	cols := tbl.Cols.Get()
-
-	sb := strings.Builder{}
-
-	sb.WriteString(fmt.Sprintf("CREATE TABLE [%s] (", tbl.Name))
-
-	colsDeduped := d.getDeDupedColNames(cols)
-
-	for i := 0; i < len(colsDeduped)-1; i++ {
-		colName := colsDeduped[i]
-		sb.WriteString(fmt.Sprintf("[%s] Blob NULL,", colName))
-	}
-	sb.WriteString(fmt.Sprintf("[%s] Blob NULL);", cols[len(cols)-1].Name))
-
-	// Create the table
-	sqlx := sb.String()
-
-	rowsAffected, err := d.ExecuteNonQuery(sqlx, dbFilePath)
-
-	if err != nil {
-		return -1, err
-	}
+	return d.CreateNewDatabaseContext(context.Background(), tbl, dbFilePath)
+}
 
-	return rowsAffected, nil
+// CreateNewDatabaseContext is CreateNewDatabase with a ctx.
+func (d *DBAccess) CreateNewDatabaseContext(ctx context.Context, tbl *collc.Table, dbFilePath string) (int64, error) {
+	return d.CreateNewDatabaseWithOptions(ctx, tbl, dbFilePath, CreateTableOptions{})
 }
 
-// UNDONE
-// func (d *DBAccess) StreamCSVFileToDatabase(csvFilePath, dbFilePath string) (int64, error) {
-// 	var rowsAffected int64
-// 	var err error
+// StreamCSVFileToDatabase imports a CSV file into a table of the same
+// name as the file; the table is created (with the column types inferred
+// from the values) if it does not exist, or the rows are appended to it.
+// See StreamCSVFileToDatabaseWithOptions.
+func (d *DBAccess) StreamCSVFileToDatabase(csvFilePath, dbFilePath string) (int64, error) {
+	return d.StreamCSVFileToDatabaseContext(context.Background(), csvFilePath, dbFilePath)
+}
 
-// 	return rowsAffected, err
-// }
+// StreamCSVFileToDatabaseContext is StreamCSVFileToDatabase with a ctx.
+func (d *DBAccess) StreamCSVFileToDatabaseContext(ctx context.Context, csvFilePath, dbFilePath string) (int64, error) {
+	return d.StreamCSVFileToDatabaseWithOptions(ctx, csvFilePath, dbFilePath, CSVImportOptions{})
+}
 
 // ExportDataTableToDatabase creates a new table based on a Table.
 // It creates a new table if table not exists. By default if the db
 // file does not exist, it will be created.
 func (d *DBAccess) ExportDataTableToDatabase(tbl *collc.Table, dbFilePath string) (int64, error) {
+	return d.ExportDataTableToDatabaseContext(context.Background(), tbl, dbFilePath)
+}
 
-	rowCount := tbl.Rows.Count()
-
-	if rowCount < 1 {
-		return -1, errors.New(Err_NoRowsFound)
-	}
-
-	d.CreateNewDatabase(tbl, dbFilePath)
-
-	rowfAffected, err := d.InsertDataTable(tbl, dbFilePath, nil)
-	if err != nil {
-		return rowfAffected, err
-	}
-
-	return rowfAffected, nil
+// ExportDataTableToDatabaseContext is ExportDataTableToDatabase with a ctx.
+func (d *DBAccess) ExportDataTableToDatabaseContext(ctx context.Context, tbl *collc.Table, dbFilePath string) (int64, error) {
+	return d.ExportDataTableToDatabaseWithOptions(ctx, tbl, dbFilePath, CreateTableOptions{})
 }
 
 // InsertDataTable inserts a DataTable collection into a datbase table.
@@ -335,413 +315,105 @@ func (d *DBAccess) InsertDataTable(t *collc.Table, dbFilePath string, wg *sync.W
 		defer wg.Done()
 	}
 
-	var err error
-
-	rowCount := t.Rows.Count()
+	return d.InsertDataTableContext(context.Background(), t, dbFilePath, nil)
+}
 
-	if rowCount < 1 {
-		return -1, errors.New(Err_NoRowsFound)
-	}
+// InsertDataTableContext is InsertDataTable with a ctx. Rows are not
+// inserted once the ctx is cancelled; and ctx.Err() is returned.
+func (d *DBAccess) InsertDataTableContext(ctx context.Context, t *collc.Table, dbFilePath string, wg *sync.WaitGroup) (_ int64, err error) {
 
-	if err = d.validateInsertEntry(t, dbFilePath); err != nil {
-		return -1, err
+	if wg != nil {
+		defer wg.Done()
 	}
 
-	t.Name = strings.Trim(t.Name, " ")
-
-	// Get the DataTable columns
-	cols := t.Cols.Get()
-
-	colName := make([]string, len(cols))
-	for i := 0; i < len(cols); i++ {
-		if !strings.HasPrefix(cols[i].Name, "[") {
-			colName[i] = fmt.Sprintf("[%s]", cols[i].Name)
-		} else {
-			colName[i] = cols[i].Name
-		}
-	}
+	ctx, done := d.startRetryScope(ctx, "InsertDataTable", dbFilePath)
+	defer func() {
+		err = wrapErr("InsertDataTable", dbFilePath, "", err)
+		done(err)
+	}()
 
-	// Wrap the table name to avoid keyword clashes (i.e. Group)
-	tName := t.Name
-	if !strings.HasPrefix(t.Name, "[") {
-		tName = fmt.Sprintf("[%s]", t.Name)
-	}
+	rowCount := t.Rows.Count()
 
-	// Get the database columns.
-	sqlx := fmt.Sprintf("select * from %s limit 1", tName)
-	tMaster, err := d.GetDataTable(sqlx, dbFilePath)
-	if err != nil {
-		return -1, err
+	if rowCount < 1 {
+		return -1, ErrNoRowsFound
 	}
 
-	destCols := tMaster.Cols.Get()
-
-	var allRowsAffected int64
-	var rowsAffected int64
-
-	db, err := d.GetDB(dbFilePath)
-	if err != nil {
+	if err = d.validateInsertEntry(ctx, t, dbFilePath); err != nil {
 		return -1, err
 	}
-	defer db.Close()
 
-	ctx := context.Background()
-
-	tx, err := db.BeginTx(ctx, nil)
+	db, release, err := d.acquireDB(dbFilePath, true)
 	if err != nil {
 		return -1, err
 	}
+	defer release()
 
-	for k := 0; k < rowCount; k++ {
-
-		// TODO: make this an option
-		//rowsAffected, err = d.insertOneDataTableRowPointToDB(ctx, tx, t, tName, k, cols, destCols, db)
-
-		// write to disk immediately
-		rowsAffected, err = d.insertOneDataTableRow(t, tName, k, cols, destCols, dbFilePath, nil)
-		if err != nil {
-			tx.Rollback()
-			return -1, err
-		}
-
-		allRowsAffected = allRowsAffected + rowsAffected
-	}
-
-	tx.Commit()
-
-	return allRowsAffected, nil
+	// write to disk immediately; one row per transaction.
+	return d.writeTableRows(ctx, db, t, BulkInsertOptions{BatchSize: 1})
 }
-func (d *DBAccess) InsertSingleRow(t *collc.Table, rowInx int, dbFilePath string) (int64, error) {
 
-	var err error
-	var rowsAffected int64
+// InsertSingleRow inserts a row (by its index) of a DataTable into the
+// table of the same name; with bind parameters, so that the values are
+// not spliced into the SQL (and the encrypted columns are encrypted).
+func (d *DBAccess) InsertSingleRow(t *collc.Table, rowInx int, dbFilePath string) (_ int64, err error) {
 
-	var inserts []string
-	var values []string
+	ctx, done := d.startRetryScope(context.Background(), "InsertSingleRow", dbFilePath)
+	defer func() {
+		err = wrapErr("InsertSingleRow", dbFilePath, "", err)
+		done(err)
+	}()
 
-	// A row must have at least one none-null value.
-	atleastOneNoneNULL := false
 	row := t.Rows.GetRow(rowInx)
-
 	if row == nil {
-		return -1, errors.New("there is no row in the data-table")
-	}
-
-	cols := t.Cols.Get()
-	rowColCount := len(cols)
-
-	for i := 0; i < rowColCount; i++ {
-
-		colValue := row[cols[i].Name]
-
-		// column name for insert
-		ins := fmt.Sprintf("[%s]", cols[i].Name)
-
-		inserts = append(inserts, ins)
-		var p string
-
-		colValue, isValStr := d.isString(colValue)
-		if isValStr {
-			if colValue == nil {
-				p = "NULL"
-			} else {
-				var s string
-				atleastOneNoneNULL = true
-				strValue := fmt.Sprintf("%v", colValue)
-				_, errx := strconv.Atoi(strValue)
-				if errx != nil {
-					strx := fmt.Sprintf("%v", colValue)
-					s = strings.ReplaceAll(strx, "<nil>", "")
-					p = fmt.Sprintf("'%s'", strings.ReplaceAll(s, "'", "''"))
-				} else {
-					p = strValue
-					p = strings.ReplaceAll(p, "'", "''")
-				}
-			}
-		} else {
-			if colValue == nil {
-				p = "NULL"
-			} else {
-				atleastOneNoneNULL = true
-				p = fmt.Sprintf("%v", colValue)
-				p = strings.ReplaceAll(p, "'", "''")
-			}
-		}
-		values = append(values, p)
-	}
-	if len(inserts) == 0 || !atleastOneNoneNULL {
-		// Nothing was found to insert
-		return 0, nil
-
-	}
-	for i := 0; i < len(values); i++ {
-		needSingQt := false
-		values[i], needSingQt = d.needsSingleQuotes(values[i])
-		if needSingQt {
-			hasSngOnBothSides := strings.HasPrefix(values[i], "'") && strings.HasSuffix(values[i], "'")
-			if !hasSngOnBothSides {
-				values[i] = strings.ReplaceAll(values[i], "'", "'")
-				values[i] = fmt.Sprintf("'%s'", values[i])
-			}
-		}
-	}
-	sqlx := fmt.Sprintf("insert into [%s] (%s) values(%s)", t.Name, strings.Join(inserts, ","), strings.Join(values, ","))
-	rowsAffected = 0
-
-	rowsAffected, err = d.ExecuteNonQuery(sqlx, dbFilePath)
-	if err != nil {
-		return -1, err
-	}
-
-	return rowsAffected, err
-}
-func (d *DBAccess) needsSingleQuotes(strVal string) (string, bool) {
-
-	b := bytes.ReplaceAll([]byte(strVal), []byte(`\"`), []byte(`"`))
-	strVal = string(b)
-
-	_, err := strconv.Atoi(strVal)
-	if err == nil {
-		return strVal, false
-	}
-
-	if (strings.Contains(strVal, "/") || strings.Contains(strVal, "-")) &&
-		strings.Contains(strVal, ":") && strings.Contains(strVal, " ") {
-		_, err := d.convertStringToTime(strVal)
-		if err == nil {
-			return strVal, true
-		}
-	}
-
-	// In SQLite true and false is 1 and 0.
-	if strings.ToLower(strVal) == "false" {
-		return "0", false
-	}
-	if strings.ToLower(strVal) == "true" {
-		return "0", false
+		return -1, fmt.Errorf("there is no row in the data-table; %w", ErrNoRowsFound)
 	}
-	if strings.Contains(strVal, `"`) {
-		return strVal, true
-	}
-
-	return strVal, true
-}
-func (d *DBAccess) insertOneDataTableRow(t *collc.Table, tName string, k int, cols []collc.Column, destCols []collc.Column, dbFilePath string, wg *sync.WaitGroup) (int64, error) {
-
-	if wg != nil {
-		defer wg.Done()
-	}
-
-	var err error
-	var rowsAffected int64
-
-	var inserts []string
-	var values []string
-
-	// A row must have atleat one none-null value.
-	atleastOneNoneNULL := false
-	row := t.Rows.GetRow(k)
-	rowCols := t.Rows.GetColumns()
-	rowColCount := len(rowCols)
-
-	for i := 0; i < len(cols); i++ {
 
-		// Omit the columns that do not exist in the table
-		ignoreCase := true
-		existsInDb := valueExistsInArry(destCols, cols[i].Name, ignoreCase)
-
-		// skip - if the col name from source, does not exist in the database.
-		// Here is where SQL injection gets rejected!
-		if !existsInDb {
-			continue
-		}
-
-		// get one value
-		if i >= rowColCount {
-			break
-		}
-
-		iValue := row[cols[i].Name]
-
-		// column name for insert
-		ins := fmt.Sprintf("[%s]", cols[i].Name)
-
-		inserts = append(inserts, ins)
-		var p string
-
-		iValue, isValStr := d.isString(iValue)
-
-		if isValStr {
-			if iValue == nil {
-				p = "NULL"
-			} else {
-				strVal := fmt.Sprintf("%v", iValue)
-				atleastOneNoneNULL = true
-				strVal = strings.ReplaceAll(strVal, "<nil>", "")
-				p = fmt.Sprintf("'%s'", strings.ReplaceAll(strVal, "'", "''"))
-			}
-		} else {
-			if iValue == nil {
-				p = "NULL"
-			} else {
-				atleastOneNoneNULL = true
-				p = fmt.Sprintf("%v", iValue)
-			}
-		}
-		values = append(values, p)
-	}
-	if len(inserts) == 0 || !atleastOneNoneNULL {
-		// Nothing was found to insert
-		return 0, nil
+	if !fileOrDirExists(dbFilePath) {
+		return -1, ErrDatabaseFileNotExists
 	}
-	sqlx := fmt.Sprintf("insert into %s (%s) values(%s)", tName, strings.Join(inserts, ","), strings.Join(values, ","))
-	rowsAffected = 0
 
-	rowsAffected, err = d.ExecuteNonQuery(sqlx, dbFilePath)
+	one, err := collc.NewCollection().Table.Create(t.Name)
 	if err != nil {
 		return -1, err
 	}
 
-	return rowsAffected, err
-}
-func (d *DBAccess) insertOneDataTableRowPointToDB(ctx context.Context, tx *sql.Tx, t *collc.Table, tName string, k int,
-	cols []collc.Column, destCols []collc.Column, db *sql.DB) (int64, error) {
-
-	var err error
-	var rowsAffected int64
-
-	var inserts []string
-	var values []string
-
-	// A row must have atleat one none-null value.
+	// A row must have at least one none-null value.
 	atleastOneNoneNULL := false
 
-	rowArry := t.Rows.GetRows()
-	rowCols := t.Rows.GetColumns()
-	rowColCount := len(rowCols)
-
+	cols := t.Cols.Get()
+	oneRow := one.Rows.New()
 	for i := 0; i < len(cols); i++ {
-
-		// Omit the columns that do not exist in the table
-		ignoreCase := true
-		existsInDb := valueExistsInArry(destCols, cols[i].Name, ignoreCase)
-
-		// skip - if the col name from source, does not exist in the database.
-		// Here is where SQL injection gets rejected!
-		if !existsInDb {
-			continue
+		one.Cols.Add(cols[i].Name)
+		oneRow[cols[i].Name] = row[cols[i].Name]
+		if row[cols[i].Name] != nil {
+			atleastOneNoneNULL = true
 		}
-
-		if i >= rowColCount {
-			break
-		}
-
-		// get one value
-		iValue := rowArry[k][cols[i].Name]
-
-		// column name for insert
-		ins := fmt.Sprintf("[%s]", cols[i].Name)
-
-		inserts = append(inserts, ins)
-		var p string
-
-		iValue, isValStr := d.isString(iValue)
-		if isValStr {
-			if iValue == nil {
-				p = "NULL"
-			} else {
-				strVal := fmt.Sprintf("%v", iValue)
-				atleastOneNoneNULL = true
-				strVal = strings.ReplaceAll(strVal, "<nil>", "")
-				p = fmt.Sprintf("'%s'", strings.ReplaceAll(strVal, "'", "''"))
-			}
-		} else {
-			if iValue == nil {
-				p = "NULL"
-			} else {
-				if reflect.TypeOf(iValue).Kind() == reflect.String {
-					strVal := fmt.Sprintf("%v", iValue)
-					atleastOneNoneNULL = true
-					strVal = strings.ReplaceAll(strVal, "<nil>", "")
-					p = fmt.Sprintf("'%s'", strings.ReplaceAll(strVal, "'", "''"))
-				} else {
-					atleastOneNoneNULL = true
-					p = fmt.Sprintf("%v", iValue)
-
-				}
-			}
-		}
-		values = append(values, p)
 	}
-	if len(inserts) == 0 || !atleastOneNoneNULL {
+	if !atleastOneNoneNULL {
 		// Nothing was found to insert
 		return 0, nil
-
 	}
-	sqlx := fmt.Sprintf("insert into %s (%s) values(%s)", tName, strings.Join(inserts, ","), strings.Join(values, ","))
-	rowsAffected = 0
 
-	result, err := tx.ExecContext(ctx, sqlx)
+	db, release, err := d.acquireDB(dbFilePath, true)
 	if err != nil {
-		tx.Rollback()
 		return -1, err
 	}
+	defer release()
 
-	if result != nil {
-		rowsAffected, err = result.RowsAffected()
-		if err != nil {
-			tx.Rollback()
-			return -1, err
-		}
-	}
-
-	return rowsAffected, err
-}
-
-// isString determins if a value is string. It is used for building SQL staements.
-func (d *DBAccess) isString(value interface{}) (interface{}, bool) {
-
-	strVal := fmt.Sprintf("%v", value)
-
-	if _, err := strconv.ParseFloat(strVal, 64); err == nil {
-		return value, false
-	}
-
-	if _, err := strconv.Atoi(strVal); err == nil {
-		return value, false
-	}
-
-	// Date
-	if (strings.Contains(strVal, "/") || strings.Contains(strVal, "-")) &&
-		strings.Contains(strVal, ":") && strings.Contains(strVal, " ") {
-		_, err := convertStringToTime(strVal)
-		if err == nil {
-			// the type is date; in sql staement still needs a single quote
-			return strVal, true
-		}
-	}
-
-	if strings.ToLower(strVal) == "false" {
-		return 0, false
-	}
-	if strings.ToLower(strVal) == "true" {
-		return 1, false
-	}
-
-	return value, true
+	return d.writeTableRows(ctx, db, one, BulkInsertOptions{BatchSize: 1})
 }
 
 // GetDataMap gets a selected range of table in form of rows and columns.
-func (d *DBAccess) getDataTable(sqlQuery string, dbFilePath string, tag string) (*collc.Table, error) {
+func (d *DBAccess) getDataTable(ctx context.Context, sqlQuery string, dbFilePath string, tag string, args ...interface{}) (_ *collc.Table, err error) {
 
 	var coll = collc.NewCollection()
-	var db *sql.DB
-	var err error
 
 	if !fileOrDirExists(dbFilePath) {
-		return nil, errors.New("database file does not exists")
+		return nil, ErrDatabaseFileNotExists
 	}
 
+	defer func() { err = wrapErr("GetDataTable", dbFilePath, sqlQuery, err) }()
+
 	if d.ShrinkDatabaseFiles {
 		// Read operation; but still add to the list - as some
 		// write operations may have taken a long time... and still
@@ -749,30 +421,34 @@ func (d *DBAccess) getDataTable(sqlQuery string, dbFilePath string, tag string)
 		go d.AddDBFileToShrinkWatchList(dbFilePath)
 	}
 
-	if db, err = d.GetDB(dbFilePath); err != nil {
-		return nil, err
-	}
-	tableName := ""
-	sqlQuery = strings.TrimSpace(sqlQuery)
-	sqlQueryLower := strings.ToLower(sqlQuery)
-	if strings.HasPrefix(sqlQueryLower, "pragma ") {
-		sqlQueryLower = strings.ReplaceAll(sqlQueryLower, "  ", " ")
-		sqlQueryLower = strings.TrimSuffix(sqlQueryLower, ";")
-		sqlQueryLower = strings.ReplaceAll(sqlQueryLower, "pragma", "")
-		sqlQueryLower = strings.ReplaceAll(sqlQueryLower, "table_info", "")
-		sqlQueryLower = strings.TrimSpace(sqlQueryLower)
-		sqlQueryLower = strings.TrimPrefix(sqlQueryLower, "(")
-		sqlQueryLower = strings.TrimSuffix(sqlQueryLower, ")")
-		tableName = strings.TrimSpace(sqlQueryLower)
-		sqlQuery = fmt.Sprintf("select * from %s limit 0", tableName)
-	} else {
-		tableName = d.getTableNameFromSQLQuery(sqlQuery)
+	st := analyzeSQL(sqlQuery)
+
+	if st.Kind == SQLPragma && st.Pragma == "table_info" && st.Target != "" {
+		// An empty table with the columns of the table.
+		sqlQuery = fmt.Sprintf("select * from [%s] limit 0", st.Target)
+		st.Source = st.Target
 	}
 
+	// The DataTable is named after the table that the rows come from;
+	// or else the first table of the query (i.e. INSERT ... RETURNING).
+	tableName := st.Source
+	if tableName == "" && st.Target != "" {
+		tableName = st.Target
+	}
+	if tableName == "" && len(st.Tables) > 0 {
+		tableName = st.Tables[0]
+	}
 	if tableName == "" {
-		return nil, errors.New("malfomred query; table-name not found")
+		// i.e. select 1
+		tableName = "result"
 	}
 
+	db, release, err := d.acquireDB(dbFilePath, st.Writes())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	tbl, err := coll.Table.Create(tableName)
 	if err != nil {
 		return nil, errors.New(err.Error())
@@ -780,10 +456,21 @@ func (d *DBAccess) getDataTable(sqlQuery string, dbFilePath string, tag string)
 
 	sqlQuery = fixSQLQuery(sqlQuery)
 
-	rows, err := db.Query(sqlQuery)
-	if err != nil {
+	c := d.columnCrypter()
+	if args, err = c.encryptArgs(sqlQuery, args); err != nil {
+		return nil, err
+	}
+
+	if args, err = bindArgs(args); err != nil {
 		return nil, err
 	}
+
+	rows, err := db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	defer rows.Close()
+
 	cols, err := rows.Columns()
 	if err != nil {
 		return nil, err
@@ -793,6 +480,13 @@ func (d *DBAccess) getDataTable(sqlQuery string, dbFilePath string, tag string)
 		tbl.Cols.Add(cols[i])
 	}
 
+	// The tables of the encrypted columns; a value of any column (i.e.
+	// an alias) may be of one of them.
+	var tables []string
+	if c != nil {
+		tables = statementTables(st)
+	}
+
 	columns := make([]interface{}, len(cols))
 	columnPointers := make([]interface{}, len(cols))
 
@@ -803,7 +497,7 @@ func (d *DBAccess) getDataTable(sqlQuery string, dbFilePath string, tag string)
 	for rows.Next() {
 
 		if err := rows.Scan(columnPointers...); err != nil {
-			return nil, err
+			return nil, ctxErr(ctx, err)
 		}
 
 		oneRow := tbl.Rows.New()
@@ -814,11 +508,17 @@ func (d *DBAccess) getDataTable(sqlQuery string, dbFilePath string, tag string)
 				oneRow[cols[i]] = *val
 			}
 		}
-	}
 
-	rows.Close()
+		for i := 0; i < len(cols) && c != nil; i++ {
+			if oneRow[cols[i]], err = c.decryptValue(tables, cols[i], oneRow[cols[i]]); err != nil {
+				return nil, fmt.Errorf("column %s: %w", cols[i], err)
+			}
+		}
+	}
 
-	db.Close()
+	if err = rows.Err(); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
 
 	return tbl, nil
 }
@@ -24,15 +24,15 @@ import (
 // mode for read/write operations.
 func (d *DBAccess) GetDB(dbFilePath string) (*sql.DB, error) {
 
-	db, err := sql.Open(d.driverName, dbFilePath)
+	db, err := sql.Open(d.pragmaDriver, d.dataSource(dbFilePath))
 	if db != nil {
 		// Close first.
 		db.Close()
 
 		// Re-open.
-		db, err = sql.Open(d.driverName, dbFilePath)
+		db, err = sql.Open(d.pragmaDriver, d.dataSource(dbFilePath))
 		if err != nil {
-			return nil, err
+			return nil, wrapErr("GetDB", dbFilePath, "", err)
 		}
 	}
 
@@ -41,23 +41,18 @@ func (d *DBAccess) GetDB(dbFilePath string) (*sql.DB, error) {
 			// Try to close the lingering connection once; as the lock
 			// might have already been removed.
 			db.Close()
-			db, err = sql.Open(d.driverName, dbFilePath)
+			db, err = sql.Open(d.pragmaDriver, d.dataSource(dbFilePath))
 			if err != nil {
 				db.Close()
-				return db, err
+				return db, wrapErr("GetDB", dbFilePath, "", err)
 			}
 			// Succeeded; db lock is gone.
 		} else {
 			db.Close()
-			return db, err
+			return db, wrapErr("GetDB", dbFilePath, "", err)
 		}
 	}
 
-	// Apply the PRAGMA here.
-	for i := 0; i < len(d.PRAGMA); i++ {
-		db.Exec(d.PRAGMA[i])
-	}
-
 	db.SetMaxIdleConns(int(d.MaxIdleConns))
 	db.SetMaxOpenConns(int(d.MaxOpenConns))
 
@@ -78,8 +73,25 @@ func (d *DBAccess) DatabaseExists(path string) bool {
 	return true
 }
 
-// ExecuteScalare returns one value and closes the database.
+// ExecuteScalare returns one value. The database is kept open, or closed,
+// as the ConnPolicy; i.e. under the default ConnPolicyCloseAfterWrite a
+// read stays pooled, and a write closes the database.
 func (d *DBAccess) ExecuteScalare(sqlStatement string, dbFilePath string) (interface{}, error) {
+	return d.ExecuteScalareWithArgs(sqlStatement, dbFilePath)
+}
+
+// ExecuteScalareWithArgs is ExecuteScalare with args.
+// The args are passed to the driver as bind parameters; either positional
+// (?, ?NNN), or named (:name, @name, $name) when args is a single
+// map[string]interface{} or a struct.
+func (d *DBAccess) ExecuteScalareWithArgs(sqlStatement string, dbFilePath string, args ...interface{}) (interface{}, error) {
+	return d.ExecuteScalareContext(context.Background(), sqlStatement, dbFilePath, args...)
+}
+
+// ExecuteScalareContext is ExecuteScalareWithArgs with a ctx. The
+// running statement is interrupted when the ctx is cancelled; in which
+// case ctx.Err() is returned.
+func (d *DBAccess) ExecuteScalareContext(ctx context.Context, sqlStatement string, dbFilePath string, args ...interface{}) (interface{}, error) {
 
 	if d.ShrinkDatabaseFiles {
 		// ExecuteScalare is a read operation; but still add
@@ -90,38 +102,68 @@ func (d *DBAccess) ExecuteScalare(sqlStatement string, dbFilePath string) (inter
 		}
 	}
 
-	var db *sql.DB
-	var err error
-	var item interface{}
-
-	if db, err = d.GetDB(dbFilePath); err != nil {
+	db, release, err := d.acquireDB(dbFilePath, analyzeSQL(sqlStatement).Writes())
+	if err != nil {
 		return nil, err
 	}
 
-	item, err = executeScalare(sqlStatement, db)
+	var item interface{}
+	c := d.columnCrypter()
+	args, err = c.encryptArgs(sqlStatement, args)
+	if err == nil {
+		item, err = executeScalare(ctx, sqlStatement, db, args...)
+	}
+	if err == nil && c != nil {
+		item, err = c.decryptValue(statementTables(analyzeSQL(sqlStatement)), "", item)
+	}
 
-	db.Close()
+	release()
 
-	return item, err
+	return item, wrapErr("ExecuteScalare", dbFilePath, sqlStatement, err)
 }
 
 func (d *DBAccess) GetTableCount(tableName string, dbFilePath string) (int64, error) {
+	return d.GetTableCountContext(context.Background(), tableName, dbFilePath)
+}
+
+// GetTableCountContext returns the number of rows in a table.
+func (d *DBAccess) GetTableCountContext(ctx context.Context, tableName string, dbFilePath string) (int64, error) {
 
 	sqlx := fmt.Sprintf("select count(*) from [%s]", tableName)
-	m, err := d.ExecuteScalare(sqlx, dbFilePath)
-	if err != err {
+	m, err := d.ExecuteScalareContext(ctx, sqlx, dbFilePath)
+	if err != nil {
 		return -1, err
 	}
 
-	return m.(int64), nil
+	count, _ := m.(int64)
+
+	return count, nil
 }
 
-// ExecuteScalare returns one value and closes the database.
+// ExecuteScalarePointToDB returns one value; it keeps the database open.
 func (d *DBAccess) ExecuteScalarePointToDB(sqlStatement string, db *sql.DB) (interface{}, error) {
 
-	item, err := executeScalare(sqlStatement, db)
+	item, err := executeScalare(context.Background(), sqlStatement, db)
 
-	return item, err
+	return item, wrapErr("ExecuteScalarePointToDB", "", sqlStatement, err)
+}
+
+// ExecuteScalareWithArgsPointToDB returns one value using bind
+// parameters; it keeps the database open.
+func (d *DBAccess) ExecuteScalareWithArgsPointToDB(sqlStatement string, db *sql.DB, args ...interface{}) (interface{}, error) {
+
+	item, err := executeScalare(context.Background(), sqlStatement, db, args...)
+
+	return item, wrapErr("ExecuteScalarePointToDB", "", sqlStatement, err)
+}
+
+// ExecuteScalarePointToDBContext returns one value; it keeps the
+// database open.
+func (d *DBAccess) ExecuteScalarePointToDBContext(ctx context.Context, sqlStatement string, db *sql.DB, args ...interface{}) (interface{}, error) {
+
+	item, err := executeScalare(ctx, sqlStatement, db, args...)
+
+	return item, wrapErr("ExecuteScalarePointToDB", "", sqlStatement, err)
 }
 
 func (d *DBAccess) fixQuery(sqlx string) string {
@@ -139,194 +181,161 @@ func (d *DBAccess) fixQuery(sqlx string) string {
 //  3. It reduces lingering locks, where the database file stays locked
 //     albite closing all database handles.
 func (d *DBAccess) ExecuteNonQuery(sqlStatement string, dbFilePath string) (int64, error) {
+	return d.ExecuteNonQueryWithArgs(sqlStatement, dbFilePath)
+}
+
+// ExecuteNonQueryWithArgs is ExecuteNonQuery with bind parameters. Values
+// are never spliced into the SQL text; so BLOBs, time.Time and NULLs (nil
+// or a nil pointer) reach the database with their own types.
+func (d *DBAccess) ExecuteNonQueryWithArgs(sqlStatement string, dbFilePath string, args ...interface{}) (int64, error) {
+	return d.ExecuteNonQueryContext(context.Background(), sqlStatement, dbFilePath, args...)
+}
 
-	if d.ShrinkDatabaseFiles && !d.itemExists(dbFilePath) {
+// ExecuteNonQueryContext is ExecuteNonQuery with a ctx and bind parameters.
+// If the ctx is cancelled, the running statement is interrupted, the
+// transaction is rolled back and ctx.Err() is returned.
+func (d *DBAccess) ExecuteNonQueryContext(ctx context.Context, sqlStatement string, dbFilePath string, args ...interface{}) (int64, error) {
+
+	if d.ShrinkDatabaseFiles && !d.itemExists(dbFilePath) && analyzeSQL(sqlStatement).Writes() {
 		go d.AddDBFileToShrinkWatchList(dbFilePath)
 	}
 
-	var db *sql.DB
-	var err error
+	ctx, done := d.startRetryScope(ctx, "ExecuteNonQuery", dbFilePath)
 
-	if db, err = d.GetDB(dbFilePath); err != nil {
+	db, release, err := d.acquireDB(dbFilePath, true)
+	if err != nil {
+		done(err)
 		return -1, err
 	}
 
 	sqlStatement = d.fixQuery(sqlStatement)
 
-	// this is a rough estimate (https://sqlite.org/limits.html),
-	// but it'd be good to prevent this to go thru.
-	if len(sqlStatement) > 1000000000 {
-		db.Close()
-		return -1, errors.New("query length exceeded max length of 1000000000 bytes")
+	var rowsAffected int64
+	args, err = d.columnCrypter().encryptArgs(sqlStatement, args)
+	if err == nil {
+		err = d.withRetry(ctx, func() error {
+			rowsAffected, err = executeNonQuery(ctx, sqlStatement, db, args...)
+			return err
+		})
 	}
 
-	ctx := context.Background()
-	tx, err := db.BeginTx(ctx, nil)
-	if err != nil {
-		db.Close()
-		return -1, err
-	}
+	release()
+
+	err = wrapErr("ExecuteNonQuery", dbFilePath, sqlStatement, err)
+	done(err)
 
-	result, err := tx.ExecContext(ctx, sqlStatement)
 	if err != nil {
-		tx.Rollback()
-		db.Close()
 		return -1, err
 	}
 
-	var rowsAffected int64 = -1
-
-	if result != nil {
-		rowsAffected, err = result.RowsAffected()
-		if err != nil {
-			tx.Rollback()
-			db.Close()
-			return -1, err
-		}
-	}
-	tx.Commit()
-
-	db.Close()
-
-	return rowsAffected, err
+	return rowsAffected, nil
 }
 
 func (d *DBAccess) ExecuteNonQueryNoTxPointToDB(sqlStatement string, db *sql.DB) (int64, error) {
+	rowsAffected, err := executeNonQueryNoTx(context.Background(), sqlStatement, db)
+	return rowsAffected, wrapErr("ExecuteNonQueryNoTxPointToDB", "", sqlStatement, err)
+}
 
-	if len(sqlStatement) > 1000000000 {
-		return -1, errors.New("query length exceeded max length of 1000000000 bytes")
-	}
-
-	result, err := db.Exec(sqlStatement)
-	if err != nil {
-		return -1, err
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return -1, err
-	}
+// ExecuteNonQueryNoTxWithArgsPointToDB uses no transaction context and
+// keeps the database open; args are passed as bind parameters.
+func (d *DBAccess) ExecuteNonQueryNoTxWithArgsPointToDB(sqlStatement string, db *sql.DB, args ...interface{}) (int64, error) {
+	rowsAffected, err := executeNonQueryNoTx(context.Background(), sqlStatement, db, args...)
+	return rowsAffected, wrapErr("ExecuteNonQueryNoTxPointToDB", "", sqlStatement, err)
+}
 
-	return rowsAffected, nil
+// ExecuteNonQueryNoTxPointToDBContext uses no transaction context and
+// keeps the database open.
+func (d *DBAccess) ExecuteNonQueryNoTxPointToDBContext(ctx context.Context, sqlStatement string, db *sql.DB, args ...interface{}) (int64, error) {
+	rowsAffected, err := executeNonQueryNoTx(ctx, sqlStatement, db, args...)
+	return rowsAffected, wrapErr("ExecuteNonQueryNoTxPointToDB", "", sqlStatement, err)
 }
 
 // ExecuteNonQueryNoTx uses no transaction context to insert data.
 func (d *DBAccess) ExecuteNonQueryNoTx(sqlStatement string, dbFilePath string) (int64, error) {
+	return d.ExecuteNonQueryNoTxWithArgs(sqlStatement, dbFilePath)
+}
 
-	if d.ShrinkDatabaseFiles && !d.itemExists(dbFilePath) {
-		go d.AddDBFileToShrinkWatchList(dbFilePath)
-	}
+// ExecuteNonQueryNoTxWithArgs uses no transaction context to insert data;
+// args are passed as bind parameters.
+func (d *DBAccess) ExecuteNonQueryNoTxWithArgs(sqlStatement string, dbFilePath string, args ...interface{}) (int64, error) {
+	return d.ExecuteNonQueryNoTxContext(context.Background(), sqlStatement, dbFilePath, args...)
+}
 
-	var db *sql.DB
-	var err error
+// ExecuteNonQueryNoTxContext uses no transaction context to insert data;
+// the statement is interrupted when the ctx is cancelled.
+func (d *DBAccess) ExecuteNonQueryNoTxContext(ctx context.Context, sqlStatement string, dbFilePath string, args ...interface{}) (int64, error) {
 
-	if db, err = d.GetDB(dbFilePath); err != nil {
-		return -1, err
+	if d.ShrinkDatabaseFiles && !d.itemExists(dbFilePath) && analyzeSQL(sqlStatement).Writes() {
+		go d.AddDBFileToShrinkWatchList(dbFilePath)
 	}
 
-	result, err := db.Exec(sqlStatement)
+	db, release, err := d.acquireDB(dbFilePath, true)
 	if err != nil {
-		db.Close()
 		return -1, err
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		db.Close()
-		return -1, err
+	var rowsAffected int64
+	args, err = d.columnCrypter().encryptArgs(sqlStatement, args)
+	if err == nil {
+		rowsAffected, err = executeNonQueryNoTx(ctx, sqlStatement, db, args...)
 	}
 
-	db.Close()
+	release()
 
-	return rowsAffected, nil
+	return rowsAffected, wrapErr("ExecuteNonQueryNoTx", dbFilePath, sqlStatement, err)
 }
 
 // ExecuteNonQueryPointToDB inserts data. It does not close
 // the database after operation is completed.
 func (d *DBAccess) ExecuteNonQueryPointToDB(sqlStatement string, db *sql.DB) (int64, error) {
 
-	rowsAffected, err := executeNonQuery(sqlStatement, db)
+	rowsAffected, err := executeNonQuery(context.Background(), sqlStatement, db)
 
 	// Keep the db open.
 
-	return rowsAffected, err
+	return rowsAffected, wrapErr("ExecuteNonQueryPointToDB", "", sqlStatement, err)
 }
 
-// getTableNameFromSQLQuery parses the tables name out of an SQL statement.
-func (d *DBAccess) getTableNameFromSQLQuery(sqlQuery string) string {
-	sqlQueryLower := strings.ToLower(sqlQuery)
-	x := strings.Split(sqlQueryLower, " ")
+// ExecuteNonQueryWithArgsPointToDB is ExecuteNonQueryPointToDB with bind
+// parameters. It does not close the database.
+func (d *DBAccess) ExecuteNonQueryWithArgsPointToDB(sqlStatement string, db *sql.DB, args ...interface{}) (int64, error) {
 
-	y := make([]interface{}, len(x))
-	for i, v := range x {
-		y[i] = v
-	}
+	rowsAffected, err := executeNonQuery(context.Background(), sqlStatement, db, args...)
 
-	vx := removeElmFrmArry(y, "")
+	// Keep the db open.
 
-	count := len(vx)
-	for i := 0; i < count; i++ {
-		vxstr := vx[i].(string)
-		if vxstr == "from" || vxstr == "into" {
-			if (i + 1) < count {
-				v := vx[i+1].(string)
-				if strings.HasSuffix(v, ";") {
-					v = v[:len(v)-1]
-				}
+	return rowsAffected, wrapErr("ExecuteNonQueryPointToDB", "", sqlStatement, err)
+}
 
-				tblName := strings.TrimSpace(strings.Split(v, "\n")[0])
-				return tblName
-			}
-		}
-	}
-	return ""
+// ExecuteNonQueryPointToDBContext is ExecuteNonQueryPointToDB with a ctx
+// and bind parameters. It does not close the database.
+func (d *DBAccess) ExecuteNonQueryPointToDBContext(ctx context.Context, sqlStatement string, db *sql.DB, args ...interface{}) (int64, error) {
+
+	rowsAffected, err := executeNonQuery(ctx, sqlStatement, db, args...)
+
+	// Keep the db open.
+
+	return rowsAffected, wrapErr("ExecuteNonQueryPointToDB", "", sqlStatement, err)
 }
 
 // validateInsertEntry
-func (d *DBAccess) validateInsertEntry(t *collc.Table, dbFilePath string) error {
+func (d *DBAccess) validateInsertEntry(ctx context.Context, t *collc.Table, dbFilePath string) error {
 	if t.Name == "" {
-		return errors.New("table name is reuiqred; and it must match the table-name in the database")
+		return wrapErr("InsertDataTable", dbFilePath, "", errors.New("table name is reuiqred; and it must match the table-name in the database"))
 	}
 	if t.Rows.Count() < 1 {
-		return errors.New("table contains no rows")
+		return ErrNoRowsFound
 	}
 
 	if !fileOrDirExists(dbFilePath) {
-		return errors.New("database file does not exist")
+		return ErrDatabaseFileNotExists
 	}
 
-	// Find columns in the datbase table
-	sqlx := `SELECT name FROM sqlite_master WHERE type IN ('table','view') AND name NOT LIKE 'sqlite_%' ORDER BY 1;`
-	tMaster, err := d.GetDataTable(sqlx, dbFilePath)
-
-	if err != nil {
-		return err
-	}
-
-	tTbleName := strings.ReplaceAll(t.Name, "[", "")
-	tTbleName = strings.ToLower(strings.ReplaceAll(tTbleName, "]", ""))
-
-	tblFound := false
-	cols := tMaster.Cols.Get()
-	rowArry := tMaster.Rows.GetRows()
-	for i := 0; i < len(rowArry); i++ {
-		for j := 0; j < len(cols); j++ {
-			tblName := rowArry[i][cols[j].Name]
-			if tblName == nil {
-				continue
-			}
-			sl := strings.ToLower(tblName.(string))
-
-			if sl == tTbleName {
-				tblFound = true
-				goto lblContinue
-			}
+	if _, err := d.GetTableInfoContext(ctx, dbFilePath, t.Name); err != nil {
+		if errors.Is(err, ErrTableNotFound) {
+			return &Error{Op: "InsertDataTable", DBFilePath: dbFilePath, Err: fmt.Errorf("%w: %s", ErrTableNotFound, t.Name)}
 		}
-	}
-lblContinue:
-
-	if !tblFound {
-		return errors.New(fmt.Sprintf("could not find table %s in database file %s", t.Name, dbFilePath))
+		return err
 	}
 
 	return nil
@@ -349,112 +358,44 @@ func valueExistsInArry(arry []collc.Column, e string, ignoreCase bool) bool {
 
 // BulkInsert inserts a DataTable into a database.
 func (dc *DBAccess) BulkInsert(dtSrc *collc.Table, dbFilePath string /*fast bool,*/, notify func(status string)) error {
+	return dc.BulkInsertContext(context.Background(), dtSrc, dbFilePath, notify)
+}
 
-	var err error
-
-	// Make a new instance for this.
-	var pragma []string = []string{
-		"PRAGMA journal_mode = MEMORY;",
-		"PRAGMA synchronous = OFF;",
-	}
-
-	d := NewDBAccess(DBAccess{
-		MaxIdleConns: 100, MaxOpenConns: 100,
-		ShrinkDatabaseFiles: false,
-		PRAGMA:              pragma,
-	})
-
-	_, err = d.CreateNewDatabase(dtSrc, dbFilePath)
-	if err != nil {
-		return err
-	}
-
-	// anything more than one will acutally slow thing down!
-	pageSize := 1
-
-	tblSrcRecordCount := dtSrc.Rows.Count()
-
-	totalPages := tblSrcRecordCount / pageSize
-	if tblSrcRecordCount%pageSize != 0 {
-		totalPages++
-	}
-
-	if tblSrcRecordCount < 1 {
-		return errors.New("source data-table has no rows")
-	}
-
-	from := 0
-	to := pageSize - 1
-	tstart := time.Now()
-
-	var allRowsAffected, rowsAffected int64
-	fmtTblRecCnt := formatNumber(int64(tblSrcRecordCount))
-
-	for i := 0; i < tblSrcRecordCount; i++ {
-		if to > tblSrcRecordCount {
-			to = tblSrcRecordCount
-		}
-
-		var coll = collc.NewCollection()
-		dtDest, err := coll.Table.Create(dtSrc.Name)
-		if err != nil {
-			return err
-		}
-
-		err = d.GetDataTableRange(dtSrc, dtDest, from, to)
-		if err != nil {
-			return err
-		}
-		rowsAffected, err = d.InsertDataTable(dtDest, dbFilePath, nil)
-		if err != nil {
-			if err.Error() == Err_NoRowsFound {
-				return nil
-			}
-			return err
-		}
-
-		from += pageSize
-		to = from + (pageSize - 1)
+// BulkInsertContext inserts a DataTable into a database; it stops
+// when the ctx is cancelled and returns ctx.Err().
+func (dc *DBAccess) BulkInsertContext(ctx context.Context, dtSrc *collc.Table, dbFilePath string, notify func(status string)) error {
 
-		if notify != nil {
-			allRowsAffected += rowsAffected
-			go createNotifyMsg(allRowsAffected, fmtTblRecCnt, tstart, notify)
-		}
+	var opt BulkInsertOptions
+	if notify != nil {
+		opt.Notify = func(p BulkInsertProgress) { notify(p.Status) }
 	}
 
-	return nil
-}
-func createNotifyMsg(allRowsAffected int64, fmtTblRecCnt string, tstart time.Time, notify func(status string)) {
-	msg := fmt.Sprintf("copied => rows: %s of %s, elapsed: %v",
-		formatNumber(allRowsAffected), fmtTblRecCnt, durationToString(time.Since(tstart)))
+	_, err := dc.BulkInsertWithOptions(ctx, dtSrc, dbFilePath, opt)
 
-	notify(msg)
+	return err
 }
 
 // CloneDatabase copies one database to the other.
 func (dc *DBAccess) CloneDatabase(srcFilePath string, destFilePath string, notify func(status string)) error {
+	return dc.CloneDatabaseContext(context.Background(), srcFilePath, destFilePath, notify)
+}
 
-	// Make a new instance for this.
-	var prag []string = []string{
-		//"PRAGMA journal_mode = MEMORY;",
-		"PRAGMA synchronous = OFF;",
-	}
-
-	d := NewDBAccess(DBAccess{
-		MaxIdleConns: 100, MaxOpenConns: 100,
-		ShrinkDatabaseFiles: false,
-		PRAGMA:              prag,
-	})
+// CloneDatabaseContext copies one database to the other with the online
+// backup API; it stops when the ctx is cancelled and returns ctx.Err().
+func (dc *DBAccess) CloneDatabaseContext(ctx context.Context, srcFilePath string, destFilePath string, notify func(status string)) error {
+	return dc.CloneDatabaseWithOptions(ctx, srcFilePath, destFilePath, CloneOptions{Notify: notify})
+}
 
-	if !fileOrDirExists(srcFilePath) {
-		return errors.New("source file does not exist")
-	}
-
-	if srcFilePath == destFilePath {
-		return errors.New("source and destination cannot be the same")
-	}
+// cloneRows creates the tables of the source in the destination, and
+// copies the rows page by page; the destination file is replaced.
+func (dc *DBAccess) cloneRows(ctx context.Context, srcFilePath string, destFilePath string, notify func(status string)) error {
 
 	if fileOrDirExists(destFilePath) {
+		// Release the pooled handle of the previous file.
+		if err := dc.releaseDBFile(ctx, destFilePath); err != nil {
+			return err
+		}
+
 		err := os.Remove(destFilePath)
 		if err != nil {
 			return err
@@ -463,51 +404,49 @@ func (dc *DBAccess) CloneDatabase(srcFilePath string, destFilePath string, notif
 
 	tstart := time.Now()
 
-	// Get the count
-	sqlx := "SELECT [sql],[name],[type] FROM sqlite_master"
-	m, err := d.GetDataMap(sqlx, srcFilePath)
+	schema, err := dc.GetSchemaContext(ctx, srcFilePath)
 	if err != nil {
 		return err
 	}
 
-	var tables []string
-	pageSize := 30
-	colName := "_rowid_"
-
-	for i := 0; i < len(m); i++ {
-		sqlx = fmt.Sprintf("%v", m[i]["sql"])
-		if sqlx == "<nil>" {
-			continue
-		}
-		if !strings.Contains(sqlx, " sqlite_sequence") {
-			_, err := d.ExecuteNonQuery(sqlx, destFilePath)
-			if err != nil {
-				return err
-			}
-		}
-
-		if m[i]["type"].(string) == "table" {
-			tables = append(tables, m[i]["name"].(string))
+	// The tables are created first; the indexes, views and triggers
+	// after the rows are copied; so that the triggers do not fire on
+	// the copied rows.
+	var sqlx string
+	for i := 0; i < len(schema.Tables); i++ {
+		if _, err = dc.ExecuteNonQueryContext(ctx, schema.Tables[i].SQL, destFilePath); err != nil {
+			return err
 		}
 	}
 
+	pageSize := 30
+
 	// Go through all tables page by page
 	var allRowsCopied int64
-	for k := 0; k < len(tables); k++ {
+	for k := 0; k < len(schema.Tables); k++ {
 
-		p, offset, ci := d.GetPagingInfo(pageSize, 1, tables[k], colName, "", srcFilePath)
-		var rowsCopiedTable int64
-		for i := 0; i < ci.TotalPages; i++ {
+		tbl := schema.Tables[k].Name
+		colName := "_rowid_"
+		if schema.Tables[k].WithoutRowID {
+			colName = quoteColumnList(schema.Tables[k].PrimaryKey())
+		}
 
-			pageSize, offset, ci = d.GetPagingInfo(p, (i + 1), tables[k], colName, "", srcFilePath)
+		// The pages are read by their plain offsets; the last page of
+		// GetPagingInfo is a full page, which overlaps the one before.
+		_, _, ci, err := dc.GetPagingInfoContext(ctx, pageSize, 1, tbl, colName, "", srcFilePath)
+		if err != nil {
+			return err
+		}
+		var rowsCopiedTable int64
+		for offset := 0; offset < ci.RecordCount; offset += pageSize {
 
-			sqlx = fmt.Sprintf("select * from [%s] order by %s limit %d offset %d", tables[k], colName, pageSize, offset)
+			sqlx = fmt.Sprintf("select * from [%s] order by %s limit %d offset %d", tbl, colName, pageSize, offset)
 
-			dt, err := d.GetDataTable(sqlx, srcFilePath)
+			dt, err := dc.GetDataTableContext(ctx, sqlx, srcFilePath)
 			if err != nil {
 				return err
 			}
-			rowsAffected, err := d.InsertDataTable(dt, destFilePath, nil)
+			rowsAffected, err := dc.InsertDataTableContext(ctx, dt, destFilePath, nil)
 			if err != nil {
 				return err
 			}
@@ -519,22 +458,47 @@ func (dc *DBAccess) CloneDatabase(srcFilePath string, destFilePath string, notif
 				msg := fmt.Sprintf("rows copied => %s: %v, total: %v, elapsed: %v", dt.Name,
 					formatNumber(rowsCopiedTable), formatNumber(allRowsCopied), durationToString(time.Since(tstart)))
 
-				go notify(msg)
+				notify(msg)
+			}
+		}
+	}
 
+	var stmts []string
+	for i := 0; i < len(schema.Tables); i++ {
+		for j := 0; j < len(schema.Tables[i].Indexes); j++ {
+			// The automatic indexes are created with the table.
+			if schema.Tables[i].Indexes[j].SQL != "" {
+				stmts = append(stmts, schema.Tables[i].Indexes[j].SQL)
 			}
 		}
 	}
+	for i := 0; i < len(schema.Views); i++ {
+		stmts = append(stmts, schema.Views[i].SQL)
+	}
+	triggers := schema.Triggers()
+	for i := 0; i < len(triggers); i++ {
+		stmts = append(stmts, triggers[i].SQL)
+	}
+
+	for i := 0; i < len(stmts); i++ {
+		if _, err = dc.ExecuteNonQueryContext(ctx, stmts[i], destFilePath); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 // GetDataMapPage returns a map of query by page.
 func (d *DBAccess) GetDataMapPage(sqlQuery string, pageNo int, pageSize int, dbFilePath string) ([]map[string]interface{}, error) {
+	return d.GetDataMapPageContext(context.Background(), sqlQuery, pageNo, pageSize, dbFilePath)
+}
 
-	tblName := d.getTableNameFromSQLQuery(sqlQuery)
+// GetDataMapPageContext returns a map of query by page.
+func (d *DBAccess) GetDataMapPageContext(ctx context.Context, sqlQuery string, pageNo int, pageSize int, dbFilePath string) ([]map[string]interface{}, error) {
 
-	sqlx := fmt.Sprintf("select count(_rowid_) from %s", tblName)
-	m, err := d.ExecuteScalare(sqlx, dbFilePath)
+	sqlx := fmt.Sprintf("select count(*) from (%s)", strings.TrimSuffix(strings.TrimSpace(sqlQuery), ";"))
+	m, err := d.ExecuteScalareContext(ctx, sqlx, dbFilePath)
 	if err != nil {
 		return nil, err
 	}
@@ -553,7 +517,7 @@ func (d *DBAccess) GetDataMapPage(sqlQuery string, pageNo int, pageSize int, dbF
 	// pageSize: how many records to return
 	// offset: from what position in the data-set
 	sqlx = fmt.Sprintf("%s limit %d offset %d", sqlQuery, pageSize, offset)
-	mx, err := d.GetDataMap(sqlx, dbFilePath)
+	mx, err := d.GetDataMapContext(ctx, sqlx, dbFilePath)
 
 	if err != nil {
 		return nil, err
@@ -564,6 +528,16 @@ func (d *DBAccess) GetDataMapPage(sqlQuery string, pageNo int, pageSize int, dbF
 
 // GetDataMap gets a selected range of table in form of rows and columns.
 func (d *DBAccess) GetDataMap(sqlQuery string, dbFilePath string) ([]map[string]interface{}, error) {
+	return d.GetDataMapWithArgs(sqlQuery, dbFilePath)
+}
+
+// GetDataMapWithArgs is GetDataMap with bind parameters.
+func (d *DBAccess) GetDataMapWithArgs(sqlQuery string, dbFilePath string, args ...interface{}) ([]map[string]interface{}, error) {
+	return d.GetDataMapContext(context.Background(), sqlQuery, dbFilePath, args...)
+}
+
+// GetDataMapContext is GetDataMap with a ctx and bind parameters.
+func (d *DBAccess) GetDataMapContext(ctx context.Context, sqlQuery string, dbFilePath string, args ...interface{}) ([]map[string]interface{}, error) {
 
 	if d.ShrinkDatabaseFiles {
 		// Read operation; but still add to the list - as some
@@ -574,19 +548,24 @@ func (d *DBAccess) GetDataMap(sqlQuery string, dbFilePath string) ([]map[string]
 		}
 	}
 
-	var db *sql.DB
-	var err error
-	var valueSlice []map[string]interface{}
-
-	if db, err = d.GetDB(dbFilePath); err != nil {
+	db, release, err := d.acquireDB(dbFilePath, analyzeSQL(sqlQuery).Writes())
+	if err != nil {
 		return nil, err
 	}
 
-	valueSlice, err = getDataMap(sqlQuery, db)
+	var valueSlice []map[string]interface{}
+	c := d.columnCrypter()
+	args, err = c.encryptArgs(sqlQuery, args)
+	if err == nil {
+		valueSlice, err = getDataMap(ctx, sqlQuery, db, args...)
+	}
+	if err == nil {
+		err = c.decryptRows(sqlQuery, valueSlice)
+	}
 
-	db.Close()
+	release()
 
-	return valueSlice, err
+	return valueSlice, wrapErr("GetDataMap", dbFilePath, sqlQuery, err)
 }
 
 // GetDataMapPointToDB gets a selected range of table in form of rows
@@ -596,9 +575,22 @@ func (d *DBAccess) GetDataMapPointToDB(sqlQuery string, db *sql.DB) ([]map[strin
 	var err error
 	var valueSlice []map[string]interface{}
 
-	valueSlice, err = getDataMap(sqlQuery, db)
+	valueSlice, err = getDataMap(context.Background(), sqlQuery, db)
 
-	return valueSlice, err
+	return valueSlice, wrapErr("GetDataMapPointToDB", "", sqlQuery, err)
+}
+
+// GetDataMapWithArgsPointToDB is GetDataMapPointToDB with bind parameters.
+func (d *DBAccess) GetDataMapWithArgsPointToDB(sqlQuery string, db *sql.DB, args ...interface{}) ([]map[string]interface{}, error) {
+	valueSlice, err := getDataMap(context.Background(), sqlQuery, db, args...)
+	return valueSlice, wrapErr("GetDataMapPointToDB", "", sqlQuery, err)
+}
+
+// GetDataMapPointToDBContext is GetDataMapPointToDB with a ctx and bind
+// parameters.
+func (d *DBAccess) GetDataMapPointToDBContext(ctx context.Context, sqlQuery string, db *sql.DB, args ...interface{}) ([]map[string]interface{}, error) {
+	valueSlice, err := getDataMap(ctx, sqlQuery, db, args...)
+	return valueSlice, wrapErr("GetDataMapPointToDB", "", sqlQuery, err)
 }
 
 func (d *DBAccess) isFileSQLiteDB(dbFilePath string) bool {
@@ -630,15 +622,13 @@ func (d *DBAccess) ShrinkDB(dbFilePath string) error {
 		return nil
 	}
 
-	db, err = sql.Open(d.driverName, dbFilePath)
+	db, err = sql.Open(d.driverName, d.dataSource(dbFilePath))
 	if err != nil {
-		if err.Error() == Err_FileIsNotDatabase {
-		}
 		return nil
 	}
 	if _, err = db.Exec("VACUUM;"); err != nil {
 		db.Close()
-		return err
+		return wrapErr("ShrinkDB", dbFilePath, "VACUUM;", err)
 	}
 
 	db.Close()
@@ -674,31 +664,39 @@ func (d *DBAccess) Decrypt(data []byte, pwdPhrase string) ([]byte, error) {
 	return Decrypt(data, pwdPhrase)
 }
 
+// EncryptDatabase encrypts a db file in place (see EncryptFile). Its pooled
+// handle is closed first; so that the WAL is checkpointed into the file.
+// The handles that are in use are waited for, up to the BusyTimeout of
+// the RetryPolicy; ErrDatabaseIsLocked is returned if one is still open
+// (i.e. of a Cursor that has not been closed).
 func (d *DBAccess) EncryptDatabase(dbFilePath string, pwdPhrase string) error {
-	return EncryptFile(dbFilePath, pwdPhrase)
+
+	if err := d.releaseDBFile(context.Background(), dbFilePath); err != nil {
+		return wrapErr("EncryptDatabase", dbFilePath, "", err)
+	}
+
+	return wrapErr("EncryptDatabase", dbFilePath, "", EncryptFile(dbFilePath, pwdPhrase))
 }
+
+// DecryptDatabase decrypts a db file in place (see DecryptFile); of the
+// encrypted format, or the legacy format. The open handles of the file
+// are waited for, as in EncryptDatabase.
 func (d *DBAccess) DecryptDatabase(dbFilePath string, pwdPhrase string) error {
-	return DecryptFile(dbFilePath, pwdPhrase)
+
+	if err := d.releaseDBFile(context.Background(), dbFilePath); err != nil {
+		return wrapErr("DecryptDatabase", dbFilePath, "", err)
+	}
+
+	return wrapErr("DecryptDatabase", dbFilePath, "", DecryptFile(dbFilePath, pwdPhrase))
 }
 
 // GetColumnNames gets the column names of a table.
 func (d *DBAccess) GetColumnNames(dbFilePath string, tblName string) ([]string, error) {
-	var col []string
 
-	sqlx := fmt.Sprintf("PRAGMA table_info(%s);", strings.ToLower(tblName))
-	t, err := d.GetDataTable(sqlx, dbFilePath)
+	t, err := d.GetTableInfo(dbFilePath, tblName)
 	if err != nil {
-		return col, err
-	}
-	if t == nil {
-		return col, nil
-	}
-
-	c := t.Cols.Get()
-
-	for i := 0; i < t.Cols.Count(); i++ {
-		col = append(col, c[i].Name)
+		return nil, err
 	}
 
-	return col, nil
+	return t.ColumnNames(), nil
 }
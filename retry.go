@@ -0,0 +1,134 @@
+package sqlitehench
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// retryScope counts the retries of one operation; including the retries
+// of the statements that it runs (i.e. BulkInsert -> InsertDataTable ->
+// ExecuteNonQuery).
+type retryScope struct {
+	retries int64
+}
+
+type retryScopeKey struct{}
+
+// startRetryScope returns a ctx that carries a retry counter for the
+// operation, and a func to be called when the operation is completed;
+// which reports the retries via RetryPolicy.Notify. Nested operations
+// count towards the outermost scope and do not report on their own.
+func (d *DBAccess) startRetryScope(ctx context.Context, op string, dbFilePath string) (context.Context, func(err error)) {
+
+	if _, ok := ctx.Value(retryScopeKey{}).(*retryScope); ok {
+		return ctx, func(error) {}
+	}
+
+	sc := &retryScope{}
+	tstart := time.Now()
+
+	done := func(err error) {
+		if d.RetryPolicy.Notify == nil {
+			return
+		}
+		d.RetryPolicy.Notify(RetryReport{
+			Operation:  op,
+			DBFilePath: dbFilePath,
+			Retries:    int(atomic.LoadInt64(&sc.retries)),
+			Elapsed:    time.Since(tstart),
+			Err:        err,
+		})
+	}
+
+	return context.WithValue(ctx, retryScopeKey{}, sc), done
+}
+
+// withRetry runs fn; if it fails with a retryable error, it is run again
+// after a backoff; until it succeeds, MaxAttempts is reached, or the ctx
+// is cancelled. fn must be safe to re-run; i.e. one statement in its own
+// transaction that is rolled back on failure.
+func (d *DBAccess) withRetry(ctx context.Context, fn func() error) error {
+
+	rp := d.RetryPolicy
+
+	retryOn := rp.RetryOn
+	if retryOn == nil {
+		retryOn = isBusyError
+	}
+
+	sc, _ := ctx.Value(retryScopeKey{}).(*retryScope)
+
+	var err error
+
+	for attempt := 1; ; attempt++ {
+
+		if err = fn(); err == nil || attempt >= rp.MaxAttempts || !retryOn(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(rp.backoff(attempt)):
+		}
+
+		if sc != nil {
+			atomic.AddInt64(&sc.retries, 1)
+		}
+	}
+}
+
+// backoff returns the wait time before the next attempt; it grows
+// exponentially and is randomised by the Jitter fraction.
+func (rp RetryPolicy) backoff(attempt int) time.Duration {
+
+	initial := rp.InitialBackoff
+	if initial <= 0 {
+		initial = 50 * time.Millisecond
+	}
+	maxWait := rp.MaxBackoff
+	if maxWait <= 0 {
+		maxWait = 2 * time.Second
+	}
+	mul := rp.Multiplier
+	if mul < 1 {
+		mul = 2
+	}
+	jitter := rp.Jitter
+	if jitter < 0 {
+		jitter = 0.2
+	} else if jitter > 1 {
+		jitter = 1
+	}
+
+	wait := float64(initial) * math.Pow(mul, float64(attempt-1))
+	if wait > float64(maxWait) {
+		wait = float64(maxWait)
+	}
+
+	// i.e. with a jitter of 0.2, the wait time is between 80% and 120%.
+	wait = wait * (1 - jitter + 2*jitter*rand.Float64())
+
+	return time.Duration(wait)
+}
+
+// isBusyError is the default retry classifier; it retries on the
+// SQLITE_BUSY and SQLITE_LOCKED conditions.
+func isBusyError(err error) bool {
+
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrDatabaseIsLocked) {
+		return true
+	}
+
+	code, _ := resultCodes(err)
+
+	return code == sqliteBusy || code == sqliteLocked
+}
@@ -0,0 +1,150 @@
+package sqlitehench
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+
+	rp := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 3, Jitter: 0.1}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 300 * time.Millisecond},
+		{3, 900 * time.Millisecond},
+		{4, time.Second},
+		{10, time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			got := rp.backoff(tt.attempt)
+			if got < tt.want*9/10 || got > tt.want*11/10 {
+				t.Fatalf("attempt %d: %v; want %v +/- 10%%", tt.attempt, got, tt.want)
+			}
+		}
+	}
+
+	// The defaults; 50ms with no jitter, or a jitter of 0.2.
+	for i := 0; i < 20; i++ {
+		if got := (RetryPolicy{}).backoff(1); got != 50*time.Millisecond {
+			t.Fatalf("backoff with no jitter %v; want 50ms", got)
+		}
+		if got := (RetryPolicy{Jitter: -1}).backoff(1); got < 40*time.Millisecond || got > 60*time.Millisecond {
+			t.Fatalf("default backoff %v", got)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+
+	var reports []RetryReport
+
+	d := &DBAccess{RetryPolicy: RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Millisecond,
+		Notify:         func(r RetryReport) { reports = append(reports, r) },
+	}}
+
+	busy := errors.New("database is locked")
+
+	// The retries of the nested operations count towards the
+	// outermost; which reports once.
+	ctx, done := d.startRetryScope(context.Background(), "Outer", "x.sqlite")
+	inner, innerDone := d.startRetryScope(ctx, "Inner", "x.sqlite")
+
+	calls := 0
+	err := d.withRetry(inner, func() error {
+		if calls++; calls < 3 {
+			return busy
+		}
+		return nil
+	})
+	innerDone(err)
+	done(err)
+
+	if err != nil || calls != 3 {
+		t.Errorf("got %v after %d calls", err, calls)
+	}
+	if len(reports) != 1 || reports[0].Operation != "Outer" || reports[0].Retries != 2 {
+		t.Errorf("got %+v", reports)
+	}
+
+	// MaxAttempts is the number of calls.
+	calls = 0
+	if err = d.withRetry(context.Background(), func() error { calls++; return busy }); err != busy || calls != 4 {
+		t.Errorf("got %v after %d calls", err, calls)
+	}
+
+	// Other errors are not retried.
+	calls = 0
+	other := errors.New("no such table: t")
+	if err = d.withRetry(context.Background(), func() error { calls++; return other }); err != other || calls != 1 {
+		t.Errorf("got %v after %d calls", err, calls)
+	}
+
+	// A cancelled ctx ends the backoff.
+	d.RetryPolicy.InitialBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = d.withRetry(ctx, func() error { return busy }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRetryLockedDatabase(t *testing.T) {
+
+	var reports []RetryReport
+
+	d := NewDBAccess(DBAccess{RetryPolicy: RetryPolicy{
+		BusyTimeout:    time.Millisecond,
+		MaxAttempts:    50,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+		Notify:         func(r RetryReport) { reports = append(reports, r) },
+	}})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "retry.sqlite")
+	if _, err := d.ExecuteNonQuery("CREATE TABLE t (id INTEGER)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+	reports = nil
+
+	// Another connection holds the write lock for a while.
+	db, err := d.GetDB(dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("INSERT INTO t VALUES (0)"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		tx.Commit()
+	}()
+
+	if _, err = d.ExecuteNonQuery("INSERT INTO t VALUES (1)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reports) != 1 || reports[0].Retries == 0 || reports[0].Err != nil {
+		t.Errorf("got %+v", reports)
+	}
+	if n := rowCount(t, d, "t", dbFilePath); n != 2 {
+		t.Errorf("got %d rows; want 2", n)
+	}
+}
@@ -0,0 +1,564 @@
+package sqlitehench
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Query runs a query and returns the rows as []T. T is either a struct,
+// whose fields are mapped to the columns by their `db` tag (or the field
+// name; case-insensitive), or a single-column type (i.e. Query[string]).
+// i.e.
+//
+//	type Customer struct {
+//		ID      int        `db:"ID"`
+//		Name    string     `db:"Name"`
+//		Joined  time.Time  `db:"JoinedDate"`
+//		Note    *string    `db:"Note"`
+//	}
+//	rows, err := sqlitehench.Query[Customer](d, "SELECT * FROM Customer", dbFilePath)
+//
+// A column that has no matching field, and a value that cannot be
+// converted to the field type (i.e. NULL into an int), return an error.
+func Query[T any](d *DBAccess, sqlQuery string, dbFilePath string, args ...interface{}) ([]T, error) {
+	return QueryContext[T](context.Background(), d, sqlQuery, dbFilePath, args...)
+}
+
+// QueryContext is Query with a ctx.
+func QueryContext[T any](ctx context.Context, d *DBAccess, sqlQuery string, dbFilePath string, args ...interface{}) (_ []T, err error) {
+
+	defer func() { err = wrapErr("Query", dbFilePath, sqlQuery, err) }()
+
+	if !fileOrDirExists(dbFilePath) {
+		return nil, ErrDatabaseFileNotExists
+	}
+
+	db, release, err := d.acquireDB(dbFilePath, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return scanAll[T](ctx, db, d.columnCrypter(), sqlQuery, -1, args...)
+}
+
+// QueryOne returns the first row of a query as T; or ErrNoRowsFound
+// if the query returned no rows. See Query for how T is mapped.
+func QueryOne[T any](d *DBAccess, sqlQuery string, dbFilePath string, args ...interface{}) (T, error) {
+	return QueryOneContext[T](context.Background(), d, sqlQuery, dbFilePath, args...)
+}
+
+// QueryOneContext is QueryOne with a ctx.
+func QueryOneContext[T any](ctx context.Context, d *DBAccess, sqlQuery string, dbFilePath string, args ...interface{}) (_ T, err error) {
+
+	var zero T
+
+	defer func() { err = wrapErr("QueryOne", dbFilePath, sqlQuery, err) }()
+
+	if !fileOrDirExists(dbFilePath) {
+		return zero, ErrDatabaseFileNotExists
+	}
+
+	db, release, err := d.acquireDB(dbFilePath, false)
+	if err != nil {
+		return zero, err
+	}
+	defer release()
+
+	v, err := scanAll[T](ctx, db, d.columnCrypter(), sqlQuery, 1, args...)
+	if err != nil {
+		return zero, err
+	}
+	if len(v) == 0 {
+		return zero, ErrNoRowsFound
+	}
+
+	return v[0], nil
+}
+
+// ScalarAs returns the first column of the first row as T; i.e.
+// ScalarAs[int](d, "SELECT COUNT(*) FROM Customer", dbFilePath).
+// ErrNoRowsFound is returned if the query returned no rows; a NULL
+// value needs T to be a pointer or one of the sql.Null* types.
+func ScalarAs[T any](d *DBAccess, sqlStatement string, dbFilePath string, args ...interface{}) (T, error) {
+	return ScalarAsContext[T](context.Background(), d, sqlStatement, dbFilePath, args...)
+}
+
+// ScalarAsContext is ScalarAs with a ctx.
+func ScalarAsContext[T any](ctx context.Context, d *DBAccess, sqlStatement string, dbFilePath string, args ...interface{}) (_ T, err error) {
+
+	var ret T
+
+	defer func() { err = wrapErr("ScalarAs", dbFilePath, sqlStatement, err) }()
+
+	if !fileOrDirExists(dbFilePath) {
+		return ret, ErrDatabaseFileNotExists
+	}
+
+	db, release, err := d.acquireDB(dbFilePath, false)
+	if err != nil {
+		return ret, err
+	}
+	defer release()
+
+	c := d.columnCrypter()
+	if args, err = c.encryptArgs(sqlStatement, args); err != nil {
+		return ret, err
+	}
+	if args, err = bindArgs(args); err != nil {
+		return ret, err
+	}
+
+	rows, err := db.QueryContext(ctx, sqlStatement, args...)
+	if err != nil {
+		return ret, ctxErr(ctx, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return ret, ctxErr(ctx, err)
+		}
+		return ret, ErrNoRowsFound
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return ret, err
+	}
+
+	vals := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := 0; i < len(vals); i++ {
+		ptrs[i] = &vals[i]
+	}
+	if err = rows.Scan(ptrs...); err != nil {
+		return ret, ctxErr(ctx, err)
+	}
+
+	// As ExecuteScalare; the value is tried with every encrypted
+	// column of the tables.
+	if c != nil {
+		if vals[0], err = c.decryptValue(statementTables(analyzeSQL(sqlStatement)), "", vals[0]); err != nil {
+			return ret, err
+		}
+	}
+
+	if err = convertValue(vals[0], reflect.ValueOf(&ret).Elem()); err != nil {
+		return ret, fmt.Errorf("column %q: %w", cols[0], err)
+	}
+
+	return ret, nil
+}
+
+// scanAll reads the rows of a query into []T; up to limit rows
+// (-1 for all). The args and values of the encrypted columns are
+// encrypted and decrypted with c (if not nil).
+func scanAll[T any](ctx context.Context, db *sql.DB, c *columnCrypter, sqlQuery string, limit int, args ...interface{}) ([]T, error) {
+
+	var err error
+	var ret []T
+
+	if args, err = c.encryptArgs(sqlQuery, args); err != nil {
+		return nil, err
+	}
+	if args, err = bindArgs(args); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	sc, err := newRowScanner(reflect.TypeOf((*T)(nil)).Elem(), cols)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []string
+	if c != nil {
+		tables = statementTables(analyzeSQL(sqlQuery))
+	}
+
+	for (limit < 0 || len(ret) < limit) && rows.Next() {
+		var v T
+		if err = rows.Scan(sc.ptrs...); err != nil {
+			return nil, ctxErr(ctx, err)
+		}
+		if err = c.decryptValues(tables, cols, sc.vals); err != nil {
+			return nil, err
+		}
+		if err = sc.convert(sc.vals, reflect.ValueOf(&v).Elem()); err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	return ret, nil
+}
+
+// rowScanner maps the columns of a result set to a Go type.
+type rowScanner struct {
+	cols []string
+
+	// fields holds the struct field index of each column; nil
+	// when the type is not a struct (single-column results).
+	fields [][]int
+
+	vals []interface{}
+	ptrs []interface{}
+}
+
+// newRowScanner returns a rowScanner for the columns of a result set
+// and the type t. Every column must map to a field of a struct type;
+// any other type needs a single-column result set.
+func newRowScanner(t reflect.Type, cols []string) (*rowScanner, error) {
+
+	sc := &rowScanner{
+		cols: cols,
+		vals: make([]interface{}, len(cols)),
+		ptrs: make([]interface{}, len(cols)),
+	}
+	for i := 0; i < len(cols); i++ {
+		sc.ptrs[i] = &sc.vals[i]
+	}
+
+	if !isStructTarget(t) {
+		if len(cols) != 1 {
+			return nil, fmt.Errorf("cannot scan %d columns into %s; use a struct type", len(cols), t)
+		}
+		return sc, nil
+	}
+
+	fm := structFields(t)
+
+	sc.fields = make([][]int, len(cols))
+	for i := 0; i < len(cols); i++ {
+		idx, ok := fm[strings.ToLower(cols[i])]
+		if !ok {
+			return nil, fmt.Errorf("column %q has no matching field in %s", cols[i], t)
+		}
+		sc.fields[i] = idx
+	}
+
+	return sc, nil
+}
+
+// convert sets dst to the values of a row (in column order).
+func (sc *rowScanner) convert(vals []interface{}, dst reflect.Value) error {
+
+	if sc.fields == nil {
+		if err := convertValue(vals[0], dst); err != nil {
+			return fmt.Errorf("column %q: %w", sc.cols[0], err)
+		}
+		return nil
+	}
+
+	for i := 0; i < len(sc.cols); i++ {
+		if err := convertValue(vals[i], dst.FieldByIndex(sc.fields[i])); err != nil {
+			return fmt.Errorf("column %q: %w", sc.cols[i], err)
+		}
+	}
+
+	return nil
+}
+
+// isStructTarget reports whether the columns are mapped to the fields
+// of t; time.Time and the sql.Scanner types are read as one value.
+func isStructTarget(t reflect.Type) bool {
+
+	if t.Kind() != reflect.Struct || t == timeType {
+		return false
+	}
+
+	return !reflect.PointerTo(t).Implements(scannerType)
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+	// structFieldCache holds the field map of each struct type.
+	structFieldCache sync.Map
+)
+
+// structFields returns the field index by (lower-case) column name of
+// a struct type. The column name is taken from the `db` tag, or the
+// field name; fields tagged with `db:"-"` and unexported fields are
+// skipped. The fields of embedded structs are included.
+func structFields(t reflect.Type) map[string][]int {
+
+	if fm, ok := structFieldCache.Load(t); ok {
+		return fm.(map[string][]int)
+	}
+
+	fm := make(map[string][]int)
+	addStructFields(t, nil, fm)
+
+	structFieldCache.Store(t, fm)
+
+	return fm
+}
+
+func addStructFields(t reflect.Type, parent []int, fm map[string][]int) {
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := strings.Split(f.Tag.Get("db"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+
+		idx := append(append([]int{}, parent...), i)
+
+		if f.Anonymous && tag == "" && isStructTarget(f.Type) {
+			addStructFields(f.Type, idx, fm)
+			continue
+		}
+
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = f.Name
+		}
+		name = strings.ToLower(name)
+
+		// An outer field takes precedence over an embedded one.
+		if _, ok := fm[name]; !ok || len(fm[name]) > len(idx) {
+			fm[name] = idx
+		}
+	}
+}
+
+// convertValue sets dst to a value returned by the driver (int64,
+// float64, string, []byte, time.Time, bool or nil).
+func convertValue(src interface{}, dst reflect.Value) error {
+
+	if dst.CanAddr() {
+		if s, ok := dst.Addr().Interface().(sql.Scanner); ok {
+			err := s.Scan(src)
+			if err != nil {
+				// i.e. sql.NullTime from a TEXT column
+				if t, ok := parseTimeValue(src); ok {
+					return s.Scan(t)
+				}
+			}
+			return err
+		}
+	}
+
+	if src == nil {
+		switch dst.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		return fmt.Errorf("cannot assign NULL to %s; use a pointer or an sql.Null* type", dst.Type())
+	}
+
+	if dst.Kind() == reflect.Ptr {
+		v := reflect.New(dst.Type().Elem())
+		if err := convertValue(src, v.Elem()); err != nil {
+			return err
+		}
+		dst.Set(v)
+		return nil
+	}
+
+	if dst.Kind() == reflect.Interface {
+		dst.Set(reflect.ValueOf(src))
+		return nil
+	}
+
+	if dst.Type() == timeType {
+		if t, ok := parseTimeValue(src); ok {
+			dst.Set(reflect.ValueOf(t))
+			return nil
+		}
+		if n, ok := src.(int64); ok {
+			// unix epoch
+			dst.Set(reflect.ValueOf(time.Unix(n, 0).UTC()))
+			return nil
+		}
+		return mismatchErr(src, dst)
+	}
+
+	switch dst.Kind() {
+	case reflect.String:
+		switch v := src.(type) {
+		case string:
+			dst.SetString(v)
+		case []byte:
+			dst.SetString(string(v))
+		case int64:
+			dst.SetString(strconv.FormatInt(v, 10))
+		case float64:
+			dst.SetString(strconv.FormatFloat(v, 'g', -1, 64))
+		case time.Time:
+			dst.SetString(v.Format(sqlite3.SQLiteTimestampFormats[0]))
+		default:
+			return mismatchErr(src, dst)
+		}
+		return nil
+
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() != reflect.Uint8 {
+			return mismatchErr(src, dst)
+		}
+		switch v := src.(type) {
+		case []byte:
+			dst.SetBytes(append([]byte{}, v...))
+		case string:
+			dst.SetBytes([]byte(v))
+		default:
+			return mismatchErr(src, dst)
+		}
+		return nil
+
+	case reflect.Bool:
+		switch v := src.(type) {
+		case bool:
+			dst.SetBool(v)
+		case int64:
+			dst.SetBool(v != 0)
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return mismatchErr(src, dst)
+			}
+			dst.SetBool(b)
+		default:
+			return mismatchErr(src, dst)
+		}
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch v := src.(type) {
+		case int64:
+			n = v
+		case float64:
+			if v != float64(int64(v)) {
+				return mismatchErr(src, dst)
+			}
+			n = int64(v)
+		case bool:
+			if v {
+				n = 1
+			}
+		case string:
+			var err error
+			if n, err = strconv.ParseInt(strings.TrimSpace(v), 10, 64); err != nil {
+				return mismatchErr(src, dst)
+			}
+		default:
+			return mismatchErr(src, dst)
+		}
+		if dst.OverflowInt(n) {
+			return fmt.Errorf("value %d overflows %s", n, dst.Type())
+		}
+		dst.SetInt(n)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n int64
+		switch v := src.(type) {
+		case int64:
+			n = v
+		case float64:
+			if v != float64(int64(v)) {
+				return mismatchErr(src, dst)
+			}
+			n = int64(v)
+		case string:
+			var err error
+			if n, err = strconv.ParseInt(strings.TrimSpace(v), 10, 64); err != nil {
+				return mismatchErr(src, dst)
+			}
+		default:
+			return mismatchErr(src, dst)
+		}
+		if n < 0 || dst.OverflowUint(uint64(n)) {
+			return fmt.Errorf("value %d overflows %s", n, dst.Type())
+		}
+		dst.SetUint(uint64(n))
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch v := src.(type) {
+		case float64:
+			f = v
+		case int64:
+			f = float64(v)
+		case string:
+			var err error
+			if f, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				return mismatchErr(src, dst)
+			}
+		default:
+			return mismatchErr(src, dst)
+		}
+		dst.SetFloat(f)
+		return nil
+	}
+
+	return mismatchErr(src, dst)
+}
+
+// parseTimeValue returns the time of a time.Time value, or of a TEXT
+// value in one of the formats that SQLite understands.
+func parseTimeValue(src interface{}) (time.Time, bool) {
+
+	var s string
+
+	switch v := src.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return time.Time{}, false
+	}
+
+	// A trailing Z is UTC; which is also the default.
+	s = strings.TrimSuffix(strings.TrimSpace(s), "Z")
+
+	for i := 0; i < len(sqlite3.SQLiteTimestampFormats); i++ {
+		if t, err := time.ParseInLocation(sqlite3.SQLiteTimestampFormats[i], s, time.UTC); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func mismatchErr(src interface{}, dst reflect.Value) error {
+	return fmt.Errorf("cannot convert %T (%v) to %s", src, src, dst.Type())
+}
@@ -0,0 +1,175 @@
+package sqlitehench
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scanTest returns the path of a db file with the table c of two rows;
+// the second with NULL values.
+func scanTest(t *testing.T, d *DBAccess) string {
+
+	t.Helper()
+
+	return testDB(t, d, "scan.sqlite", []string{
+		"CREATE TABLE c (ID INTEGER PRIMARY KEY, Name TEXT, Joined DATETIME, Score REAL, Active INTEGER, Note TEXT, Data BLOB)",
+		"INSERT INTO c VALUES (1, 'Ann', '2024-03-01 10:20:30', 1.5, 1, 'x', X'0102')",
+		"INSERT INTO c VALUES (2, 'Bob', NULL, NULL, 0, NULL, NULL)",
+	}, "")
+}
+
+type scanBase struct {
+	ID int64 `db:"ID"`
+}
+
+type scanCustomer struct {
+	scanBase
+	Name   string
+	Joined sql.NullTime
+	Score  *float64
+	Active bool
+	Note   *string `db:"Note"`
+	Data   []byte
+	Skip   string `db:"-"`
+	hidden int
+}
+
+func TestQuery(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := scanTest(t, d)
+
+	cs, err := Query[scanCustomer](d, "SELECT * FROM c ORDER BY ID", dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 2 {
+		t.Fatalf("got %d rows", len(cs))
+	}
+
+	ann := cs[0]
+	joined := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+	if ann.ID != 1 || ann.Name != "Ann" || !ann.Joined.Valid || !ann.Joined.Time.Equal(joined) ||
+		ann.Score == nil || *ann.Score != 1.5 || !ann.Active || ann.Note == nil || *ann.Note != "x" ||
+		!reflect.DeepEqual(ann.Data, []byte{1, 2}) {
+		t.Errorf("got %+v", ann)
+	}
+
+	bob := cs[1]
+	if bob.ID != 2 || bob.Joined.Valid || bob.Score != nil || bob.Active || bob.Note != nil || bob.Data != nil {
+		t.Errorf("got %+v", bob)
+	}
+
+	// A single column is read into T.
+	names, err := Query[string](d, "SELECT Name FROM c WHERE ID > ? ORDER BY ID", dbFilePath, 0)
+	if err != nil || !equalStrings(names, []string{"Ann", "Bob"}) {
+		t.Errorf("got %v, %v", names, err)
+	}
+
+	// The bind parameters by name; from a struct.
+	type filter struct {
+		Name string `db:"name"`
+	}
+	ids, err := Query[int](d, "SELECT ID FROM c WHERE Name = :name", dbFilePath, filter{Name: "Bob"})
+	if err != nil || len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("got %v, %v", ids, err)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := scanTest(t, d)
+
+	tests := []struct {
+		name string
+		fn   func() error
+		want string
+	}{
+		{"no field", func() error {
+			_, err := Query[scanBase](d, "SELECT ID, Name FROM c", dbFilePath)
+			return err
+		}, `column "Name" has no matching field`},
+		{"several columns", func() error {
+			_, err := Query[string](d, "SELECT ID, Name FROM c", dbFilePath)
+			return err
+		}, "cannot scan 2 columns into string"},
+		{"null", func() error {
+			_, err := Query[float64](d, "SELECT Score FROM c", dbFilePath)
+			return err
+		}, "cannot assign NULL to float64"},
+		{"mismatch", func() error {
+			_, err := Query[int](d, "SELECT Name FROM c", dbFilePath)
+			return err
+		}, "cannot convert string (Ann) to int"},
+		{"overflow", func() error {
+			_, err := ScalarAs[int8](d, "SELECT 300", dbFilePath)
+			return err
+		}, "value 300 overflows int8"},
+	}
+
+	for _, tt := range tests {
+		if err := tt.fn(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v; want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestQueryOneAndScalarAs(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := scanTest(t, d)
+
+	c, err := QueryOne[scanCustomer](d, "SELECT * FROM c WHERE ID = ?", dbFilePath, 2)
+	if err != nil || c.Name != "Bob" {
+		t.Errorf("got %+v, %v", c, err)
+	}
+	if _, err = QueryOne[scanCustomer](d, "SELECT * FROM c WHERE ID = ?", dbFilePath, 3); !errors.Is(err, ErrNoRowsFound) {
+		t.Errorf("got %v; want %v", err, ErrNoRowsFound)
+	}
+
+	n, err := ScalarAs[int](d, "SELECT count(*) FROM c", dbFilePath)
+	if err != nil || n != 2 {
+		t.Errorf("got %d, %v", n, err)
+	}
+
+	joined, err := ScalarAs[time.Time](d, "SELECT Joined FROM c WHERE ID = 1", dbFilePath)
+	if err != nil || joined.Hour() != 10 {
+		t.Errorf("got %v, %v", joined, err)
+	}
+
+	note, err := ScalarAs[*string](d, "SELECT Note FROM c WHERE ID = 2", dbFilePath)
+	if err != nil || note != nil {
+		t.Errorf("got %v, %v", note, err)
+	}
+
+	if _, err = ScalarAs[int](d, "SELECT ID FROM c WHERE ID = 3", dbFilePath); !errors.Is(err, ErrNoRowsFound) {
+		t.Errorf("got %v; want %v", err, ErrNoRowsFound)
+	}
+}
+
+func TestParseTimeValue(t *testing.T) {
+
+	want := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+
+	for _, s := range []string{"2024-03-01 10:20:30", "2024-03-01T10:20:30Z", "2024-03-01T10:20:30"} {
+		got, ok := parseTimeValue(s)
+		if !ok || !got.Equal(want) {
+			t.Errorf("%q: got %v, %v", s, got, ok)
+		}
+	}
+
+	if _, ok := parseTimeValue("yesterday"); ok {
+		t.Error("parsed yesterday")
+	}
+}
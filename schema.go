@@ -0,0 +1,424 @@
+package sqlitehench
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Schema holds the tables and views of a database; the sqlite_ internal
+// objects are not included. The objects are in the order that they were
+// created.
+type Schema struct {
+	Tables []TableInfo
+	Views  []TableInfo
+}
+
+// TableInfo describes a table or a view.
+type TableInfo struct {
+	Name string
+	// Type is "table" or "view".
+	Type string
+	// SQL is the CREATE statement as stored in sqlite_master.
+	SQL          string
+	WithoutRowID bool
+	Strict       bool
+	Columns      []ColumnInfo
+	// Indexes includes the automatic indexes of the UNIQUE and
+	// PRIMARY KEY constraints (see IndexInfo.Origin).
+	Indexes     []IndexInfo
+	ForeignKeys []ForeignKeyInfo
+	Triggers    []TriggerInfo
+}
+
+// ColumnInfo describes a column of a table or a view.
+type ColumnInfo struct {
+	Name         string
+	DeclaredType string
+	NotNull      bool
+	// Default is the text of the DEFAULT expression; nil if the column
+	// has none.
+	Default *string
+	// PKOrder is the position (1-based) of the column in the primary
+	// key; 0 if the column is not part of it.
+	PKOrder int
+	// Hidden is 0 for normal columns; 1 for the hidden columns of a
+	// virtual table, 2 and 3 for generated (VIRTUAL and STORED) columns.
+	Hidden int
+}
+
+// IndexInfo describes an index.
+type IndexInfo struct {
+	Name   string
+	Table  string
+	Unique bool
+	// Partial is true for an index with a WHERE clause.
+	Partial bool
+	// Origin is "c" for CREATE INDEX, "u" for a UNIQUE constraint
+	// and "pk" for a PRIMARY KEY.
+	Origin string
+	// Columns are the key columns in order; an expression column
+	// has an empty name.
+	Columns []string
+	// SQL is empty for the automatic indexes.
+	SQL string
+}
+
+// ForeignKeyInfo describes a foreign key of a table.
+type ForeignKeyInfo struct {
+	ID int
+	// Table is the parent (referenced) table.
+	Table string
+	From  []string
+	// To is empty when the foreign key refers to the primary key of the
+	// parent table.
+	To       []string
+	OnUpdate string
+	OnDelete string
+	Match    string
+}
+
+// TriggerInfo describes a trigger.
+type TriggerInfo struct {
+	Name  string
+	Table string
+	SQL   string
+}
+
+// Table returns the table (or view) of a name; the name is not
+// case-sensitive. It returns nil if there is no such table.
+func (s *Schema) Table(name string) *TableInfo {
+
+	name = strings.Trim(name, "[]\"`")
+
+	for i := 0; i < len(s.Tables); i++ {
+		if strings.EqualFold(s.Tables[i].Name, name) {
+			return &s.Tables[i]
+		}
+	}
+	for i := 0; i < len(s.Views); i++ {
+		if strings.EqualFold(s.Views[i].Name, name) {
+			return &s.Views[i]
+		}
+	}
+
+	return nil
+}
+
+// Triggers returns the triggers of all tables and views.
+func (s *Schema) Triggers() []TriggerInfo {
+
+	var trg []TriggerInfo
+	for i := 0; i < len(s.Tables); i++ {
+		trg = append(trg, s.Tables[i].Triggers...)
+	}
+	for i := 0; i < len(s.Views); i++ {
+		trg = append(trg, s.Views[i].Triggers...)
+	}
+
+	return trg
+}
+
+// Column returns the column of a name; the name is not case-sensitive.
+// It returns nil if there is no such column.
+func (t *TableInfo) Column(name string) *ColumnInfo {
+
+	name = strings.Trim(name, "[]\"`")
+
+	for i := 0; i < len(t.Columns); i++ {
+		if strings.EqualFold(t.Columns[i].Name, name) {
+			return &t.Columns[i]
+		}
+	}
+
+	return nil
+}
+
+// ColumnNames returns the column names in order.
+func (t *TableInfo) ColumnNames() []string {
+
+	names := make([]string, len(t.Columns))
+	for i := 0; i < len(t.Columns); i++ {
+		names[i] = t.Columns[i].Name
+	}
+
+	return names
+}
+
+// PrimaryKey returns the primary key columns in key order; empty if the
+// table has no declared primary key.
+func (t *TableInfo) PrimaryKey() []string {
+
+	var pk []string
+	for n := 1; ; n++ {
+		found := false
+		for i := 0; i < len(t.Columns); i++ {
+			if t.Columns[i].PKOrder == n {
+				pk = append(pk, t.Columns[i].Name)
+				found = true
+			}
+		}
+		if !found {
+			return pk
+		}
+	}
+}
+
+// GetSchema returns the tables, views, indexes, foreign keys and
+// triggers of a database.
+func (d *DBAccess) GetSchema(dbFilePath string) (*Schema, error) {
+	return d.GetSchemaContext(context.Background(), dbFilePath)
+}
+
+// GetSchemaContext is GetSchema with a ctx.
+func (d *DBAccess) GetSchemaContext(ctx context.Context, dbFilePath string) (_ *Schema, err error) {
+
+	defer func() { err = wrapErr("GetSchema", dbFilePath, "", err) }()
+
+	return d.readSchema(ctx, dbFilePath, "")
+}
+
+// GetTableInfo returns the columns, indexes, foreign keys and triggers
+// of a table or a view; ErrTableNotFound if there is no such table.
+func (d *DBAccess) GetTableInfo(dbFilePath string, tblName string) (*TableInfo, error) {
+	return d.GetTableInfoContext(context.Background(), dbFilePath, tblName)
+}
+
+// GetTableInfoContext is GetTableInfo with a ctx.
+func (d *DBAccess) GetTableInfoContext(ctx context.Context, dbFilePath string, tblName string) (_ *TableInfo, err error) {
+
+	defer func() { err = wrapErr("GetTableInfo", dbFilePath, "", err) }()
+
+	tblName = strings.Trim(strings.TrimSpace(tblName), "[]\"`")
+
+	s, err := d.readSchema(ctx, dbFilePath, tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	t := s.Table(tblName)
+	if t == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, tblName)
+	}
+
+	return t, nil
+}
+
+// masterRow is a row of sqlite_master.
+type masterRow struct {
+	Type    string         `db:"type"`
+	Name    string         `db:"name"`
+	TblName string         `db:"tbl_name"`
+	SQL     sql.NullString `db:"sql"`
+}
+
+// readSchema reads the schema of a database; of one table (or view), if
+// tblName is set.
+func (d *DBAccess) readSchema(ctx context.Context, dbFilePath string, tblName string) (*Schema, error) {
+
+	if !fileOrDirExists(dbFilePath) {
+		return nil, ErrDatabaseFileNotExists
+	}
+
+	db, release, err := d.acquireDB(dbFilePath, false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	sqlx := `SELECT type, name, tbl_name, sql FROM sqlite_master
+		WHERE type IN ('table','view','trigger') AND name NOT LIKE 'sqlite_%'`
+	var args []interface{}
+	if tblName != "" {
+		sqlx += ` AND tbl_name = ? COLLATE NOCASE`
+		args = append(args, tblName)
+	}
+	sqlx += ` ORDER BY rowid`
+
+	objs, err := scanAll[masterRow](ctx, db, nil, sqlx, -1, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Schema
+	var triggers []masterRow
+
+	for i := 0; i < len(objs); i++ {
+
+		o := objs[i]
+
+		if o.Type == "trigger" {
+			triggers = append(triggers, o)
+			continue
+		}
+
+		t := TableInfo{Name: o.Name, Type: o.Type, SQL: o.SQL.String}
+		if err = readTableInfo(ctx, db, &t); err != nil {
+			return nil, err
+		}
+
+		if o.Type == "view" {
+			s.Views = append(s.Views, t)
+		} else {
+			s.Tables = append(s.Tables, t)
+		}
+	}
+
+	for i := 0; i < len(triggers); i++ {
+		t := s.Table(triggers[i].TblName)
+		if t == nil {
+			continue
+		}
+		t.Triggers = append(t.Triggers, TriggerInfo{
+			Name:  triggers[i].Name,
+			Table: triggers[i].TblName,
+			SQL:   triggers[i].SQL.String,
+		})
+	}
+
+	return &s, nil
+}
+
+// readTableInfo reads the columns of a table (or view); and the indexes
+// and foreign keys of a table.
+func readTableInfo(ctx context.Context, db *sql.DB, t *TableInfo) error {
+
+	var err error
+
+	type colRow struct {
+		Name    string         `db:"name"`
+		Type    string         `db:"type"`
+		NotNull bool           `db:"notnull"`
+		Default sql.NullString `db:"dflt_value"`
+		PK      int            `db:"pk"`
+		Hidden  int            `db:"hidden"`
+	}
+
+	cols, err := scanAll[colRow](ctx, db, nil,
+		`SELECT name, type, "notnull", dflt_value, pk, hidden FROM pragma_table_xinfo(?) ORDER BY cid`, -1, t.Name)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(cols); i++ {
+		c := ColumnInfo{
+			Name:         cols[i].Name,
+			DeclaredType: cols[i].Type,
+			NotNull:      cols[i].NotNull,
+			PKOrder:      cols[i].PK,
+			Hidden:       cols[i].Hidden,
+		}
+		if cols[i].Default.Valid {
+			s := cols[i].Default.String
+			c.Default = &s
+		}
+		t.Columns = append(t.Columns, c)
+	}
+
+	if t.Type != "table" {
+		return nil
+	}
+
+	type listRow struct {
+		WithoutRowID bool `db:"wr"`
+		Strict       bool `db:"strict"`
+	}
+
+	// pragma_table_list needs SQLite 3.37; the CREATE statement is
+	// checked on older versions.
+	lst, err := scanAll[listRow](ctx, db, nil,
+		`SELECT wr, strict FROM pragma_table_list(?) WHERE schema = 'main'`, 1, t.Name)
+	if err == nil && len(lst) > 0 {
+		t.WithoutRowID = lst[0].WithoutRowID
+		t.Strict = lst[0].Strict
+	} else {
+		sqlx := strings.ToUpper(t.SQL)
+		t.WithoutRowID = strings.Contains(sqlx, "WITHOUT ROWID")
+	}
+
+	type indexRow struct {
+		Name    string `db:"name"`
+		Unique  bool   `db:"unique"`
+		Origin  string `db:"origin"`
+		Partial bool   `db:"partial"`
+	}
+
+	ixs, err := scanAll[indexRow](ctx, db, nil,
+		`SELECT name, "unique", origin, partial FROM pragma_index_list(?) ORDER BY seq DESC`, -1, t.Name)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(ixs); i++ {
+
+		ix := IndexInfo{
+			Name:    ixs[i].Name,
+			Table:   t.Name,
+			Unique:  ixs[i].Unique,
+			Partial: ixs[i].Partial,
+			Origin:  ixs[i].Origin,
+		}
+
+		if ix.Columns, err = scanAll[string](ctx, db, nil,
+			`SELECT coalesce(name, '') FROM pragma_index_xinfo(?) WHERE key = 1 ORDER BY seqno`, -1, ix.Name); err != nil {
+			return err
+		}
+
+		if ix.SQL, err = scanOneString(ctx, db,
+			`SELECT coalesce(sql, '') FROM sqlite_master WHERE type = 'index' AND name = ?`, ix.Name); err != nil {
+			return err
+		}
+
+		t.Indexes = append(t.Indexes, ix)
+	}
+
+	type fkRow struct {
+		ID       int            `db:"id"`
+		Table    string         `db:"table"`
+		From     string         `db:"from"`
+		To       sql.NullString `db:"to"`
+		OnUpdate string         `db:"on_update"`
+		OnDelete string         `db:"on_delete"`
+		Match    string         `db:"match"`
+	}
+
+	fks, err := scanAll[fkRow](ctx, db, nil,
+		`SELECT id, "table", "from", "to", on_update, on_delete, "match" FROM pragma_foreign_key_list(?) ORDER BY id, seq`, -1, t.Name)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(fks); i++ {
+		n := len(t.ForeignKeys)
+		if n == 0 || t.ForeignKeys[n-1].ID != fks[i].ID {
+			t.ForeignKeys = append(t.ForeignKeys, ForeignKeyInfo{
+				ID:       fks[i].ID,
+				Table:    fks[i].Table,
+				OnUpdate: fks[i].OnUpdate,
+				OnDelete: fks[i].OnDelete,
+				Match:    fks[i].Match,
+			})
+			n++
+		}
+		fk := &t.ForeignKeys[n-1]
+		fk.From = append(fk.From, fks[i].From)
+		if fks[i].To.Valid {
+			fk.To = append(fk.To, fks[i].To.String)
+		}
+	}
+
+	return nil
+}
+
+// scanOneString returns the first column of the first row of a query;
+// an empty string if there are no rows.
+func scanOneString(ctx context.Context, db *sql.DB, sqlQuery string, args ...interface{}) (string, error) {
+
+	v, err := scanAll[string](ctx, db, nil, sqlQuery, 1, args...)
+	if err != nil || len(v) == 0 {
+		return "", err
+	}
+
+	return v[0], nil
+}
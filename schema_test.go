@@ -0,0 +1,109 @@
+package sqlitehench
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetSchema(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "schema.sqlite")
+	stmts := []string{
+		"CREATE TABLE parent (a INTEGER, b TEXT, PRIMARY KEY (b, a)) WITHOUT ROWID",
+		`CREATE TABLE child (id INTEGER PRIMARY KEY, pa INTEGER NOT NULL, pb TEXT DEFAULT 'x',
+			code TEXT UNIQUE, total INTEGER GENERATED ALWAYS AS (id * 2) STORED,
+			FOREIGN KEY (pb, pa) REFERENCES parent (b, a) ON DELETE CASCADE)`,
+		"CREATE INDEX ix_child_pa ON child (pa, lower(pb)) WHERE pa > 0",
+		"CREATE VIEW v AS SELECT id, code FROM child",
+		"CREATE TRIGGER trg AFTER INSERT ON child BEGIN SELECT 1; END",
+	}
+	for i := 0; i < len(stmts); i++ {
+		if _, err := d.ExecuteNonQuery(stmts[i], dbFilePath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := d.GetSchema(dbFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Tables) != 2 || s.Tables[0].Name != "parent" || len(s.Views) != 1 || s.Views[0].Type != "view" {
+		t.Fatalf("got %+v", s)
+	}
+	if trg := s.Triggers(); len(trg) != 1 || trg[0].Name != "trg" || trg[0].Table != "child" {
+		t.Errorf("got the triggers %+v", trg)
+	}
+
+	p := s.Table("[PARENT]")
+	if p == nil || !p.WithoutRowID || !equalStrings(p.PrimaryKey(), []string{"b", "a"}) {
+		t.Errorf("got %+v", p)
+	}
+
+	c := s.Table("child")
+	if !equalStrings(c.ColumnNames(), []string{"id", "pa", "pb", "code", "total"}) {
+		t.Errorf("got the columns %v", c.ColumnNames())
+	}
+	if pa := c.Column("PA"); pa == nil || !pa.NotNull || pa.DeclaredType != "INTEGER" || pa.Default != nil {
+		t.Errorf("got %+v", pa)
+	}
+	if pb := c.Column("pb"); pb.Default == nil || *pb.Default != "'x'" {
+		t.Errorf("got %+v", pb)
+	}
+	if total := c.Column("total"); total.Hidden != 3 {
+		t.Errorf("got %+v", total)
+	}
+	if c.Column("none") != nil {
+		t.Error("found the column none")
+	}
+
+	if len(c.ForeignKeys) != 1 {
+		t.Fatalf("got the foreign keys %+v", c.ForeignKeys)
+	}
+	fk := c.ForeignKeys[0]
+	if fk.Table != "parent" || !equalStrings(fk.From, []string{"pb", "pa"}) || !equalStrings(fk.To, []string{"b", "a"}) || fk.OnDelete != "CASCADE" {
+		t.Errorf("got %+v", fk)
+	}
+
+	// The automatic index of the UNIQUE constraint; and CREATE INDEX.
+	origins := map[string]IndexInfo{}
+	for _, ix := range c.Indexes {
+		origins[ix.Origin] = ix
+	}
+	if u := origins["u"]; !u.Unique || !equalStrings(u.Columns, []string{"code"}) || u.SQL != "" {
+		t.Errorf("got %+v", u)
+	}
+	if ix := origins["c"]; ix.Name != "ix_child_pa" || !ix.Partial || !equalStrings(ix.Columns, []string{"pa", ""}) || ix.SQL == "" {
+		t.Errorf("got %+v", ix)
+	}
+
+	if v := s.Table("v"); !equalStrings(v.ColumnNames(), []string{"id", "code"}) || v.Indexes != nil {
+		t.Errorf("got %+v", v)
+	}
+}
+
+func TestGetTableInfo(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	dbFilePath := filepath.Join(t.TempDir(), "schema.sqlite")
+	if _, err := d.ExecuteNonQuery("CREATE TABLE t (id INTEGER PRIMARY KEY)", dbFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	ti, err := d.GetTableInfo(dbFilePath, " [T] ")
+	if err != nil || ti.Name != "t" || ti.Type != "table" || ti.SQL != "CREATE TABLE t (id INTEGER PRIMARY KEY)" {
+		t.Errorf("got %+v, %v", ti, err)
+	}
+
+	if _, err = d.GetTableInfo(dbFilePath, "none"); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("got %v; want %v", err, ErrTableNotFound)
+	}
+	if _, err = d.GetSchema(filepath.Join(t.TempDir(), "none.sqlite")); !errors.Is(err, ErrDatabaseFileNotExists) {
+		t.Errorf("got %v; want %v", err, ErrDatabaseFileNotExists)
+	}
+}
@@ -0,0 +1,716 @@
+package sqlitehench
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// SchemaChange is one difference between two schemas.
+type SchemaChange struct {
+	// Object is "table", "column", "index", "view" or "trigger".
+	Object string
+	Name   string
+
+	// Table is the table of a column, index or trigger.
+	Table string
+
+	// Action is "create", "drop" or "alter"; as needed to make the
+	// destination match the source.
+	Action string
+
+	// Detail describes an altered object (i.e. "type INT -> TEXT").
+	Detail string
+}
+
+// SchemaDiffResult is the difference between the schemas of two
+// databases, and the SQL that changes the destination to match the
+// source.
+type SchemaDiffResult struct {
+	Changes []SchemaChange
+
+	// SQL are the statements in the order to be run; see Script.
+	SQL []string
+
+	// Rebuilt are the tables that are rebuilt (created anew, with
+	// the rows copied), as their change cannot be done with ALTER
+	// TABLE.
+	Rebuilt []string
+}
+
+// Equal reports whether the schemas are the same.
+func (r *SchemaDiffResult) Equal() bool {
+	return len(r.Changes) == 0
+}
+
+// Script returns the SQL as one script; in a transaction, with the
+// foreign keys turned off (as the table rebuilds need), and turned on
+// again after the commit. The script ends the transaction with
+// PRAGMA foreign_key_check, which lists the rows that violate a foreign
+// key; but does not fail the COMMIT. ApplySchemaDiff rolls back, if
+// there are such rows.
+func (r *SchemaDiffResult) Script() string {
+
+	if len(r.SQL) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("PRAGMA foreign_keys = OFF;\nBEGIN;\n")
+	for i := 0; i < len(r.SQL); i++ {
+		sb.WriteString(strings.TrimSuffix(strings.TrimSpace(r.SQL[i]), ";"))
+		sb.WriteString(";\n")
+	}
+	sb.WriteString("PRAGMA foreign_key_check;\nCOMMIT;\nPRAGMA foreign_keys = ON;\n")
+
+	return sb.String()
+}
+
+// ApplySchemaDiff runs the SQL of a SchemaDiffResult on a database; in a
+// transaction, with the foreign keys turned off (as the table rebuilds
+// need). The transaction is rolled back, and an ErrConstraint error
+// returned, if a row violates a foreign key once the statements have
+// run. The foreign_keys setting of the connection is restored.
+func (d *DBAccess) ApplySchemaDiff(r *SchemaDiffResult, dbFilePath string) error {
+	return d.ApplySchemaDiffContext(context.Background(), r, dbFilePath)
+}
+
+// ApplySchemaDiffContext is ApplySchemaDiff with a ctx.
+func (d *DBAccess) ApplySchemaDiffContext(ctx context.Context, r *SchemaDiffResult, dbFilePath string) (err error) {
+
+	sqlx := ""
+	defer func() { err = wrapErr("ApplySchemaDiff", dbFilePath, sqlx, err) }()
+
+	if r == nil || len(r.SQL) == 0 {
+		return nil
+	}
+
+	// The statements run on a connection of their own; as the
+	// foreign_keys PRAGMA is set on the connection.
+	db, release, err := d.acquireOwnDB(dbFilePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer conn.Close()
+
+	var fk int64
+	if err = conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&fk); err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	// foreign_keys cannot be changed in a transaction.
+	if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer conn.ExecContext(context.Background(), fmt.Sprintf("PRAGMA foreign_keys = %d", fk))
+
+	if _, err = conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	rollback := func() {
+		// The ctx may have been cancelled.
+		conn.ExecContext(context.Background(), "ROLLBACK")
+	}
+
+	for i := 0; i < len(r.SQL); i++ {
+		sqlx = r.SQL[i]
+		if _, err = conn.ExecContext(ctx, sqlx); err != nil {
+			rollback()
+			return ctxErr(ctx, err)
+		}
+	}
+	sqlx = ""
+
+	// The first violation is reported.
+	var table, parent string
+	var rowid sql.NullInt64
+	var fkid int64
+	err = conn.QueryRowContext(ctx, "PRAGMA foreign_key_check").Scan(&table, &rowid, &parent, &fkid)
+	if err == nil {
+		rollback()
+		return fmt.Errorf("a row of %s (rowid %v) has no parent in %s; %w", table, rowid.Int64, parent, ErrConstraint)
+	}
+	if err != sql.ErrNoRows {
+		rollback()
+		return ctxErr(ctx, err)
+	}
+
+	if _, err = conn.ExecContext(ctx, "COMMIT"); err != nil {
+		rollback()
+		return ctxErr(ctx, err)
+	}
+
+	return nil
+}
+
+// SchemaDiff compares the tables, columns, indexes, views and triggers
+// of two databases (i.e. a reference database, and a deployed one).
+func (d *DBAccess) SchemaDiff(srcFilePath string, destFilePath string) (*SchemaDiffResult, error) {
+	return d.SchemaDiffContext(context.Background(), srcFilePath, destFilePath)
+}
+
+// SchemaDiffContext is SchemaDiff with a ctx.
+//
+// The columns that are added at the end of a table are added with ALTER
+// TABLE ADD COLUMN; all other table changes are done with SQLite's
+// table-rebuild procedure (create the new table, copy the rows of the
+// common columns, drop the old table, and rename the new one); after
+// which the indexes and triggers of the table are created again. A
+// column that is added NOT NULL without a default fails the copy; the
+// SQL must be edited for such a change.
+func (d *DBAccess) SchemaDiffContext(ctx context.Context, srcFilePath string, destFilePath string) (_ *SchemaDiffResult, err error) {
+
+	defer func() { err = wrapErr("SchemaDiff", destFilePath, "", err) }()
+
+	src, err := d.GetSchemaContext(ctx, srcFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	dest, err := d.GetSchemaContext(ctx, destFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffSchemas(src, dest), nil
+}
+
+// schemaDiffer collects the changes and the SQL in the order that the
+// statements must run.
+type schemaDiffer struct {
+	res SchemaDiffResult
+
+	dropTriggers []string
+	dropViews    []string
+	dropIndexes  []string
+	dropTables   []string
+	createTables []string
+	alterTables  []string
+	rebuilds     []string
+	createIdx    []string
+	createViews  []string
+	createTrg    []string
+
+	// recreated are the (lowercase) tables and views that are dropped
+	// (or rebuilt) and created again; their indexes and triggers are
+	// created again too.
+	recreated map[string]bool
+}
+
+func diffSchemas(src *Schema, dest *Schema) *SchemaDiffResult {
+
+	sd := &schemaDiffer{recreated: make(map[string]bool)}
+
+	// Tables
+	for i := 0; i < len(src.Tables); i++ {
+		s := &src.Tables[i]
+		t := dest.Table(s.Name)
+		switch {
+		case t == nil || t.Type != "table":
+			if t != nil {
+				sd.dropView(t)
+			}
+			sd.change("table", s.Name, "", "create", "")
+			sd.createTables = append(sd.createTables, s.SQL)
+			sd.recreated[strings.ToLower(s.Name)] = true
+		default:
+			sd.diffTable(s, t)
+		}
+	}
+	for i := 0; i < len(dest.Tables); i++ {
+		t := &dest.Tables[i]
+		if s := src.Table(t.Name); s == nil || s.Type != "table" {
+			sd.change("table", t.Name, "", "drop", "")
+			sd.dropObjectTriggers(t)
+			sd.dropTables = append(sd.dropTables, fmt.Sprintf("DROP TABLE [%s]", t.Name))
+		}
+	}
+
+	// The views are dropped and created again, if a table is
+	// rebuilt; as they may refer to it.
+	rebuild := len(sd.rebuilds) > 0
+
+	// Views
+	for i := 0; i < len(src.Views); i++ {
+		s := &src.Views[i]
+		t := dest.Table(s.Name)
+		switch {
+		case t == nil:
+			sd.change("view", s.Name, "", "create", "")
+		case t.Type != "view":
+			// replaced by a table (above)
+			sd.change("view", s.Name, "", "create", "")
+		case !sqlEquivalent(s.SQL, t.SQL):
+			sd.change("view", s.Name, "", "alter", "definition")
+			sd.dropView(t)
+		case rebuild:
+			sd.dropView(t)
+		default:
+			continue
+		}
+		sd.createViews = append(sd.createViews, s.SQL)
+		sd.recreated[strings.ToLower(s.Name)] = true
+	}
+	for i := 0; i < len(dest.Views); i++ {
+		t := &dest.Views[i]
+		if src.Table(t.Name) == nil {
+			sd.change("view", t.Name, "", "drop", "")
+			sd.dropView(t)
+		}
+	}
+
+	// Indexes and triggers
+	for i := 0; i < len(src.Tables); i++ {
+		s := &src.Tables[i]
+		t := dest.Table(s.Name)
+		if t != nil && t.Type != "table" {
+			t = nil
+		}
+		sd.diffIndexes(s, t)
+	}
+	sd.diffTriggers(src, dest)
+
+	sd.res.SQL = joinStmts(sd.dropTriggers, sd.dropViews, sd.dropIndexes, sd.dropTables, sd.createTables,
+		sd.alterTables, sd.rebuilds, sd.createIdx, sd.createViews, sd.createTrg)
+
+	return &sd.res
+}
+
+func (sd *schemaDiffer) change(object string, name string, table string, action string, detail string) {
+	sd.res.Changes = append(sd.res.Changes, SchemaChange{Object: object, Name: name, Table: table, Action: action, Detail: detail})
+}
+
+// dropView drops a view of the destination once; its triggers are
+// dropped with it.
+func (sd *schemaDiffer) dropView(t *TableInfo) {
+
+	key := strings.ToLower(t.Name)
+	if sd.recreated["view:"+key] {
+		return
+	}
+	sd.recreated["view:"+key] = true
+
+	sd.dropObjectTriggers(t)
+	sd.dropViews = append(sd.dropViews, fmt.Sprintf("DROP VIEW [%s]", t.Name))
+}
+
+// dropObjectTriggers drops the triggers of a destination table or view;
+// before the object itself is dropped.
+func (sd *schemaDiffer) dropObjectTriggers(t *TableInfo) {
+
+	for i := 0; i < len(t.Triggers); i++ {
+		sd.dropTriggers = append(sd.dropTriggers, fmt.Sprintf("DROP TRIGGER IF EXISTS [%s]", t.Triggers[i].Name))
+	}
+}
+
+// diffTable compares a table in both schemas; and adds the columns, or
+// rebuilds the table.
+func (sd *schemaDiffer) diffTable(s *TableInfo, t *TableInfo) {
+
+	if sqlEquivalent(s.SQL, t.SQL) {
+		return
+	}
+
+	n := len(sd.res.Changes)
+
+	var added []ColumnInfo
+
+	for i := 0; i < len(s.Columns); i++ {
+		sc := s.Columns[i]
+		tc := t.Column(sc.Name)
+		if tc == nil {
+			sd.change("column", sc.Name, s.Name, "create", sc.DeclaredType)
+			added = append(added, sc)
+			continue
+		}
+		if detail := columnDiff(&sc, tc); detail != "" {
+			sd.change("column", sc.Name, s.Name, "alter", detail)
+		}
+	}
+	for i := 0; i < len(t.Columns); i++ {
+		if s.Column(t.Columns[i].Name) == nil {
+			sd.change("column", t.Columns[i].Name, s.Name, "drop", "")
+		}
+	}
+
+	if len(sd.res.Changes) == n {
+		// i.e. a CHECK constraint, a foreign key, WITHOUT ROWID
+		sd.change("table", s.Name, "", "alter", "definition")
+	}
+
+	if defs, ok := addColumnDefs(s, t, added); ok {
+		for i := 0; i < len(defs); i++ {
+			sd.alterTables = append(sd.alterTables, fmt.Sprintf("ALTER TABLE [%s] ADD COLUMN %s", s.Name, defs[i]))
+		}
+		return
+	}
+
+	sd.rebuildTable(s, t)
+}
+
+// columnDiff describes how a column differs; empty if it does not.
+func columnDiff(s *ColumnInfo, t *ColumnInfo) string {
+
+	var diffs []string
+
+	if !strings.EqualFold(s.DeclaredType, t.DeclaredType) {
+		diffs = append(diffs, fmt.Sprintf("type %s -> %s", t.DeclaredType, s.DeclaredType))
+	}
+	if s.NotNull != t.NotNull {
+		diffs = append(diffs, fmt.Sprintf("not null %v -> %v", t.NotNull, s.NotNull))
+	}
+	sdef, tdef := "<none>", "<none>"
+	if s.Default != nil {
+		sdef = *s.Default
+	}
+	if t.Default != nil {
+		tdef = *t.Default
+	}
+	if sdef != tdef {
+		diffs = append(diffs, fmt.Sprintf("default %s -> %s", tdef, sdef))
+	}
+	if s.PKOrder != t.PKOrder {
+		diffs = append(diffs, fmt.Sprintf("primary key %d -> %d", t.PKOrder, s.PKOrder))
+	}
+	if s.Hidden != t.Hidden {
+		diffs = append(diffs, fmt.Sprintf("hidden %d -> %d", t.Hidden, s.Hidden))
+	}
+
+	return strings.Join(diffs, "; ")
+}
+
+// addColumnDefs returns the definitions of the added columns; if the
+// source table is the destination table with the columns added at the
+// end, and ALTER TABLE ADD COLUMN can add them.
+func addColumnDefs(s *TableInfo, t *TableInfo, added []ColumnInfo) ([]string, bool) {
+
+	if len(added) == 0 || len(s.Columns) != len(t.Columns)+len(added) {
+		return nil, false
+	}
+
+	stoks := tokenizeSQL(s.SQL)
+	ttoks := tokenizeSQL(t.SQL)
+
+	sparts, sclose := tableBodyParts(stoks)
+	tparts, tclose := tableBodyParts(ttoks)
+	if sclose < 0 || tclose < 0 || len(sparts) != len(tparts)+len(added) {
+		return nil, false
+	}
+
+	// The destination definition is the same up to the added columns;
+	// and after them (i.e. WITHOUT ROWID).
+	if !tokensEquivalent(stoks[:sparts[len(tparts)][0]-1], ttoks[:tclose]) ||
+		!tokensEquivalent(stoks[sclose:], ttoks[tclose:]) {
+		return nil, false
+	}
+
+	var defs []string
+
+	for i := len(tparts); i < len(sparts); i++ {
+
+		p := stoks[sparts[i][0]:sparts[i][1]]
+		if len(p) == 0 || isTableConstraint(p[0]) {
+			return nil, false
+		}
+
+		c := s.Column(p[0].val)
+		if c == nil || c.PKOrder > 0 || c.Hidden == 3 || (c.NotNull && c.Default == nil) {
+			return nil, false
+		}
+
+		for k := 1; k < len(p); k++ {
+			// ADD COLUMN cannot add a UNIQUE or PRIMARY KEY column,
+			// nor one with a non-constant default.
+			if p[k].isKeyword("UNIQUE") || p[k].isKeyword("PRIMARY") {
+				return nil, false
+			}
+			if p[k].isKeyword("DEFAULT") && k+1 < len(p) &&
+				(p[k+1].isPunct("(") || strings.HasPrefix(strings.ToUpper(p[k+1].val), "CURRENT_")) {
+				return nil, false
+			}
+		}
+
+		defs = append(defs, s.SQL[p[0].start:p[len(p)-1].end])
+	}
+
+	return defs, true
+}
+
+// tableBodyParts returns the token ranges of the column definitions and
+// table constraints of a CREATE TABLE statement; and the position of
+// its closing parenthesis (-1 for CREATE TABLE ... AS SELECT).
+func tableBodyParts(toks []sqlToken) ([][2]int, int) {
+
+	open := -1
+	for i := 0; i < len(toks); i++ {
+		if toks[i].isPunct("(") {
+			open = i
+			break
+		}
+		if toks[i].isKeyword("AS") {
+			return nil, -1
+		}
+	}
+	if open < 0 {
+		return nil, -1
+	}
+
+	var parts [][2]int
+
+	depth := 0
+	start := open + 1
+	for i := open; i < len(toks); i++ {
+		switch {
+		case toks[i].isPunct("("):
+			depth++
+		case toks[i].isPunct(")"):
+			depth--
+			if depth == 0 {
+				parts = append(parts, [2]int{start, i})
+				return parts, i
+			}
+		case toks[i].isPunct(",") && depth == 1:
+			parts = append(parts, [2]int{start, i})
+			start = i + 1
+		}
+	}
+
+	return nil, -1
+}
+
+func isTableConstraint(t sqlToken) bool {
+	return t.isKeyword("CONSTRAINT") || t.isKeyword("PRIMARY") || t.isKeyword("UNIQUE") ||
+		t.isKeyword("CHECK") || t.isKeyword("FOREIGN")
+}
+
+// rebuildTable adds the statements of the table-rebuild procedure.
+func (sd *schemaDiffer) rebuildTable(s *TableInfo, t *TableInfo) {
+
+	newName := "__new_" + s.Name
+
+	sd.res.Rebuilt = append(sd.res.Rebuilt, s.Name)
+	sd.recreated[strings.ToLower(s.Name)] = true
+	sd.dropObjectTriggers(t)
+
+	var cols []string
+	for i := 0; i < len(s.Columns); i++ {
+		if s.Columns[i].Hidden != 0 {
+			continue
+		}
+		if c := t.Column(s.Columns[i].Name); c != nil && c.Hidden == 0 {
+			cols = append(cols, s.Columns[i].Name)
+		}
+	}
+
+	sd.rebuilds = append(sd.rebuilds,
+		renameCreateTable(s.SQL, newName),
+		fmt.Sprintf("INSERT INTO [%s] (%s) SELECT %s FROM [%s]", newName, quoteColumnList(cols), quoteColumnList(cols), t.Name),
+		fmt.Sprintf("DROP TABLE [%s]", t.Name),
+		fmt.Sprintf("ALTER TABLE [%s] RENAME TO [%s]", newName, s.Name),
+	)
+}
+
+// renameCreateTable returns a CREATE TABLE statement with another table
+// name.
+func renameCreateTable(sqlText string, name string) string {
+
+	toks := tokenizeSQL(sqlText)
+
+	i := 0
+	for i < len(toks) && !toks[i].isKeyword("TABLE") {
+		i++
+	}
+	i++
+	if i+2 < len(toks) && toks[i].isKeyword("IF") && toks[i+1].isKeyword("NOT") && toks[i+2].isKeyword("EXISTS") {
+		i += 3
+	}
+	if i >= len(toks) {
+		return sqlText
+	}
+
+	from, to := toks[i].start, toks[i].end
+	if i+2 < len(toks) && toks[i+1].isPunct(".") {
+		// schema.table
+		to = toks[i+2].end
+	}
+
+	return sqlText[:from] + fmt.Sprintf("[%s]", name) + sqlText[to:]
+}
+
+// diffIndexes compares the (created) indexes of a table; t is nil, if
+// the table is not in the destination.
+func (sd *schemaDiffer) diffIndexes(s *TableInfo, t *TableInfo) {
+
+	recreated := sd.recreated[strings.ToLower(s.Name)]
+
+	for i := 0; i < len(s.Indexes); i++ {
+
+		si := s.Indexes[i]
+		if si.SQL == "" {
+			// part of the table definition
+			continue
+		}
+
+		var ti *IndexInfo
+		if t != nil {
+			ti = findIndex(t.Indexes, si.Name)
+		}
+
+		switch {
+		case ti == nil:
+			sd.change("index", si.Name, s.Name, "create", "")
+		case !sqlEquivalent(si.SQL, ti.SQL):
+			sd.change("index", si.Name, s.Name, "alter", "definition")
+			if !recreated {
+				sd.dropIndexes = append(sd.dropIndexes, fmt.Sprintf("DROP INDEX [%s]", ti.Name))
+			}
+		case !recreated:
+			continue
+		}
+		sd.createIdx = append(sd.createIdx, si.SQL)
+	}
+
+	if t == nil {
+		return
+	}
+
+	for i := 0; i < len(t.Indexes); i++ {
+		ti := t.Indexes[i]
+		if ti.SQL == "" || findIndex(s.Indexes, ti.Name) != nil {
+			continue
+		}
+		sd.change("index", ti.Name, t.Name, "drop", "")
+		if !recreated {
+			sd.dropIndexes = append(sd.dropIndexes, fmt.Sprintf("DROP INDEX [%s]", ti.Name))
+		}
+	}
+}
+
+func findIndex(ixs []IndexInfo, name string) *IndexInfo {
+
+	for i := 0; i < len(ixs); i++ {
+		if strings.EqualFold(ixs[i].Name, name) {
+			return &ixs[i]
+		}
+	}
+
+	return nil
+}
+
+// diffTriggers compares the triggers; the triggers of the recreated
+// tables and views are created again.
+func (sd *schemaDiffer) diffTriggers(src *Schema, dest *Schema) {
+
+	srcTrg := src.Triggers()
+	destTrg := dest.Triggers()
+
+	find := func(trg []TriggerInfo, name string) *TriggerInfo {
+		for i := 0; i < len(trg); i++ {
+			if strings.EqualFold(trg[i].Name, name) {
+				return &trg[i]
+			}
+		}
+		return nil
+	}
+
+	for i := 0; i < len(srcTrg); i++ {
+
+		s := srcTrg[i]
+		t := find(destTrg, s.Name)
+		recreated := sd.recreated[strings.ToLower(s.Table)]
+
+		switch {
+		case t == nil:
+			sd.change("trigger", s.Name, s.Table, "create", "")
+		case !sqlEquivalent(s.SQL, t.SQL):
+			sd.change("trigger", s.Name, s.Table, "alter", "definition")
+			sd.dropTriggers = append(sd.dropTriggers, fmt.Sprintf("DROP TRIGGER IF EXISTS [%s]", t.Name))
+		case !recreated:
+			continue
+		}
+		sd.createTrg = append(sd.createTrg, s.SQL)
+	}
+
+	for i := 0; i < len(destTrg); i++ {
+		t := destTrg[i]
+		if find(srcTrg, t.Name) == nil {
+			sd.change("trigger", t.Name, t.Table, "drop", "")
+			sd.dropTriggers = append(sd.dropTriggers, fmt.Sprintf("DROP TRIGGER IF EXISTS [%s]", t.Name))
+		}
+	}
+}
+
+// joinStmts joins the lists of statements; the duplicates (i.e. a
+// trigger dropped twice) are removed.
+func joinStmts(lists ...[]string) []string {
+
+	var ret []string
+	seen := make(map[string]bool)
+
+	for i := 0; i < len(lists); i++ {
+		for j := 0; j < len(lists[i]); j++ {
+			if seen[lists[i][j]] {
+				continue
+			}
+			seen[lists[i][j]] = true
+			ret = append(ret, lists[i][j])
+		}
+	}
+
+	return ret
+}
+
+// sqlEquivalent reports whether two statements are the same; apart
+// from whitespace, comments, the case of keywords and identifiers, and
+// the quoting of identifiers.
+func sqlEquivalent(a string, b string) bool {
+	return tokensEquivalent(tokenizeSQL(a), tokenizeSQL(b))
+}
+
+func tokensEquivalent(a []sqlToken, b []sqlToken) bool {
+
+	a = skipIfNotExists(a)
+	b = skipIfNotExists(b)
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := 0; i < len(a); i++ {
+		x, y := a[i], b[i]
+		xName := x.kind == tokWord || x.kind == tokQuoted
+		yName := y.kind == tokWord || y.kind == tokQuoted
+		switch {
+		case xName && yName:
+			if !strings.EqualFold(x.val, y.val) {
+				return false
+			}
+		case x.kind != y.kind || x.val != y.val:
+			return false
+		}
+	}
+
+	return true
+}
+
+// skipIfNotExists removes IF NOT EXISTS from a CREATE statement.
+func skipIfNotExists(toks []sqlToken) []sqlToken {
+
+	for i := 0; i+2 < len(toks) && i < 4; i++ {
+		if toks[i].isKeyword("IF") && toks[i+1].isKeyword("NOT") && toks[i+2].isKeyword("EXISTS") {
+			ret := append([]sqlToken(nil), toks[:i]...)
+			return append(ret, toks[i+3:]...)
+		}
+	}
+
+	return toks
+}
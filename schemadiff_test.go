@@ -0,0 +1,214 @@
+package sqlitehench
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// schemaDiffTest returns the paths of two db files; created by the
+// scripts.
+func schemaDiffTest(t *testing.T, d *DBAccess, srcSQL string, destSQL string) (string, string) {
+
+	t.Helper()
+
+	return testDB(t, d, "src.sqlite", []string{srcSQL}, ""), testDB(t, d, "dest.sqlite", []string{destSQL}, "")
+}
+
+func TestSchemaDiff(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	srcFilePath, destFilePath := schemaDiffTest(t, d,
+		`CREATE TABLE Customer (ID INTEGER PRIMARY KEY, Name TEXT NOT NULL, Email TEXT, Phone TEXT DEFAULT '', Score INT);
+		CREATE INDEX ix_name ON Customer (Name);
+		CREATE TABLE Orders (ID INTEGER PRIMARY KEY, CustomerID INT REFERENCES Customer (ID), Amount REAL CHECK (Amount >= 0));
+		CREATE INDEX ix_cust ON Orders (CustomerID, Amount);
+		CREATE TABLE Audit (msg TEXT);
+		CREATE VIEW vOrders AS SELECT o.ID, c.Name FROM Orders o JOIN Customer c ON c.ID = o.CustomerID;
+		CREATE TRIGGER trgOrd AFTER INSERT ON Orders BEGIN INSERT INTO Audit VALUES ('order'); END;`,
+		`CREATE TABLE Customer (ID INTEGER PRIMARY KEY, Name TEXT NOT NULL, Email TEXT);
+		CREATE INDEX ix_name ON Customer (Name DESC);
+		CREATE TABLE Orders (ID INTEGER PRIMARY KEY, CustomerID INT, Amount TEXT, Legacy INT);
+		CREATE INDEX ix_cust ON Orders (CustomerID);
+		CREATE INDEX ix_old ON Orders (Legacy);
+		CREATE TABLE Old (x);
+		CREATE TABLE Audit (msg TEXT);
+		create view vOrders as select o.ID, c.Name from Orders o join Customer c on c.ID = o.CustomerID;
+		CREATE TRIGGER trgOrd AFTER INSERT ON Orders BEGIN INSERT INTO Audit VALUES ('order'); END;
+		INSERT INTO Customer VALUES (1, 'a', 'a@x'), (2, 'b', NULL);
+		INSERT INTO Orders VALUES (1, 1, '5.5', 0), (2, 2, '7', 1);`)
+
+	diff, err := d.SchemaDiff(srcFilePath, destFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []SchemaChange{
+		{Object: "column", Name: "Phone", Table: "Customer", Action: "create", Detail: "TEXT"},
+		{Object: "column", Name: "Score", Table: "Customer", Action: "create", Detail: "INT"},
+		{Object: "column", Name: "Amount", Table: "Orders", Action: "alter", Detail: "type TEXT -> REAL"},
+		{Object: "column", Name: "Legacy", Table: "Orders", Action: "drop"},
+		{Object: "table", Name: "Old", Action: "drop"},
+		{Object: "index", Name: "ix_name", Table: "Customer", Action: "alter", Detail: "definition"},
+		{Object: "index", Name: "ix_cust", Table: "Orders", Action: "alter", Detail: "definition"},
+		{Object: "index", Name: "ix_old", Table: "Orders", Action: "drop"},
+	}
+	if len(diff.Changes) != len(want) {
+		t.Fatalf("got %+v", diff.Changes)
+	}
+	for i := 0; i < len(want); i++ {
+		if diff.Changes[i] != want[i] {
+			t.Errorf("change %d: got %+v; want %+v", i, diff.Changes[i], want[i])
+		}
+	}
+
+	// The columns added at the end of Customer are added; Orders is
+	// rebuilt, and the view and trigger that refer to it are created
+	// again.
+	if !equalStrings(diff.Rebuilt, []string{"Orders"}) {
+		t.Errorf("rebuilt %v", diff.Rebuilt)
+	}
+	script := diff.Script()
+	for _, s := range []string{
+		"ALTER TABLE [Customer] ADD COLUMN Phone TEXT DEFAULT '';",
+		"ALTER TABLE [__new_Orders] RENAME TO [Orders];",
+		"DROP VIEW [vOrders];",
+		"DROP TRIGGER IF EXISTS [trgOrd];",
+	} {
+		if !strings.Contains(script, s) {
+			t.Errorf("the script has no %q", s)
+		}
+	}
+
+	// ExecuteNonQuery runs in a transaction of its own; so the SQL is
+	// run, rather than the Script.
+	if _, err = d.ExecuteNonQuery(strings.Join(diff.SQL, ";\n"), destFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff, err = d.SchemaDiff(srcFilePath, destFilePath); err != nil || !diff.Equal() || diff.Script() != "" {
+		t.Errorf("got %+v, %v", diff, err)
+	}
+
+	// The rows are copied to the rebuilt table.
+	amounts, err := Query[float64](d, "SELECT Amount FROM vOrders v JOIN Orders o ON o.ID = v.ID ORDER BY v.ID", destFilePath)
+	if err != nil || len(amounts) != 2 || amounts[0] != 5.5 || amounts[1] != 7 {
+		t.Errorf("got %v, %v", amounts, err)
+	}
+}
+
+func TestSchemaDiffObjects(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	srcFilePath, destFilePath := schemaDiffTest(t, d,
+		`CREATE TABLE a (id INTEGER PRIMARY KEY, n INT NOT NULL DEFAULT 0);
+		CREATE TABLE log (msg TEXT);
+		CREATE VIEW v AS SELECT id FROM a WHERE n > 0;
+		CREATE TABLE b (id INTEGER PRIMARY KEY);
+		CREATE TRIGGER trg AFTER DELETE ON a BEGIN INSERT INTO log VALUES ('deleted'); END;`,
+		`CREATE TABLE a (id INTEGER PRIMARY KEY);
+		CREATE TABLE log (msg TEXT);
+		CREATE VIEW v AS SELECT id FROM a;
+		CREATE VIEW b AS SELECT 1 AS id;
+		CREATE TRIGGER old AFTER INSERT ON a BEGIN INSERT INTO log VALUES ('inserted'); END;`)
+
+	diff, err := d.SchemaDiff(srcFilePath, destFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions := map[string]string{}
+	for _, c := range diff.Changes {
+		actions[c.Object+" "+c.Name] = c.Action
+	}
+	for k, v := range map[string]string{
+		"column n":    "create",
+		"view v":      "alter",
+		"table b":     "create",
+		"trigger trg": "create",
+		"trigger old": "drop",
+	} {
+		if actions[k] != v {
+			t.Errorf("%s: got %q; want %q", k, actions[k], v)
+		}
+	}
+
+	if _, err = d.ExecuteNonQuery(strings.Join(diff.SQL, ";\n"), destFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if diff, err = d.SchemaDiff(srcFilePath, destFilePath); err != nil || !diff.Equal() {
+		t.Errorf("got %+v, %v", diff, err)
+	}
+}
+
+func TestSQLEquivalent(t *testing.T) {
+
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"CREATE TABLE t (id INT)", "create table [T] ( \"id\"  int ) -- note", true},
+		{"CREATE INDEX IF NOT EXISTS ix ON t (a)", "CREATE INDEX ix ON t (a)", true},
+		{"CREATE TABLE t (n TEXT DEFAULT 'a')", "CREATE TABLE t (n TEXT DEFAULT 'A')", false},
+		{"CREATE TABLE t (id INT)", "CREATE TABLE t (id INT, n INT)", false},
+	}
+
+	for _, tt := range tests {
+		if got := sqlEquivalent(tt.a, tt.b); got != tt.want {
+			t.Errorf("sqlEquivalent(%q, %q) = %v; want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	if got := renameCreateTable("CREATE TABLE IF NOT EXISTS main.[t] (id INT)", "__new_t"); got != "CREATE TABLE IF NOT EXISTS [__new_t] (id INT)" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestApplySchemaDiff(t *testing.T) {
+
+	d := NewDBAccess(DBAccess{})
+	defer d.Close()
+
+	// The rebuild of Orders adds a foreign key that a row violates.
+	srcFilePath, destFilePath := schemaDiffTest(t, d,
+		`CREATE TABLE Customer (ID INTEGER PRIMARY KEY, Name TEXT);
+		CREATE TABLE Orders (ID INTEGER PRIMARY KEY, CustomerID INT REFERENCES Customer (ID));`,
+		`CREATE TABLE Customer (ID INTEGER PRIMARY KEY, Name TEXT);
+		CREATE TABLE Orders (ID INTEGER PRIMARY KEY, CustomerID INT);
+		INSERT INTO Customer VALUES (1, 'a');
+		INSERT INTO Orders VALUES (1, 1), (2, 9);`)
+
+	diff, err := d.SchemaDiff(srcFilePath, destFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(diff.Script(), "COMMIT;\nPRAGMA foreign_keys = ON;\n") {
+		t.Errorf("the script does not turn the foreign keys on:\n%s", diff.Script())
+	}
+
+	// The script would commit; ApplySchemaDiff rolls back.
+	if err = d.ApplySchemaDiff(diff, destFilePath); !errors.Is(err, ErrConstraint) {
+		t.Fatalf("got %v; want %v", err, ErrConstraint)
+	}
+	if again, err := d.SchemaDiff(srcFilePath, destFilePath); err != nil || again.Equal() {
+		t.Errorf("got %+v, %v; want the changes to remain", again, err)
+	}
+	if c := rowCount(t, d, "Orders", destFilePath); c != 2 {
+		t.Errorf("Orders has %d rows; want 2", c)
+	}
+
+	// Once the row has a parent, the diff is applied.
+	if _, err = d.ExecuteNonQuery("INSERT INTO Customer VALUES (9, 'b')", destFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.ApplySchemaDiff(diff, destFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if diff, err = d.SchemaDiff(srcFilePath, destFilePath); err != nil || !diff.Equal() {
+		t.Errorf("got %+v, %v", diff, err)
+	}
+}
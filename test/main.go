@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -29,9 +30,51 @@ func createDatabase(filePath string, d *sqlitehench.DBAccess) {
 		log.Fatal(err)
 	} else {
 		fmt.Println("--- ExecuteNonQuery()")
-		fmt.Println("      created table DBTest; rowsAffected:", rowsAffected, "\n")
+		fmt.Println("      created table DBTest; rowsAffected:", rowsAffected)
+		fmt.Println()
 	}
 }
+
+// migratorDemo applies versioned migrations to a database of its own;
+// the applied versions are kept in the SchemaVersion table.
+func migratorDemo(d *sqlitehench.DBAccess) {
+
+	p := "dbaccess-migrate-test.sqlite"
+	defer func() {
+		for _, f := range []string{p, p + "-shm", p + "-wal"} {
+			os.Remove(f)
+		}
+	}()
+
+	migrations := []sqlitehench.Migration{
+		{
+			Version: 1,
+			Name:    "create Customer",
+			UpSQL:   "CREATE TABLE Customer (CustomerID INTEGER NOT NULL PRIMARY KEY, Name TEXT NOT NULL);",
+			DownSQL: "DROP TABLE Customer;",
+		},
+		{
+			Version: 2,
+			Name:    "add Customer.Email",
+			UpSQL:   "ALTER TABLE Customer ADD COLUMN Email TEXT;",
+			DownSQL: "ALTER TABLE Customer DROP COLUMN Email;",
+		},
+	}
+
+	m, err := d.NewMigrator(migrations, sqlitehench.MigratorOptions{Table: "SchemaVersion"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	applied, err := m.Up(context.Background(), p)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("--- Migrator.Up()")
+	fmt.Println("      migrations applied:", len(applied))
+	fmt.Println()
+}
+
 func cleanup() {
 	var f = []string{
 		"dbaccess-test.sqlite",
@@ -59,13 +102,16 @@ func main() {
 
 	createDatabase(p, d)
 
+	migratorDemo(d)
+
 	// ExcecuteScalare
 	q := "select Message from DBTest limit 1"
 	if m, err := d.ExecuteScalare(q, p); err != nil {
 		log.Fatal(err)
 	} else {
 		fmt.Println("--- ExecuteScalare()")
-		fmt.Println("     ", m, "\n")
+		fmt.Println("     ", m)
+		fmt.Println()
 	}
 
 	// A test of entering a few records
@@ -94,10 +140,14 @@ func main() {
 	}
 	db.Close()
 	took := time.Since(t)
-	fmt.Println("    keep db open took ................", took, "\n")
+	fmt.Println("    keep db open took ................", took)
+	fmt.Println()
 
 	//Closing the db on every loop is slower.
 	//But exposes the db for lock errors.
+	dc := sqlitehench.NewDBAccess(sqlitehench.DBAccess{MaxIdleConns: 100, MaxOpenConns: 100,
+		ConnPolicy: sqlitehench.ConnPolicyCloseAfterOp})
+
 	totalRecords = 1000
 	fmt.Println("inserting", totalRecords, "records...")
 
@@ -108,12 +158,35 @@ func main() {
 			values('Hello World %s %d x',strftime('%%Y-%%m-%%d %%H:%%M:%%f','now'))`,
 			strings.Repeat(fmt.Sprintf(" some text to fill spaces %d >> ", i+totalRecords), 2), i+1+totalRecords)
 
-		if _, err := d.ExecuteNonQuery(sqlx, p); err != nil {
+		if _, err := dc.ExecuteNonQuery(sqlx, p); err != nil {
+			log.Fatal(err)
+		}
+	}
+	took = time.Since(t)
+	fmt.Println("    close db on every loop took ......", took)
+	fmt.Println()
+
+	// The pooled policy keeps a db per file open.
+	dp := sqlitehench.NewDBAccess(sqlitehench.DBAccess{MaxIdleConns: 100, MaxOpenConns: 100,
+		ConnPolicy: sqlitehench.ConnPolicyPooled})
+	defer dp.Close()
+
+	fmt.Println("inserting", totalRecords, "records...")
+
+	t = time.Now()
+	for i := 0; i < totalRecords; i++ {
+		sqlx := fmt.Sprintf(`
+			insert into DBTest(Message,DateTimeCreated)
+			values('Hello World %s %d x',strftime('%%Y-%%m-%%d %%H:%%M:%%f','now'))`,
+			strings.Repeat(fmt.Sprintf(" some text to fill spaces %d >> ", i+2*totalRecords), 2), i+1+2*totalRecords)
+
+		if _, err := dp.ExecuteNonQuery(sqlx, p); err != nil {
 			log.Fatal(err)
 		}
 	}
 	took = time.Since(t)
-	fmt.Println("    close db on every loop took ......", took, "\n")
+	fmt.Println("    pooled db on every loop took .....", took)
+	fmt.Println()
 
 	// GetDataMap
 	var tp []map[string]interface{}
@@ -147,5 +220,6 @@ func main() {
 	fi, _ = os.Stat(p)
 	fmt.Println("db size after shrink ....", fi.Size()/1024, "  kB")
 
-	fmt.Println("\ndone\n")
+	fmt.Println("\ndone")
+	fmt.Println()
 }